@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+var (
+	seedBrokers = flag.String("brokers", "localhost:9092", "comma delimited list of seed brokers")
+	dryRun      = flag.Bool("dry-run", false, "print the changes that would be made without making them")
+)
+
+func die(msg string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, msg+"\n", args...)
+	os.Exit(1)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: %s [flags] (acls|configs) FILE
+
+Applies the ACLs or topic configs declared in FILE, printing what changed.
+
+flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	raw, err := ioutil.ReadFile(flag.Arg(1))
+	if err != nil {
+		die("unable to read %s: %v", flag.Arg(1), err)
+	}
+
+	cl, err := kgo.NewClient(kgo.SeedBrokers(strings.Split(*seedBrokers, ",")...))
+	if err != nil {
+		die("unable to create client: %v", err)
+	}
+	adm := kadm.NewClient(cl)
+	defer adm.Close()
+
+	switch flag.Arg(0) {
+	case "acls":
+		applyACLs(context.Background(), adm, raw)
+	case "configs":
+		applyConfigs(context.Background(), adm, raw)
+	default:
+		usage()
+		os.Exit(1)
+	}
+}
+
+/////////////
+// CONFIGS //
+/////////////
+
+// The configs file maps topics to config keys to values. Only the keys in the
+// file are managed: a key with a string value is set to that value, and a key
+// with a null value is deleted (reset to the broker default). Keys that are
+// not in the file are left alone.
+//
+//	{
+//	  "my-topic": {"retention.ms": "86400000", "cleanup.policy": null}
+//	}
+type declaredConfigs map[string]map[string]*string
+
+func applyConfigs(ctx context.Context, adm *kadm.Client, raw []byte) {
+	var declared declaredConfigs
+	if err := json.Unmarshal(raw, &declared); err != nil {
+		die("unable to parse configs: %v", err)
+	}
+	topics := make([]string, 0, len(declared))
+	for topic := range declared {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	described, err := adm.DescribeTopicConfigs(ctx, topics...)
+	if err != nil {
+		die("unable to describe topic configs: %v", err)
+	}
+
+	var changed bool
+	for _, topic := range topics {
+		rc, err := described.On(topic, nil)
+		if err == nil {
+			err = rc.Err
+		}
+		if err != nil {
+			die("unable to describe configs for topic %s: %v", topic, err)
+		}
+
+		alters := diffConfigs(topic, rc, declared[topic])
+		if len(alters) == 0 {
+			continue
+		}
+		changed = true
+
+		// A dry run validates the changes with the broker, so that an
+		// invalid value is caught before applying for real.
+		alter := adm.AlterTopicConfigs
+		if *dryRun {
+			alter = adm.ValidateAlterTopicConfigs
+		}
+		resps, err := alter(ctx, alters, topic)
+		if err == nil {
+			var resp kadm.AlterConfigsResponse
+			resp, err = resps.On(topic, nil)
+			if err == nil {
+				err = resp.Err
+			}
+		}
+		if err != nil {
+			die("unable to alter configs for topic %s: %v", topic, err)
+		}
+	}
+	if !changed {
+		fmt.Println("configs are up to date")
+	}
+}
+
+// diffConfigs prints and returns the alterations needed to move the topic's
+// current configs to the declared configs.
+func diffConfigs(topic string, rc kadm.ResourceConfig, declared map[string]*string) []kadm.AlterConfig {
+	current := make(map[string]kadm.Config, len(rc.Configs))
+	for _, c := range rc.Configs {
+		current[c.Key] = c
+	}
+
+	keys := make([]string, 0, len(declared))
+	for key := range declared {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var alters []kadm.AlterConfig
+	for _, key := range keys {
+		want := declared[key]
+		c, exists := current[key]
+		isSet := exists && c.Source == kmsg.ConfigSourceDynamicTopicConfig
+
+		switch {
+		case want == nil && isSet:
+			fmt.Printf("topic %s: - %s=%s\n", topic, key, c.MaybeValue())
+			alters = append(alters, kadm.AlterConfig{Op: kadm.DeleteConfig, Name: key})
+		case want != nil && (!isSet || c.MaybeValue() != *want):
+			if isSet {
+				fmt.Printf("topic %s: ~ %s=%s => %s\n", topic, key, c.MaybeValue(), *want)
+			} else {
+				fmt.Printf("topic %s: + %s=%s\n", topic, key, *want)
+			}
+			alters = append(alters, kadm.AlterConfig{Op: kadm.SetConfig, Name: key, Value: want})
+		}
+	}
+	return alters
+}
+
+//////////
+// ACLS //
+//////////
+
+// The ACLs file is a list of ACLs. Every principal in the file is managed:
+// ACLs for these principals that are in the file but not in the cluster are
+// created, and ACLs for these principals that are in the cluster but not in
+// the file are deleted. ACLs for other principals are left alone.
+//
+//	[
+//	  {"principal": "User:etl", "resource_type": "topic", "resource_name": "events", "operation": "read"},
+//	  {"principal": "User:etl", "resource_type": "group", "resource_name": "etl-", "pattern": "prefixed", "operation": "read"}
+//	]
+//
+// The host defaults to "*", the pattern to "literal", and the permission to
+// "allow".
+type declaredACL struct {
+	Principal    string `json:"principal"`
+	Host         string `json:"host"`
+	ResourceType string `json:"resource_type"`
+	ResourceName string `json:"resource_name"`
+	Pattern      string `json:"pattern"`
+	Operation    string `json:"operation"`
+	Permission   string `json:"permission"`
+}
+
+// acl is a fully parsed ACL that can be compared with described ACLs.
+type acl struct {
+	principal  string
+	host       string
+	typ        kmsg.ACLResourceType
+	name       string
+	pattern    kadm.ACLPattern
+	operation  kadm.ACLOperation
+	permission kmsg.ACLPermissionType
+}
+
+func (a acl) String() string {
+	return fmt.Sprintf("%s %s %s on %s %s (%s) from host %s",
+		a.principal,
+		strings.ToLower(a.permission.String()),
+		strings.ToLower(a.operation.String()),
+		strings.ToLower(a.typ.String()),
+		a.name,
+		strings.ToLower(a.pattern.String()),
+		a.host,
+	)
+}
+
+// builder returns an ACL builder for exactly this ACL, which can be used to
+// create or delete it.
+func (a acl) builder() *kadm.ACLBuilder {
+	b := kadm.NewACLs().
+		Operations(a.operation).
+		ResourcePatternType(a.pattern)
+	switch a.typ {
+	case kmsg.ACLResourceTypeTopic:
+		b.Topics(a.name)
+	case kmsg.ACLResourceTypeGroup:
+		b.Groups(a.name)
+	case kmsg.ACLResourceTypeCluster:
+		b.Clusters()
+	case kmsg.ACLResourceTypeTransactionalId:
+		b.TransactionalIDs(a.name)
+	case kmsg.ACLResourceTypeDelegationToken:
+		b.DelegationTokens(a.name)
+	}
+	if a.permission == kmsg.ACLPermissionTypeAllow {
+		b.Allow(a.principal).AllowHosts(a.host)
+	} else {
+		b.Deny(a.principal).DenyHosts(a.host)
+	}
+	return b
+}
+
+func (d declaredACL) parse() (acl, error) {
+	a := acl{
+		principal:  d.Principal,
+		host:       d.Host,
+		name:       d.ResourceName,
+		pattern:    kadm.ACLPatternLiteral,
+		permission: kmsg.ACLPermissionTypeAllow,
+	}
+	if a.principal == "" {
+		return a, fmt.Errorf("missing principal")
+	}
+	if a.host == "" {
+		a.host = "*"
+	}
+
+	var err error
+	if a.typ, err = kmsg.ParseACLResourceType(d.ResourceType); err != nil {
+		return a, err
+	}
+	if a.typ == kmsg.ACLResourceTypeCluster {
+		a.name = "kafka-cluster"
+	}
+	if a.operation, err = kmsg.ParseACLOperation(d.Operation); err != nil {
+		return a, err
+	}
+	if d.Pattern != "" {
+		if a.pattern, err = kmsg.ParseACLResourcePatternType(d.Pattern); err != nil {
+			return a, err
+		}
+	}
+	if d.Permission != "" {
+		if a.permission, err = kmsg.ParseACLPermissionType(d.Permission); err != nil {
+			return a, err
+		}
+	}
+	return a, a.builder().ValidateCreate()
+}
+
+func applyACLs(ctx context.Context, adm *kadm.Client, raw []byte) {
+	var declared []declaredACL
+	if err := json.Unmarshal(raw, &declared); err != nil {
+		die("unable to parse ACLs: %v", err)
+	}
+
+	want := make(map[acl]bool)
+	principals := make(map[string]bool)
+	for i, d := range declared {
+		a, err := d.parse()
+		if err != nil {
+			die("invalid ACL %d: %v", i, err)
+		}
+		want[a] = true
+		principals[a.principal] = true
+	}
+	if len(principals) == 0 {
+		fmt.Println("no ACLs declared")
+		return
+	}
+
+	var managed []string
+	for principal := range principals {
+		managed = append(managed, principal)
+	}
+	sort.Strings(managed)
+
+	// We describe every ACL for every managed principal, on any resource,
+	// with any pattern, for any operation, from any host.
+	results, err := adm.DescribeACLs(ctx, kadm.NewACLs().
+		AnyResource().
+		Allow(managed...).AllowHosts().
+		Deny(managed...).DenyHosts().
+		Operations().
+		ResourcePatternType(kadm.ACLPatternAny),
+	)
+	if err != nil {
+		die("unable to describe ACLs: %v", err)
+	}
+	have := make(map[acl]bool)
+	for _, r := range results {
+		if r.Err != nil {
+			die("unable to describe ACLs: %v", r.Err)
+		}
+		for _, d := range r.Described {
+			have[acl{d.Principal, d.Host, d.Type, d.Name, d.Pattern, d.Operation, d.Permission}] = true
+		}
+	}
+
+	var create, remove []acl
+	for a := range want {
+		if !have[a] {
+			create = append(create, a)
+		}
+	}
+	for a := range have {
+		if !want[a] {
+			remove = append(remove, a)
+		}
+	}
+	sortACLs(create)
+	sortACLs(remove)
+
+	if len(create) == 0 && len(remove) == 0 {
+		fmt.Println("ACLs are up to date")
+		return
+	}
+	for _, a := range create {
+		fmt.Printf("+ %s\n", a)
+	}
+	for _, a := range remove {
+		fmt.Printf("- %s\n", a)
+	}
+	if *dryRun {
+		return
+	}
+
+	for _, a := range create {
+		results, err := adm.CreateACLs(ctx, a.builder())
+		if err != nil {
+			die("unable to create ACL %s: %v", a, err)
+		}
+		for _, r := range results {
+			if r.Err != nil {
+				die("unable to create ACL %s: %v", a, r.Err)
+			}
+		}
+	}
+	for _, a := range remove {
+		results, err := adm.DeleteACLs(ctx, a.builder())
+		if err != nil {
+			die("unable to delete ACL %s: %v", a, err)
+		}
+		for _, r := range results {
+			if r.Err != nil {
+				die("unable to delete ACL %s: %v", a, r.Err)
+			}
+		}
+	}
+}
+
+func sortACLs(as []acl) {
+	sort.Slice(as, func(i, j int) bool { return as[i].String() < as[j].String() })
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+var (
+	seedBrokers = flag.String("brokers", "localhost:9092", "comma delimited list of seed brokers")
+	groups      = flag.String("groups", "", "comma delimited list of groups to describe, or empty for all groups")
+	asJSON      = flag.Bool("json", false, "print JSON rather than a table")
+)
+
+func die(msg string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, msg+"\n", args...)
+	os.Exit(1)
+}
+
+// describedLag is one row of output: a partition, the member consuming it,
+// and how far behind the member is.
+type describedLag struct {
+	Topic      string `json:"topic"`
+	Partition  int32  `json:"partition"`
+	Commit     int64  `json:"commit"`
+	End        int64  `json:"end"`
+	Lag        int64  `json:"lag"`
+	MemberID   string `json:"member_id,omitempty"`
+	InstanceID string `json:"instance_id,omitempty"`
+	ClientID   string `json:"client_id,omitempty"`
+	ClientHost string `json:"client_host,omitempty"`
+	Err        string `json:"error,omitempty"`
+}
+
+type describedGroup struct {
+	Group    string         `json:"group"`
+	State    string         `json:"state"`
+	Protocol string         `json:"protocol"`
+	Members  int            `json:"members"`
+	Lag      int64          `json:"total_lag"`
+	Err      string         `json:"error,omitempty"`
+	Lags     []describedLag `json:"partitions"`
+}
+
+func main() {
+	flag.Parse()
+
+	cl, err := kgo.NewClient(kgo.SeedBrokers(strings.Split(*seedBrokers, ",")...))
+	if err != nil {
+		die("unable to create client: %v", err)
+	}
+	adm := kadm.NewClient(cl)
+	defer adm.Close()
+
+	ctx := context.Background()
+
+	var names []string
+	if *groups != "" {
+		names = strings.Split(*groups, ",")
+	} else {
+		listed, err := adm.ListGroups(ctx)
+		if err != nil {
+			die("unable to list groups: %v", err)
+		}
+		names = listed.Groups()
+	}
+
+	described, err := adm.DescribeGroups(ctx, names...)
+	if err != nil {
+		die("unable to describe groups: %v", err)
+	}
+
+	var out []describedGroup
+	for _, g := range described.Sorted() {
+		dg := describedGroup{
+			Group:    g.Group,
+			State:    g.State,
+			Protocol: g.Protocol,
+			Members:  len(g.Members),
+		}
+		if g.Err != nil {
+			dg.Err = g.Err.Error()
+			out = append(out, dg)
+			continue
+		}
+
+		// Lag can only be calculated for consumer groups, which
+		// commit offsets.
+		if g.ProtocolType == "consumer" || g.State == "Empty" {
+			if err := describeLag(ctx, adm, g, &dg); err != nil {
+				dg.Err = err.Error()
+			}
+		}
+		out = append(out, dg)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(out); err != nil {
+			die("unable to encode output: %v", err)
+		}
+		return
+	}
+	printTable(out)
+}
+
+// describeLag joins the group's committed offsets and end offsets with the
+// members consuming each partition.
+func describeLag(ctx context.Context, adm *kadm.Client, g kadm.DescribedGroup, dg *describedGroup) error {
+	commits, err := adm.FetchOffsets(ctx, g.Group)
+	if err != nil {
+		return fmt.Errorf("unable to fetch offsets: %v", err)
+	}
+
+	// An Empty group has no members and thus no assignments, so we
+	// calculate lag against everything the group has committed.
+	var topics []string
+	if g.State == "Empty" {
+		for topic := range commits {
+			topics = append(topics, topic)
+		}
+	} else {
+		topics = g.AssignedPartitions().Topics()
+	}
+	if len(topics) == 0 {
+		return nil
+	}
+
+	ends, err := adm.ListEndOffsets(ctx, topics...)
+	if err != nil {
+		return fmt.Errorf("unable to list end offsets: %v", err)
+	}
+
+	for _, l := range kadm.CalculateGroupLag(g, commits, ends).Sorted() {
+		dl := describedLag{
+			Topic:     l.End.Topic,
+			Partition: l.End.Partition,
+			Commit:    l.Commit.At,
+			End:       l.End.Offset,
+			Lag:       l.Lag,
+		}
+		if m := l.Member; m != nil {
+			dl.MemberID = m.MemberID
+			dl.ClientID = m.ClientID
+			dl.ClientHost = m.ClientHost
+			if m.InstanceID != nil {
+				dl.InstanceID = *m.InstanceID
+			}
+		}
+		if l.Err != nil {
+			dl.Err = l.Err.Error()
+		} else {
+			dg.Lag += l.Lag
+		}
+		dg.Lags = append(dg.Lags, dl)
+	}
+	return nil
+}
+
+func printTable(out []describedGroup) {
+	tw := tabwriter.NewWriter(os.Stdout, 6, 4, 2, ' ', 0)
+	defer tw.Flush()
+
+	for i, g := range out {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "GROUP\t%s\n", g.Group)
+		fmt.Fprintf(tw, "STATE\t%s\n", g.State)
+		fmt.Fprintf(tw, "BALANCER\t%s\n", g.Protocol)
+		fmt.Fprintf(tw, "MEMBERS\t%d\n", g.Members)
+		fmt.Fprintf(tw, "TOTAL-LAG\t%d\n", g.Lag)
+		if g.Err != "" {
+			fmt.Fprintf(tw, "ERROR\t%s\n", g.Err)
+		}
+		if len(g.Lags) == 0 {
+			continue
+		}
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "TOPIC\tPARTITION\tCURRENT-OFFSET\tLOG-END-OFFSET\tLAG\tMEMBER-ID\tINSTANCE-ID\tCLIENT-ID\tHOST\tERROR")
+		for _, l := range g.Lags {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%s\t%s\t%s\t%s\t%s\n",
+				l.Topic, l.Partition, l.Commit, l.End, l.Lag,
+				l.MemberID, l.InstanceID, l.ClientID, l.ClientHost, l.Err)
+		}
+	}
+}
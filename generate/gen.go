@@ -183,6 +183,13 @@ func (s Struct) WriteAppend(l *LineWriter) {
 			break
 		}
 	}
+	// Versioned tags are only counted at their version, which requires
+	// building the tags to encode.
+	for _, f := range tags {
+		if f.MinVersion > 0 {
+			tagsCanDefault = true
+		}
+	}
 
 	defer l.Write("dst = v.UnknownTags.AppendEach(dst)")
 
@@ -190,6 +197,9 @@ func (s Struct) WriteAppend(l *LineWriter) {
 		l.Write("var toEncode []uint32")
 		for i := 0; i < len(tags); i++ {
 			f := tags[i]
+			if f.MinVersion > 0 {
+				l.Write("if version >= %d {", f.MinVersion)
+			}
 			canDefault := false
 			if d, ok := f.Type.(Defaulter); ok {
 				canDefault = true
@@ -208,6 +218,9 @@ func (s Struct) WriteAppend(l *LineWriter) {
 			if canDefault {
 				l.Write("}")
 			}
+			if f.MinVersion > 0 {
+				l.Write("}")
+			}
 		}
 
 		l.Write("dst = kbin.AppendUvarint(dst, uint32(len(toEncode) + v.UnknownTags.Len()))")
@@ -274,7 +287,11 @@ func (f StructField) writeBeginAndTag(l *LineWriter, tags map[int]StructField) (
 		if _, exists := tags[f.Tag]; exists {
 			die("unexpected duplicate tag %d on field %s", f.Tag, f.FieldName)
 		}
+		if f.MaxVersion > -1 {
+			die("unexpected max version %d with tag %d on field %s", f.MaxVersion, f.Tag, f.FieldName)
+		}
 		tags[f.Tag] = f
+		return true // a tag with a min version is only encoded at that version
 	}
 	if f.MaxVersion > -1 {
 		l.Write("if version >= %d && version <= %d {", f.MinVersion, f.MaxVersion)
@@ -517,6 +534,12 @@ func (s Struct) WriteDecode(l *LineWriter) {
 		}
 
 		l.Write("case %d:", i)
+		if f.MinVersion > 0 {
+			l.Write("if version < %d {", f.MinVersion)
+			l.Write("s.UnknownTags.Set(key, b.Span(int(b.Uvarint())))")
+			l.Write("continue")
+			l.Write("}")
+		}
 		l.Write("b := kbin.Reader{Src: b.Span(int(b.Uvarint()))}")
 		f.WriteDecode(l)
 		l.Write("if err := b.Complete(); err != nil {")
@@ -7,7 +7,7 @@ require (
 	github.com/jcmturner/gokrb5/v8 v8.4.2
 	github.com/klauspost/compress v1.13.6
 	github.com/pierrec/lz4/v4 v4.1.11
-	github.com/twmb/franz-go/pkg/kmsg v0.0.0-20211127185622-3b34db0c6d1e
+	github.com/twmb/franz-go/pkg/kmsg v0.0.0-20261017132737-11801862ee69
 	github.com/twmb/go-rbtree v1.0.0
 	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
 	golang.org/x/net v0.0.0-20211123203042-d83791d6bcd9 // indirect
@@ -0,0 +1,164 @@
+package kadm
+
+import (
+	"context"
+	"sort"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// ReplicaSize is the size of one replica of a partition in one log directory
+// on one broker.
+type ReplicaSize struct {
+	Broker    int32  // Broker is the broker the replica is on.
+	Dir       string // Dir is the log directory the replica is in.
+	Size      int64  // Size is the size of the replica on disk, in bytes.
+	OffsetLag int64  // OffsetLag is how far this replica is behind the log end offset, or how far a future replica is behind the current replica.
+	IsFuture  bool   // IsFuture is whether this replica is being moved to this directory.
+}
+
+// PartitionSize is the on disk size of all replicas of a partition.
+type PartitionSize struct {
+	Topic     string        // Topic is the topic this partition belongs to.
+	Partition int32         // Partition is the partition number.
+	Size      int64         // Size is the sum of the size of all replicas, including future replicas.
+	Replicas  []ReplicaSize // Replicas are the replicas of this partition, sorted by broker and dir.
+}
+
+// TopicSize is the on disk size of all replicas of all partitions of a topic.
+type TopicSize struct {
+	Topic      string                  // Topic is the topic these sizes are for.
+	Size       int64                   // Size is the sum of the size of all partitions.
+	Partitions map[int32]PartitionSize // Partitions contains the size of every partition.
+
+	Err error // Err is non-nil if the topic could not be loaded from metadata.
+}
+
+// Sorted returns the partitions in sorted order.
+func (t TopicSize) Sorted() []PartitionSize {
+	s := make([]PartitionSize, 0, len(t.Partitions))
+	for _, p := range t.Partitions {
+		s = append(s, p)
+	}
+	sort.Slice(s, func(i, j int) bool { return s[i].Partition < s[j].Partition })
+	return s
+}
+
+// TopicSizes contains the on disk size of topics.
+type TopicSizes map[string]TopicSize
+
+// Sorted returns all topics in sorted order.
+func (ts TopicSizes) Sorted() []TopicSize {
+	s := make([]TopicSize, 0, len(ts))
+	for _, t := range ts {
+		s = append(s, t)
+	}
+	sort.Slice(s, func(i, j int) bool { return s[i].Topic < s[j].Topic })
+	return s
+}
+
+// BrokerSizes returns the sum of the size of all replicas of these topics per
+// broker.
+func (ts TopicSizes) BrokerSizes() map[int32]int64 {
+	sizes := make(map[int32]int64)
+	for _, t := range ts {
+		for _, p := range t.Partitions {
+			for _, r := range p.Replicas {
+				sizes[r.Broker] += r.Size
+			}
+		}
+	}
+	return sizes
+}
+
+// DescribeTopicSizes returns the approximate on disk size of every replica of
+// every partition of the requested topics, or all topics if none are
+// requested. This issues a metadata request to find the topics' partitions
+// and then a DescribeLogDirs request to every broker that has a replica.
+//
+// Every partition in metadata is returned, even if no broker reports a size
+// for it; such partitions have no replicas and a size of zero. Sizes are what
+// each broker reports for the replica's log segments and can be slightly
+// stale.
+//
+// This may return *ShardErrors or an *AuthError.
+func (cl *Client) DescribeTopicSizes(ctx context.Context, topics ...string) (TopicSizes, error) {
+	m, err := cl.Metadata(ctx, topics...)
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := make(TopicSizes, len(m.Topics))
+	req := kmsg.NewPtrDescribeLogDirsRequest()
+	for _, td := range m.Topics.Sorted() {
+		if td.Topic == "" {
+			continue
+		}
+		ts := TopicSize{
+			Topic:      td.Topic,
+			Partitions: make(map[int32]PartitionSize, len(td.Partitions)),
+			Err:        td.Err,
+		}
+		rt := kmsg.NewDescribeLogDirsRequestTopic()
+		rt.Topic = td.Topic
+		for _, p := range td.Partitions.Numbers() {
+			ts.Partitions[p] = PartitionSize{Topic: td.Topic, Partition: p}
+			rt.Partitions = append(rt.Partitions, p)
+		}
+		sizes[td.Topic] = ts
+		if len(rt.Partitions) > 0 {
+			req.Topics = append(req.Topics, rt)
+		}
+	}
+	if len(req.Topics) == 0 {
+		return sizes, nil
+	}
+
+	shards := cl.cl.RequestSharded(ctx, req)
+	err = shardErrEachBroker(req, shards, func(b BrokerDetail, kr kmsg.Response) error {
+		resp := kr.(*kmsg.DescribeLogDirsResponse)
+		for _, d := range resp.Dirs {
+			if err := maybeAuthErr(d.ErrorCode); err != nil {
+				return err
+			}
+			if kerr.ErrorForCode(d.ErrorCode) != nil {
+				continue // offline directories have no topics
+			}
+			for _, t := range d.Topics {
+				ts, ok := sizes[t.Topic]
+				if !ok {
+					continue
+				}
+				for _, p := range t.Partitions {
+					ps, ok := ts.Partitions[p.Partition]
+					if !ok {
+						continue
+					}
+					ps.Size += p.Size
+					ps.Replicas = append(ps.Replicas, ReplicaSize{
+						Broker:    b.NodeID,
+						Dir:       d.Dir,
+						Size:      p.Size,
+						OffsetLag: p.OffsetLag,
+						IsFuture:  p.IsFuture,
+					})
+					ts.Partitions[p.Partition] = ps
+					ts.Size += p.Size
+				}
+				sizes[t.Topic] = ts
+			}
+		}
+		return nil
+	})
+
+	for _, ts := range sizes {
+		for _, ps := range ts.Partitions {
+			sort.Slice(ps.Replicas, func(i, j int) bool {
+				l, r := ps.Replicas[i], ps.Replicas[j]
+				return l.Broker < r.Broker || l.Broker == r.Broker && l.Dir < r.Dir
+			})
+		}
+	}
+	return sizes, err
+}
@@ -0,0 +1,218 @@
+package kadm
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// ReplicaPlacements maps topics to partitions to the brokers each partition's
+// replicas should be placed on. The first broker for each partition is the
+// preferred leader.
+type ReplicaPlacements map[string]map[int32][]int32
+
+// Request returns the placements as a request to alter partition assignments,
+// with topics and partitions sorted. The request's timeout must be set before
+// issuing it.
+func (ps ReplicaPlacements) Request() *kmsg.AlterPartitionAssignmentsRequest {
+	req := kmsg.NewPtrAlterPartitionAssignmentsRequest()
+	topics := make([]string, 0, len(ps))
+	for t := range ps {
+		topics = append(topics, t)
+	}
+	sort.Strings(topics)
+	for _, t := range topics {
+		rt := kmsg.NewAlterPartitionAssignmentsRequestTopic()
+		rt.Topic = t
+		ts := ps[t]
+		partitions := make([]int32, 0, len(ts))
+		for p := range ts {
+			partitions = append(partitions, p)
+		}
+		for _, p := range int32s(partitions) {
+			rp := kmsg.NewAlterPartitionAssignmentsRequestTopicPartition()
+			rp.Partition = p
+			rp.Replicas = ts[p]
+			rt.Partitions = append(rt.Partitions, rp)
+		}
+		req.Topics = append(req.Topics, rt)
+	}
+	return req
+}
+
+// SuggestReplicaPlacements proposes a balanced placement of replicas for the
+// given topics, or all non-internal topics if none are given, and returns the
+// placements for only the partitions whose set of replicas would change. The
+// order of replicas in metadata is not preserved, so partitions that would
+// only have a different preferred leader are not returned. The returned
+// placements can be issued with an AlterPartitionAssignmentsRequest; see the
+// Request method.
+//
+// Replicas are placed round-robin across brokers sorted by node ID, with
+// partition leaders spread across brokers and across topics. If every broker
+// has a rack, brokers are interleaved by rack and each partition's replicas
+// are placed in as many distinct racks as possible; if only some brokers have
+// a rack, racks are ignored. Each partition keeps its current replication
+// factor. Topics and partitions that failed to load are skipped.
+//
+// This is a simple placement that does not consider partition sizes or
+// broker load, and the placement for a topic can change as brokers are added
+// or removed. This returns an error if a topic has a replication factor larger
+// than the number of brokers.
+func (m Metadata) SuggestReplicaPlacements(topics ...string) (ReplicaPlacements, error) {
+	brokers := placementBrokers(m.Brokers)
+	if brokers.len() == 0 {
+		return nil, fmt.Errorf("no brokers to place replicas on")
+	}
+
+	if len(topics) == 0 {
+		for _, t := range m.Topics.Sorted() {
+			if !t.IsInternal && t.Topic != "" {
+				topics = append(topics, t.Topic)
+			}
+		}
+	} else {
+		topics = append([]string(nil), topics...)
+		sort.Strings(topics)
+	}
+
+	ps := make(ReplicaPlacements)
+	var start int // spreads leaders across topics
+	for _, topic := range topics {
+		td, ok := m.Topics[topic]
+		if !ok || td.Err != nil {
+			continue
+		}
+		for _, pd := range td.Partitions.Sorted() {
+			rf := len(pd.Replicas)
+			if pd.Err != nil || rf == 0 {
+				continue
+			}
+			if rf > brokers.len() {
+				return nil, fmt.Errorf("topic %s partition %d has replication factor %d, which is larger than the %d available brokers", topic, pd.Partition, rf, brokers.len())
+			}
+			replicas := brokers.place(start+int(pd.Partition), rf)
+			if !sameReplicas(replicas, pd.Replicas) {
+				if ps[topic] == nil {
+					ps[topic] = make(map[int32][]int32)
+				}
+				ps[topic][pd.Partition] = replicas
+			}
+		}
+		start += len(td.Partitions)
+	}
+	return ps, nil
+}
+
+type placementBroker struct {
+	id   int32
+	rack string
+}
+
+// placementOrder is brokers in the order that replicas are placed.
+type placementOrder struct {
+	brokers  []placementBroker
+	numRacks int // 0 if racks are ignored
+}
+
+// placementBrokers returns brokers sorted by node ID or, if every broker has a
+// rack, interleaved by rack: the first broker of every rack, then the second
+// broker of every rack, and so on.
+func placementBrokers(bs BrokerDetails) placementOrder {
+	var o placementOrder
+	byRack := make(map[string][]placementBroker)
+	allRacks := true
+	for _, b := range bs {
+		if b.NodeID < 0 {
+			continue
+		}
+		pb := placementBroker{id: b.NodeID}
+		if b.Rack != nil {
+			pb.rack = *b.Rack
+		} else {
+			allRacks = false
+		}
+		o.brokers = append(o.brokers, pb)
+		byRack[pb.rack] = append(byRack[pb.rack], pb)
+	}
+	sort.Slice(o.brokers, func(i, j int) bool { return o.brokers[i].id < o.brokers[j].id })
+	if !allRacks || len(byRack) < 2 {
+		return o
+	}
+
+	racks := make([]string, 0, len(byRack))
+	for rack, rbs := range byRack {
+		racks = append(racks, rack)
+		sort.Slice(rbs, func(i, j int) bool { return rbs[i].id < rbs[j].id })
+	}
+	sort.Strings(racks)
+
+	total := len(o.brokers)
+	o.brokers = o.brokers[:0]
+	o.numRacks = len(racks)
+	for i := 0; len(o.brokers) < total; i++ {
+		for _, rack := range racks {
+			if rbs := byRack[rack]; i < len(rbs) {
+				o.brokers = append(o.brokers, rbs[i])
+			}
+		}
+	}
+	return o
+}
+
+func (o placementOrder) len() int { return len(o.brokers) }
+
+// place returns rf replicas for the n'th partition. The first replica is the
+// n'th broker, and the remaining replicas follow at a shift that changes
+// every round through all brokers so that followers are not always placed
+// next to the same leader. When using racks, followers prefer brokers in
+// racks that the partition does not have a replica in yet.
+func (o placementOrder) place(n, rf int) []int32 {
+	num := len(o.brokers)
+	first := n % num
+	shift := 1
+	if num > 1 {
+		shift += (n / num) % (num - 1)
+	}
+
+	replicas := []int32{o.brokers[first].id}
+	used := map[int32]bool{o.brokers[first].id: true}
+	usedRacks := map[string]bool{o.brokers[first].rack: true}
+	for len(replicas) < rf {
+		chosen := -1
+		for k := 0; k < num; k++ {
+			idx := (first + shift + k) % num
+			b := o.brokers[idx]
+			if used[b.id] {
+				continue
+			}
+			if o.numRacks > 0 && len(usedRacks) < o.numRacks && usedRacks[b.rack] {
+				continue
+			}
+			chosen = idx
+			break
+		}
+		b := o.brokers[chosen]
+		replicas = append(replicas, b.id)
+		used[b.id] = true
+		usedRacks[b.rack] = true
+	}
+	return replicas
+}
+
+// sameReplicas returns whether l and r contain the same brokers, ignoring
+// order.
+func sameReplicas(l, r []int32) bool {
+	if len(l) != len(r) {
+		return false
+	}
+	l = int32s(append([]int32(nil), l...))
+	r = int32s(append([]int32(nil), r...))
+	for i := range l {
+		if l[i] != r[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,217 @@
+package kadm
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func placementMetadata(brokers BrokerDetails, topics map[string][][]int32) Metadata {
+	m := Metadata{
+		Brokers: brokers,
+		Topics:  make(TopicDetails),
+	}
+	for topic, partitions := range topics {
+		td := TopicDetail{
+			Topic:      topic,
+			Partitions: make(PartitionDetails),
+		}
+		for p, replicas := range partitions {
+			td.Partitions[int32(p)] = PartitionDetail{
+				Topic:     topic,
+				Partition: int32(p),
+				Leader:    replicas[0],
+				Replicas:  replicas,
+			}
+		}
+		m.Topics[topic] = td
+	}
+	return m
+}
+
+func placementBrokerDetails(racks ...string) BrokerDetails {
+	var bs BrokerDetails
+	for i, rack := range racks {
+		b := BrokerDetail{NodeID: int32(i + 1)}
+		if rack != "" {
+			rack := rack
+			b.Rack = &rack
+		}
+		bs = append(bs, b)
+	}
+	return bs
+}
+
+func TestSuggestReplicaPlacements(t *testing.T) {
+	// Brokers are placed in ID order, and leaders continue across topics:
+	// a is partitions 0 through 2, b is partitions 3 and 4. The expected
+	// placement is
+	//
+	//	a: [1 2] [2 3] [3 1]
+	//	b: [1 3] [2 1]
+	//
+	// Partitions whose replica set already matches are not returned, even
+	// if their preferred leader differs.
+	m := placementMetadata(
+		placementBrokerDetails("", "", ""),
+		map[string][][]int32{
+			"a":                  {{2, 1}, {1, 2}, {3, 1}},
+			"b":                  {{1, 2}, {1, 2}},
+			"__consumer_offsets": {{1, 2}},
+		},
+	)
+	internal := m.Topics["__consumer_offsets"]
+	internal.IsInternal = true
+	m.Topics["__consumer_offsets"] = internal
+
+	got, err := m.SuggestReplicaPlacements()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	exp := ReplicaPlacements{
+		"a": {1: {2, 3}},
+		"b": {0: {1, 3}},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("got %v != exp %v", got, exp)
+	}
+
+	// Requesting topics explicitly includes internal topics and skips
+	// unknown topics. __consumer_offsets sorts first and is placed as
+	// partition 0, so b is placed as partitions 1 and 2.
+	got, err = m.SuggestReplicaPlacements("b", "unknown", "__consumer_offsets")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	exp = ReplicaPlacements{
+		"b": {0: {2, 3}, 1: {3, 1}},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("got %v != exp %v", got, exp)
+	}
+}
+
+func TestSuggestReplicaPlacementsRacks(t *testing.T) {
+	// Brokers 1 and 2 are in rack r1, 3 and 4 in rack r2; interleaved by
+	// rack, the placement order is 1 3 2 4, and every partition has a
+	// replica in each rack.
+	m := placementMetadata(
+		placementBrokerDetails("r1", "r1", "r2", "r2"),
+		map[string][][]int32{
+			"t": {{1, 2}, {1, 2}, {1, 2}, {1, 2}},
+		},
+	)
+	got, err := m.SuggestReplicaPlacements()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	exp := ReplicaPlacements{
+		"t": {
+			0: {1, 3},
+			1: {3, 2},
+			2: {2, 4},
+			3: {4, 1},
+		},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("got %v != exp %v", got, exp)
+	}
+
+	// If any broker is missing a rack, racks are ignored and brokers are
+	// placed in ID order.
+	m = placementMetadata(
+		placementBrokerDetails("r1", "r1", "", "r2"),
+		map[string][][]int32{
+			"t": {{3, 4}, {3, 4}},
+		},
+	)
+	got, err = m.SuggestReplicaPlacements()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	exp = ReplicaPlacements{
+		"t": {
+			0: {1, 2},
+			1: {2, 3},
+		},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("got %v != exp %v", got, exp)
+	}
+}
+
+func TestSuggestReplicaPlacementsSkipsAndErrors(t *testing.T) {
+	m := placementMetadata(
+		placementBrokerDetails("", ""),
+		map[string][][]int32{
+			"ok":        {{2}},
+			"topic_err": {{2}},
+			"part_err":  {{2}, {2}},
+		},
+	)
+	td := m.Topics["topic_err"]
+	td.Err = errors.New("topic load failure")
+	m.Topics["topic_err"] = td
+	pd := m.Topics["part_err"].Partitions[0]
+	pd.Err = errors.New("partition load failure")
+	m.Topics["part_err"].Partitions[0] = pd
+
+	got, err := m.SuggestReplicaPlacements()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	// Topics sort as ok, part_err, topic_err. Errored partitions still
+	// count toward spreading leaders, so part_err partition 1 is placed as
+	// partition 2, on broker 1.
+	exp := ReplicaPlacements{
+		"ok":       {0: {1}},
+		"part_err": {1: {1}},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("got %v != exp %v", got, exp)
+	}
+
+	// A replication factor larger than the number of brokers cannot be
+	// placed.
+	m = placementMetadata(
+		placementBrokerDetails("", ""),
+		map[string][][]int32{"t": {{1, 2, 3}}},
+	)
+	if _, err := m.SuggestReplicaPlacements(); err == nil {
+		t.Error("expected error for replication factor larger than the number of brokers")
+	}
+
+	// With no brokers, nothing can be placed.
+	m = placementMetadata(nil, map[string][][]int32{"t": {{1}}})
+	if _, err := m.SuggestReplicaPlacements(); err == nil {
+		t.Error("expected error with no brokers")
+	}
+}
+
+func TestReplicaPlacementsRequest(t *testing.T) {
+	ps := ReplicaPlacements{
+		"b": {2: {3, 1}, 0: {1, 2}},
+		"a": {1: {2, 3}},
+	}
+	req := ps.Request()
+
+	type placement struct {
+		topic     string
+		partition int32
+		replicas  []int32
+	}
+	var got []placement
+	for _, rt := range req.Topics {
+		for _, rp := range rt.Partitions {
+			got = append(got, placement{rt.Topic, rp.Partition, rp.Replicas})
+		}
+	}
+	exp := []placement{
+		{"a", 1, []int32{2, 3}},
+		{"b", 0, []int32{1, 2}},
+		{"b", 2, []int32{3, 1}},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("got %v != exp %v", got, exp)
+	}
+}
@@ -1,4 +1,10 @@
 // Package kbin contains Kafka primitive reading and writing functions.
+//
+// This package is what kmsg uses to encode and decode Kafka messages, and is
+// stable: it can be used to build custom encodings that reuse Kafka's
+// primitives, such as group member user data or control record parsers. All
+// Append functions append big endian (or varint) encodings to the input slice
+// and return the extended slice, and Reader decodes the same encodings.
 package kbin
 
 import (
@@ -180,6 +186,47 @@ func AppendUvarint(dst []byte, u uint32) []byte {
 	return dst
 }
 
+// VarlongLen returns how long i would be if it were varlong encoded.
+func VarlongLen(i int64) int {
+	u := uint64(i)<<1 ^ uint64(i>>63)
+	return UvarlongLen(u)
+}
+
+// UvarlongLen returns how long u would be if it were uvarlong encoded.
+func UvarlongLen(u uint64) int {
+	if u < 0x80 {
+		return 1
+	}
+	return (bits.Len64(u) + 6) / 7
+}
+
+// Varlong is a 64 bit varint decoder. The return semantics are the same as
+// binary.Varint.
+func Varlong(in []byte) (int64, int) {
+	x, n := Uvarlong(in)
+	return int64((x >> 1) ^ -(x & 1)), n
+}
+
+// Uvarlong is a 64 bit uvarint decoder. The return semantics are the same as
+// binary.Uvarint.
+func Uvarlong(in []byte) (uint64, int) {
+	return binary.Uvarint(in)
+}
+
+// AppendVarlong appends a varlong encoded i to dst.
+func AppendVarlong(dst []byte, i int64) []byte {
+	return AppendUvarlong(dst, uint64(i)<<1^uint64(i>>63))
+}
+
+// AppendUvarlong appends a uvarlong encoded u to dst.
+func AppendUvarlong(dst []byte, u uint64) []byte {
+	for u >= 0x80 {
+		dst = append(dst, byte(u)|0x80)
+		u >>= 7
+	}
+	return append(dst, byte(u))
+}
+
 // AppendString appends a string to dst prefixed with its int16 length.
 func AppendString(dst []byte, s string) []byte {
 	dst = AppendInt16(dst, int16(len(s)))
@@ -438,6 +485,30 @@ func (b *Reader) Uvarint() uint32 {
 	return val
 }
 
+// Varlong returns a varlong int64 from the reader.
+func (b *Reader) Varlong() int64 {
+	val, n := Varlong(b.Src)
+	if n <= 0 {
+		b.bad = true
+		b.Src = nil
+		return 0
+	}
+	b.Src = b.Src[n:]
+	return val
+}
+
+// Uvarlong returns a uvarlong encoded uint64 from the reader.
+func (b *Reader) Uvarlong() uint64 {
+	val, n := Uvarlong(b.Src)
+	if n <= 0 {
+		b.bad = true
+		b.Src = nil
+		return 0
+	}
+	b.Src = b.Src[n:]
+	return val
+}
+
 // Span returns l bytes from the reader.
 func (b *Reader) Span(l int) []byte {
 	if len(b.Src) < l || l < 0 {
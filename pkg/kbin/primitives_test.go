@@ -47,3 +47,108 @@ func BenchmarkUvarint(b *testing.B) {
 		})
 	}
 }
+
+func TestVarint(t *testing.T) {
+	if err := quick.Check(func(i int32) bool {
+		var expPut [10]byte
+		n := binary.PutVarint(expPut[:], int64(i))
+
+		gotPut := AppendVarint(nil, i)
+		if !bytes.Equal(expPut[:n], gotPut) || VarintLen(i) != n {
+			return false
+		}
+
+		gotRead, gotN := Varint(gotPut)
+		return gotN == n && gotRead == i
+	}, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVarlong(t *testing.T) {
+	if err := quick.Check(func(i int64) bool {
+		var expPut [10]byte
+		n := binary.PutVarint(expPut[:], i)
+
+		gotPut := AppendVarlong(nil, i)
+		if !bytes.Equal(expPut[:n], gotPut) || VarlongLen(i) != n {
+			return false
+		}
+
+		gotRead, gotN := Varlong(gotPut)
+		return gotN == n && gotRead == i
+	}, nil); err != nil {
+		t.Error(err)
+	}
+
+	for _, u := range []uint64{0, 127, 128, 1<<63 - 1, 1<<64 - 1} {
+		var expPut [10]byte
+		n := binary.PutUvarint(expPut[:], u)
+		if gotPut := AppendUvarlong(nil, u); !bytes.Equal(expPut[:n], gotPut) || UvarlongLen(u) != n {
+			t.Errorf("uvarlong %d: got %x (len %d) != exp %x", u, gotPut, UvarlongLen(u), expPut[:n])
+		}
+	}
+}
+
+func TestReader(t *testing.T) {
+	uuid := [16]byte{1, 2, 3}
+	s := "foo"
+	var dst []byte
+	dst = AppendBool(dst, true)
+	dst = AppendInt8(dst, -8)
+	dst = AppendInt16(dst, -16)
+	dst = AppendInt32(dst, -32)
+	dst = AppendInt64(dst, -64)
+	dst = AppendFloat64(dst, 6.4)
+	dst = AppendUuid(dst, uuid)
+	dst = AppendVarint(dst, -1)
+	dst = AppendVarlong(dst, -1<<40)
+	dst = AppendUvarlong(dst, 1<<40)
+	dst = AppendString(dst, s)
+	dst = AppendCompactString(dst, s)
+	dst = AppendNullableString(dst, nil)
+	dst = AppendCompactNullableString(dst, &s)
+	dst = AppendBytes(dst, []byte(s))
+	dst = AppendCompactBytes(dst, []byte(s))
+	dst = AppendNullableBytes(dst, nil)
+	dst = AppendCompactNullableBytes(dst, nil)
+	dst = AppendVarintString(dst, s)
+	dst = AppendCompactArrayLen(dst, 0)
+
+	r := Reader{Src: dst}
+	for i, ok := range []bool{
+		r.Bool(),
+		r.Int8() == -8,
+		r.Int16() == -16,
+		r.Int32() == -32,
+		r.Int64() == -64,
+		r.Float64() == 6.4,
+		r.Uuid() == uuid,
+		r.Varint() == -1,
+		r.Varlong() == -1<<40,
+		r.Uvarlong() == 1<<40,
+		r.String() == s,
+		r.CompactString() == s,
+		r.NullableString() == nil,
+		*r.CompactNullableString() == s,
+		string(r.Bytes()) == s,
+		string(r.CompactBytes()) == s,
+		r.NullableBytes() == nil,
+		r.CompactNullableBytes() == nil,
+		r.VarintString() == s,
+		r.CompactArrayLen() == 0,
+	} {
+		if !ok {
+			t.Errorf("read %d was not as expected", i)
+		}
+	}
+	if err := r.Complete(); err != nil || len(r.Src) != 0 {
+		t.Errorf("reader not complete: err %v, %d bytes remaining", err, len(r.Src))
+	}
+
+	r = Reader{Src: dst[:1]}
+	r.Int32()
+	if r.Ok() || r.Complete() == nil {
+		t.Error("reader unexpectedly ok after reading past the end")
+	}
+}
@@ -0,0 +1,223 @@
+package kcompat
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// WriteErrors is returned from Writer.WriteMessages if any message failed to
+// be produced, mirroring kafka-go's WriteErrors. Each error corresponds to the
+// message at the same index, and is nil if the message was produced.
+type WriteErrors []error
+
+// Count returns the number of non-nil errors.
+func (es WriteErrors) Count() int {
+	var n int
+	for _, err := range es {
+		if err != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func (es WriteErrors) Error() string {
+	var sb strings.Builder
+	sb.WriteString("kafka write errors (")
+	var wrote bool
+	for _, err := range es {
+		if err == nil {
+			continue
+		}
+		if wrote {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(err.Error())
+		wrote = true
+	}
+	sb.WriteString(")")
+	return sb.String()
+}
+
+// Writer produces messages through a *kgo.Client, mirroring kafka-go's Writer.
+type Writer struct {
+	cl    *kgo.Client
+	topic string
+}
+
+// NewWriter returns a Writer that produces through cl. If topic is non-empty,
+// it is used for any message that does not specify its own topic. Producing is
+// configured through the client's options, e.g. kgo.RequiredAcks or
+// kgo.ProducerBatchCompression.
+func NewWriter(cl *kgo.Client, topic string) *Writer {
+	return &Writer{cl: cl, topic: topic}
+}
+
+// WriteMessages produces all messages and waits for them to be acknowledged.
+// If any message fails, this returns WriteErrors. If the context is canceled
+// before all messages are produced, this returns the context error.
+//
+// Messages are partitioned with the client's partitioner.
+func (w *Writer) WriteMessages(ctx context.Context, msgs ...Message) error {
+	rs := make([]*kgo.Record, 0, len(msgs))
+	for i := range msgs {
+		rs = append(rs, msgs[i].toRecord(w.topic))
+	}
+
+	results := w.cl.ProduceSync(ctx, rs...)
+	if results.FirstErr() == nil {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// ProduceSync returns results in the order of the input records.
+	errs := make(WriteErrors, len(msgs))
+	for i, result := range results {
+		errs[i] = result.Err
+	}
+	return errs
+}
+
+// Close flushes anything buffered in the client. This does not close the
+// client.
+func (w *Writer) Close() error {
+	return w.cl.Flush(context.Background())
+}
+
+// Reader consumes messages through a *kgo.Client, mirroring kafka-go's
+// Reader.
+//
+// Unlike kafka-go, what to consume is configured on the client, e.g. with
+// kgo.ConsumeTopics and kgo.ConsumerGroup. Consuming in a group is what
+// kafka-go calls using a GroupID.
+type Reader struct {
+	cl *kgo.Client
+
+	// quit is canceled when the reader is closed, which unblocks any
+	// concurrent poll.
+	quit   context.Context
+	cancel func()
+
+	mu     sync.Mutex
+	buf    []*kgo.Record
+	errs   []error
+	closed bool
+}
+
+// NewReader returns a Reader that consumes through cl.
+func NewReader(cl *kgo.Client) *Reader {
+	quit, cancel := context.WithCancel(context.Background())
+	return &Reader{cl: cl, quit: quit, cancel: cancel}
+}
+
+// FetchMessage returns the next message, blocking until one is available or
+// the context is canceled. This does not commit the message; see
+// CommitMessages.
+//
+// This returns io.EOF once the reader or the client is closed. Any fetch
+// error is returned once, after which fetching continues.
+func (r *Reader) FetchMessage(ctx context.Context) (Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for {
+		if r.closed {
+			return Message{}, io.EOF
+		}
+		if len(r.errs) > 0 {
+			err := r.errs[0]
+			r.errs = r.errs[1:]
+			return Message{}, err
+		}
+		if len(r.buf) > 0 {
+			rec := r.buf[0]
+			r.buf[0] = nil
+			r.buf = r.buf[1:]
+			return messageFromRecord(rec), nil
+		}
+
+		fetches := r.poll(ctx)
+		if fetches.IsClientClosed() || r.quit.Err() != nil {
+			r.closed = true
+			continue
+		}
+		// Anything polled is buffered before checking the context,
+		// so that records polled as ctx is canceled are returned
+		// from the next call rather than lost.
+		for _, fe := range fetches.Errors() {
+			r.errs = append(r.errs, fe.Err)
+		}
+		r.buf = fetches.Records()
+		if err := ctx.Err(); err != nil {
+			return Message{}, err
+		}
+	}
+}
+
+// poll polls the client until either ctx is canceled or the reader is closed.
+func (r *Reader) poll(ctx context.Context) kgo.Fetches {
+	pollCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-r.quit.Done():
+			cancel()
+		case <-pollCtx.Done():
+		}
+	}()
+	return r.cl.PollFetches(pollCtx)
+}
+
+// ReadMessage returns the next message, as FetchMessage does. If the client is
+// consuming in a group, the message is committed before it is returned.
+//
+// As with kafka-go, committing every message is slow; it is recommended to use
+// FetchMessage and CommitMessages instead, or to rely on the client's
+// autocommitting.
+func (r *Reader) ReadMessage(ctx context.Context) (Message, error) {
+	m, err := r.FetchMessage(ctx)
+	if err != nil {
+		return m, err
+	}
+	if _, inGroup := r.cl.GroupSnapshot(); inGroup {
+		if err := r.CommitMessages(ctx, m); err != nil {
+			return m, err
+		}
+	}
+	return m, nil
+}
+
+// CommitMessages synchronously commits the offsets following the given
+// messages. For each partition, the highest offset is committed. This returns
+// an error if the client is not consuming in a group.
+func (r *Reader) CommitMessages(ctx context.Context, msgs ...Message) error {
+	if _, inGroup := r.cl.GroupSnapshot(); !inGroup {
+		return errNotGroup
+	}
+	rs := make([]*kgo.Record, 0, len(msgs))
+	for i := range msgs {
+		rs = append(rs, msgs[i].commitRecord())
+	}
+	return r.cl.CommitRecords(ctx, rs...)
+}
+
+// Close stops the reader, after which FetchMessage returns io.EOF. This does
+// not close the client nor leave its group.
+func (r *Reader) Close() error {
+	r.cancel() // unblock a concurrent FetchMessage before we lock
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	r.buf = nil
+	r.errs = nil
+	return nil
+}
+
+var errNotGroup = errors.New("unable to commit messages when the client is not consuming in a group")
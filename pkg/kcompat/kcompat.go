@@ -0,0 +1,109 @@
+// Package kcompat provides adapters that mirror the shapes of commonly used
+// APIs from other Kafka clients on top of a *kgo.Client.
+//
+// This package exists to ease migrating large codebases to kgo. Rather than
+// rewriting every call site at once, code can switch to the types in this
+// package, which keep the method names and semantics that the code was
+// written against, and then migrate to kgo directly piece by piece. This
+// package does not import any other client: the types here are look-alikes,
+// so migrating code changes its imports and construction but not its call
+// sites.
+//
+// The package currently mirrors kafka-go's Reader and Writer, and sarama's
+// ConsumerGroup with its ConsumerGroupHandler, ConsumerGroupSession, and
+// ConsumerGroupClaim interfaces.
+//
+// The kafka-go style adapters never own the *kgo.Client they wrap. All
+// configuration, such as which topics to consume, which group to join, and how
+// to produce, is done when creating the client with kgo options, and closing
+// an adapter does not close the client. The sarama style ConsumerGroup must
+// control the client's group callbacks, so it creates and owns its client.
+//
+// This package is intentionally small. Behavior that does not map cleanly
+// onto kgo is not emulated, and once migrated, it is recommended to use kgo
+// directly.
+package kcompat
+
+import (
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Header is a key/value pair attached to a message, mirroring kafka-go's
+// Header.
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// Message is a record produced by a Writer or consumed by a Reader, mirroring
+// kafka-go's Message.
+type Message struct {
+	Topic     string
+	Partition int
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   []Header
+	Time      time.Time
+
+	// rec is the record this message was converted from, if the message
+	// was consumed. We use it to commit with the record's leader epoch.
+	rec *kgo.Record
+}
+
+func messageFromRecord(r *kgo.Record) Message {
+	m := Message{
+		Topic:     r.Topic,
+		Partition: int(r.Partition),
+		Offset:    r.Offset,
+		Key:       r.Key,
+		Value:     r.Value,
+		Time:      r.Timestamp,
+		rec:       r,
+	}
+	if len(r.Headers) > 0 {
+		m.Headers = make([]Header, 0, len(r.Headers))
+		for _, h := range r.Headers {
+			m.Headers = append(m.Headers, Header{h.Key, h.Value})
+		}
+	}
+	return m
+}
+
+// toRecord converts a message to a record for producing, using topic if the
+// message does not specify a topic.
+func (m *Message) toRecord(topic string) *kgo.Record {
+	r := &kgo.Record{
+		Topic:     m.Topic,
+		Key:       m.Key,
+		Value:     m.Value,
+		Timestamp: m.Time,
+	}
+	if r.Topic == "" {
+		r.Topic = topic
+	}
+	if len(m.Headers) > 0 {
+		r.Headers = make([]kgo.RecordHeader, 0, len(m.Headers))
+		for _, h := range m.Headers {
+			r.Headers = append(r.Headers, kgo.RecordHeader{Key: h.Key, Value: h.Value})
+		}
+	}
+	return r
+}
+
+// commitRecord returns a record that can be used to commit this message.
+// Messages that were not consumed through a Reader have no known leader
+// epoch, so we commit them without one.
+func (m *Message) commitRecord() *kgo.Record {
+	if m.rec != nil {
+		return m.rec
+	}
+	return &kgo.Record{
+		Topic:       m.Topic,
+		Partition:   int32(m.Partition),
+		Offset:      m.Offset,
+		LeaderEpoch: -1,
+	}
+}
@@ -0,0 +1,95 @@
+package kcompat
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestMessageConversion(t *testing.T) {
+	now := time.Unix(1, 0)
+	m := Message{
+		Key:     []byte("k"),
+		Value:   []byte("v"),
+		Headers: []Header{{"h", []byte("hv")}},
+		Time:    now,
+	}
+
+	r := m.toRecord("default")
+	exp := &kgo.Record{
+		Topic:     "default",
+		Key:       []byte("k"),
+		Value:     []byte("v"),
+		Headers:   []kgo.RecordHeader{{Key: "h", Value: []byte("hv")}},
+		Timestamp: now,
+	}
+	if !reflect.DeepEqual(r, exp) {
+		t.Errorf("got record %#v != exp %#v", r, exp)
+	}
+
+	m.Topic = "own"
+	if r := m.toRecord("default"); r.Topic != "own" {
+		t.Errorf("got topic %q != exp own", r.Topic)
+	}
+
+	r.Partition = 3
+	r.Offset = 10
+	r.LeaderEpoch = 2
+	back := messageFromRecord(r)
+	if back.Topic != "default" || back.Partition != 3 || back.Offset != 10 || !reflect.DeepEqual(back.Headers, m.Headers) {
+		t.Errorf("unexpected message from record: %#v", back)
+	}
+	if cr := back.commitRecord(); cr != r {
+		t.Error("expected consumed message to commit with its own record")
+	}
+
+	manual := Message{Topic: "t", Partition: 1, Offset: 5}
+	if cr := manual.commitRecord(); cr.LeaderEpoch != -1 || cr.Offset != 5 || cr.Partition != 1 {
+		t.Errorf("unexpected commit record for manual message: %#v", cr)
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	es := WriteErrors{nil, errors.New("a"), nil, errors.New("b")}
+	if n := es.Count(); n != 2 {
+		t.Errorf("got count %d != exp 2", n)
+	}
+	if s, exp := es.Error(), "kafka write errors (a, b)"; s != exp {
+		t.Errorf("got %q != exp %q", s, exp)
+	}
+}
+
+func TestReader(t *testing.T) {
+	cl, err := kgo.NewClient(kgo.SeedBrokers("127.0.0.1:1"), kgo.ConsumeTopics("t"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	r := NewReader(cl)
+	if err := r.CommitMessages(context.Background(), Message{Topic: "t"}); err != errNotGroup {
+		t.Errorf("got commit err %v != exp %v", err, errNotGroup)
+	}
+
+	// Closing unblocks a concurrent fetch.
+	errc := make(chan error, 1)
+	go func() {
+		_, err := r.FetchMessage(context.Background())
+		errc <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	r.Close()
+	select {
+	case err := <-errc:
+		if err != io.EOF {
+			t.Errorf("got fetch err %v != exp io.EOF", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("fetch was not unblocked by close")
+	}
+}
@@ -0,0 +1,753 @@
+package kcompat
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// RecordHeader is a key/value pair attached to a message, mirroring sarama's
+// RecordHeader.
+type RecordHeader struct {
+	Key   []byte
+	Value []byte
+}
+
+// ConsumerMessage is a message consumed in a group session, mirroring
+// sarama's ConsumerMessage.
+type ConsumerMessage struct {
+	Headers        []*RecordHeader
+	Timestamp      time.Time
+	BlockTimestamp time.Time
+
+	Key, Value []byte
+	Topic      string
+	Partition  int32
+	Offset     int64
+
+	// leaderEpoch is the epoch of the record this message was converted
+	// from, which we use when the message is marked.
+	leaderEpoch int32
+}
+
+func consumerMessageFromRecord(r *kgo.Record) *ConsumerMessage {
+	m := &ConsumerMessage{
+		Timestamp:   r.Timestamp,
+		Key:         r.Key,
+		Value:       r.Value,
+		Topic:       r.Topic,
+		Partition:   r.Partition,
+		Offset:      r.Offset,
+		leaderEpoch: r.LeaderEpoch,
+	}
+	if len(r.Headers) > 0 {
+		m.Headers = make([]*RecordHeader, 0, len(r.Headers))
+		for _, h := range r.Headers {
+			m.Headers = append(m.Headers, &RecordHeader{[]byte(h.Key), h.Value})
+		}
+	}
+	return m
+}
+
+// ConsumerGroupHandler handles individual topic/partition claims in a group
+// session, mirroring sarama's ConsumerGroupHandler.
+//
+// As with sarama, ConsumeClaim is called in its own goroutine for every claim,
+// and must return once the claim's Messages channel is closed.
+type ConsumerGroupHandler interface {
+	// Setup is run at the beginning of a new session, before
+	// ConsumeClaim.
+	Setup(ConsumerGroupSession) error
+
+	// Cleanup is run at the end of a session, once all ConsumeClaim
+	// goroutines have exited but before offsets are committed for the
+	// final time.
+	Cleanup(ConsumerGroupSession) error
+
+	// ConsumeClaim must start a consumer loop of ConsumerGroupClaim's
+	// Messages(). Once the Messages() channel is closed, the handler must
+	// finish its processing loop and exit.
+	ConsumeClaim(ConsumerGroupSession, ConsumerGroupClaim) error
+}
+
+// ConsumerGroupSession represents a consumer group member session, mirroring
+// sarama's ConsumerGroupSession.
+type ConsumerGroupSession interface {
+	// Claims returns information about the claimed partitions by topic.
+	Claims() map[string][]int32
+
+	// MemberID returns the member ID.
+	MemberID() string
+
+	// GenerationID returns the current generation ID.
+	GenerationID() int32
+
+	// MarkOffset marks the provided offset, alongside a metadata string
+	// that represents the state of the partition consumer at that point
+	// in time. The offset should be the offset of the next message to
+	// consume, i.e. the last processed message's offset + 1. Marking an
+	// offset lower than what is already marked does nothing.
+	//
+	// The metadata string is ignored: kgo does not commit metadata.
+	MarkOffset(topic string, partition int32, offset int64, metadata string)
+
+	// Commit synchronously commits what has been marked.
+	Commit()
+
+	// ResetOffset resets to the provided offset, alongside a metadata
+	// string. Unlike MarkOffset, this can mark an offset lower than what
+	// is already marked. The metadata string is ignored.
+	ResetOffset(topic string, partition int32, offset int64, metadata string)
+
+	// MarkMessage marks a message as consumed.
+	MarkMessage(msg *ConsumerMessage, metadata string)
+
+	// Context returns the session context, which is canceled when the
+	// session ends.
+	Context() context.Context
+}
+
+// ConsumerGroupClaim processes messages from a single topic/partition within
+// a session, mirroring sarama's ConsumerGroupClaim.
+type ConsumerGroupClaim interface {
+	// Topic returns the consumed topic name.
+	Topic() string
+
+	// Partition returns the consumed partition.
+	Partition() int32
+
+	// InitialOffset returns the committed offset for the partition as of
+	// when the session began, or -1 if it was not yet known.
+	InitialOffset() int64
+
+	// HighWaterMarkOffset returns the high water mark offset of the
+	// partition, i.e. the offset that will be used for the next message
+	// that will be produced. You can use this to determine how far
+	// behind the processing is.
+	HighWaterMarkOffset() int64
+
+	// Messages returns the read channel for the messages that are
+	// returned by the broker. The channel is closed when the session
+	// ends.
+	Messages() <-chan *ConsumerMessage
+}
+
+// ErrClosedConsumerGroup is returned when a method is called on a closed
+// ConsumerGroup, mirroring sarama's error of the same name.
+var ErrClosedConsumerGroup = errors.New("kafka: tried to use a consumer group that was closed")
+
+var (
+	errConcurrentConsume = errors.New("kafka: Consume called concurrently, which is not supported")
+	errTopicsChanged     = errors.New("kafka: Consume called with topics that differ from the first Consume; changing topics requires a new consumer group")
+)
+
+const (
+	// claimBufferSize mirrors sarama's default ChannelBufferSize.
+	claimBufferSize = 256
+
+	// autocommitInterval mirrors sarama's default auto commit interval.
+	autocommitInterval = time.Second
+)
+
+// ConsumerGroup consumes in a group through a *kgo.Client, mirroring
+// sarama's ConsumerGroup. Handlers written for sarama's ConsumerGroupHandler
+// can be used unchanged other than switching the types they reference.
+//
+// As with sarama, a session lasts until the group rebalances: Consume runs
+// the handler for one session and returns, and is expected to be called in a
+// loop. Marked offsets are committed every second and when a session ends;
+// the final commit completes before the group is allowed to rebalance, so
+// what was marked is not consumed again by the partitions' new owners.
+//
+// Unlike the kafka-go style adapters, a ConsumerGroup owns its client: it
+// creates the client on the first call to Consume, and closes the client in
+// Close.
+type ConsumerGroup struct {
+	group string
+	opts  []kgo.Opt
+
+	mu         sync.Mutex
+	cl         *kgo.Client
+	topics     []string      // sorted; set on the first Consume
+	ready      bool          // whether we are assigned and can begin a session
+	readyCh    chan struct{} // closed when ready becomes true
+	current    *groupSession // non-nil while a session is running
+	closed     bool
+	closedCh   chan struct{}
+	errs       chan error
+	errsClosed bool
+}
+
+// NewConsumerGroup returns a ConsumerGroup for the given group. The options
+// are used when creating the client on the first call to Consume, and must not
+// include consumer group callbacks nor committing options: the consumer group
+// uses kgo.DisableAutoCommit and sets the OnPartitions callbacks to run
+// sessions, overriding any that are passed. The group and the topics to
+// consume are also set from the group and the topics passed to Consume.
+func NewConsumerGroup(group string, opts ...kgo.Opt) *ConsumerGroup {
+	return &ConsumerGroup{
+		group:    group,
+		opts:     append([]kgo.Opt(nil), opts...),
+		readyCh:  make(chan struct{}),
+		closedCh: make(chan struct{}),
+		errs:     make(chan error, claimBufferSize),
+	}
+}
+
+// Errors returns a channel of errors that occur while consuming or
+// committing, as well as errors returned from ConsumeClaim. Errors are dropped
+// if the channel is full. The channel is closed when the group is closed.
+func (cg *ConsumerGroup) Errors() <-chan error { return cg.errs }
+
+func (cg *ConsumerGroup) sendErr(err error) {
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+	if cg.errsClosed {
+		return
+	}
+	select {
+	case cg.errs <- err:
+	default:
+	}
+}
+
+// Consume joins the group if necessary and runs a session for the current
+// assignment, calling the handler as described on ConsumerGroupHandler. This
+// blocks until the session ends, which happens when the group rebalances, the
+// context is canceled, or the group is closed.
+//
+// The topics must be the same for every call to Consume.
+func (cg *ConsumerGroup) Consume(ctx context.Context, topics []string, handler ConsumerGroupHandler) error {
+	if err := cg.init(topics); err != nil {
+		return err
+	}
+	s, err := cg.waitSession(ctx)
+	if err != nil {
+		return err
+	}
+	return s.run(handler)
+}
+
+func (cg *ConsumerGroup) init(topics []string) error {
+	topics = append([]string(nil), topics...)
+	sort.Strings(topics)
+
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+
+	if cg.closed {
+		return ErrClosedConsumerGroup
+	}
+	if cg.cl != nil {
+		if len(topics) != len(cg.topics) {
+			return errTopicsChanged
+		}
+		for i := range topics {
+			if topics[i] != cg.topics[i] {
+				return errTopicsChanged
+			}
+		}
+		return nil
+	}
+
+	opts := append(cg.opts[:len(cg.opts):len(cg.opts)],
+		kgo.ConsumerGroup(cg.group),
+		kgo.ConsumeTopics(topics...),
+		kgo.DisableAutoCommit(),
+		kgo.OnPartitionsAssigned(cg.onAssigned),
+		kgo.OnPartitionsRevoked(cg.onRevoked),
+		kgo.OnPartitionsLost(cg.onLost),
+	)
+	cl, err := kgo.NewClient(opts...)
+	if err != nil {
+		return err
+	}
+	cg.cl = cl
+	cg.topics = topics
+	return nil
+}
+
+// waitSession waits until we are assigned and then begins a new session.
+func (cg *ConsumerGroup) waitSession(ctx context.Context) (*groupSession, error) {
+	for {
+		cg.mu.Lock()
+		if cg.closed {
+			cg.mu.Unlock()
+			return nil, ErrClosedConsumerGroup
+		}
+		if cg.current != nil {
+			cg.mu.Unlock()
+			return nil, errConcurrentConsume
+		}
+		if cg.ready {
+			s := newGroupSession(ctx, cg)
+			cg.current = s
+			cg.mu.Unlock()
+			return s, nil
+		}
+		readyCh := cg.readyCh
+		cg.mu.Unlock()
+
+		select {
+		case <-readyCh:
+		case <-cg.closedCh:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// onAssigned marks us ready to begin a session. With a cooperative balancer,
+// partitions can be added without anything being revoked; a running session
+// has no claims for the new partitions, so we end it and the next Consume
+// begins a session that includes them.
+func (cg *ConsumerGroup) onAssigned(_ context.Context, _ *kgo.Client, assigned map[string][]int32) {
+	cg.mu.Lock()
+	if !cg.ready {
+		cg.ready = true
+		close(cg.readyCh)
+	}
+	s := cg.current
+	cg.mu.Unlock()
+
+	if s != nil && len(assigned) > 0 {
+		s.end(nil, true)
+	}
+}
+
+func (cg *ConsumerGroup) onRevoked(_ context.Context, _ *kgo.Client, revoked map[string][]int32) {
+	cg.endSession(revoked, true)
+}
+
+func (cg *ConsumerGroup) onLost(_ context.Context, _ *kgo.Client, lost map[string][]int32) {
+	cg.endSession(lost, false)
+}
+
+// endSession is called from a revoke or loss, which blocks the group from
+// continuing until we return. We end any current session and wait for it to
+// be done, which includes the final commit if we are not lost.
+func (cg *ConsumerGroup) endSession(revoked map[string][]int32, commit bool) {
+	cg.mu.Lock()
+	if cg.ready {
+		cg.ready = false
+		cg.readyCh = make(chan struct{})
+	}
+	s := cg.current
+	cg.mu.Unlock()
+
+	if s != nil {
+		s.end(revoked, commit)
+	}
+}
+
+// Pause pauses fetching the given partitions.
+func (cg *ConsumerGroup) Pause(partitions map[string][]int32) {
+	if cl := cg.client(); cl != nil {
+		cl.PauseFetchPartitions(partitions)
+	}
+}
+
+// Resume resumes fetching the given partitions.
+func (cg *ConsumerGroup) Resume(partitions map[string][]int32) {
+	if cl := cg.client(); cl != nil {
+		cl.ResumeFetchPartitions(partitions)
+	}
+}
+
+// PauseAll pauses fetching all topics.
+func (cg *ConsumerGroup) PauseAll() {
+	if cl := cg.client(); cl != nil {
+		cl.PauseFetchTopics(cg.topics...)
+	}
+}
+
+// ResumeAll resumes fetching all topics.
+func (cg *ConsumerGroup) ResumeAll() {
+	if cl := cg.client(); cl != nil {
+		cl.ResumeFetchTopics(cg.topics...)
+	}
+}
+
+func (cg *ConsumerGroup) client() *kgo.Client {
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+	return cg.cl
+}
+
+// Close ends any current session, commits what was marked, leaves the group,
+// and closes the client.
+func (cg *ConsumerGroup) Close() error {
+	cg.mu.Lock()
+	if cg.closed {
+		cg.mu.Unlock()
+		return ErrClosedConsumerGroup
+	}
+	cg.closed = true
+	close(cg.closedCh)
+	cl := cg.cl
+	cg.mu.Unlock()
+
+	if cl != nil {
+		cl.Close() // leaving the group revokes, ending any session
+	}
+
+	cg.mu.Lock()
+	cg.errsClosed = true
+	close(cg.errs)
+	cg.mu.Unlock()
+	return nil
+}
+
+// groupSession is one sarama style session, lasting from an assignment until
+// the next revoke, loss, or until the Consume context is canceled.
+type groupSession struct {
+	cg     *ConsumerGroup
+	cl     *kgo.Client
+	ctx    context.Context
+	cancel func()
+
+	memberID   string
+	generation int32
+	claims     map[string]map[int32]*groupClaim
+
+	markMu sync.Mutex
+	marks  map[string]map[int32]kgo.EpochOffset
+	dirty  bool // whether marks changed since the last autocommit
+
+	// undelivered is the first record per partition that was polled
+	// but not sent to a claim. This is only used in the poll loop and
+	// then once the poll loop is done.
+	undelivered map[string]map[int32]kgo.EpochOffset
+
+	endMu       sync.Mutex
+	ending      bool
+	revoked     map[string][]int32
+	commitOnEnd bool
+
+	pollDone chan struct{}
+	done     chan struct{}
+}
+
+type groupClaim struct {
+	topic     string
+	partition int32
+	initial   int64
+	hwm       int64 // atomic
+	msgs      chan *ConsumerMessage
+	exited    chan struct{} // closed when ConsumeClaim returns
+}
+
+func (c *groupClaim) Topic() string                     { return c.topic }
+func (c *groupClaim) Partition() int32                  { return c.partition }
+func (c *groupClaim) InitialOffset() int64              { return c.initial }
+func (c *groupClaim) HighWaterMarkOffset() int64        { return atomic.LoadInt64(&c.hwm) }
+func (c *groupClaim) Messages() <-chan *ConsumerMessage { return c.msgs }
+
+// newGroupSession is called with the group mu held once we are ready.
+func newGroupSession(ctx context.Context, cg *ConsumerGroup) *groupSession {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &groupSession{
+		cg:          cg,
+		cl:          cg.cl,
+		ctx:         ctx,
+		cancel:      cancel,
+		claims:      make(map[string]map[int32]*groupClaim),
+		marks:       make(map[string]map[int32]kgo.EpochOffset),
+		undelivered: make(map[string]map[int32]kgo.EpochOffset),
+		commitOnEnd: true,
+		pollDone:    make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	snap, _ := cg.cl.GroupSnapshot()
+	s.memberID = snap.MemberID
+	s.generation = snap.Generation
+	committed := cg.cl.CommittedOffsets()
+	for topic, partitions := range snap.Assigned {
+		ps := make(map[int32]*groupClaim, len(partitions))
+		for _, partition := range partitions {
+			initial := int64(-1)
+			if eo, ok := committed[topic][partition]; ok {
+				initial = eo.Offset
+			}
+			ps[partition] = &groupClaim{
+				topic:     topic,
+				partition: partition,
+				initial:   initial,
+				hwm:       -1,
+				msgs:      make(chan *ConsumerMessage, claimBufferSize),
+				exited:    make(chan struct{}),
+			}
+		}
+		s.claims[topic] = ps
+	}
+	return s
+}
+
+func (s *groupSession) Claims() map[string][]int32 {
+	claims := make(map[string][]int32, len(s.claims))
+	for topic, partitions := range s.claims {
+		ps := make([]int32, 0, len(partitions))
+		for partition := range partitions {
+			ps = append(ps, partition)
+		}
+		sort.Slice(ps, func(i, j int) bool { return ps[i] < ps[j] })
+		claims[topic] = ps
+	}
+	return claims
+}
+
+func (s *groupSession) MemberID() string         { return s.memberID }
+func (s *groupSession) GenerationID() int32      { return s.generation }
+func (s *groupSession) Context() context.Context { return s.ctx }
+
+func (s *groupSession) MarkOffset(topic string, partition int32, offset int64, _ string) {
+	s.mark(topic, partition, kgo.EpochOffset{Epoch: -1, Offset: offset}, false)
+}
+
+func (s *groupSession) ResetOffset(topic string, partition int32, offset int64, _ string) {
+	s.mark(topic, partition, kgo.EpochOffset{Epoch: -1, Offset: offset}, true)
+}
+
+func (s *groupSession) MarkMessage(msg *ConsumerMessage, _ string) {
+	s.mark(msg.Topic, msg.Partition, kgo.EpochOffset{Epoch: msg.leaderEpoch, Offset: msg.Offset + 1}, false)
+}
+
+func (s *groupSession) mark(topic string, partition int32, eo kgo.EpochOffset, reset bool) {
+	s.markMu.Lock()
+	defer s.markMu.Unlock()
+	ps := s.marks[topic]
+	if ps == nil {
+		ps = make(map[int32]kgo.EpochOffset)
+		s.marks[topic] = ps
+	}
+	if prior, ok := ps[partition]; ok && !reset && prior.Offset >= eo.Offset {
+		return
+	}
+	ps[partition] = eo
+	s.dirty = true
+}
+
+// loadMarks returns a copy of what is marked, and whether anything changed
+// since the last loadMarks.
+func (s *groupSession) loadMarks() (map[string]map[int32]kgo.EpochOffset, bool) {
+	s.markMu.Lock()
+	defer s.markMu.Unlock()
+	marks := make(map[string]map[int32]kgo.EpochOffset, len(s.marks))
+	for topic, partitions := range s.marks {
+		ps := make(map[int32]kgo.EpochOffset, len(partitions))
+		for partition, eo := range partitions {
+			ps[partition] = eo
+		}
+		marks[topic] = ps
+	}
+	dirty := s.dirty
+	s.dirty = false
+	return marks, dirty
+}
+
+func (s *groupSession) Commit() {
+	s.commitSync(s.ctx)
+}
+
+func (s *groupSession) commitSync(ctx context.Context) {
+	marks, _ := s.loadMarks()
+	s.cl.CommitOffsetsSync(ctx, marks, s.onCommit)
+}
+
+func (s *groupSession) onCommit(_ *kgo.Client, _ *kmsg.OffsetCommitRequest, resp *kmsg.OffsetCommitResponse, err error) {
+	if err != nil {
+		if err != context.Canceled {
+			s.cg.sendErr(err)
+		}
+		return
+	}
+	for _, t := range resp.Topics {
+		for _, p := range t.Partitions {
+			if err := kerr.ErrorForCode(p.ErrorCode); err != nil {
+				s.cg.sendErr(err)
+			}
+		}
+	}
+}
+
+// autocommit asynchronously commits what is marked until the session ends.
+func (s *groupSession) autocommit() {
+	ticker := time.NewTicker(autocommitInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			if marks, dirty := s.loadMarks(); dirty {
+				s.cl.CommitOffsets(s.ctx, marks, s.onCommit)
+			}
+		}
+	}
+}
+
+// end ends the session and waits for it to be done. Anything revoked is not
+// rewound, and we commit what was marked if commit is true.
+func (s *groupSession) end(revoked map[string][]int32, commit bool) {
+	s.endMu.Lock()
+	if !s.ending {
+		s.ending = true
+		s.revoked = revoked
+		s.commitOnEnd = commit
+	}
+	s.endMu.Unlock()
+	s.cancel()
+	<-s.done
+}
+
+// run runs the session and ends it once the session context is canceled.
+func (s *groupSession) run(handler ConsumerGroupHandler) error {
+	defer func() {
+		s.cg.mu.Lock()
+		if s.cg.current == s {
+			s.cg.current = nil
+		}
+		s.cg.mu.Unlock()
+		close(s.done)
+	}()
+
+	if err := handler.Setup(s); err != nil {
+		s.cancel()
+		return err
+	}
+
+	go s.poll()
+	go s.autocommit()
+
+	var wg sync.WaitGroup
+	for _, partitions := range s.claims {
+		for _, c := range partitions {
+			wg.Add(1)
+			go func(c *groupClaim) {
+				defer wg.Done()
+				defer close(c.exited)
+				if err := handler.ConsumeClaim(s, c); err != nil {
+					s.cg.sendErr(err)
+				}
+			}(c)
+		}
+	}
+
+	<-s.ctx.Done()
+	<-s.pollDone
+	for _, partitions := range s.claims {
+		for _, c := range partitions {
+			close(c.msgs)
+		}
+	}
+	wg.Wait()
+	err := handler.Cleanup(s)
+
+	s.endMu.Lock()
+	revoked, commit := s.revoked, s.commitOnEnd
+	s.endMu.Unlock()
+
+	if commit {
+		s.commitSync(context.Background())
+	}
+	s.rewind(revoked)
+	return err
+}
+
+// poll polls records and sends them to claims until the session ends.
+func (s *groupSession) poll() {
+	defer close(s.pollDone)
+	for s.ctx.Err() == nil {
+		fetches := s.cl.PollFetches(s.ctx)
+		if fetches.IsClientClosed() {
+			s.cancel()
+			return
+		}
+		fetches.EachError(func(_ string, _ int32, err error) {
+			if s.ctx.Err() == nil || err != context.Canceled && err != context.DeadlineExceeded {
+				s.cg.sendErr(err)
+			}
+		})
+		fetches.EachPartition(s.dispatch)
+	}
+}
+
+func (s *groupSession) dispatch(p kgo.FetchTopicPartition) {
+	c := s.claims[p.Topic][p.Partition]
+	if c != nil {
+		atomic.StoreInt64(&c.hwm, p.HighWatermark)
+	}
+	for _, r := range p.Records {
+		if c == nil || s.ctx.Err() != nil {
+			s.setUndelivered(r)
+			return
+		}
+		select {
+		case c.msgs <- consumerMessageFromRecord(r):
+		case <-c.exited:
+			s.setUndelivered(r)
+			return
+		case <-s.ctx.Done():
+			s.setUndelivered(r)
+			return
+		}
+	}
+}
+
+func (s *groupSession) setUndelivered(r *kgo.Record) {
+	ps := s.undelivered[r.Topic]
+	if ps == nil {
+		ps = make(map[int32]kgo.EpochOffset)
+		s.undelivered[r.Topic] = ps
+	}
+	if _, ok := ps[r.Partition]; !ok {
+		ps[r.Partition] = kgo.EpochOffset{Epoch: r.LeaderEpoch, Offset: r.Offset}
+	}
+}
+
+// rewind resets consuming for any partition we are keeping to the first
+// message that was not processed in this session. The client has already
+// polled past these messages, and without rewinding, the next session would
+// skip them.
+//
+// Messages left in a claim's channel were sent before anything the poll loop
+// recorded as undelivered, so they take precedence.
+func (s *groupSession) rewind(revoked map[string][]int32) {
+	if rewind := s.rewindOffsets(revoked); len(rewind) > 0 {
+		s.cl.SetOffsets(rewind)
+	}
+}
+
+func (s *groupSession) rewindOffsets(revoked map[string][]int32) map[string]map[int32]kgo.EpochOffset {
+	for _, partitions := range s.claims {
+		for _, c := range partitions {
+			if m, ok := <-c.msgs; ok {
+				ps := s.undelivered[c.topic]
+				if ps == nil {
+					ps = make(map[int32]kgo.EpochOffset)
+					s.undelivered[c.topic] = ps
+				}
+				ps[c.partition] = kgo.EpochOffset{Epoch: m.leaderEpoch, Offset: m.Offset}
+			}
+		}
+	}
+	for topic, partitions := range revoked {
+		for _, partition := range partitions {
+			delete(s.undelivered[topic], partition)
+		}
+	}
+	for topic, partitions := range s.undelivered {
+		if len(partitions) == 0 {
+			delete(s.undelivered, topic)
+		}
+	}
+	return s.undelivered
+}
@@ -0,0 +1,169 @@
+package kcompat
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestConsumerMessageFromRecord(t *testing.T) {
+	r := &kgo.Record{
+		Key:         []byte("k"),
+		Value:       []byte("v"),
+		Headers:     []kgo.RecordHeader{{Key: "h", Value: []byte("hv")}},
+		Timestamp:   time.Unix(1, 0),
+		Topic:       "t",
+		Partition:   2,
+		Offset:      9,
+		LeaderEpoch: 4,
+	}
+	got := consumerMessageFromRecord(r)
+	exp := &ConsumerMessage{
+		Headers:     []*RecordHeader{{[]byte("h"), []byte("hv")}},
+		Timestamp:   time.Unix(1, 0),
+		Key:         []byte("k"),
+		Value:       []byte("v"),
+		Topic:       "t",
+		Partition:   2,
+		Offset:      9,
+		leaderEpoch: 4,
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("got %#v != exp %#v", got, exp)
+	}
+}
+
+func TestGroupSessionMarks(t *testing.T) {
+	s := &groupSession{marks: make(map[string]map[int32]kgo.EpochOffset)}
+
+	s.MarkMessage(&ConsumerMessage{Topic: "t", Partition: 0, Offset: 4, leaderEpoch: 1}, "")
+	s.MarkOffset("t", 0, 3, "") // lower, ignored
+	s.MarkOffset("t", 1, 10, "")
+	s.ResetOffset("t", 1, 7, "") // lower, but reset
+
+	marks, dirty := s.loadMarks()
+	exp := map[string]map[int32]kgo.EpochOffset{
+		"t": {
+			0: {Epoch: 1, Offset: 5},
+			1: {Epoch: -1, Offset: 7},
+		},
+	}
+	if !dirty {
+		t.Error("expected marks to be dirty")
+	}
+	if !reflect.DeepEqual(marks, exp) {
+		t.Errorf("got marks %v != exp %v", marks, exp)
+	}
+	if _, dirty := s.loadMarks(); dirty {
+		t.Error("expected marks to not be dirty after loading")
+	}
+}
+
+func TestGroupSessionUndelivered(t *testing.T) {
+	s := &groupSession{undelivered: make(map[string]map[int32]kgo.EpochOffset)}
+	s.setUndelivered(&kgo.Record{Topic: "t", Partition: 0, Offset: 5, LeaderEpoch: 1})
+	s.setUndelivered(&kgo.Record{Topic: "t", Partition: 0, Offset: 6, LeaderEpoch: 1})
+
+	// A message left in the claim channel precedes what the poll loop
+	// could not deliver.
+	c := &groupClaim{topic: "t", partition: 0, msgs: make(chan *ConsumerMessage, 1)}
+	c.msgs <- &ConsumerMessage{Topic: "t", Partition: 0, Offset: 3, leaderEpoch: 1}
+	close(c.msgs)
+	s.claims = map[string]map[int32]*groupClaim{"t": {0: c}}
+
+	s.setUndelivered(&kgo.Record{Topic: "u", Partition: 0, Offset: 1, LeaderEpoch: 1})
+	got := s.rewindOffsets(map[string][]int32{"u": {0}}) // u is revoked
+	exp := map[string]map[int32]kgo.EpochOffset{"t": {0: {Epoch: 1, Offset: 3}}}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("got rewind %v != exp %v", got, exp)
+	}
+}
+
+func TestConsumerGroupClosed(t *testing.T) {
+	cg := NewConsumerGroup("g", kgo.SeedBrokers("127.0.0.1:1"))
+
+	// Consume waits for an assignment, which never comes.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := cg.Consume(ctx, []string{"t"}, nil); err != context.DeadlineExceeded {
+		t.Errorf("got err %v != exp %v", err, context.DeadlineExceeded)
+	}
+	if err := cg.Consume(context.Background(), []string{"u"}, nil); err != errTopicsChanged {
+		t.Errorf("got err %v != exp %v", err, errTopicsChanged)
+	}
+
+	if err := cg.Close(); err != nil {
+		t.Errorf("unexpected close err %v", err)
+	}
+	if err := cg.Close(); err != ErrClosedConsumerGroup {
+		t.Errorf("got second close err %v != exp %v", err, ErrClosedConsumerGroup)
+	}
+	if err := cg.Consume(context.Background(), []string{"t"}, nil); err != ErrClosedConsumerGroup {
+		t.Errorf("got err %v != exp %v", err, ErrClosedConsumerGroup)
+	}
+	if _, ok := <-cg.Errors(); ok {
+		t.Error("expected errors channel to be closed")
+	}
+}
+
+type testHandler struct {
+	setup   chan struct{}
+	cleanup chan struct{}
+}
+
+func (h *testHandler) Setup(ConsumerGroupSession) error {
+	close(h.setup)
+	return nil
+}
+
+func (h *testHandler) Cleanup(ConsumerGroupSession) error {
+	close(h.cleanup)
+	return nil
+}
+
+func (*testHandler) ConsumeClaim(ConsumerGroupSession, ConsumerGroupClaim) error { return nil }
+
+func TestConsumerGroupAssignedDuringSession(t *testing.T) {
+	cg := NewConsumerGroup("g", kgo.SeedBrokers("127.0.0.1:1"))
+	defer cg.Close()
+	if err := cg.init([]string{"t"}); err != nil {
+		t.Fatalf("unexpected init err: %v", err)
+	}
+	cg.onAssigned(context.Background(), nil, map[string][]int32{"t": {0}})
+
+	h := &testHandler{setup: make(chan struct{}), cleanup: make(chan struct{})}
+	consumed := make(chan error, 1)
+	go func() { consumed <- cg.Consume(context.Background(), []string{"t"}, h) }()
+	<-h.setup
+
+	// An assignment with nothing new, which the client issues every
+	// rebalance, does not end the session.
+	cg.onAssigned(context.Background(), nil, nil)
+	select {
+	case <-h.cleanup:
+		t.Fatal("session ended from an empty assignment")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// A cooperative balancer adds partitions without revoking anything;
+	// the session must end so that the next session claims them. We
+	// remain ready to begin that session.
+	cg.onAssigned(context.Background(), nil, map[string][]int32{"t": {1}})
+	select {
+	case <-h.cleanup:
+	default:
+		t.Fatal("session not ended after partitions were added")
+	}
+	if err := <-consumed; err != nil {
+		t.Errorf("unexpected consume err: %v", err)
+	}
+	cg.mu.Lock()
+	ready, current := cg.ready, cg.current
+	cg.mu.Unlock()
+	if !ready || current != nil {
+		t.Errorf("got ready %v, current session %v; exp ready with no current session", ready, current != nil)
+	}
+}
@@ -175,6 +175,7 @@ var (
 	InconsistentClusterID              = &Error{"INCONSISTENT_CLUSTER_ID", 104, false, "The clusterId in the request does not match that found on the server."}
 	TransactionalIDNotFound            = &Error{"TRANSACTIONAL_ID_NOT_FOUND", 105, false, "The transactionalId could not be found."}
 	FetchSessionTopicIDError           = &Error{"FETCH_SESSION_TOPIC_ID_ERROR", 106, true, "The fetch session encountered inconsistent topic ID usage."}
+	TransactionAbortable               = &Error{"TRANSACTION_ABORTABLE", 120, false, "The server encountered an error with the transaction. The client can abort the transaction to continue using this transactional ID."}
 )
 
 var code2err = map[int16]error{
@@ -286,4 +287,5 @@ var code2err = map[int16]error{
 	104: InconsistentClusterID,
 	105: TransactionalIDNotFound,
 	106: FetchSessionTopicIDError,
+	120: TransactionAbortable,
 }
@@ -0,0 +1,148 @@
+package kgo
+
+import (
+	"context"
+	"fmt"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// actors tracks the long lived goroutines and the connections that a client
+// spawns, so that CloseAndCheck can report any that outlive closing.
+//
+// We only track actors that should stop once the client is closed; short
+// lived goroutines that finish a single request or promise are not tracked.
+// Tracking is an atomic add on a per kind counter when an actor starts and
+// stops; the counter is only created, under the map's lock, the first time
+// a kind of actor starts.
+type actors struct {
+	kinds sync.Map // actor kind => *int64, the number of live actors of that kind
+}
+
+func (a *actors) live(name string) *int64 {
+	if v, ok := a.kinds.Load(name); ok {
+		return v.(*int64)
+	}
+	v, _ := a.kinds.LoadOrStore(name, new(int64))
+	return v.(*int64)
+}
+
+// start registers an actor, returning a function to call exactly once when
+// the actor is done. This must be called before spawning a goroutine, not
+// within it, so that a goroutine that has not yet begun running is not
+// missed.
+func (a *actors) start(name string) func() {
+	live := a.live(name)
+	atomic.AddInt64(live, 1)
+	return func() { atomic.AddInt64(live, -1) }
+}
+
+// spawn runs fn in a tracked goroutine with the given pprof labels.
+//
+// The labels replace any labels the spawning goroutine has, so that an actor
+// started from a user goroutine (e.g., the first request to a broker) is not
+// attributed to the user's work. Goroutines that the actor itself starts
+// inherit the labels.
+func (a *actors) spawn(name string, labels pprof.LabelSet, fn func()) {
+	live := a.live(name)
+	atomic.AddInt64(live, 1)
+	go func() {
+		defer atomic.AddInt64(live, -1)
+		pprof.Do(context.Background(), labels, func(context.Context) { fn() })
+	}()
+}
+
+// The pprof label keys that the client's long lived goroutines are run with.
+//
+// Every goroutine has a purpose label, one of "metadata", "connections",
+// "requests", "produce", "fetch", "group", or "checkpoint". Goroutines that
+// are specific to a broker also have a broker label, which is the broker's
+// node ID, or "seed N" for the Nth seed broker.
+const (
+	pprofPurpose = "kgo_purpose"
+	pprofBroker  = "kgo_broker"
+)
+
+// purposeLabels returns the pprof labels for a client goroutine that is not
+// specific to any broker.
+func purposeLabels(purpose string) pprof.LabelSet {
+	return pprof.Labels(pprofPurpose, purpose)
+}
+
+// brokerLabels returns the pprof labels for a client goroutine working on
+// behalf of a single broker.
+func brokerLabels(purpose string, nodeID int32) pprof.LabelSet {
+	return pprof.Labels(pprofPurpose, purpose, pprofBroker, logID(nodeID))
+}
+
+func (a *actors) snapshot() map[string]int {
+	var live map[string]int
+	a.kinds.Range(func(k, v interface{}) bool {
+		if n := atomic.LoadInt64(v.(*int64)); n > 0 {
+			if live == nil {
+				live = make(map[string]int)
+			}
+			live[k.(string)] = int(n)
+		}
+		return true
+	})
+	return live
+}
+
+// LeakError is returned from CloseAndCheck if any goroutines or connections
+// that the client started are still running once the context is done.
+type LeakError struct {
+	// Leaked is the number of goroutines or connections still running,
+	// per kind (e.g., "source fetch loop" or "connection to broker 1").
+	Leaked map[string]int
+}
+
+func (e *LeakError) Error() string {
+	kinds := make([]string, 0, len(e.Leaked))
+	for kind := range e.Leaked {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	var sb strings.Builder
+	sb.WriteString("client goroutines or connections still running after close: ")
+	for i, kind := range kinds {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		fmt.Fprintf(&sb, "%s (%d)", kind, e.Leaked[kind])
+	}
+	return sb.String()
+}
+
+// CloseAndCheck closes the client, as with Close, and then waits for every
+// long lived goroutine and every connection that the client started to
+// finish. If anything is still running once the context is done, this returns
+// a *LeakError listing what is still running.
+//
+// Close itself does not wait for everything to finish: some goroutines, such
+// as those reading the final response on a connection, can run briefly after
+// Close returns. This function is useful in tests that embed the client and
+// want to ensure that closing the client releases everything, or in tests that
+// use a goroutine leak detector and need the client to be fully shut down
+// before checking. A leak that is reported here is a bug in the client.
+func (cl *Client) CloseAndCheck(ctx context.Context) error {
+	cl.Close()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		live := cl.actors.snapshot()
+		if len(live) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return &LeakError{Leaked: live}
+		case <-ticker.C:
+		}
+	}
+}
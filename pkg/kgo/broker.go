@@ -132,6 +132,11 @@ type broker struct {
 
 	reapMu sync.Mutex // held when modifying a brokerCxn
 
+	// If throttle pacing is enabled, these space out produce and fetch
+	// requests after the broker throttles us.
+	producePacer pacer
+	fetchPacer   pacer
+
 	// reqs manages incoming message requests.
 	reqs ringReq
 	// dead is an atomic so a backed up reqs cannot block broker stoppage.
@@ -220,7 +225,7 @@ func (b *broker) do(
 	first, dead := b.reqs.push(pr)
 
 	if first {
-		go b.handleReqs(pr)
+		b.cl.actors.spawn("broker request handler", brokerLabels("requests", b.meta.NodeID), func() { b.handleReqs(pr) })
 	} else if dead {
 		promise(nil, errChosenBrokerDead)
 	}
@@ -427,6 +432,7 @@ func (p bufPool) put(b []byte) { p.p.Put(&b) }
 func (b *broker) loadConnection(ctx context.Context, req kmsg.Request) (*brokerCxn, error) {
 	var (
 		pcxn         = &b.cxnNormal
+		purpose      = "requests"
 		isProduceCxn bool // see docs on brokerCxn.discard for why we do this
 		reqKey       = req.Key()
 		_, isTimeout = req.(kmsg.TimeoutRequest)
@@ -434,11 +440,14 @@ func (b *broker) loadConnection(ctx context.Context, req kmsg.Request) (*brokerC
 	switch {
 	case reqKey == 0:
 		pcxn = &b.cxnProduce
+		purpose = "produce"
 		isProduceCxn = true
 	case reqKey == 1:
 		pcxn = &b.cxnFetch
+		purpose = "fetch"
 	case reqKey == 11 || reqKey == 14: // join || sync
 		pcxn = &b.cxnGroup
+		purpose = "group"
 	case isTimeout:
 		pcxn = &b.cxnSlow
 	}
@@ -456,9 +465,11 @@ func (b *broker) loadConnection(ctx context.Context, req kmsg.Request) (*brokerC
 		cl: b.cl,
 		b:  b,
 
-		addr:   b.addr,
-		conn:   conn,
-		deadCh: make(chan struct{}),
+		addr:    b.addr,
+		conn:    conn,
+		deadCh:  make(chan struct{}),
+		untrack: b.cl.actors.start("connection to broker " + logID(b.meta.NodeID)),
+		purpose: purpose,
 	}
 	if err = cxn.init(isProduceCxn); err != nil {
 		b.cl.cfg.logger.Log(LogLevelDebug, "connection initialization failed", "addr", b.addr, "broker", logID(b.meta.NodeID), "err", err)
@@ -602,6 +613,10 @@ type brokerCxn struct {
 	dead int32
 	// closed in cloneConn; allows throttle waiting to quit
 	deadCh chan struct{}
+	// untrack is called in closeConn; see CloseAndCheck
+	untrack func()
+	// purpose is the pprof purpose label for the response reader
+	purpose string
 }
 
 func (cxn *brokerCxn) init(isProduceCxn bool) error {
@@ -629,7 +644,7 @@ func (cxn *brokerCxn) init(isProduceCxn bool) error {
 	}
 
 	if isProduceCxn && cxn.cl.cfg.acks.val == 0 {
-		go cxn.discard() // see docs on discard for why we do this
+		cxn.cl.actors.spawn("produce connection discarder", brokerLabels("produce", cxn.b.meta.NodeID), cxn.discard) // see docs on discard for why we do this
 	}
 	return nil
 }
@@ -1147,6 +1162,7 @@ func (cxn *brokerCxn) closeConn() {
 	})
 	cxn.conn.Close()
 	close(cxn.deadCh)
+	cxn.untrack()
 }
 
 // die kills a broker connection (which could be dead already) and replies to
@@ -1164,7 +1180,7 @@ func (cxn *brokerCxn) die() {
 func (cxn *brokerCxn) waitResp(pr promisedResp) {
 	first, dead := cxn.resps.push(pr)
 	if first {
-		go cxn.handleResps(pr)
+		cxn.cl.actors.spawn("connection response reader", brokerLabels(cxn.purpose, cxn.b.meta.NodeID), func() { cxn.handleResps(pr) })
 	} else if dead {
 		pr.promise(nil, errChosenBrokerDead)
 		cxn.hookWriteE2E(pr.resp.Key(), pr.bytesWritten, pr.writeWait, pr.timeToWrite, errChosenBrokerDead)
@@ -1341,6 +1357,15 @@ func (cxn *brokerCxn) handleResp(pr promisedResp) {
 					}
 				})
 			}
+			if p := cxn.b.pacer(pr.resp.Key()); p != nil {
+				if pace, changed := p.observe(time.Duration(millis)*time.Millisecond, cxn.cl.cfg.throttlePacingMax); changed {
+					cxn.cl.cfg.hooks.each(func(h Hook) {
+						if h, ok := h.(HookThrottlePacing); ok {
+							h.OnThrottlePacing(cxn.b.meta, pr.resp.Key(), pace)
+						}
+					})
+				}
+			}
 		}
 	}
 
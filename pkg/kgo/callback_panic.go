@@ -0,0 +1,86 @@
+package kgo
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// CallbackPanicError is the error for a panic in a user callback that was
+// recovered due to the RecoverCallbackPanics option.
+//
+// If the panic occurred while partitioning a record, the record's promise is
+// called with this error. Otherwise, the callback itself is what failed, and
+// the error is only logged and passed to any HookCallbackPanic.
+type CallbackPanicError struct {
+	// Callback is what panicked: "partitioner", "produce promise", "hook",
+	// "commit callback", or one of "OnAssigned", "OnRevoked", or "OnLost"
+	// for group rebalance callbacks.
+	Callback string
+
+	// Type is the type implementing the callback, if the callback is an
+	// interface (i.e., a hook or partitioner).
+	Type string
+
+	// Value is the value that was passed to panic.
+	Value interface{}
+
+	// Stack is the stack of the panicking goroutine at the time of the
+	// panic.
+	Stack []byte
+}
+
+func (e *CallbackPanicError) Error() string {
+	if e.Type != "" {
+		return fmt.Sprintf("recovered panic in %s %s: %v", e.Callback, e.Type, e.Value)
+	}
+	return fmt.Sprintf("recovered panic in %s: %v", e.Callback, e.Value)
+}
+
+// panicRecoverer recovers panics from user callbacks that run on internal
+// client goroutines; see the RecoverCallbackPanics option.
+type panicRecoverer struct {
+	logger Logger
+	hooks  []HookCallbackPanic
+}
+
+func newPanicRecoverer(cfg *cfg) *panicRecoverer {
+	r := &panicRecoverer{logger: cfg.logger}
+	for _, h := range cfg.hooks.hs {
+		if h, ok := h.(HookCallbackPanic); ok {
+			r.hooks = append(r.hooks, h)
+		}
+	}
+	return r
+}
+
+// run calls fn, returning a *CallbackPanicError if fn panicked. If the
+// recoverer is nil, this does not recover and simply calls fn.
+//
+// impl, if non-nil, is the user type implementing the callback.
+func (r *panicRecoverer) run(callback string, impl interface{}, fn func()) (err error) {
+	if r == nil {
+		fn()
+		return nil
+	}
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		pe := &CallbackPanicError{
+			Callback: callback,
+			Value:    v,
+			Stack:    debug.Stack(),
+		}
+		if impl != nil {
+			pe.Type = fmt.Sprintf("%T", impl)
+		}
+		r.logger.Log(LogLevelError, "recovered panic in user callback", "callback", callback, "type", pe.Type, "panic", v, "stack", string(pe.Stack))
+		for _, h := range r.hooks {
+			h.OnCallbackPanic(pe)
+		}
+		err = pe
+	}()
+	fn()
+	return nil
+}
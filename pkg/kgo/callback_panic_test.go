@@ -0,0 +1,68 @@
+package kgo
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+type panicHook struct{ recovered []*CallbackPanicError }
+
+func (h *panicHook) OnCallbackPanic(pe *CallbackPanicError) { h.recovered = append(h.recovered, pe) }
+
+type panickingHook struct{}
+
+func (panickingHook) OnNewClient(*Client) { panic("new client") }
+
+func TestRecoverCallbackPanics(t *testing.T) {
+	h := new(panicHook)
+	cl, err := NewClient(
+		RecoverCallbackPanics(),
+		WithHooks(h, panickingHook{}),
+		RecordPartitioner(BasicConsistentPartitioner(func(string) func(*Record, int) int {
+			return func(r *Record, _ int) int {
+				if r.Key != nil {
+					panic("bad key")
+				}
+				return 0
+			}
+		})),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	// The panicking OnNewClient hook was recovered in NewClient.
+	if len(h.recovered) != 1 || h.recovered[0].Callback != "hook" || h.recovered[0].Type != "kgo.panickingHook" || h.recovered[0].Value != "new client" {
+		t.Fatalf("got recovered %v, expected the OnNewClient panic", h.recovered)
+	}
+
+	parts := new(topicPartitions)
+	tp := &topicPartition{records: &recBuf{cl: cl, topic: "foo"}}
+	data := &topicPartitionsData{partitions: []*topicPartition{tp}, writablePartitions: []*topicPartition{tp}}
+	parts.v.Store(data)
+
+	// A partitioner panic fails the record with the recovered panic.
+	var promised error
+	atomic.AddInt64(&cl.producer.bufferedRecords, 1)
+	cl.doPartitionRecord(parts, data, promisedRec{context.Background(), func(_ *Record, err error) { promised = err }, &Record{Topic: "foo", Key: []byte("k")}})
+	var pe *CallbackPanicError
+	if !errors.As(promised, &pe) || pe.Callback != "partitioner" || pe.Value != "bad key" || len(pe.Stack) == 0 {
+		t.Errorf("got promised error %v, expected a recovered partitioner panic", promised)
+	}
+	if len(h.recovered) != 2 {
+		t.Errorf("got %d recovered panics, expected 2", len(h.recovered))
+	}
+
+	// A promise panic is recovered and the record is still finished.
+	atomic.AddInt64(&cl.producer.bufferedRecords, 1)
+	cl.finishRecordPromise(promisedRec{context.Background(), func(*Record, error) { panic("promise") }, &Record{Topic: "foo"}}, nil)
+	if len(h.recovered) != 3 || h.recovered[2].Callback != "produce promise" {
+		t.Errorf("got recovered %v, expected a produce promise panic", h.recovered)
+	}
+	if n := cl.BufferedProduceRecords(); n != 0 {
+		t.Errorf("got %d buffered records after a panicking promise, expected 0", n)
+	}
+}
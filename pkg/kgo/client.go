@@ -34,7 +34,8 @@ type Client struct {
 	ctx       context.Context
 	ctxCancel func()
 
-	rng *rand.Rand
+	rngMu sync.Mutex
+	rng   *rand.Rand
 
 	brokersMu    sync.RWMutex
 	brokers      []*broker // ordered by broker ID
@@ -53,6 +54,9 @@ type Client struct {
 	sinksAndSources   map[int32]sinkAndSource
 
 	reqFormatter  *kmsg.RequestFormatter
+	slowCallbacks *callbackWatcher // non-nil if WarnSlowCallbacks is used
+	panics        *panicRecoverer  // non-nil if RecoverCallbackPanics is used
+	actors        actors           // long lived goroutines and connections; see CloseAndCheck
 	connTimeouter connTimeouter
 
 	bufPool bufPool // for to brokers to share underlying reusable request buffers
@@ -72,6 +76,7 @@ type Client struct {
 
 	producer producer
 	consumer consumer
+	metrics  clientMetrics
 
 	compressor   *compressor
 	decompressor *decompressor
@@ -111,12 +116,27 @@ const defaultKafkaPort = 9092
 //
 // NewClient also launches a goroutine which periodically updates the cached
 // topic metadata.
+//
+// The client's long lived goroutines run with pprof labels so that CPU and
+// goroutine profiles attribute work to the client: "kgo_purpose" is one of
+// "metadata", "connections", "requests", "produce", "fetch", "group", or
+// "checkpoint", and goroutines that work on behalf of a single broker also
+// have "kgo_broker", the broker's node ID.
 func NewClient(opts ...Opt) (*Client, error) {
 	cfg := defaultCfg()
 	for _, opt := range opts {
 		opt.apply(&cfg)
 	}
 
+	seed := time.Now().UnixNano()
+	if cfg.randSeed != nil {
+		seed = *cfg.randSeed
+	}
+	rng := rand.New(rand.NewSource(seed))
+	if cfg.retryBackoff == nil {
+		cfg.retryBackoff = defaultRetryBackoff(rng.Int63())
+	}
+
 	if cfg.retryTimeout == nil {
 		cfg.retryTimeout = func(key int16) time.Duration {
 			switch key {
@@ -148,7 +168,7 @@ func NewClient(opts ...Opt) (*Client, error) {
 		cfg:       cfg,
 		ctx:       ctx,
 		ctxCancel: cancel,
-		rng:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		rng:       rng,
 
 		controllerID: unknownControllerID,
 
@@ -169,11 +189,28 @@ func NewClient(opts ...Opt) (*Client, error) {
 		metadone:            make(chan struct{}),
 	}
 
+	if cfg.slowCallbackAfter > 0 {
+		cl.slowCallbacks = &callbackWatcher{threshold: cfg.slowCallbackAfter, logger: cfg.logger}
+		cl.cfg.hooks.slow = cl.slowCallbacks
+	}
+	if cfg.recoverPanics {
+		cl.panics = newPanicRecoverer(&cfg)
+		cl.cfg.hooks.panics = cl.panics
+	}
+
 	compressor, err := newCompressor(cl.cfg.compression...)
 	if err != nil {
 		return nil, err
 	}
+	if cl.cfg.adaptiveCompressionBudget > 0 {
+		compressor = newAdaptiveCompressor(cl.cfg.adaptiveCompressionBudget, cl.cfg.hooks)
+	}
+	if compressor != nil {
+		compressor.codecs = cl.cfg.codecs
+	}
 	cl.compressor = compressor
+	cl.decompressor.codecs = cl.cfg.codecs
+	cl.decompressor.limit = newLimiter(cl.cfg.decompressConcurrency)
 
 	// Before we start any goroutines below, we must notify any interested
 	// hooks of our existence.
@@ -183,6 +220,8 @@ func NewClient(opts ...Opt) (*Client, error) {
 		}
 	})
 
+	cl.cfg.logger.Log(LogLevelInfo, "creating client", "rand_seed", seed)
+
 	cl.producer.init(cl)
 	cl.consumer.init(cl)
 	cl.metawait.init()
@@ -196,8 +235,8 @@ func NewClient(opts ...Opt) (*Client, error) {
 		cl.seeds = append(cl.seeds, b)
 	}
 	sort.Slice(cl.seeds, func(i, j int) bool { return cl.seeds[i].meta.NodeID < cl.seeds[j].meta.NodeID })
-	go cl.updateMetadataLoop()
-	go cl.reapConnectionsLoop()
+	cl.actors.spawn("metadata loop", purposeLabels("metadata"), cl.updateMetadataLoop)
+	cl.actors.spawn("connection reaper", purposeLabels("connections"), cl.reapConnectionsLoop)
 
 	return cl, nil
 }
@@ -311,6 +350,14 @@ func (cl *Client) broker() *broker {
 	return b
 }
 
+// randInt63 returns a random number from the client's seeded source, for
+// seeding anything else that uses randomness.
+func (cl *Client) randInt63() int64 {
+	cl.rngMu.Lock()
+	defer cl.rngMu.Unlock()
+	return cl.rng.Int63()
+}
+
 func (cl *Client) waitTries(ctx context.Context, backoff time.Duration) bool {
 	after := time.NewTimer(backoff)
 	defer after.Stop()
@@ -473,9 +520,12 @@ func (cl *Client) updateBrokers(brokers []kmsg.MetadataResponseBroker) {
 // Close leaves any group and closes all connections and goroutines.
 //
 // If you are group consuming and have overridden the default OnRevoked, you
-// must manually commit offsets before closing the client.
+// must manually commit offsets before closing the client, or use the
+// CommitOnRevoke or CommitOnClose options.
 func (cl *Client) Close() {
+	cl.commitOnClose()
 	cl.LeaveGroup()
+	cl.consumer.checkpoint.close()
 
 	// Now we kill the client context and all brokers, ensuring all
 	// requests fail. This will finish all producer callbacks and
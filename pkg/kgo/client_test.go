@@ -1,7 +1,16 @@
 package kgo
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"reflect"
+	"runtime/pprof"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestParseBrokerAddr(t *testing.T) {
@@ -93,3 +102,204 @@ func TestParseBrokerAddrErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestRandSeed(t *testing.T) {
+	run := func() (backoffs []time.Duration, partitions []int) {
+		cl, err := NewClient(RandSeed(42))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cl.Close()
+
+		for i := 1; i < 5; i++ {
+			backoffs = append(backoffs, cl.cfg.retryBackoff(i))
+		}
+		for _, p := range []Partitioner{StickyPartitioner(), StickyKeyPartitioner(nil), LeastBackupPartitioner()} {
+			tp := p.ForTopic("t")
+			tp.(randSeeder).seedRand(cl.randInt63())
+			if tbp, ok := tp.(TopicBackupPartitioner); ok {
+				partitions = append(partitions, tbp.PartitionByBackup(new(Record), 100, &equalBackups{100}))
+				continue
+			}
+			for i := 0; i < 5; i++ {
+				tp.(TopicPartitionerOnNewBatch).OnNewBatch()
+				partitions = append(partitions, tp.Partition(new(Record), 1000))
+			}
+		}
+		return backoffs, partitions
+	}
+
+	backoffs1, partitions1 := run()
+	backoffs2, partitions2 := run()
+	if !reflect.DeepEqual(backoffs1, backoffs2) {
+		t.Errorf("backoffs differ with the same seed: %v != %v", backoffs1, backoffs2)
+	}
+	if !reflect.DeepEqual(partitions1, partitions2) {
+		t.Errorf("partitions differ with the same seed: %v != %v", partitions1, partitions2)
+	}
+}
+
+// equalBackups is a TopicBackupIter where every partition is equally backed
+// up, forcing a random choice.
+type equalBackups struct{ n int }
+
+func (e *equalBackups) Next() (int, int64) { e.n--; return e.n, 0 }
+func (e *equalBackups) Rem() int           { return e.n }
+
+func TestCloseAndCheck(t *testing.T) {
+	// A broker that accepts connections and never responds keeps
+	// requests, and thus goroutines and connections, outstanding.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var (
+		connsMu sync.Mutex
+		conns   []net.Conn
+	)
+	defer func() {
+		connsMu.Lock()
+		defer connsMu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			connsMu.Lock()
+			conns = append(conns, conn)
+			connsMu.Unlock()
+		}
+	}()
+
+	for _, opts := range [][]Opt{
+		{SeedBrokers(ln.Addr().String()), ConsumeTopics("t")},
+		{SeedBrokers(ln.Addr().String()), DefaultProduceTopic("t")},
+		{SeedBrokers("127.0.0.1:1"), ConsumeTopics("t"), ConsumerGroup("g")},
+	} {
+		cl, err := NewClient(opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cl.Produce(context.Background(), &Record{Topic: "t"}, nil)
+		time.Sleep(50 * time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if live := cl.actors.snapshot(); live["metadata loop"] != 1 {
+			t.Errorf("got live %v before closing, expected a metadata loop", live)
+		}
+		if err := cl.CloseAndCheck(ctx); err != nil {
+			t.Errorf("unexpected leak: %v", err)
+		}
+		cancel()
+	}
+
+	// Anything still running once the context is done is reported.
+	cl, err := NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := cl.actors.start("fake actor")
+	defer done()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = cl.CloseAndCheck(ctx)
+	var le *LeakError
+	if !errors.As(err, &le) || len(le.Leaked) != 1 || le.Leaked["fake actor"] != 1 {
+		t.Errorf("got %v, expected a leak of only the fake actor", err)
+	}
+}
+
+func TestDebugDump(t *testing.T) {
+	cl, err := NewClient(SeedBrokers("127.0.0.1:1"), ConsumeTopics("t"), ConsumerGroup("g"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	s := cl.newSink(1)
+	recBuf := &recBuf{cl: cl, topic: "p", partition: 3, sink: s, recBufsIdx: -1}
+	recBuf.leader = 1
+	recBuf.batches = []*recBatch{recBuf.newRecordBatch()}
+	parts := new(topicPartitions)
+	parts.v.Store(&topicPartitionsData{partitions: []*topicPartition{{records: recBuf}}})
+	cl.producer.topics.storeData(topicsPartitionsData{"p": parts})
+
+	var dump struct {
+		Brokers []struct {
+			Seed bool   `json:"seed"`
+			Addr string `json:"addr"`
+		} `json:"brokers"`
+		Producer struct {
+			Partitions map[string]map[string]struct {
+				Leader  int32 `json:"leader"`
+				Batches int   `json:"batches"`
+			} `json:"partitions"`
+		} `json:"producer"`
+		Consumer *struct {
+			Group *struct {
+				Group string
+				Phase string
+			} `json:"group"`
+		} `json:"consumer"`
+		Running map[string]int `json:"running"`
+	}
+	raw := cl.DebugDump()
+	if err := json.Unmarshal(raw, &dump); err != nil {
+		t.Fatalf("unable to decode dump: %v\n%s", err, raw)
+	}
+	if len(dump.Brokers) != 1 || !dump.Brokers[0].Seed || dump.Brokers[0].Addr != "127.0.0.1:1" {
+		t.Errorf("got brokers %+v, expected the one seed", dump.Brokers)
+	}
+	if p := dump.Producer.Partitions["p"]["3"]; p.Leader != 1 || p.Batches != 1 {
+		t.Errorf("got producer partition %+v, expected leader 1 with 1 batch", p)
+	}
+	if dump.Consumer == nil || dump.Consumer.Group == nil || dump.Consumer.Group.Group != "g" || dump.Consumer.Group.Phase == "" {
+		t.Errorf("got consumer %+v, expected group g with a phase\n%s", dump.Consumer, raw)
+	}
+	if dump.Running["metadata loop"] != 1 {
+		t.Errorf("got running %v, expected the metadata loop", dump.Running)
+	}
+}
+
+func TestPprofLabels(t *testing.T) {
+	cl, err := NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	block := make(chan struct{})
+	defer close(block)
+	cl.actors.spawn("test", brokerLabels("fetch", 1), func() { <-block })
+	cl.actors.spawn("test", brokerLabels("requests", -2147483648), func() { <-block })
+
+	// Labels are only in the profile once the goroutines are running.
+	want := []string{
+		`"kgo_purpose":"metadata"`,
+		`"kgo_broker":"1", "kgo_purpose":"fetch"`,
+		`"kgo_broker":"seed 0", "kgo_purpose":"requests"`,
+	}
+	var missing []string
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		var sb strings.Builder
+		if err := pprof.Lookup("goroutine").WriteTo(&sb, 1); err != nil {
+			t.Fatal(err)
+		}
+		missing = missing[:0]
+		for _, w := range want {
+			if !strings.Contains(sb.String(), w) {
+				missing = append(missing, w)
+			}
+		}
+		if len(missing) == 0 {
+			return
+		}
+	}
+	t.Errorf("goroutine profile is missing labels %v", missing)
+}
@@ -70,8 +70,25 @@ func (c CompressionCodec) WithLevel(level int) CompressionCodec {
 	return c
 }
 
+// Codec is an implementation of one of Kafka's compression codecs, and can be
+// used to replace the client's builtin implementation of that codec; see the
+// WithCodec option.
+//
+// A Codec must be safe for concurrent use.
+type Codec interface {
+	// Compress appends the compressed form of src to dst and returns the
+	// extended dst. The output must be decompressable by any Kafka
+	// client, i.e., it must be in the codec's standard format.
+	Compress(dst, src []byte) ([]byte, error)
+
+	// Decompress returns the decompressed form of src.
+	Decompress(src []byte) ([]byte, error)
+}
+
 type compressor struct {
 	options  []int8
+	adaptive *adaptiveCompression
+	codecs   map[int8]Codec // user provided implementations, if any
 	gzPool   sync.Pool
 	lz4Pool  sync.Pool
 	zstdPool sync.Pool
@@ -180,6 +197,22 @@ func (c *compressor) compress(dst *sliceWriter, src []byte, produceRequestVersio
 		use = option
 		break
 	}
+	return c.compressWith(dst, src, use)
+}
+
+// compressWith compresses src to dst with the given codec, which must have
+// been one of the codecs the compressor was created with.
+func (c *compressor) compressWith(dst *sliceWriter, src []byte, use int8) ([]byte, int8) {
+	dst.inner = dst.inner[:0]
+
+	if codec := c.codecs[use]; codec != nil && use != 0 {
+		compressed, err := codec.Compress(dst.inner, src)
+		if err != nil {
+			return nil, -1
+		}
+		dst.inner = compressed
+		return dst.inner, use
+	}
 
 	switch use {
 	case 0:
@@ -218,6 +251,9 @@ func (c *compressor) compress(dst *sliceWriter, src []byte, produceRequestVersio
 }
 
 type decompressor struct {
+	codecs map[int8]Codec // user provided implementations, if any
+	limit  limiter        // bounds concurrent decompression; see DecompressionConcurrency
+
 	ungzPool   sync.Pool
 	unlz4Pool  sync.Pool
 	unzstdPool sync.Pool
@@ -253,6 +289,19 @@ type zstdDecoder struct {
 }
 
 func (d *decompressor) decompress(src []byte, codec byte) ([]byte, error) {
+	if codec != 0 {
+		d.limit.acquire(nil)
+		defer d.limit.release()
+	}
+	// Xerial framed snappy is not standard snappy, so a user codec cannot
+	// be expected to understand it; we always decode the framing
+	// ourselves.
+	if codec == 2 && len(src) > 16 && bytes.HasPrefix(src, xerialPfx) {
+		return xerialDecode(src)
+	}
+	if impl := d.codecs[int8(codec)]; impl != nil && codec != 0 {
+		return impl.Decompress(src)
+	}
 	switch codec {
 	case 0:
 		return src, nil
@@ -264,9 +313,6 @@ func (d *decompressor) decompress(src []byte, codec byte) ([]byte, error) {
 		}
 		return ioutil.ReadAll(ungz)
 	case 2:
-		if len(src) > 16 && bytes.HasPrefix(src, xerialPfx) {
-			return xerialDecode(src)
-		}
 		return s2.Decode(nil, src)
 	case 3:
 		unlz4 := d.unlz4Pool.Get().(*lz4.Reader)
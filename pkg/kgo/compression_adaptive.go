@@ -0,0 +1,131 @@
+package kgo
+
+import (
+	"sync"
+	"time"
+)
+
+// adaptiveCompression chooses the codec to use per topic for the
+// AdaptiveBatchCompression option by periodically compressing one batch with
+// every candidate codec and keeping the best codec that is within budget.
+type adaptiveCompression struct {
+	budget time.Duration // max time to compress 1MiB
+	hooks  hooks
+
+	mu     sync.Mutex
+	topics map[string]*adaptiveTopic
+}
+
+type adaptiveTopic struct {
+	batches int  // batches compressed since we last sampled
+	sampled bool // whether codec has been chosen from a sample yet
+	codec   int8
+}
+
+const (
+	// adaptiveSampleEvery is how many batches per topic we compress
+	// with the chosen codec before sampling again.
+	adaptiveSampleEvery = 100
+
+	// adaptiveMinSampleBytes is the minimum size of a batch to sample.
+	// Timing small batches is too noisy, so we wait for a larger batch.
+	adaptiveMinSampleBytes = 4 << 10
+
+	// adaptiveMaxRatio is the worst compressed to uncompressed ratio
+	// that we consider worth compressing for.
+	adaptiveMaxRatio = 0.9
+
+	// adaptiveZstdGain is how much smaller zstd must compress compared
+	// to lz4 for us to prefer zstd's slower compression.
+	adaptiveZstdGain = 0.9
+)
+
+func newAdaptiveCompressor(budget time.Duration, hooks hooks) *compressor {
+	c, _ := newCompressor(Lz4Compression(), ZstdCompression())
+	c.adaptive = &adaptiveCompression{
+		budget: budget,
+		hooks:  hooks,
+		topics: make(map[string]*adaptiveTopic),
+	}
+	return c
+}
+
+// compressTopic compresses src for the given topic, using the adaptive codec
+// choice if the client uses AdaptiveBatchCompression.
+func (c *compressor) compressTopic(dst *sliceWriter, src []byte, produceRequestVersion int16, topic string) ([]byte, int8) {
+	a := c.adaptive
+	if a == nil {
+		return c.compress(dst, src, produceRequestVersion)
+	}
+
+	a.mu.Lock()
+	t := a.topics[topic]
+	if t == nil {
+		t = &adaptiveTopic{codec: 3} // lz4 until we sample
+		a.topics[topic] = t
+	}
+	// Once we have compressed adaptiveSampleEvery batches, batches is
+	// zero and we sample the next batch that is large enough.
+	sample := t.batches == 0 && len(src) >= adaptiveMinSampleBytes
+	if sample || t.batches > 0 {
+		t.batches = (t.batches + 1) % adaptiveSampleEvery
+	}
+	use := t.codec
+	a.mu.Unlock()
+
+	if sample {
+		var ratio float64
+		use, ratio = c.sampleCodecs(src, produceRequestVersion)
+
+		a.mu.Lock()
+		changed := !t.sampled || t.codec != use
+		t.sampled = true
+		t.codec = use
+		a.mu.Unlock()
+
+		if changed {
+			a.hooks.each(func(h Hook) {
+				if h, ok := h.(HookProduceCompressionChanged); ok {
+					h.OnProduceCompressionChanged(topic, uint8(use), ratio)
+				}
+			})
+		}
+	}
+
+	if use == 4 && produceRequestVersion < 7 {
+		use = 3
+	}
+	return c.compressWith(dst, src, use)
+}
+
+// sampleCodecs compresses src with lz4 and zstd, returning the codec to use
+// and the ratio it compresses to.
+func (c *compressor) sampleCodecs(src []byte, produceRequestVersion int16) (int8, float64) {
+	budget := float64(c.adaptive.budget) * float64(len(src)) / (1 << 20)
+
+	try := func(codec int8) (float64, bool) {
+		w := sliceWriters.Get().(*sliceWriter)
+		defer sliceWriters.Put(w)
+		w.inner = w.inner[:0]
+
+		start := time.Now()
+		compressed, _ := c.compressWith(w, src, codec)
+		elapsed := time.Since(start)
+		if compressed == nil {
+			return 1, false
+		}
+		ratio := float64(len(compressed)) / float64(len(src))
+		return ratio, ratio <= adaptiveMaxRatio && float64(elapsed) <= budget
+	}
+
+	use, useRatio := int8(0), float64(1)
+	if ratio, ok := try(3); ok {
+		use, useRatio = 3, ratio
+	}
+	if produceRequestVersion >= 7 {
+		if ratio, ok := try(4); ok && (use == 0 || ratio <= useRatio*adaptiveZstdGain) {
+			use, useRatio = 4, ratio
+		}
+	}
+	return use, useRatio
+}
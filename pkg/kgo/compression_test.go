@@ -3,9 +3,14 @@ package kgo
 import (
 	"bytes"
 	"encoding/base64"
+	"math/rand"
 	"reflect"
+	"runtime"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/klauspost/compress/s2"
 )
 
 func TestNewCompressor(t *testing.T) {
@@ -154,3 +159,202 @@ func Test_xerialDecode(t *testing.T) {
 		})
 	}
 }
+
+type compressionChangedHook struct {
+	topics []string
+	codecs []uint8
+}
+
+func (h *compressionChangedHook) OnProduceCompressionChanged(topic string, codec uint8, _ float64) {
+	h.topics = append(h.topics, topic)
+	h.codecs = append(h.codecs, codec)
+}
+
+func TestAdaptiveCompression(t *testing.T) {
+	t.Parallel()
+
+	repetitive := bytes.Repeat([]byte("abcdefgh"), 1<<10)
+	random := make([]byte, 8<<10)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	h := new(compressionChangedHook)
+	c := newAdaptiveCompressor(time.Second, hooks{hs: []Hook{h}})
+
+	compress := func(src []byte, topic string) int8 {
+		w := sliceWriters.Get().(*sliceWriter)
+		defer sliceWriters.Put(w)
+		w.inner = w.inner[:0]
+		_, codec := c.compressTopic(w, src, 7, topic)
+		return codec
+	}
+
+	// A small batch does not sample, and we use lz4 until we do.
+	if got := compress([]byte("small"), "rand"); got != 3 {
+		t.Errorf("small batch: got codec %d != exp 3", got)
+	}
+	if len(h.topics) != 0 {
+		t.Errorf("small batch: unexpectedly sampled")
+	}
+
+	if got := compress(repetitive, "rep"); got != 3 && got != 4 {
+		t.Errorf("repetitive: got codec %d, exp lz4 or zstd", got)
+	}
+	if got := compress(random, "rand"); got != 0 {
+		t.Errorf("random: got codec %d != exp 0", got)
+	}
+	if !reflect.DeepEqual(h.topics, []string{"rep", "rand"}) {
+		t.Errorf("got changed topics %v != exp [rep rand]", h.topics)
+	}
+
+	// We do not sample again until adaptiveSampleEvery batches.
+	if got := compress(repetitive, "rand"); got != 0 {
+		t.Errorf("random topic, repetitive batch: got codec %d != exp 0", got)
+	}
+	if len(h.topics) != 2 {
+		t.Errorf("unexpectedly sampled again")
+	}
+}
+
+// countingCodec implements snappy with s2, counting its calls.
+type countingCodec struct {
+	mu                       sync.Mutex
+	compresses, decompresses int
+}
+
+func (c *countingCodec) Compress(dst, src []byte) ([]byte, error) {
+	c.mu.Lock()
+	c.compresses++
+	c.mu.Unlock()
+	return append(dst, s2.EncodeSnappy(nil, src)...), nil
+}
+
+func (c *countingCodec) Decompress(src []byte) ([]byte, error) {
+	c.mu.Lock()
+	c.decompresses++
+	c.mu.Unlock()
+	return s2.Decode(nil, src)
+}
+
+func TestWithCodec(t *testing.T) {
+	t.Parallel()
+
+	codec := new(countingCodec)
+	cl, err := NewClient(
+		SeedBrokers("127.0.0.1:1"),
+		ProducerBatchCompression(SnappyCompression()),
+		WithCodec(SnappyCompression(), codec),
+	)
+	if err != nil {
+		t.Fatalf("unexpected client error: %v", err)
+	}
+	defer cl.Close()
+
+	in := bytes.Repeat([]byte("foo"), 100)
+	w := sliceWriters.Get().(*sliceWriter)
+	defer sliceWriters.Put(w)
+	compressed, used := cl.compressor.compress(w, in, 7)
+	if used != 2 {
+		t.Fatalf("got codec %d != exp 2", used)
+	}
+	out, err := cl.decompressor.decompress(compressed, 2)
+	if err != nil {
+		t.Fatalf("unexpected decompress error: %v", err)
+	}
+	if !bytes.Equal(in, out) {
+		t.Error("round trip mismatch")
+	}
+	if codec.compresses != 1 || codec.decompresses != 1 {
+		t.Errorf("got %d compresses and %d decompresses, exp 1 and 1", codec.compresses, codec.decompresses)
+	}
+
+	// Xerial framed snappy is decoded by the builtin decoder, not the
+	// user codec, which only understands standard snappy.
+	xerial, _ := base64.StdEncoding.DecodeString("glNOQVBQWQAAAAABAAAAAQAAAA8NMEhlbGxvLCBXb3JsZCE=")
+	out, err = cl.decompressor.decompress(xerial, 2)
+	if err != nil || string(out) != "Hello, World!" {
+		t.Errorf("got xerial decompress %q, err %v; exp Hello, World!", out, err)
+	}
+	if codec.decompresses != 1 {
+		t.Errorf("user codec was used to decompress xerial framed snappy")
+	}
+
+	if _, err := NewClient(SeedBrokers("127.0.0.1:1"), WithCodec(NoCompression(), codec)); err == nil {
+		t.Error("expected error replacing NoCompression, got nil")
+	}
+}
+
+type concurrencyCodec struct {
+	mu       sync.Mutex
+	running  int
+	maxSeen  int
+	released chan struct{}
+}
+
+func (*concurrencyCodec) Compress(dst, src []byte) ([]byte, error) { return append(dst, src...), nil }
+
+func (c *concurrencyCodec) Decompress(src []byte) ([]byte, error) {
+	c.mu.Lock()
+	c.running++
+	if c.running > c.maxSeen {
+		c.maxSeen = c.running
+	}
+	c.mu.Unlock()
+	<-c.released
+	c.mu.Lock()
+	c.running--
+	c.mu.Unlock()
+	return src, nil
+}
+
+func TestDecompressionConcurrency(t *testing.T) {
+	cl, err := NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl.Close()
+	if n := cap(cl.decompressor.limit); n != runtime.GOMAXPROCS(0) {
+		t.Errorf("got default decompression concurrency %d, expected GOMAXPROCS", n)
+	}
+	cl, err = NewClient(DecompressionConcurrency(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl.Close()
+	if cl.decompressor.limit != nil {
+		t.Error("got a decompression limit, expected unbounded")
+	}
+	if _, err := NewClient(DecompressionConcurrency(-1)); err == nil {
+		t.Error("got no error for negative decompression concurrency")
+	}
+
+	codec := &concurrencyCodec{released: make(chan struct{})}
+	d := newDecompressor()
+	d.codecs = map[int8]Codec{1: codec}
+	d.limit = newLimiter(2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.decompress([]byte("foo"), 1)
+		}()
+	}
+	// Once two decompressions are running, the rest must wait.
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		codec.mu.Lock()
+		running := codec.running
+		codec.mu.Unlock()
+		if running == 2 {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 8; i++ {
+		codec.released <- struct{}{}
+	}
+	wg.Wait()
+	if codec.maxSeen != 2 {
+		t.Errorf("got %d concurrent decompressions, expected 2", codec.maxSeen)
+	}
+}
@@ -0,0 +1,36 @@
+package kgo
+
+// limiter bounds how many goroutines can run a section of work at once; see
+// the DecompressionConcurrency, PromiseConcurrency, and ProduceDrainConcurrency
+// options. A nil limiter does not limit.
+type limiter chan struct{}
+
+// newLimiter returns a limiter allowing n concurrent goroutines, or nil if n
+// is not positive (unbounded).
+func newLimiter(n int) limiter {
+	if n <= 0 {
+		return nil
+	}
+	return make(limiter, n)
+}
+
+// acquire waits for a slot, returning false if done is closed first. A nil
+// done waits indefinitely.
+func (l limiter) acquire(done <-chan struct{}) bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l <- struct{}{}:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// release releases a slot previously acquired.
+func (l limiter) release() {
+	if l != nil {
+		<-l
+	}
+}
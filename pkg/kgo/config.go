@@ -9,6 +9,7 @@ import (
 	"math/rand"
 	"net"
 	"regexp"
+	"runtime"
 	"runtime/debug"
 	"sync"
 	"time"
@@ -85,6 +86,8 @@ type cfg struct {
 	retries      int64
 	retryTimeout func(int16) time.Duration
 
+	randSeed *int64 // if non-nil, seeds all client randomness
+
 	maxBrokerWriteBytes int32
 	maxBrokerReadBytes  int32
 
@@ -95,17 +98,24 @@ type cfg struct {
 
 	sasls []sasl.Mechanism
 
-	hooks hooks
+	throttlePacingMax time.Duration
+
+	hooks             hooks
+	slowCallbackAfter time.Duration
+	recoverPanics     bool
+
+	codecs map[int8]Codec
 
 	//////////////////////
 	// PRODUCER SECTION //
 	//////////////////////
 
-	txnID              *string
-	txnTimeout         time.Duration
-	acks               Acks
-	disableIdempotency bool
-	compression        []CompressionCodec // order of preference
+	txnID                     *string
+	txnTimeout                time.Duration
+	acks                      Acks
+	disableIdempotency        bool
+	compression               []CompressionCodec // order of preference
+	adaptiveCompressionBudget time.Duration
 
 	defaultProduceTopic string
 	maxRecordBatchBytes int32
@@ -115,6 +125,10 @@ type cfg struct {
 	linger              time.Duration
 	recordTimeout       time.Duration
 	manualFlushing      bool
+	produceTopicMaxIdle time.Duration
+
+	promiseConcurrency int
+	drainConcurrency   int
 
 	partitioner Partitioner
 
@@ -130,34 +144,57 @@ type cfg struct {
 	maxBytes       int32
 	maxPartBytes   int32
 	resetOffset    Offset
+	topicResets    map[string]Offset
 	isolationLevel int8
 	keepControl    bool
+	recordLag      bool
 	rack           string
 
-	maxConcurrentFetches int
-	disableFetchSessions bool
+	maxConcurrentFetches  int
+	decompressConcurrency int
+	disableFetchSessions  bool
+
+	maxBufferedFetches      int
+	maxBufferedFetchRecords int64
+	maxBufferedFetchBytes   int64
+	prefetchDrained         bool
+	backpressureBytes       int64
 
 	topics     map[string]*regexp.Regexp   // topics to consume; if regex is true, values are compiled regular expressions
 	partitions map[string]map[int32]Offset // partitions to directly consume from
 	regex      bool
 
+	regexRemoveDeleted bool
+
+	maxConsumeTopics int // if positive, the max number of topics to consume
+
+	replayCacheBytes int64
+
+	checkpointPath     string
+	checkpointInterval time.Duration
 	////////////////////////////
 	// CONSUMER GROUP SECTION //
 	////////////////////////////
 
-	group      string          // group we are in
-	instanceID *string         // optional group instance ID
-	balancers  []GroupBalancer // balancers we can use
-	protocol   string          // "consumer" by default, expected to never be overridden
+	group        string          // group we are in
+	instanceID   *string         // optional group instance ID
+	groupReason  string          // optional default KIP-800 join & leave reason
+	balancers    []GroupBalancer // balancers we can use
+	protocol     string          // "consumer" by default, expected to never be overridden
+	adjustPlan   func(*ConsumerBalancer, map[string]map[string][]int32)
+	externalPlan func(context.Context, *ConsumerBalancer, map[string]int32) (map[string]map[string][]int32, error)
 
 	sessionTimeout    time.Duration
 	rebalanceTimeout  time.Duration
 	heartbeatInterval time.Duration
 	requireStable     bool
+	requireReady      bool
+	prefetchStandby   bool
 
 	onAssigned func(context.Context, *Client, map[string][]int32)
 	onRevoked  func(context.Context, *Client, map[string][]int32)
 	onLost     func(context.Context, *Client, map[string][]int32)
+	onFenced   func(*Client, error) GroupFencedAction
 
 	setAssigned       bool
 	setRevoked        bool
@@ -168,7 +205,12 @@ type cfg struct {
 	autocommitGreedy   bool
 	autocommitMarks    bool
 	autocommitInterval time.Duration
+	commitOnRevoke     bool
+	commitOnClose      bool
 	commitCallback     func(*Client, *kmsg.OffsetCommitRequest, *kmsg.OffsetCommitResponse, error)
+
+	offsetStore         OffsetStore
+	mirrorStoredOffsets bool
 }
 
 // cooperative is a helper that returns whether all group balancers in the
@@ -181,6 +223,12 @@ func (cfg *cfg) cooperative() bool {
 	return cooperative
 }
 
+// customGroupProtocol returns whether the group uses a protocol type other
+// than "consumer", in which case the group can be joined without topics.
+func (cfg *cfg) customGroupProtocol() bool {
+	return len(cfg.group) > 0 && cfg.protocol != "consumer"
+}
+
 func (cfg *cfg) validate() error {
 	if len(cfg.seedBrokers) == 0 {
 		return errors.New("config erroneously has no seed brokers")
@@ -195,9 +243,23 @@ func (cfg *cfg) validate() error {
 	if cfg.disableIdempotency && cfg.txnID != nil {
 		return errors.New("cannot both disable idempotent writes and use transactional IDs")
 	}
+	if cfg.produceTopicMaxIdle < 0 {
+		return errors.New("invalid negative produce topic max idle")
+	}
+	if cfg.produceTopicMaxIdle > 0 && cfg.txnID != nil {
+		return errors.New("cannot evict idle produce topics when using transactional IDs")
+	}
 	if !cfg.disableIdempotency && cfg.acks.val != -1 {
 		return errors.New("idempotency requires acks=all")
 	}
+	for codec, impl := range cfg.codecs {
+		if codec < 1 || codec > 4 {
+			return errors.New("cannot replace the implementation of an unknown or no-op compression codec")
+		}
+		if impl == nil {
+			return errors.New("invalid nil codec implementation")
+		}
+	}
 
 	for _, limit := range []struct {
 		name    string
@@ -264,6 +326,21 @@ func (cfg *cfg) validate() error {
 
 		// 0 <= allowed concurrency
 		{name: "max concurrent fetches", v: int64(cfg.maxConcurrentFetches), allowed: 0, badcmp: i64lt},
+		{name: "decompression concurrency", v: int64(cfg.decompressConcurrency), allowed: 0, badcmp: i64lt},
+		{name: "promise concurrency", v: int64(cfg.promiseConcurrency), allowed: 0, badcmp: i64lt},
+		{name: "produce drain concurrency", v: int64(cfg.drainConcurrency), allowed: 0, badcmp: i64lt},
+
+		// 0 <= buffered fetch limits
+		{name: "max buffered fetches", v: int64(cfg.maxBufferedFetches), allowed: 0, badcmp: i64lt},
+		{name: "max buffered fetch records", v: cfg.maxBufferedFetchRecords, allowed: 0, badcmp: i64lt},
+		{name: "max buffered fetch bytes", v: cfg.maxBufferedFetchBytes, allowed: 0, badcmp: i64lt},
+		{name: "fetch backpressure bytes", v: cfg.backpressureBytes, allowed: 0, badcmp: i64lt},
+
+		// 0 <= slow callback threshold
+		{name: "slow callback threshold", v: int64(cfg.slowCallbackAfter), allowed: 0, badcmp: i64lt, durs: true},
+
+		// 0 <= replay cache bytes
+		{name: "replay cache bytes", v: cfg.replayCacheBytes, allowed: 0, badcmp: i64lt},
 
 		// 1s <= request timeout overhead <= 15m
 		{name: "request timeout max overhead", v: int64(cfg.requestTimeoutOverhead), allowed: int64(15 * time.Minute), badcmp: i64gt, durs: true},
@@ -273,6 +350,14 @@ func (cfg *cfg) validate() error {
 		{name: "conn min idle timeout", v: int64(cfg.connIdleTimeout), allowed: int64(time.Second), badcmp: i64lt, durs: true},
 		{name: "conn max idle timeout", v: int64(cfg.connIdleTimeout), allowed: int64(15 * time.Minute), badcmp: i64gt, durs: true},
 
+		// 0 <= throttle pacing <= 1m
+		{name: "max throttle pacing", v: int64(cfg.throttlePacingMax), allowed: 0, badcmp: i64lt, durs: true},
+		{name: "max throttle pacing", v: int64(cfg.throttlePacingMax), allowed: int64(time.Minute), badcmp: i64gt, durs: true},
+
+		// 0 <= adaptive compression budget <= 1s
+		{name: "adaptive compression budget", v: int64(cfg.adaptiveCompressionBudget), allowed: 0, badcmp: i64lt, durs: true},
+		{name: "adaptive compression budget", v: int64(cfg.adaptiveCompressionBudget), allowed: int64(time.Second), badcmp: i64gt, durs: true},
+
 		// 10ms <= metadata <= 1hr
 		{name: "metadata max age", v: int64(cfg.metadataMaxAge), allowed: int64(time.Hour), badcmp: i64gt, durs: true},
 		{name: "metadata min age", v: int64(cfg.metadataMinAge), allowed: int64(10 * time.Millisecond), badcmp: i64lt, durs: true},
@@ -300,9 +385,10 @@ func (cfg *cfg) validate() error {
 
 		{name: "session timeout", v: int64(cfg.sessionTimeout), allowed: int64(100 * time.Millisecond), badcmp: i64lt, durs: true},
 		{name: "rebalance timeout", v: int64(cfg.rebalanceTimeout), allowed: int64(100 * time.Millisecond), badcmp: i64lt, durs: true},
+		{name: "heartbeat interval", v: int64(cfg.heartbeatInterval), allowed: int64(10 * time.Millisecond), badcmp: i64lt, durs: true},
 		{name: "autocommit interval", v: int64(cfg.autocommitInterval), allowed: int64(100 * time.Millisecond), badcmp: i64lt, durs: true},
 
-		{v: int64(cfg.heartbeatInterval), allowed: int64(cfg.rebalanceTimeout) * int64(time.Millisecond), badcmp: i64gt, durs: true, fmt: "heartbeat interval %v is erroneously larger than the session timeout %v"},
+		{v: int64(cfg.heartbeatInterval), allowed: int64(cfg.sessionTimeout), badcmp: func(l, r int64) (bool, string) { return l >= r, "" }, durs: true, fmt: "heartbeat interval %v is erroneously not less than the session timeout %v"},
 	} {
 		bad, cmp := limit.badcmp(limit.v, limit.allowed)
 		if bad {
@@ -320,7 +406,7 @@ func (cfg *cfg) validate() error {
 	}
 
 	if len(cfg.group) > 0 {
-		if len(cfg.topics) == 0 {
+		if len(cfg.topics) == 0 && !cfg.customGroupProtocol() {
 			return errors.New("unable to consume from a group when no topics are specified")
 		}
 		if len(cfg.partitions) != 0 {
@@ -341,21 +427,66 @@ func (cfg *cfg) validate() error {
 		}
 	}
 
+	if cfg.maxConsumeTopics > 0 && !cfg.regex {
+		unique := make(map[string]struct{}, len(cfg.topics)+len(cfg.partitions))
+		for topic := range cfg.topics {
+			unique[topic] = struct{}{}
+		}
+		for topic := range cfg.partitions {
+			unique[topic] = struct{}{}
+		}
+		if len(unique) > cfg.maxConsumeTopics {
+			return fmt.Errorf("consuming %d topics exceeds the max consume topics %d", len(unique), cfg.maxConsumeTopics)
+		}
+	}
+
+	for _, balancer := range cfg.balancers {
+		if u, ok := balancer.(*userDataBalancer); ok {
+			if err := u.validate(); err != nil {
+				return err
+			}
+		}
+	}
+
 	if cfg.autocommitDisable && cfg.autocommitGreedy {
 		return errors.New("cannot both disable autocommitting and enable greedy autocommitting")
 	}
 	if cfg.autocommitDisable && cfg.autocommitMarks {
 		return errors.New("cannot both disable autocommitting and enable marked autocommitting")
 	}
+	if cfg.autocommitDisable && cfg.commitOnRevoke {
+		return errors.New("cannot both disable autocommitting and enable committing on revoke")
+	}
 	if cfg.autocommitGreedy && cfg.autocommitMarks {
 		return errors.New("cannot enable both greedy autocommitting and marked autocommitting")
 	}
-	if (cfg.autocommitGreedy || cfg.autocommitDisable || cfg.autocommitMarks || cfg.setCommitCallback) && len(cfg.group) == 0 {
+	if (cfg.autocommitGreedy || cfg.autocommitDisable || cfg.autocommitMarks || cfg.commitOnRevoke || cfg.commitOnClose || cfg.setCommitCallback) && len(cfg.group) == 0 {
 		return errors.New("invalid autocommit options specified when a group was not specified")
 	}
 	if (cfg.setLost || cfg.setRevoked || cfg.setAssigned) && len(cfg.group) == 0 {
 		return errors.New("invalid group partition assigned/revoked/lost functions set when a group was not specified")
 	}
+	if cfg.offsetStore != nil && len(cfg.group) == 0 {
+		return errors.New("invalid external offset store set when a group was not specified")
+	}
+	if cfg.mirrorStoredOffsets && cfg.offsetStore == nil {
+		return errors.New("cannot mirror stored offsets without an external offset store")
+	}
+	if cfg.onFenced != nil && len(cfg.group) == 0 {
+		return errors.New("invalid group fenced function set when a group was not specified")
+	}
+	if cfg.requireReady && len(cfg.group) == 0 {
+		return errors.New("invalid require partitions ready option set when a group was not specified")
+	}
+	if cfg.prefetchStandby && len(cfg.group) == 0 {
+		return errors.New("invalid prefetch standby partitions option set when a group was not specified")
+	}
+	if cfg.checkpointPath != "" && len(cfg.group) > 0 {
+		return errors.New("invalid checkpoint file set when consuming as a group; groups resume from committed offsets")
+	}
+	if cfg.checkpointPath != "" && cfg.checkpointInterval <= 0 {
+		return errors.New("invalid non-positive checkpoint interval")
+	}
 
 	return nil
 }
@@ -401,35 +532,6 @@ func defaultCfg() cfg {
 		seedBrokers: []string{"127.0.0.1"},
 		maxVersions: kversion.Stable(),
 
-		retryBackoff: func() func(int) time.Duration {
-			var rngMu sync.Mutex
-			rng := rand.New(rand.NewSource(time.Now().UnixNano()))
-			return func(fails int) time.Duration {
-				const (
-					min = 250 * time.Millisecond
-					max = 5 * time.Second / 2
-				)
-				if fails <= 0 {
-					return min
-				}
-				if fails > 10 {
-					return max
-				}
-
-				backoff := min * time.Duration(1<<(fails-1))
-
-				rngMu.Lock()
-				jitter := 0.8 + 0.4*rng.Float64()
-				rngMu.Unlock()
-
-				backoff = time.Duration(float64(backoff) * jitter)
-
-				if backoff > max {
-					return max
-				}
-				return backoff
-			}
-		}(),
 		retries: 20,
 
 		maxBrokerWriteBytes: 100 << 20, // Kafka socket.request.max.bytes default is 100<<20
@@ -450,6 +552,7 @@ func defaultCfg() cfg {
 		produceTimeout:      10 * time.Second,
 		recordRetries:       math.MaxInt64,             // effectively unbounded
 		partitioner:         StickyKeyPartitioner(nil), // default to how Kafka partitions
+		promiseConcurrency:  runtime.GOMAXPROCS(0),
 
 		//////////////
 		// consumer //
@@ -462,7 +565,8 @@ func defaultCfg() cfg {
 		resetOffset:    NewOffset().AtStart(),
 		isolationLevel: 0,
 
-		maxConcurrentFetches: 0, // unbounded default
+		maxConcurrentFetches:  0, // unbounded default
+		decompressConcurrency: runtime.GOMAXPROCS(0),
 
 		///////////
 		// group //
@@ -481,6 +585,38 @@ func defaultCfg() cfg {
 	}
 }
 
+// defaultRetryBackoff returns the default jittery exponential backoff, ranging
+// from 250ms to 2.5s, with jitter from the given seed.
+func defaultRetryBackoff(seed int64) func(int) time.Duration {
+	var rngMu sync.Mutex
+	rng := rand.New(rand.NewSource(seed))
+	return func(fails int) time.Duration {
+		const (
+			min = 250 * time.Millisecond
+			max = 5 * time.Second / 2
+		)
+		if fails <= 0 {
+			return min
+		}
+		if fails > 10 {
+			return max
+		}
+
+		backoff := min * time.Duration(1<<(fails-1))
+
+		rngMu.Lock()
+		jitter := 0.8 + 0.4*rng.Float64()
+		rngMu.Unlock()
+
+		backoff = time.Duration(float64(backoff) * jitter)
+
+		if backoff > max {
+			return max
+		}
+		return backoff
+	}
+}
+
 //////////////////////////
 // CLIENT CONFIGURATION //
 //////////////////////////
@@ -554,6 +690,25 @@ func ConnIdleTimeout(timeout time.Duration) Opt {
 	return clientOpt{func(cfg *cfg) { cfg.connIdleTimeout = timeout }}
 }
 
+// ThrottlePacing enables adaptively spacing out produce and fetch requests to
+// brokers that throttle the client for exceeding a quota, with the pace
+// between requests capped at max. By default, requests are not paced.
+//
+// When a broker throttles a request, the client waits out the throttle and
+// then sends requests as fast as it can, which usually immediately exceeds
+// the quota again. With pacing, every throttled produce or fetch response
+// increases the minimum time between requests of that kind to the broker by
+// half of the throttle time, and every response that is not throttled
+// decreases the minimum time by 10%. This converges on a request rate that
+// stays under the quota.
+//
+// Pacing happens before the client builds a produce or fetch request, so
+// other requests to the same broker are not delayed. Use HookThrottlePacing to
+// observe the current pace.
+func ThrottlePacing(max time.Duration) Opt {
+	return clientOpt{func(cfg *cfg) { cfg.throttlePacingMax = max }}
+}
+
 // Dialer uses fn to dial addresses, overriding the default dialer that uses a
 // 10s dial timeout and no TLS.
 //
@@ -647,6 +802,21 @@ func RetryBackoffFn(backoff func(int) time.Duration) Opt {
 	return clientOpt{func(cfg *cfg) { cfg.retryBackoff = backoff }}
 }
 
+// RandSeed seeds all randomness within the client, overriding the default
+// seed of the current time. The client logs its seed at the info level when
+// created, so that a seed from a problematic run can be used to reproduce the
+// run in tests.
+//
+// The seed is used for jitter in the default retry backoff and for random
+// partition choices in this package's partitioners. Each topic partitioner is
+// seeded from the client's seed in the order that topics are first produced
+// to. Goroutine scheduling, network timing, and broker responses are not
+// deterministic, so a seed only makes a run reproducible to the extent that
+// the run itself does not depend on these.
+func RandSeed(seed int64) Opt {
+	return clientOpt{func(cfg *cfg) { cfg.randSeed = &seed }}
+}
+
 // RequestRetries sets the number of tries that retriable requests are allowed,
 // overriding the default of 20.
 //
@@ -767,7 +937,83 @@ func SASL(sasls ...sasl.Mechanism) Opt {
 // to know the available hooks. A single hook can implement zero or all hook
 // interfaces, and only the hooks that it implements will be called.
 func WithHooks(hooks ...Hook) Opt {
-	return clientOpt{func(cfg *cfg) { cfg.hooks = append(cfg.hooks, hooks...) }}
+	return clientOpt{func(cfg *cfg) { cfg.hooks.hs = append(cfg.hooks.hs, hooks...) }}
+}
+
+// WarnSlowCallbacks opts in to warning about user callbacks that run longer
+// than the given threshold, overriding the default of not watching callbacks.
+//
+// Produce promises, group rebalance callbacks (OnPartitionsAssigned,
+// OnPartitionsRevoked, OnPartitionsLost), the group commit callback, and
+// hooks all run on internal client goroutines. A callback that blocks stalls
+// whatever that goroutine is responsible for: a slow produce promise delays
+// finishing every other record in the same batch and any further produce
+// requests to the same broker, and a slow hook can stall reading responses
+// from a broker. These stalls are otherwise silent.
+//
+// With this option, if a callback is still running once the threshold
+// elapses, the client logs a warning including the stack of the goroutine
+// running the callback, which shows where the callback is blocked. If the
+// callback eventually returns, the client logs another warning with how long
+// it took in total.
+//
+// Watching a callback has a small cost (on the order of a microsecond) per
+// invocation, and capturing a stack briefly stops the world, so this option
+// is best used while debugging stalls or with a generous threshold. A value
+// of 0 disables watching.
+func WarnSlowCallbacks(threshold time.Duration) Opt {
+	return clientOpt{func(cfg *cfg) { cfg.slowCallbackAfter = threshold }}
+}
+
+// RecoverCallbackPanics opts in to recovering panics in user callbacks that run
+// on internal client goroutines, overriding the default of letting panics
+// crash the program.
+//
+// By default, a panic in a produce promise, a partitioner, a group rebalance
+// or commit callback, or a hook unwinds an internal client goroutine and,
+// as with any unrecovered panic, crashes the program. With this option, the
+// client recovers the panic, logs it at the error level with the panicking
+// stack, and passes a *CallbackPanicError to any HookCallbackPanic.
+//
+// What happens after recovering depends on the callback:
+//
+//   - a partitioner panic fails the record being partitioned with the
+//     *CallbackPanicError
+//   - a produce promise panic is reported only; the record was already
+//     finished, and the client continues finishing the remaining records
+//   - a hook, rebalance callback, or commit callback panic is reported
+//     only, and the client continues as if the callback returned
+//
+// Recovering lets the client keep running, but the callback did not finish
+// what it was doing: a rebalance callback that panics partway through may
+// have left your own state inconsistent. Callbacks called directly on your
+// own goroutines, such as HookProduceRecordBuffered within Produce, are not
+// recovered.
+func RecoverCallbackPanics() Opt {
+	return clientOpt{func(cfg *cfg) { cfg.recoverPanics = true }}
+}
+
+// WithCodec replaces the client's builtin implementation of a compression
+// codec with impl, which is used both when compressing batches to produce and
+// when decompressing fetched batches. Only the type of the codec is used; the
+// codec's level is ignored, and any level must be configured in impl itself.
+//
+// This can be used to plug in alternative implementations of the standard
+// codecs, such as cgo zstd bindings. Kafka only supports gzip, snappy, lz4,
+// and zstd, so this cannot add new codecs, and NoCompression cannot be
+// replaced. Whether a codec is used when producing is still controlled by
+// ProducerBatchCompression. This option can be used multiple times to replace
+// multiple codecs, and the last implementation given for a codec wins.
+//
+// Snappy batches in the xerial framing that old Java clients produce are
+// always decoded with the builtin snappy implementation.
+func WithCodec(codec CompressionCodec, impl Codec) Opt {
+	return clientOpt{func(cfg *cfg) {
+		if cfg.codecs == nil {
+			cfg.codecs = make(map[int8]Codec)
+		}
+		cfg.codecs[codec.codec] = impl
+	}}
 }
 
 ////////////////////////////
@@ -841,6 +1087,27 @@ func ProducerBatchCompression(preference ...CompressionCodec) ProducerOpt {
 	return producerOpt{func(cfg *cfg) { cfg.compression = preference }}
 }
 
+// AdaptiveBatchCompression switches batch compression to choose between lz4,
+// zstd, and no compression per topic based on how well each topic's batches
+// compress, overriding ProducerBatchCompression. By default, compression is
+// not adaptive.
+//
+// Every 100 batches for a topic, the next batch of at least 4KiB is
+// compressed with both lz4 and zstd, and the compression time is scaled to
+// the time it would take to compress 1MiB. A codec is only used if it
+// compresses within cpuBudget per MiB and shrinks the batch by at least 10%.
+// lz4 is preferred unless zstd compresses at least 10% smaller, and no
+// compression is used if neither codec is worth it. Until a topic is first
+// sampled, and for brokers that do not support zstd, lz4 is used.
+//
+// A reasonable budget is a few milliseconds; zstd at its default level
+// compresses 1MiB in a few milliseconds on modern hardware, and lz4 is faster.
+// Use HookProduceCompressionChanged to observe the codec chosen for each
+// topic.
+func AdaptiveBatchCompression(cpuBudget time.Duration) ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.adaptiveCompressionBudget = cpuBudget }}
+}
+
 // ProducerBatchMaxBytes upper bounds the size of a record batch, overriding
 // the default 1MB.
 //
@@ -985,6 +1252,62 @@ func RecordDeliveryTimeout(timeout time.Duration) ProducerOpt {
 	return producerOpt{func(cfg *cfg) { cfg.recordTimeout = timeout }}
 }
 
+// ProduceTopicMaxIdle evicts topics that have not been produced to within the
+// given duration, overriding the default of never evicting topics.
+//
+// The client keeps metadata and per-partition buffers for every topic it has
+// ever produced to, and refreshes metadata for all of these topics. For
+// long lived producers that produce to many topics over time (for example,
+// one topic per tenant), this grows without bound. With this option, a topic
+// that has not been produced to within the max idle and that has nothing
+// buffered or inflight is evicted on the next metadata update. Producing to
+// an evicted topic later loads it again as if it were new, which requires a
+// metadata request before the record can be buffered. The client remembers
+// the idempotent sequence number of each evicted partition, so producing after
+// a reload continues where the partition left off.
+//
+// Evicting topics is not supported with transactions, because a transaction
+// must track every partition that was produced to in it.
+func ProduceTopicMaxIdle(idle time.Duration) ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.produceTopicMaxIdle = idle }}
+}
+
+// PromiseConcurrency sets the maximum number of goroutines that can call
+// produce promises at once, overriding the default of GOMAXPROCS.
+//
+// When a broker replies to a produce request, the client calls the promises
+// for every record in the request. Responses from different brokers are
+// handled concurrently, so by default, as many promises as there are brokers
+// can run at once. Bounding this is useful in small containers where many
+// brokers replying at once can otherwise crowd out the rest of the
+// application. Promises for records that fail before being buffered, such as
+// records that cannot be partitioned, are not bounded.
+//
+// Note that a low limit paired with slow promises slows down handling produce
+// responses from all brokers, not just the broker the slow promise is for.
+//
+// A value of 0 implies the allowed concurrency is unbounded.
+func PromiseConcurrency(n int) ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.promiseConcurrency = n }}
+}
+
+// ProduceDrainConcurrency sets the maximum number of goroutines that can
+// build produce requests at once, overriding the default of 0 (unbounded).
+//
+// Each broker that the client produces to has a goroutine that drains
+// buffered records into produce requests. Building a request picks batches
+// from every partition the broker leads, and bounding how many goroutines
+// can do this at once bounds the CPU that the client uses for draining when
+// producing to many brokers. Loading the producer ID, adding partitions to a
+// transaction, compressing and writing a request, and waiting for its
+// response are not bounded.
+//
+// A value of 0 implies the allowed concurrency is unbounded and will be
+// limited only by the number of brokers in the cluster.
+func ProduceDrainConcurrency(n int) ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.drainConcurrency = n }}
+}
+
 // TransactionalID sets a transactional ID for the client, ensuring that
 // records are produced transactionally under this ID (exactly once semantics).
 //
@@ -1107,22 +1430,159 @@ func MaxConcurrentFetches(n int) ConsumerOpt {
 	return consumerOpt{func(cfg *cfg) { cfg.maxConcurrentFetches = n }}
 }
 
+// DecompressionConcurrency sets the maximum number of goroutines that can
+// decompress fetched record batches at once, overriding the default of
+// GOMAXPROCS.
+//
+// Fetch responses from different brokers are processed concurrently, and
+// decompressing is the bulk of processing a response. Bounding this bounds
+// the CPU that the client uses for consuming when consuming from many
+// brokers, which is useful in small containers.
+//
+// A value of 0 implies the allowed concurrency is unbounded and will be
+// limited only by the number of brokers in the cluster.
+func DecompressionConcurrency(n int) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.decompressConcurrency = n }}
+}
+
+// PrefetchDrainedPartitions allows a source to fetch partitions that have been
+// fully drained from its buffered fetch while the rest of the fetch is still
+// buffered, overriding the default of fetching only once an entire buffered
+// fetch has been polled.
+//
+// Each broker has at most one fetch response buffered at a time. With
+// PollRecords, a buffered fetch can be drained over many polls. By default,
+// the broker is not fetched from again until every record is polled, which
+// can leave the application waiting on a new fetch once it finishes the
+// buffered records. With this option, once a poll drains every record for a
+// partition, a new fetch for that partition (and any other drained partitions)
+// is issued in the background. The response is held until the prior buffered
+// fetch is fully drained, so records are still returned in order and only one
+// fetch is buffered per broker.
+func PrefetchDrainedPartitions() ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.prefetchDrained = true }}
+}
+
+// MaxBufferedFetches sets the maximum number of fetch responses that can be
+// buffered waiting to be polled, overriding the unbounded default. Unlike
+// MaxConcurrentFetches, fetch requests that are in flight do not count
+// against this limit: this only limits responses that have been received and
+// not yet drained by polling.
+//
+// Once this many fetches are buffered, sources that want to fetch wait until
+// polling fully drains a buffered fetch. Requests that were already in flight
+// when the limit was reached may still be buffered, so the limit can be
+// exceeded by up to the number of in flight requests.
+//
+// A value of 0 implies no limit.
+func MaxBufferedFetches(n int) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.maxBufferedFetches = n }}
+}
+
+// MaxBufferedFetchRecords sets the maximum number of records that can be
+// buffered from fetching, overriding the unbounded default. This is the same
+// count that is returned from BufferedFetchRecords.
+//
+// Once this many records are buffered, no new fetch requests are issued until
+// polling brings the count back below the limit. Polling even a few records
+// can allow fetching to resume, which makes this the right knob for bounding
+// how far ahead of processing the client reads.
+//
+// Buffered records for paused partitions count against the limit. If the
+// limit is reached with only paused partitions buffered, fetching stops until
+// the partitions are resumed and polled, or until their buffered records are
+// dropped with PauseFetchPartitionsDropBuffered.
+//
+// A value of 0 implies no limit.
+func MaxBufferedFetchRecords(n int64) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.maxBufferedFetchRecords = n }}
+}
+
+// MaxBufferedFetchBytes sets the maximum number of bytes that can be buffered
+// from fetching, overriding the unbounded default. This is the same count that
+// is returned from BufferedFetchBytes.
+//
+// Once this many bytes are buffered, no new fetch requests are issued until
+// polling brings the count back below the limit. Additionally, while below
+// the limit, each fetch request asks for at most the remaining room (bounded
+// by FetchMaxBytes), so that a single response does not overshoot the limit
+// by much. Inflight fetches reserve the room they ask for, so concurrent
+// fetches to different brokers share the room rather than each asking for all
+// of it. Brokers always return at least one record batch so that the client
+// can make progress, so a single large batch can still exceed the limit.
+//
+// As with MaxBufferedFetchRecords, buffered bytes for paused partitions count
+// against the limit.
+//
+// A value of 0 implies no limit.
+func MaxBufferedFetchBytes(n int64) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.maxBufferedFetchBytes = n }}
+}
+
+// FetchBackpressureBytes opts in to pausing fetching the heaviest partitions
+// when more than n bytes are buffered from fetching, overriding the default of
+// never pausing automatically.
+//
+// Unlike MaxBufferedFetchBytes, which stops all fetching once the limit is
+// reached, this option keeps fetching partitions that are light on buffered
+// data. Once buffered bytes exceed n, the partitions with the most bytes
+// buffered are paused, heaviest first, until the bytes buffered for
+// partitions that remain unpaused are at most half of n. A paused partition
+// is resumed once all of its buffered records have been polled or discarded.
+// Pausing does not drop anything that is already buffered.
+//
+// Partitions paused by this option are tracked separately from
+// PauseFetchPartitions and can be inspected with BackpressuredPartitions.
+// Transitions are logged and reported to any HookFetchBackpressure.
+//
+// A value of 0 implies no automatic pausing.
+func FetchBackpressureBytes(n int64) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.backpressureBytes = n }}
+}
+
 // ConsumeResetOffset sets the offset to restart consuming from when a
 // partition has no commits (for groups) or when beginning to consume a
 // partition (for direct partition consuming), or when a fetch sees an
 // OffsetOutOfRange error, overriding the default ConsumeStartOffset.
 //
+// To fail loudly rather than reset, use NoResetOffset: partitions that would
+// be reset instead have ErrNoResetOffset injected into a poll.
+//
 // Defaults to: NewOffset().AtStart() / Earliest Offset
 func ConsumeResetOffset(offset Offset) ConsumerOpt {
 	return consumerOpt{func(cfg *cfg) { cfg.resetOffset = offset }}
 }
 
+// ConsumeTopicResetOffsets overrides ConsumeResetOffset for specific topics.
+// Topics that are not in the map use the ConsumeResetOffset offset.
+//
+// This is useful to begin consuming some topics at a timestamp with
+// NewOffset().AfterMilli, while other topics begin at the start or end. To
+// begin consuming specific partitions at a timestamp, use ConsumePartitions.
+func ConsumeTopicResetOffsets(offsets map[string]Offset) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.topicResets = offsets }}
+}
+
+// resetOffsetFor returns the offset to reset a partition in topic to.
+func (cfg *cfg) resetOffsetFor(topic string) Offset {
+	if offset, ok := cfg.topicResets[topic]; ok {
+		return offset
+	}
+	return cfg.resetOffset
+}
+
 // Rack specifies where the client is physically located and changes fetch
 // requests to consume from the closest replica as opposed to the leader
 // replica.
 //
 // Consuming from a preferred replica can increase latency but can decrease
 // cross datacenter costs. See KIP-392 for more information.
+//
+// If fetching from a preferred replica fails, either because the replica
+// returns an error for a partition (it is out of sync, offline, or is no
+// longer a replica) or because the replica cannot be reached at all, the
+// client fails over to fetching from the leader. The leader may redirect the
+// client to a preferred replica again on the next fetch.
 func Rack(rack string) ConsumerOpt {
 	return consumerOpt{func(cfg *cfg) { cfg.rack = rack }}
 }
@@ -1138,6 +1598,15 @@ type IsolationLevel struct {
 func ReadUncommitted() IsolationLevel { return IsolationLevel{0} }
 
 // ReadCommitted is an isolation level to only fetch committed records.
+//
+// Brokers do not return records past the last stable offset, which is the
+// offset of the earliest still open transaction, and return the aborted
+// transactions within each fetched range. The client uses the aborted
+// transactions to filter records from aborted transactions, and skips
+// decoding batches that are entirely aborted.
+//
+// Consuming transactionally produced data with ReadUncommitted returns records
+// from aborted transactions as well.
 func ReadCommitted() IsolationLevel { return IsolationLevel{1} }
 
 // FetchIsolationLevel sets the "isolation level" used for fetching
@@ -1149,15 +1618,39 @@ func FetchIsolationLevel(level IsolationLevel) ConsumerOpt {
 // KeepControlRecords sets the client to keep control messages and return
 // them with fetches, overriding the default that discards them.
 //
-// Generally, control messages are not useful.
+// Generally, control messages are not useful. They are useful if you need to
+// observe transaction boundaries, such as when auditing: a transaction ends
+// with a COMMIT or ABORT control record in each partition that it wrote to,
+// with the producer ID and epoch of the transaction set on the record. Use
+// ParseControlRecord to decode a control record's type and value.
+//
+// When consuming with ReadCommitted, records of aborted transactions are still
+// discarded, but the ABORT control records that end those transactions are
+// kept.
 func KeepControlRecords() ConsumerOpt {
 	return consumerOpt{func(cfg *cfg) { cfg.keepControl = true }}
 }
 
+// AnnotateRecordLag sets the Lag field on every consumed record to how far
+// behind the partition's high watermark the record was when it was fetched,
+// or, when consuming with ReadCommitted, how far behind the partition's last
+// stable offset.
+//
+// This allows deciding whether a record is stale, or whether the consumer is
+// falling behind, per record without issuing any extra requests. The lag is
+// as of the fetch response, not as of polling, so a record that was buffered
+// for a while may be more behind than its lag suggests.
+func AnnotateRecordLag() ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.recordLag = true }}
+}
+
 // ConsumeTopics adds topics to use for consuming.
 //
 // By default, consuming will start at the beginning of partitions. To change
 // this, use the ConsumeResetOffset option.
+//
+// Topics can be added or removed at runtime with the client's
+// AddConsumeTopics and RemoveConsumeTopics methods.
 func ConsumeTopics(topics ...string) ConsumerOpt {
 	return consumerOpt{func(cfg *cfg) {
 		cfg.topics = make(map[string]*regexp.Regexp, len(topics))
@@ -1179,6 +1672,10 @@ func ConsumeTopics(topics ...string) ConsumerOpt {
 // offsets, check out the kadm package's FetchOffsets and CommitOffsets
 // methods. These will allow you to commit as a group outside the context of a
 // Kafka group.
+//
+// Partitions can be added or removed at runtime with the client's
+// AddConsumePartitions and RemoveConsumePartitions methods. To begin with no
+// partitions and add them later, use an empty, non-nil map.
 func ConsumePartitions(partitions map[string]map[int32]Offset) ConsumerOpt {
 	return consumerOpt{func(cfg *cfg) { cfg.partitions = partitions }}
 }
@@ -1194,6 +1691,45 @@ func ConsumeRegex() ConsumerOpt {
 	return consumerOpt{func(cfg *cfg) { cfg.regex = true }}
 }
 
+// ConsumeRegexRemoveDeleted, when consuming via regex, stops consuming topics
+// once they are deleted. By default, a matched topic is consumed for the
+// lifetime of the client even if it is deleted, and fetching it fails until
+// it is recreated.
+//
+// New topics matching the regular expressions are discovered on every
+// metadata update, which happens at least every MetadataMaxAge. With this
+// option, a consumed topic that is missing from a metadata update is
+// considered deleted and is removed from consumption: a group consumer
+// rejoins the group with its reduced interests, and a direct consumer stops
+// consuming the topic's partitions. If the topic is later recreated, it is
+// evaluated against the regular expressions again and consumed from the reset
+// offset as if it were a new topic.
+//
+// This option is ignored unless ConsumeRegex is also used.
+func ConsumeRegexRemoveDeleted() ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.regexRemoveDeleted = true }}
+}
+
+// MaxConsumeTopics sets the maximum number of topics the client will consume,
+// overriding the default of no limit. This is mostly useful with ConsumeRegex
+// against clusters with very many topics, to guard against an overly broad
+// regular expression building enormous fetch requests and group subscriptions.
+//
+// If not consuming via regex, the topics specified in ConsumeTopics and
+// ConsumePartitions must not exceed the limit, or creating the client fails.
+// Topics added at runtime with AddConsumeTopics or AddConsumePartitions that
+// would exceed the limit are not consumed; the client logs a warning and calls
+// any HookConsumeTopicsCapped hooks.
+//
+// When consuming via regex, topics that match once the limit is reached are
+// not consumed. Since every topic is only ever evaluated once, these topics
+// are permanently skipped. Each time topics are skipped, the client logs a
+// warning and calls any HookConsumeTopicsCapped hooks. If many new topics are
+// discovered at once, which of them are consumed is unspecified.
+func MaxConsumeTopics(n int) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.maxConsumeTopics = n }}
+}
+
 // DisableFetchSessions sets the client to not use fetch sessions (Kafka 1.0+).
 //
 // A "fetch session" is is a way to reduce bandwidth for fetch requests &
@@ -1223,12 +1759,58 @@ func DisableFetchSessions() ConsumerOpt {
 	return consumerOpt{func(cfg *cfg) { cfg.disableFetchSessions = true }}
 }
 
+// ConsumeReplayCacheBytes enables a cache of recently polled records, bounded
+// to roughly the given number of bytes, that can be read with ReplayRecords.
+// This allows reprocessing records within the process, such as retrying a
+// failed handler, without seeking and refetching from the broker. By default,
+// no records are cached.
+//
+// The size of the cache is the sum of the keys, values, and headers of cached
+// records, as with BufferedFetchBytes. Once the cache is full, the oldest
+// polled records across all partitions are evicted first. If a partition is
+// polled from an offset at or before what is cached, e.g. after SetOffsets,
+// what was cached for that partition is dropped.
+//
+// Cached records are not dropped when partitions are revoked or lost in a
+// group rebalance: if a partition may be reassigned, you likely should not
+// replay records for it after it is revoked.
+func ConsumeReplayCacheBytes(n int64) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.replayCacheBytes = n }}
+}
+
+// ConsumeCheckpointFile periodically persists the offsets that a direct
+// (non-group) consumer has polled to the given local file, and resumes from
+// that file when the client is created. This allows a restarting consumer to
+// begin consuming immediately where it left off rather than at the offsets
+// configured with ConsumePartitions or ConsumeResetOffset. The file is written
+// every interval if anything new was polled, as well as once more when the
+// client is closed.
+//
+// Similar to group autocommitting, the checkpoint only includes what was
+// polled before the latest poll, which ensures at-least-once processing if
+// you process all polled records before polling again. The file is JSON,
+// mapping topics to partitions to an epoch and offset, and is replaced
+// atomically on every write.
+//
+// A checkpointed offset is only used when a partition is first assigned,
+// and it overrides the partition's configured offset. If a checkpointed
+// offset is out of range, e.g. because the partition's data was deleted, the
+// client resets per ConsumeResetOffset as normal, and if the checkpointed
+// epoch shows that the partition was truncated, the client handles data loss
+// as normal. If the file does not exist or cannot be parsed, the client
+// consumes from its configured offsets. This option cannot be used with
+// ConsumerGroup, which resumes from committed offsets.
+func ConsumeCheckpointFile(path string, interval time.Duration) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.checkpointPath, cfg.checkpointInterval = path, interval }}
+}
+
 //////////////////////////////////
 // CONSUMER GROUP CONFIGURATION //
 //////////////////////////////////
 
 // ConsumerGroup sets the consumer group for the client to join and consume in.
-// This option is required if using any other group options.
+// This option is required if using any other group options. A client can only
+// consume in one group; to consume in multiple groups, use multiple clients.
 //
 // Note that when group consuming, the default is to autocommit every 5s. To be
 // safe, autocommitting only commits what is *previously* polled. If you poll
@@ -1256,6 +1838,51 @@ func Balancers(balancers ...GroupBalancer) GroupOpt {
 	return groupOpt{func(cfg *cfg) { cfg.balancers = balancers }}
 }
 
+// AdjustBalancePlan sets a function to call when this client is the group
+// leader, after balancing and before the plan is sent to the group. The
+// function can inspect and modify the plan (member => topic => partitions)
+// to enforce constraints that the balancers do not know about.
+//
+// The function is only called if the chosen balancer uses a ConsumerBalancer
+// and returns a *BalancePlan, which all balancers in this package do.
+//
+// After the function returns, the modified plan is validated: every member
+// must be in the group and interested in each of its topics, every partition
+// must exist and be assigned at most once, and every partition that was
+// assigned in the original plan must still be assigned. If validation fails,
+// the client logs an error and uses the original plan. If the balancer is
+// cooperative, the adjusted plan is adjusted again to be cooperative (see
+// BalancePlan.AdjustCooperative).
+func AdjustBalancePlan(fn func(b *ConsumerBalancer, plan map[string]map[string][]int32)) GroupOpt {
+	return groupOpt{func(cfg *cfg) { cfg.adjustPlan = fn }}
+}
+
+// ExternalBalancePlan sets a function to call when this client is the group
+// leader to compute the balance plan (member => topic => partitions) rather
+// than using the chosen balancer's plan. This can be used to delegate
+// assignment to an external service, such as a central scheduler that knows
+// each member's capacity.
+//
+// The function is passed a context that is canceled if the group is left or
+// the rebalance timeout elapses, the ConsumerBalancer for the group, which can
+// be used to inspect each member's interests and metadata, and the number of
+// partitions in every topic the group is interested in. Members and topics can
+// be omitted from the returned plan.
+//
+// The balancer still chooses the protocol, encodes join metadata, and is the
+// fallback: if the function returns an error, or if the returned plan is
+// invalid, the client logs an error and uses the balancer's plan. The returned
+// plan is validated the same as in AdjustBalancePlan, meaning every partition
+// that the balancer assigned must be assigned in the external plan. If the
+// balancer is cooperative, the plan is adjusted to be cooperative. If
+// AdjustBalancePlan is also used, it is called with the external plan.
+//
+// The function is only called if the chosen balancer uses a ConsumerBalancer
+// and returns a *BalancePlan, which all balancers in this package do.
+func ExternalBalancePlan(fn func(ctx context.Context, b *ConsumerBalancer, topics map[string]int32) (map[string]map[string][]int32, error)) GroupOpt {
+	return groupOpt{func(cfg *cfg) { cfg.externalPlan = fn }}
+}
+
 // SessionTimeout sets how long a member in the group can go between
 // heartbeats, overriding the default 45,000ms. If a member does not heartbeat
 // in this timeout, the broker will remove the member from the group and
@@ -1294,7 +1921,8 @@ func RebalanceTimeout(timeout time.Duration) GroupOpt {
 //
 // Kafka uses heartbeats to ensure that a group member's session stays active.
 // This value can be any value lower than the session timeout, but should be no
-// higher than 1/3rd the session timeout.
+// higher than 1/3rd the session timeout. Creating a client fails if the
+// interval is not lower than the session timeout.
 //
 // This corresponds to Kafka's heartbeat.interval.ms.
 func HeartbeatInterval(interval time.Duration) GroupOpt {
@@ -1317,6 +1945,93 @@ func RequireStableFetchOffsets() GroupOpt {
 	return groupOpt{func(cfg *cfg) { cfg.requireStable = true }}
 }
 
+// ExternalOffsetStore sets the group consumer to store offsets in an external
+// store rather than committing them to Kafka, and to begin consuming newly
+// assigned partitions from the stored offsets.
+//
+// This allows offsets to be stored atomically alongside application state,
+// such as in the same database transaction that writes the results of
+// processing (the outbox pattern). Every commit, whether from autocommitting
+// or from a commit function such as CommitUncommittedOffsets, calls the
+// store's StoreOffsets with the context passed to the commit function, so an
+// application can disable autocommitting and carry its own database
+// transaction in the context to commit offsets within it. The commit callback
+// is called with a successful response if the offsets are stored.
+//
+// When partitions are assigned, offsets are first fetched from the store;
+// partitions that the store has no offsets for fall back to offsets committed
+// in Kafka, and then to the reset offset. This allows migrating an existing
+// group to an external store.
+//
+// Kafka's commits are fenced by group generation, which prevents a member
+// that has been kicked from the group from committing. Offsets stored
+// externally are not fenced, so applications should guard against zombie
+// members in their store if necessary.
+//
+// Offsets committed in transactions, such as with GroupTransactSession, do not
+// use the store.
+func ExternalOffsetStore(store OffsetStore) GroupOpt {
+	return groupOpt{func(cfg *cfg) { cfg.offsetStore = store }}
+}
+
+// MirrorStoredOffsets sets the group consumer to also commit offsets to Kafka
+// after storing them in the ExternalOffsetStore. The mirrored commit is for
+// observability only, allowing Kafka tooling to track the group's progress
+// and lag: failures to mirror are logged and otherwise ignored, and offsets
+// are always fetched from the store first.
+func MirrorStoredOffsets() GroupOpt {
+	return groupOpt{func(cfg *cfg) { cfg.mirrorStoredOffsets = true }}
+}
+
+// RequirePartitionsReady sets the group consumer to not fetch newly assigned
+// partitions until they are marked ready with MarkPartitionsReady. This can be
+// used to warm up state for a partition, e.g. restoring a local store in
+// OnPartitionsAssigned, without the client buffering records for partitions
+// that cannot be processed yet.
+//
+// Partitions can be marked ready at any time, including from within
+// OnPartitionsAssigned, and even before their committed offsets are fetched.
+// Eager consumers are assigned all partitions after every rebalance and thus
+// must mark all partitions ready after every rebalance, while cooperative
+// consumers only need to mark newly assigned partitions ready. Partitions that
+// are revoked or lost before being marked ready no longer need to be marked.
+//
+// Readiness is independent from pausing: a partition is only fetched if it is
+// both ready and not paused.
+func RequirePartitionsReady() GroupOpt {
+	return groupOpt{func(cfg *cfg) { cfg.requireReady = true }}
+}
+
+// PrefetchStandbyPartitions opts in to prefetching standby partitions that
+// are published by the group balancer, which allows a member to quickly take
+// over a partition if the member consuming it fails.
+//
+// Standby partitions are only published by balancers that implement
+// GroupStandbyBalancer; none of the balancers in this package do. When the
+// chosen balancer publishes standby partitions for this member, the client
+// fetches the group's committed offsets for those partitions in the
+// background and positions the partitions as if they were assigned, validating
+// the offsets and listing offsets per ConsumeResetOffset as necessary.
+//
+// Standby partitions are then fetched at low priority but never returned from
+// polling: they are requested at their prefetched position with a small
+// partition limit, and their position does not advance. This keeps standby partitions in the broker's fetch session,
+// keeps their positions valid across leader changes, and has the broker read
+// the log where this member would start consuming. If a fetch has nothing to
+// return, the client waits out FetchMaxWait before fetching again, since
+// standby partitions with data make the broker reply immediately.
+//
+// If a standby partition is later assigned to this member, the client still
+// fetches the committed offset for the partition as normal. If the committed
+// offset has not moved since the partition was prefetched, which is common if
+// the prior member failed, the client begins fetching immediately from the
+// prefetched position rather than validating or listing offsets again.
+// Otherwise, the partition is positioned as it would be without this option.
+// Prefetched positions are refreshed every group generation.
+func PrefetchStandbyPartitions() GroupOpt {
+	return groupOpt{func(cfg *cfg) { cfg.prefetchStandby = true }}
+}
+
 // OnPartitionsAssigned sets the function to be called when a group is joined
 // after partitions are assigned before fetches for those partitions begin.
 //
@@ -1327,6 +2042,13 @@ func RequireStableFetchOffsets() GroupOpt {
 // The OnPartitionsAssigned function is passed the client's context, which is
 // only canceled if the client is closed.
 //
+// The map passed to OnPartitionsAssigned is exactly what is newly assigned.
+// For eager balancers, members give up everything every rebalance, so this is
+// the member's entire assignment. For cooperative balancers, this is only the
+// partitions that the member did not own before the rebalance, and it may be
+// empty: the function is always called once a rebalance completes so that
+// you know the assignment is done.
+//
 // This function is not called concurrent with any other On callback, and this
 // function is given a new map that the user is free to modify.
 func OnPartitionsAssigned(onAssigned func(context.Context, *Client, map[string][]int32)) GroupOpt {
@@ -1353,7 +2075,19 @@ func OnPartitionsAssigned(onAssigned func(context.Context, *Client, map[string][
 // called at the end of a group session even if there are no partitions being
 // revoked. If you are committing offsets manually (have disabled
 // autocommitting), it is highly recommended to do a proper blocking commit in
-// OnPartitionsRevoked.
+// OnPartitionsRevoked. If you are autocommitting, setting this function
+// replaces the default blocking commit unless you also use CommitOnRevoke.
+//
+// The map passed to OnPartitionsRevoked is exactly what this member is no
+// longer consuming. For eager balancers, this is everything the member owned.
+// For cooperative balancers, this is only the partitions that moved to other
+// members, and it is empty at the end of a group session in which nothing
+// moved. When leaving the group, this is everything the member owned.
+//
+// Before this function is called, the client stops fetching the revoked
+// partitions, cancels any in flight fetches for them, and drops any buffered
+// records for them that have not yet been polled. Once this function is
+// called, no poll returns records for revoked partitions.
 //
 // This function is not called concurrent with any other On callback, and this
 // function is given a new map that the user is free to modify.
@@ -1371,12 +2105,54 @@ func OnPartitionsRevoked(onRevoked func(context.Context, *Client, map[string][]i
 // forcefully loses all partitions. If you wish to use the same callback for
 // lost and revoked, you can use OnPartitionsLostAsRevoked as a shortcut.
 //
+// The map passed to OnPartitionsLost is everything the member owned when the
+// error occurred, for both eager and cooperative balancers. As with
+// OnPartitionsRevoked, the client stops fetching and drops buffered records
+// for the lost partitions before calling this function.
+//
 // This function is not called concurrent with any other On callback, and this
 // function is given a new map that the user is free to modify.
 func OnPartitionsLost(onLost func(context.Context, *Client, map[string][]int32)) GroupOpt {
 	return groupOpt{func(cfg *cfg) { cfg.onLost, cfg.setLost = onLost, true }}
 }
 
+// GroupFencedAction is what the client does after it is fenced from its group,
+// as returned from an OnGroupFenced function.
+type GroupFencedAction int8
+
+const (
+	// GroupFencedRejoin rejoins the group after the usual retry backoff.
+	// This is the default for ILLEGAL_GENERATION and UNKNOWN_MEMBER_ID,
+	// which are usually a race with a rebalance or a session that
+	// expired while the client was paused.
+	GroupFencedRejoin GroupFencedAction = iota
+
+	// GroupFencedStop stops managing the group and injects the error into
+	// polling as a fake fetch with no topic and a partition of 0. This is
+	// the default for FENCED_INSTANCE_ID, which means another client joined
+	// with this client's instance ID. The client does not rejoin the group
+	// until it is closed and recreated.
+	GroupFencedStop
+)
+
+// OnGroupFenced sets the function to call when this group member is fenced,
+// that is, when a group request fails with ILLEGAL_GENERATION,
+// UNKNOWN_MEMBER_ID, or FENCED_INSTANCE_ID. The returned action determines
+// whether the client rejoins the group or stops managing the group. This can
+// be used to fail fast if a fenced member likely means this client is a
+// zombie, such as if another process took over its work, rather than rejoining
+// as if the fence was a routine rebalance race.
+//
+// The function is called after OnPartitionsLost, is passed the fencing error,
+// which can be checked with errors.Is against the kerr errors, and must not
+// block. If this is not set, the defaults documented on GroupFencedRejoin and
+// GroupFencedStop are used. Returning GroupFencedRejoin for
+// FENCED_INSTANCE_ID is not recommended: the rejoin fences the other member,
+// which will likely rejoin and fence this member, and so on.
+func OnGroupFenced(fn func(cl *Client, err error) GroupFencedAction) GroupOpt {
+	return groupOpt{func(cfg *cfg) { cfg.onFenced = fn }}
+}
+
 // DisableAutoCommit disable auto committing.
 //
 // If you disable autocommitting, you may want to use a custom
@@ -1424,10 +2200,51 @@ func AutoCommitInterval(interval time.Duration) GroupOpt {
 // manually mark records to be autocommitted before you poll again. This way,
 // if you usually take a long time between polls, your partial work can still
 // be automatically checkpointed through autocommitting.
+//
+// Marking a record marks everything before it in the same partition, so for
+// at-least-once processing, only mark a record once it and all records before
+// it in its partition have been processed.
 func AutoCommitMarks() GroupOpt {
 	return groupOpt{func(cfg *cfg) { cfg.autocommitMarks = true }}
 }
 
+// CommitOnRevoke keeps the default blocking commit in OnPartitionsRevoked even
+// if you set your own OnPartitionsRevoked function.
+//
+// By default, setting OnPartitionsRevoked replaces the default revoke, which
+// is a blocking commit of what has been autocommitted but not yet committed.
+// If your function only logs or cleans up state, the records consumed since
+// the last autocommit are consumed again by the partitions' new owners. With
+// this option, the blocking commit runs before your function, and the
+// rebalance does not continue until the commit finishes. As with autocommit,
+// what is committed is what was previously polled, or what was marked if
+// using AutoCommitMarks.
+//
+// This option cannot be used with DisableAutoCommit: if you commit manually,
+// you should commit in OnPartitionsRevoked yourself. This option does nothing
+// for transactional clients, which commit through transactions.
+func CommitOnRevoke() GroupOpt {
+	return groupOpt{func(cfg *cfg) { cfg.commitOnRevoke = true }}
+}
+
+// CommitOnClose makes Close synchronously commit everything that has been
+// polled, as with CommitUncommittedOffsets, before leaving the group.
+//
+// By default, leaving the group in Close only commits through the revoke,
+// which for autocommitting commits what was previously polled: the records
+// from the latest poll are consumed again by the partitions' new owners. If
+// you finish processing everything you polled before calling Close, this
+// option avoids those duplicates. If using AutoCommitMarks, this commits what
+// was marked.
+//
+// This option can be used with DisableAutoCommit, in which case Close is the
+// only place the client commits on its own. If the commit fails, the error is
+// logged and the client continues closing. This option does nothing for
+// transactional clients, which commit through transactions.
+func CommitOnClose() GroupOpt {
+	return groupOpt{func(cfg *cfg) { cfg.commitOnClose = true }}
+}
+
 // InstanceID sets the group consumer's instance ID, switching the group member
 // from "dynamic" to "static".
 //
@@ -1452,14 +2269,47 @@ func AutoCommitMarks() GroupOpt {
 // issues a leave group request on behalf of this instance ID (see kcl), or you
 // can manually use the kmsg package with a proper LeaveGroupRequest.
 //
+// If another client joins the group with the same instance ID, the broker
+// fences this client with FENCED_INSTANCE_ID. By default, being fenced is
+// fatal: OnLost is called, the client stops managing the group, and the error
+// is injected into polling as a fake fetch with no topic and a partition of 0
+// (see OnGroupFenced). Only one client should ever use a given instance ID at
+// a time.
+//
 // NOTE: Leaving a group with an instance ID is only supported in Kafka 2.4.0+.
 func InstanceID(id string) GroupOpt {
 	return groupOpt{func(cfg *cfg) { cfg.instanceID = &id }}
 }
 
+// GroupReason sets a default reason to send to Kafka when this member joins
+// or leaves the group (KIP-800), which brokers log to explain rebalances.
+//
+// When the client rejoins the group for a reason of its own, such as a
+// rebalance, a subscription change, or an error, it sends that reason
+// instead. The default is sent on the initial join and when leaving the group
+// in LeaveGroup or Close. Without this option, the client sends no reason on
+// the initial join and a generic reason when leaving.
+//
+// Reasons are only sent to brokers that support JoinGroup v8 and LeaveGroup
+// v5 (Kafka 3.2+).
+func GroupReason(reason string) GroupOpt {
+	return groupOpt{func(cfg *cfg) { cfg.groupReason = reason }}
+}
+
 // GroupProtocol sets the group's join protocol, overriding the default value
 // "consumer". The only reason to override this is if you are implementing
 // custom join and sync group logic.
+//
+// With a protocol other than "consumer", the client can join a group without
+// consuming any topics, which allows for using the group for coordination
+// only, similar to Kafka Connect's "connect" protocol. In this case, you must
+// use the Balancers option with your own GroupBalancer: its JoinGroupMetadata
+// returns this member's raw protocol metadata, the GroupMemberBalancer from
+// MemberBalancer receives every member's raw metadata when this member is the
+// leader and returns each member's raw assignment, and ParseSyncAssignment
+// receives this member's raw assignment. ParseSyncAssignment can return no
+// topics if the assignment is not of topics and partitions. If no topics are
+// consumed, the client joins the group as soon as it is created.
 func GroupProtocol(protocol string) GroupOpt {
 	return groupOpt{func(cfg *cfg) { cfg.protocol = protocol }}
 }
@@ -0,0 +1,75 @@
+package kgo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateGroupTimeouts(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		opts  []Opt
+		valid bool
+	}{
+		{"defaults", nil, true},
+		{"slow revoke", []Opt{RebalanceTimeout(5 * time.Minute), SessionTimeout(time.Minute), HeartbeatInterval(10 * time.Second)}, true},
+		{"heartbeat equals session", []Opt{SessionTimeout(3 * time.Second), HeartbeatInterval(3 * time.Second)}, false},
+		{"heartbeat above session", []Opt{SessionTimeout(time.Second), HeartbeatInterval(3 * time.Second)}, false},
+		{"heartbeat zero", []Opt{HeartbeatInterval(0)}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := defaultCfg()
+			for _, opt := range test.opts {
+				opt.apply(&cfg)
+			}
+			if err := cfg.validate(); (err == nil) != test.valid {
+				t.Errorf("got err %v, expected valid %v", err, test.valid)
+			}
+		})
+	}
+}
+
+func TestValidateCommitOnRevoke(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		opts  []Opt
+		valid bool
+	}{
+		{"with group", []Opt{ConsumerGroup("g"), ConsumeTopics("t"), CommitOnRevoke()}, true},
+		{"with marks", []Opt{ConsumerGroup("g"), ConsumeTopics("t"), AutoCommitMarks(), CommitOnRevoke()}, true},
+		{"without group", []Opt{ConsumeTopics("t"), CommitOnRevoke()}, false},
+		{"autocommit disabled", []Opt{ConsumerGroup("g"), ConsumeTopics("t"), DisableAutoCommit(), CommitOnRevoke()}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := defaultCfg()
+			for _, opt := range test.opts {
+				opt.apply(&cfg)
+			}
+			if err := cfg.validate(); (err == nil) != test.valid {
+				t.Errorf("got err %v, expected valid %v", err, test.valid)
+			}
+		})
+	}
+}
+
+func TestValidateCommitOnClose(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		opts  []Opt
+		valid bool
+	}{
+		{"with group", []Opt{ConsumerGroup("g"), ConsumeTopics("t"), CommitOnClose()}, true},
+		{"autocommit disabled", []Opt{ConsumerGroup("g"), ConsumeTopics("t"), DisableAutoCommit(), CommitOnClose()}, true},
+		{"without group", []Opt{ConsumeTopics("t"), CommitOnClose()}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := defaultCfg()
+			for _, opt := range test.opts {
+				opt.apply(&cfg)
+			}
+			if err := cfg.validate(); (err == nil) != test.valid {
+				t.Errorf("got err %v, expected valid %v", err, test.valid)
+			}
+		})
+	}
+}
@@ -3,6 +3,8 @@ package kgo
 import (
 	"context"
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -18,9 +20,13 @@ type Offset struct {
 	relative     int64
 	epoch        int32
 	currentEpoch int32 // set by us when mapping offsets to brokers
+	afterMilli   bool  // if true, at is a millisecond timestamp to list
 }
 
 func (o Offset) MarshalJSON() ([]byte, error) {
+	if o.afterMilli {
+		return []byte(fmt.Sprintf(`{"AfterMilli":%d,"Epoch":%d,"CurrentEpoch":%d}`, o.at, o.epoch, o.currentEpoch)), nil
+	}
 	if o.relative == 0 {
 		return []byte(fmt.Sprintf(`{"At":%d,"Epoch":%d,"CurrentEpoch":%d}`, o.at, o.epoch, o.currentEpoch)), nil
 	}
@@ -29,6 +35,9 @@ func (o Offset) MarshalJSON() ([]byte, error) {
 
 // String returns the offset as a string; the purpose of this is for logs.
 func (o Offset) String() string {
+	if o.afterMilli {
+		return fmt.Sprintf("{after %dms.%d %d}", o.at, o.epoch, o.currentEpoch)
+	}
 	if o.relative == 0 {
 		return fmt.Sprintf("{%d.%d %d}", o.at, o.epoch, o.currentEpoch)
 	} else if o.relative > 0 {
@@ -53,6 +62,7 @@ func NewOffset() Offset {
 // to begin at the beginning of a partition.
 func (o Offset) AtStart() Offset {
 	o.at = -2
+	o.afterMilli = false
 	return o
 }
 
@@ -60,12 +70,20 @@ func (o Offset) AtStart() Offset {
 // begin at the end of a partition.
 func (o Offset) AtEnd() Offset {
 	o.at = -1
+	o.afterMilli = false
 	return o
 }
 
 // Relative returns a copy of the calling offset, changing the returned offset
 // to be n relative to what it currently is. If the offset is beginning at the
 // end, Relative(-100) will begin 100 before the end.
+//
+// Offsets relative to the start or end are resolved with a ListOffsets
+// request whenever a partition begins being consumed, so that, for example,
+// NewOffset().AtEnd().Relative(-1000) used with ConsumeResetOffset consumes
+// roughly the last 1000 records of every partition at the time each
+// partition is assigned. If a partition has fewer records than the relative
+// amount, consuming begins at the start (or end) of the partition.
 func (o Offset) Relative(n int64) Offset {
 	o.relative = n
 	return o
@@ -96,16 +114,100 @@ func (o Offset) At(at int64) Offset {
 		at = -2
 	}
 	o.at = at
+	o.afterMilli = false
+	return o
+}
+
+// AfterMilli returns a copy of the calling offset, changing the returned
+// offset to begin at the first record whose timestamp is at or after the
+// given Unix millisecond timestamp. The offset is found with a ListOffsets
+// request when the partition begins being consumed.
+//
+// If no record is at or after the timestamp, consuming begins at the end of
+// the partition. Relative offsets are not supported with AfterMilli and are
+// ignored.
+//
+// For example, to replay everything since midnight yesterday:
+//
+//     y, m, d := time.Now().AddDate(0, 0, -1).Date()
+//     kgo.NewOffset().AfterMilli(time.Date(y, m, d, 0, 0, 0, 0, time.Local).UnixNano() / 1e6)
+func (o Offset) AfterMilli(millisec int64) Offset {
+	if millisec < 0 {
+		millisec = 0
+	}
+	o.at = millisec
+	o.relative = 0
+	o.afterMilli = true
+	return o
+}
+
+// withinBounds is used when resetting after OffsetOutOfRange. If the offset is
+// relative to the start or end and resolving it against the partition's
+// current log start and high watermark would be out of range again, we reset
+// to the nearest bound instead. Otherwise, a relative offset that points
+// before the start of a short partition would loop resetting forever.
+func (o Offset) withinBounds(logStart, hwm int64) Offset {
+	if o.relative == 0 || o.afterMilli || o.at >= 0 || logStart < 0 || hwm < 0 {
+		return o
+	}
+	var at int64
+	switch o.at {
+	case -2:
+		at = logStart + o.relative
+	case -1:
+		at = hwm + o.relative
+	default:
+		return o
+	}
+	if at < logStart {
+		return NewOffset().AtStart()
+	} else if at > hwm {
+		return NewOffset().AtEnd()
+	}
 	return o
 }
 
+// isExact returns whether the offset is an exact offset to consume at, rather
+// than a special offset or a timestamp that must be listed.
+func (o Offset) isExact() bool { return o.at >= 0 && !o.afterMilli }
+
+// NoResetOffset returns an offset to use in ConsumeResetOffset that disables
+// resetting entirely, similar to the Java client's "none" reset policy.
+//
+// With this offset, whenever the client would otherwise reset a partition,
+// that is, when a group partition has no commit, when beginning to consume a
+// partition directly, or when a fetch sees OffsetOutOfRange, the client
+// instead stops consuming the partition and injects ErrNoResetOffset into a
+// poll for it. The partition is not consumed again until it is repositioned,
+// for example with SetOffsets, or reassigned in a new group session.
+//
+// Calling AtStart, AtEnd, or At on the returned offset turns it back into a
+// normal offset.
+func NoResetOffset() Offset {
+	return Offset{
+		at:    -3,
+		epoch: -1,
+	}
+}
+
+func (o Offset) isNoReset() bool { return o.at == -3 }
+
 type consumer struct {
 	cl *Client
 
 	bufferedRecords int64
+	bufferedBytes   int64
+	bufferedFetches int64
 
-	pausedMu sync.Mutex   // grabbed when updating paused
-	paused   atomic.Value // loaded when issuing fetches
+	// reservedFetchBytes is the max bytes of inflight fetches when
+	// buffered bytes are limited; see MaxBufferedFetchBytes.
+	reservedFetchBytes int64
+
+	pausedMu   sync.Mutex   // grabbed when updating paused, unready, standby, or dropPaused
+	paused     atomic.Value // loaded when issuing fetches
+	unready    atomic.Value // pausedTopics; partitions awaiting MarkPartitionsReady
+	standby    atomic.Value // pausedTopics; prefetched standby partitions, fetched but never returned
+	dropPaused atomic.Value // pausedTopics; paused partitions whose buffered records are dropped when polling
 
 	// mu is grabbed when
 	//  - polling fetches, for quickly draining sources / updating group uncommitted
@@ -138,6 +240,11 @@ type consumer struct {
 
 	usingCursors usedCursors
 
+	replay     *replayCache // non-nil if ConsumeReplayCacheBytes is used
+	checkpoint *checkpoint  // non-nil if ConsumeCheckpointFile is used
+
+	bp *backpressure // non-nil if FetchBackpressureBytes is used
+
 	sourcesReadyMu          sync.Mutex
 	sourcesReadyCond        *sync.Cond
 	sourcesReadyForDraining []*source
@@ -148,6 +255,57 @@ func (c *consumer) loadPaused() pausedTopics   { return c.paused.Load().(pausedT
 func (c *consumer) clonePaused() pausedTopics  { return c.paused.Load().(pausedTopics).clone() }
 func (c *consumer) storePaused(p pausedTopics) { c.paused.Store(p) }
 
+func (c *consumer) loadUnready() pausedTopics    { return c.unready.Load().(pausedTopics) }
+func (c *consumer) loadDropPaused() pausedTopics { return c.dropPaused.Load().(pausedTopics) }
+func (c *consumer) loadStandby() pausedTopics    { return c.standby.Load().(pausedTopics) }
+
+// storeStandby replaces the partitions that are only fetched as standby
+// partitions, which are never returned from polling.
+func (c *consumer) storeStandby(topicPartitions map[string][]int32) {
+	c.pausedMu.Lock()
+	defer c.pausedMu.Unlock()
+	standby := make(pausedTopics)
+	standby.addPartitions(topicPartitions)
+	c.standby.Store(standby)
+}
+
+// gateUnready marks partitions as not ready, blocking fetching them until
+// they are marked ready.
+func (c *consumer) gateUnready(topicPartitions map[string][]int32) {
+	c.pausedMu.Lock()
+	defer c.pausedMu.Unlock()
+	unready := c.loadUnready().clone()
+	unready.addPartitions(topicPartitions)
+	c.unready.Store(unready)
+}
+
+// markReady unblocks fetching partitions; if topicPartitions is nil, this
+// unblocks all partitions.
+func (c *consumer) markReady(topicPartitions map[string][]int32) {
+	c.pausedMu.Lock()
+	defer c.pausedMu.Unlock()
+	var unready pausedTopics
+	if topicPartitions == nil {
+		unready = make(pausedTopics)
+	} else {
+		unready = c.loadUnready().clone()
+		unready.delPartitions(topicPartitions)
+	}
+	c.unready.Store(unready)
+}
+
+// MarkPartitionsReady marks partitions as ready to be fetched when using the
+// RequirePartitionsReady group option. Marking partitions that are already
+// ready, or that are not assigned, is a no-op.
+func (cl *Client) MarkPartitionsReady(topicPartitions map[string][]int32) {
+	cl.consumer.markReady(topicPartitions)
+	cl.sinksAndSourcesMu.Lock()
+	for _, sns := range cl.sinksAndSources {
+		sns.source.maybeConsume()
+	}
+	cl.sinksAndSourcesMu.Unlock()
+}
+
 // BufferedFetchRecords returns the number of records currently buffered from
 // fetching within the client.
 //
@@ -161,6 +319,18 @@ func (cl *Client) BufferedFetchRecords() int64 {
 	return atomic.LoadInt64(&cl.consumer.bufferedRecords)
 }
 
+// BufferedFetchBytes returns the number of bytes currently buffered from
+// fetching within the client. This is the sum of all keys, values, and headers
+// in buffered records, which is the bulk of the memory the client holds for
+// fetching, but not including per-record overhead nor fetch responses that are
+// still being decompressed and decoded.
+//
+// As with BufferedFetchRecords, spikes are normal; this is only problematic if
+// it is consistently large.
+func (cl *Client) BufferedFetchBytes() int64 {
+	return atomic.LoadInt64(&cl.consumer.bufferedBytes)
+}
+
 type usedCursors map[*cursor]struct{}
 
 func (u *usedCursors) use(c *cursor) {
@@ -173,15 +343,28 @@ func (u *usedCursors) use(c *cursor) {
 func (c *consumer) init(cl *Client) {
 	c.cl = cl
 	c.paused.Store(make(pausedTopics))
+	c.unready.Store(make(pausedTopics))
+	c.standby.Store(make(pausedTopics))
+	c.dropPaused.Store(make(pausedTopics))
 	c.sourcesReadyCond = sync.NewCond(&c.sourcesReadyMu)
+	if cl.cfg.backpressureBytes > 0 {
+		c.bp = newBackpressure(cl.cfg.backpressureBytes)
+	}
 
-	if len(cl.cfg.topics) == 0 && len(cl.cfg.partitions) == 0 {
+	if len(cl.cfg.topics) == 0 && cl.cfg.partitions == nil && !cl.cfg.customGroupProtocol() {
 		return // not consuming
 	}
 
 	defer cl.triggerUpdateMetadata(true, "client initialization") // we definitely want to trigger a metadata update
 
+	if cl.cfg.replayCacheBytes > 0 {
+		c.replay = newReplayCache(cl.cfg.replayCacheBytes)
+	}
+
 	if len(cl.cfg.group) == 0 {
+		if cl.cfg.checkpointPath != "" {
+			c.checkpoint = newCheckpoint(cl)
+		}
 		c.initDirect()
 	} else {
 		c.initGroup()
@@ -192,6 +375,210 @@ func (c *consumer) consuming() bool {
 	return c.g != nil || c.d != nil
 }
 
+// AddConsumeTopics begins consuming the given topics, in addition to any
+// already being consumed. This is the runtime equivalent of ConsumeTopics: if
+// the client is consuming via regex, the topics are parsed as regular
+// expressions, and any expression that fails to compile is logged and
+// ignored.
+//
+// For group consumers, the group is rejoined once metadata for the new topics
+// is loaded, so that the new topics can be assigned. Direct consumers begin
+// consuming the new topics from the reset offset once their metadata is
+// loaded.
+//
+// If not consuming via regex, topics past the MaxConsumeTopics limit are not
+// added; they are logged and passed to any HookConsumeTopicsCapped hooks. With
+// regex, the limit applies as expressions match topics.
+//
+// This only works for clients that were configured to consume. To begin a
+// direct consumer with nothing to consume, use an empty, non-nil
+// ConsumePartitions map.
+func (cl *Client) AddConsumeTopics(topics ...string) {
+	c := &cl.consumer
+	if !c.consuming() {
+		cl.cfg.logger.Log(LogLevelWarn, "ignoring AddConsumeTopics on a client that is not configured to consume")
+		return
+	}
+	if len(topics) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	topics = c.capTopics(topics)
+	next := make(map[string]*regexp.Regexp, len(cl.cfg.topics)+len(topics))
+	for topic, re := range cl.cfg.topics {
+		next[topic] = re
+	}
+	var added []string
+	for _, topic := range topics {
+		if _, exists := next[topic]; exists {
+			continue
+		}
+		var re *regexp.Regexp
+		if cl.cfg.regex {
+			var err error
+			if re, err = regexp.Compile(topic); err != nil {
+				cl.cfg.logger.Log(LogLevelWarn, "ignoring AddConsumeTopics regular expression that does not compile", "regex", topic, "err", err)
+				continue
+			}
+		}
+		next[topic] = re
+		added = append(added, topic)
+	}
+	if len(added) == 0 {
+		return
+	}
+	c.storeConsumeTopics(next)
+
+	// With regex, topics that did not match before may match the new
+	// expressions, so we forget what did not match to evaluate it again.
+	// Without regex, we need to load metadata for the new topics.
+	switch {
+	case c.d != nil && cl.cfg.regex:
+		forgetUnwanted(c.d.reSeen)
+	case c.d != nil:
+		c.d.tps.storeTopics(added)
+	case cl.cfg.regex:
+		forgetUnwanted(c.g.reSeen)
+	default:
+		c.g.tps.storeTopics(added)
+	}
+
+	cl.triggerUpdateMetadataNow("AddConsumeTopics")
+}
+
+// RemoveConsumeTopics stops consuming the given topics, dropping any buffered
+// records for them. If the client is consuming via regex, the topics must be
+// the regular expressions originally given, and topics that no longer match
+// any remaining expression stop being consumed.
+//
+// For group consumers, the group is rejoined with the reduced interests, and
+// the removed topics are revoked as part of the rebalance. Direct consumers
+// immediately stop consuming the removed topics, including any partitions of
+// them that were added with ConsumePartitions or AddConsumePartitions.
+func (cl *Client) RemoveConsumeTopics(topics ...string) {
+	c := &cl.consumer
+	if !c.consuming() {
+		cl.cfg.logger.Log(LogLevelWarn, "ignoring RemoveConsumeTopics on a client that is not configured to consume")
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	next := make(map[string]*regexp.Regexp, len(cl.cfg.topics))
+	for topic, re := range cl.cfg.topics {
+		next[topic] = re
+	}
+	var removed []string
+	for _, topic := range topics {
+		if _, exists := next[topic]; exists {
+			delete(next, topic)
+			removed = append(removed, topic)
+		}
+	}
+	if len(removed) == 0 && (c.d == nil || cl.cfg.regex) {
+		return
+	}
+	c.storeConsumeTopics(next)
+
+	if c.d != nil {
+		remove := c.d.removeTopics(topics)
+		if len(remove) > 0 {
+			c.assignPartitions(remove, assignInvalidateMatching, c.d.tps, "RemoveConsumeTopics")
+		}
+		return
+	}
+
+	// With regex, we re-evaluate the topics we are using against the
+	// remaining expressions. Without regex, we stop loading metadata for
+	// the removed topics. Either way, the group notices it is using
+	// topics it no longer wants and rejoins.
+	if cl.cfg.regex {
+		c.g.unwantRegexTopics()
+	} else {
+		c.g.tps.purgeTopics(removed)
+	}
+	c.g.findNewAssignments()
+}
+
+// storeConsumeTopics replaces the topics to consume. This must be called
+// with the consumer mu held; the group mu is also grabbed because leaving a
+// group checks the topics.
+// capTopics, called under the consumer mu, returns the topics that can be
+// added without exceeding MaxConsumeTopics, in order. Topics past the limit
+// are logged and passed to HookConsumeTopicsCapped. With regex, the limit is
+// applied as expressions match topics, so everything is returned.
+func (c *consumer) capTopics(adding []string) []string {
+	cfg := &c.cl.cfg
+	if cfg.maxConsumeTopics <= 0 || cfg.regex {
+		return adding
+	}
+
+	consumed := make(map[string]struct{}, len(cfg.topics))
+	for topic := range cfg.topics {
+		consumed[topic] = struct{}{}
+	}
+	if c.d != nil {
+		for topic := range c.d.partitions {
+			consumed[topic] = struct{}{}
+		}
+	}
+
+	var keep, capped []string
+	for _, topic := range adding {
+		if _, exists := consumed[topic]; !exists {
+			if len(consumed) >= cfg.maxConsumeTopics {
+				capped = append(capped, topic)
+				continue
+			}
+			consumed[topic] = struct{}{}
+		}
+		keep = append(keep, topic)
+	}
+
+	if len(capped) > 0 {
+		sort.Strings(capped)
+		cfg.logger.Log(LogLevelWarn, "adding consume topics would exceed the max consume topics, not consuming them", "max_consume_topics", cfg.maxConsumeTopics, "capped", capped)
+		cfg.hooks.each(func(h Hook) {
+			if h, ok := h.(HookConsumeTopicsCapped); ok {
+				h.OnConsumeTopicsCapped(capped)
+			}
+		})
+	}
+	return keep
+}
+
+func (c *consumer) storeConsumeTopics(topics map[string]*regexp.Regexp) {
+	if c.g != nil {
+		c.g.mu.Lock()
+		defer c.g.mu.Unlock()
+	}
+	c.cl.cfg.topics = topics
+}
+
+// forgetUnwanted deletes every regex topic that was evaluated and skipped, so
+// that it is evaluated again.
+func forgetUnwanted(reSeen map[string]bool) {
+	for topic, want := range reSeen {
+		if !want {
+			delete(reSeen, topic)
+		}
+	}
+}
+
+// regexWants returns whether topic matches any of the regex topics.
+func regexWants(topics map[string]*regexp.Regexp, topic string) bool {
+	for _, re := range topics {
+		if re.MatchString(topic) {
+			return true
+		}
+	}
+	return false
+}
+
 // addSourceReadyForDraining tracks that a source needs its buffered fetch
 // consumed.
 func (c *consumer) addSourceReadyForDraining(source *source) {
@@ -239,7 +626,9 @@ func (cl *Client) PollFetches(ctx context.Context) Fetches {
 // return immediately with any currently buffered records.
 //
 // This returns a maximum of maxPollRecords total across all fetches, or
-// returns all buffered records if maxPollRecords is <= 0.
+// returns all buffered records if maxPollRecords is <= 0. To keep fetching in
+// the background while buffered records are polled a few at a time, see the
+// PrefetchDrainedPartitions option.
 //
 // It is important to check all partition errors in the returned fetches. If
 // any partition has a fatal error and actually had no records, fake fetch will
@@ -256,6 +645,7 @@ func (cl *Client) PollRecords(ctx context.Context, maxPollRecords int) Fetches {
 	c := &cl.consumer
 
 	c.g.undirtyUncommitted()
+	c.checkpoint.undirty()
 
 	var fetches Fetches
 	fill := func() {
@@ -275,6 +665,9 @@ func (cl *Client) PollRecords(ctx context.Context, maxPollRecords int) Fetches {
 		defer c.mu.Unlock()
 
 		c.sourcesReadyMu.Lock()
+		if drop := c.loadDropPaused(); len(drop) > 0 {
+			c.dropPausedBuffered(drop)
+		}
 		if maxPollRecords < 0 {
 			for _, ready := range c.sourcesReadyForDraining {
 				fetches = append(fetches, ready.takeBuffered())
@@ -303,6 +696,10 @@ func (cl *Client) PollRecords(ctx context.Context, maxPollRecords int) Fetches {
 			return
 		}
 
+		if c.replay != nil {
+			c.replay.add(realFetches)
+		}
+
 		// Before returning, we want to update our uncommitted. If we
 		// updated after, then we could end up with weird interactions
 		// with group invalidations where we return a stale fetch after
@@ -315,6 +712,8 @@ func (cl *Client) PollRecords(ctx context.Context, maxPollRecords int) Fetches {
 		if c.g != nil {
 			c.g.updateUncommitted(realFetches)
 		}
+		c.checkpoint.update(realFetches)
+		c.cl.metrics.observePolled(realFetches)
 	}
 
 	fill()
@@ -334,7 +733,7 @@ func (cl *Client) PollRecords(ctx context.Context, maxPollRecords int) Fetches {
 		defer c.sourcesReadyMu.Unlock()
 		defer close(done)
 
-		for !quit && len(c.sourcesReadyForDraining) == 0 {
+		for !quit && len(c.sourcesReadyForDraining) == 0 && len(c.fakeReadyForDraining) == 0 {
 			c.sourcesReadyCond.Wait()
 		}
 	}()
@@ -363,6 +762,104 @@ func (cl *Client) PollRecords(ctx context.Context, maxPollRecords int) Fetches {
 	return fetches
 }
 
+// PollFetchesChan returns a channel that fetches are delivered on, as an
+// alternative to calling PollRecords in a loop. This allows consuming within a
+// select loop alongside other channels. A goroutine polls with maxPollRecords
+// (see PollRecords) and sends each non-empty poll on the channel, which has
+// the given capacity.
+//
+// Delivery is bounded: once the channel is full, the goroutine stops polling
+// until the channel is received from. Records that are not yet polled remain
+// buffered in the client, which, as usual, stops fetching once the client has
+// a buffered fetch from every broker. To bound this further, see the
+// MaxBufferedFetches, MaxBufferedFetchRecords, and MaxBufferedFetchBytes
+// options.
+//
+// The channel is closed once the context is canceled or the client is closed.
+// If the client is closed, the final fetches sent on the channel contain
+// ErrClientClosed, as with PollRecords. The context should be canceled once
+// the channel is no longer received from, otherwise the polling goroutine
+// blocks until the client is closed.
+//
+// If the context is canceled while the goroutine is waiting for room in the
+// channel, the fetches it is waiting to send are sent only if there is room
+// right away. Otherwise, the partitions in those fetches are rewound to their
+// first unsent record: the records are not committed, and later polls return
+// them again.
+//
+// Polling happens ahead of processing: records are considered polled once
+// the goroutine polls them, not once they are received from the channel.
+// Because autocommitting commits what was polled before the latest poll,
+// autocommitting can commit records that are still in the channel or being
+// processed. If that is a concern, use AutoCommitMarks and mark records as
+// they are processed, or commit manually.
+//
+// This must not be used concurrently with other polling.
+func (cl *Client) PollFetchesChan(ctx context.Context, maxPollRecords, capacity int) <-chan Fetches {
+	ch := make(chan Fetches, capacity)
+	go func() {
+		defer close(ch)
+		for ctx.Err() == nil {
+			fetches := cl.PollRecords(ctx, maxPollRecords)
+			if len(fetches) > 0 {
+				select {
+				case ch <- fetches:
+				case <-ctx.Done():
+					select {
+					case ch <- fetches:
+					default:
+						cl.rewindFetches(fetches)
+					}
+					return
+				}
+			}
+			if fetches.IsClientClosed() {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// rewindFetches resets consuming for every partition in fetches to the
+// partition's first record. This is used when records were polled but not
+// handed to the user, so that later polls return them again and a group does
+// not commit them.
+func (cl *Client) rewindFetches(fetches Fetches) {
+	rewind := make(map[string]map[int32]Offset)
+	fetches.EachPartition(func(p FetchTopicPartition) {
+		if len(p.Records) == 0 {
+			return
+		}
+		r := p.Records[0]
+		ps := rewind[p.Topic]
+		if ps == nil {
+			ps = make(map[int32]Offset)
+			rewind[p.Topic] = ps
+		}
+		ps[p.Partition] = Offset{at: r.Offset, epoch: r.LeaderEpoch}
+	})
+	if len(rewind) == 0 {
+		return
+	}
+
+	c := &cl.consumer
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var tps *topicsPartitions
+	switch {
+	case c.g != nil:
+		c.g.rewindUncommitted(rewind)
+		tps = c.g.tps
+	case c.d != nil:
+		tps = c.d.tps
+	default:
+		return
+	}
+	c.assignPartitions(rewind, assignSetMatching, tps, "rewinding polled fetches that were not delivered")
+}
+
 // PauseFetchTopics sets the client to no longer fetch the given topics and
 // returns all currently paused topics. Paused topics persist until resumed.
 // You can call this function with no topics to simply receive the list of
@@ -370,7 +867,13 @@ func (cl *Client) PollRecords(ctx context.Context, maxPollRecords int) Fetches {
 //
 // In contrast to the canonical Java client, this function does not clear
 // anything currently buffered. Buffered fetches containing paused topics are
-// still returned from polling.
+// still returned from polling, as are fetches that were in flight when the
+// topics were paused.
+//
+// Pausing does not affect group membership: the client continues to heartbeat
+// and keeps ownership of paused partitions, so pausing can be used for
+// backpressure without causing a rebalance. Paused partitions are still
+// revoked or lost normally if the group rebalances for other reasons.
 //
 // Pausing topics is independent from pausing individual partitions with the
 // PauseFetchPartitions method. If you pause partitions for a topic with
@@ -399,7 +902,12 @@ func (cl *Client) PauseFetchTopics(topics ...string) []string {
 //
 // In contrast to the canonical Java client, this function does not clear
 // anything currently buffered. Buffered fetches containing paused partitions
-// are still returned from polling.
+// are still returned from polling, as are fetches that were in flight when the
+// partitions were paused. To drop buffered records for paused partitions, use
+// PauseFetchPartitionsDropBuffered.
+//
+// As with PauseFetchTopics, pausing partitions does not affect group
+// membership and does not cause a rebalance.
 //
 // Pausing individual partitions is independent from pausing topics with the
 // PauseFetchTopics method. If you pause partitions for a topic with
@@ -421,9 +929,52 @@ func (cl *Client) PauseFetchPartitions(topicPartitions map[string][]int32) map[s
 	return paused.pausedPartitions()
 }
 
+// PauseFetchPartitionsDropBuffered is the same as PauseFetchPartitions, but
+// additionally drops any records for the given partitions that are buffered in
+// the client, including records from fetches that were in flight when the
+// partitions were paused. Dropping happens when polling, so polling after this
+// function returns never returns records for these partitions until they are
+// resumed.
+//
+// Dropped records are not lost: the consume position of each dropped
+// partition is not advanced past what was dropped, so resuming the partition
+// with ResumeFetchPartitions fetches the dropped records again. This is useful
+// for per-partition flow control, where records for a stalled downstream
+// should not sit in memory while the partition is paused.
+func (cl *Client) PauseFetchPartitionsDropBuffered(topicPartitions map[string][]int32) map[string][]int32 {
+	c := &cl.consumer
+	c.pausedMu.Lock()
+	defer c.pausedMu.Unlock()
+
+	drop := c.loadDropPaused().clone()
+	drop.addPartitions(topicPartitions)
+	c.dropPaused.Store(drop)
+
+	paused := c.clonePaused()
+	paused.addPartitions(topicPartitions)
+	c.storePaused(paused)
+	return paused.pausedPartitions()
+}
+
+// dropPausedBuffered drops buffered records for partitions paused with
+// PauseFetchPartitionsDropBuffered. This must be called with the consumer mu
+// and sourcesReadyMu held.
+func (c *consumer) dropPausedBuffered(drop pausedTopics) {
+	keep := c.sourcesReadyForDraining[:0]
+	for _, source := range c.sourcesReadyForDraining {
+		if !source.dropBuffered(drop) {
+			keep = append(keep, source)
+		}
+	}
+	for i := len(keep); i < len(c.sourcesReadyForDraining); i++ {
+		c.sourcesReadyForDraining[i] = nil
+	}
+	c.sourcesReadyForDraining = keep
+}
+
 // ResumeFetchTopics resumes fetching the input topics if they were previously
 // paused. Resuming topics that are not currently paused is a per-topic no-op.
-// See the documentation on PauseTfetchTopics for more details.
+// See the documentation on PauseFetchTopics for more details.
 func (cl *Client) ResumeFetchTopics(topics ...string) {
 	defer func() {
 		cl.sinksAndSourcesMu.Lock()
@@ -459,11 +1010,154 @@ func (cl *Client) ResumeFetchPartitions(topicPartitions map[string][]int32) {
 	c.pausedMu.Lock()
 	defer c.pausedMu.Unlock()
 
+	if drop := c.loadDropPaused(); len(drop) > 0 {
+		drop = drop.clone()
+		drop.delPartitions(topicPartitions)
+		c.dropPaused.Store(drop)
+	}
+
 	paused := c.clonePaused()
 	paused.delPartitions(topicPartitions)
 	c.storePaused(paused)
 }
 
+// ConsumePosition is the current consume position of an assigned partition.
+type ConsumePosition struct {
+	// Next is the offset of the next record to be returned from polling,
+	// along with the leader epoch of the last polled record. If the
+	// client is still resolving where to start consuming the partition,
+	// this is {-1, -1}.
+	Next EpochOffset
+
+	// Committed is the last offset committed for this partition. This is
+	// only tracked when consuming as a group member. If not in a group, or
+	// if nothing has been committed yet, this is {-1, -1}.
+	Committed EpochOffset
+
+	// HighWatermark, LastStableOffset, and LogStartOffset are from the
+	// latest fetch response for this partition, including responses that
+	// had no records for the partition. These are -1 until the partition
+	// has been fetched.
+	//
+	// The partition's lag is HighWatermark minus Next.Offset, or
+	// LastStableOffset minus Next.Offset if reading committed; the
+	// partition is caught up once the lag is zero. Since the watermarks
+	// are from the latest fetch and Next is from the latest poll, the lag
+	// only counts records that have not yet been polled.
+	HighWatermark    int64
+	LastStableOffset int64
+	LogStartOffset   int64
+}
+
+// GetAssignment returns the topics and partitions this client is currently
+// assigned to consume, with partitions sorted. This includes partitions that
+// are still having their start offsets resolved.
+//
+// For group consumers, this does not include partitions that were assigned in
+// the latest rebalance but have not yet had their committed offsets fetched.
+// If the client is not consuming, this returns nil.
+//
+// This function is meant for debugging and health endpoints; it takes the
+// same lock that polling takes and should not be called in a hot loop.
+func (cl *Client) GetAssignment() map[string][]int32 {
+	c := &cl.consumer
+	if !c.consuming() {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	assigned := make(map[string][]int32)
+	c.eachAssigned(
+		func(cursor *cursor) { assigned[cursor.topic] = append(assigned[cursor.topic], cursor.partition) },
+		func(topic string, partition int32) { assigned[topic] = append(assigned[topic], partition) },
+	)
+	for _, partitions := range assigned {
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+	}
+	return assigned
+}
+
+// GetConsumePositions returns the consume position of every partition this
+// client is currently assigned; see GetAssignment for which partitions are
+// considered assigned. If the client is not consuming, this returns nil.
+//
+// As with GetAssignment, this function is meant for debugging and health
+// endpoints.
+func (cl *Client) GetConsumePositions() map[string]map[int32]ConsumePosition {
+	c := &cl.consumer
+	if !c.consuming() {
+		return nil
+	}
+
+	unknown := EpochOffset{-1, -1}
+	positions := make(map[string]map[int32]ConsumePosition)
+	set := func(topic string, partition int32, next EpochOffset, w cursorWatermarks) {
+		ps := positions[topic]
+		if ps == nil {
+			ps = make(map[int32]ConsumePosition)
+			positions[topic] = ps
+		}
+		ps[partition] = ConsumePosition{
+			Next:             next,
+			Committed:        unknown,
+			HighWatermark:    w.highWatermark,
+			LastStableOffset: w.lastStableOffset,
+			LogStartOffset:   w.logStartOffset,
+		}
+	}
+
+	// We grab the group mu after the consumer mu, as everywhere else.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.eachAssigned(
+		func(cursor *cursor) {
+			set(cursor.topic, cursor.partition, EpochOffset{
+				Epoch:  cursor.lastConsumedEpoch,
+				Offset: cursor.offset,
+			}, cursor.loadWatermarks())
+		},
+		func(topic string, partition int32) { set(topic, partition, unknown, cursorWatermarks{-1, -1, -1}) },
+	)
+
+	if g := c.g; g != nil {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		for topic, partitions := range positions {
+			uncommitted := g.uncommitted[topic]
+			for partition, position := range partitions {
+				if u, exists := uncommitted[partition]; exists && u.committed.Offset >= 0 {
+					position.Committed = u.committed
+					partitions[partition] = position
+				}
+			}
+		}
+	}
+
+	return positions
+}
+
+// eachAssigned, called under the consumer mu, calls onCursor for every cursor
+// in use and onLoading for every partition that is still listing offsets or
+// loading epochs.
+//
+// Handling list or epoch results can concurrently update our used cursors; we
+// grab the session's listOrEpochMu to guard against that. The session cannot
+// change while we hold the consumer mu.
+func (c *consumer) eachAssigned(onCursor func(*cursor), onLoading func(string, int32)) {
+	session := c.loadSession()
+	session.listOrEpochMu.Lock()
+	defer session.listOrEpochMu.Unlock()
+
+	for cursor := range c.usingCursors {
+		onCursor(cursor)
+	}
+	session.listOrEpochLoadsWaiting.each(onLoading)
+	session.listOrEpochLoadsLoading.each(onLoading)
+}
+
 // assignHow controls how assignPartitions operates.
 type assignHow int8
 
@@ -651,7 +1345,7 @@ func (c *consumer) assignPartitions(assignments map[string]map[int32]Offset, how
 			// First, if the request is exact, get rid of the relative
 			// portion. We are modifying a copy of the offset, i.e. we
 			// are appropriately not modfying 'assignments' itself.
-			if offset.at >= 0 {
+			if offset.isExact() {
 				offset.at = offset.at + offset.relative
 				if offset.at < 0 {
 					offset.at = 0
@@ -670,7 +1364,7 @@ func (c *consumer) assignPartitions(assignments map[string]map[int32]Offset, how
 			// fetch offsets only if the broker supports KIP-320,
 			// but we do not override the user manually specifying
 			// an epoch.
-			if offset.at >= 0 && offset.epoch >= 0 {
+			if offset.isExact() && offset.epoch >= 0 {
 				loadOffsets.addLoad(topic, partition, loadTypeEpoch, offsetLoad{
 					replica: -1,
 					Offset:  offset,
@@ -685,7 +1379,7 @@ func (c *consumer) assignPartitions(assignments map[string]map[int32]Offset, how
 			// If an offset is unspecified or we have not loaded
 			// the partition, we list offsets to find out what to
 			// use.
-			if offset.at >= 0 && partition >= 0 && partition < int32(len(topicPartitions.partitions)) {
+			if offset.isExact() && partition >= 0 && partition < int32(len(topicPartitions.partitions)) {
 				part := topicPartitions.partitions[partition]
 				cursor := part.cursor
 				cursor.setOffset(cursorOffset{
@@ -722,6 +1416,9 @@ func (c *consumer) doOnMetadataUpdate() {
 
 			switch {
 			case c.d != nil:
+				if removed := c.d.findRemovedTopics(); len(removed) > 0 {
+					c.assignPartitions(removed, assignInvalidateMatching, c.d.tps, "removing deleted topics from direct consumer")
+				}
 				if new := c.d.findNewAssignments(); len(new) > 0 {
 					c.assignPartitions(new, assignWithoutInvalidating, c.d.tps, "new assignments from direct consumer")
 				}
@@ -934,6 +1631,7 @@ type consumerSession struct {
 	// they send back when they are done. Thus, three level chan.
 	desireFetchCh       chan chan chan struct{}
 	cancelFetchCh       chan chan chan struct{}
+	unbufferedCh        chan struct{} // signaled when buffered fetch data is drained, if buffering is limited
 	allowedFetches      int
 	fetchManagerStarted uint32 // atomic, once 1, we start the fetch manager
 
@@ -965,6 +1663,7 @@ func (c *consumer) newConsumerSession(tps *topicsPartitions) *consumerSession {
 
 		desireFetchCh:  make(chan chan chan struct{}, 8),
 		cancelFetchCh:  make(chan chan chan struct{}, 4),
+		unbufferedCh:   make(chan struct{}, 1),
 		allowedFetches: c.cl.cfg.maxConcurrentFetches,
 	}
 	session.workersCond = sync.NewCond(&session.workersMu)
@@ -973,7 +1672,7 @@ func (c *consumer) newConsumerSession(tps *topicsPartitions) *consumerSession {
 
 func (c *consumerSession) desireFetch() chan chan chan struct{} {
 	if atomic.SwapUint32(&c.fetchManagerStarted, 1) == 0 {
-		go c.manageFetchConcurrency()
+		c.c.cl.actors.spawn("fetch concurrency manager", purposeLabels("fetch"), c.manageFetchConcurrency)
 	}
 	return c.desireFetchCh
 }
@@ -1009,12 +1708,13 @@ func (c *consumerSession) manageFetchConcurrency() {
 
 		case <-doneFetch:
 			activeFetches--
+		case <-c.unbufferedCh:
 		case <-ctxCh:
 			wantQuit = true
 			ctxCh = nil
 		}
 
-		if len(wantFetch) > 0 && (activeFetches < c.allowedFetches || c.allowedFetches == 0) { // 0 means unbounded
+		if len(wantFetch) > 0 && (activeFetches < c.allowedFetches || c.allowedFetches == 0) && c.c.bufferRoom() { // 0 means unbounded
 			wantFetch[0] <- doneFetch
 			wantFetch = wantFetch[1:]
 			activeFetches++
@@ -1027,6 +1727,59 @@ func (c *consumerSession) manageFetchConcurrency() {
 	}
 }
 
+// bufferRoom returns whether the buffered fetch limits allow issuing another
+// fetch.
+func (c *consumer) bufferRoom() bool {
+	cfg := &c.cl.cfg
+	return (cfg.maxBufferedFetches == 0 || atomic.LoadInt64(&c.bufferedFetches) < int64(cfg.maxBufferedFetches)) &&
+		(cfg.maxBufferedFetchRecords == 0 || atomic.LoadInt64(&c.bufferedRecords) < cfg.maxBufferedFetchRecords) &&
+		(cfg.maxBufferedFetchBytes == 0 || c.bufferedOrReservedBytes() < cfg.maxBufferedFetchBytes)
+}
+
+// bufferedOrReservedBytes returns the buffered bytes plus the bytes that
+// inflight fetches may buffer.
+func (c *consumer) bufferedOrReservedBytes() int64 {
+	return atomic.LoadInt64(&c.bufferedBytes) + atomic.LoadInt64(&c.reservedFetchBytes)
+}
+
+// reserveFetchBytes reserves and returns the room a fetch can buffer, at most
+// maxBytes, when buffered bytes are limited. If no room remains, this reserves
+// one byte so that the fetch still makes progress. The room is reserved with a
+// compare-and-swap so that concurrent fetches cannot take the same room.
+func (c *consumer) reserveFetchBytes(maxBytes int64) int64 {
+	limit := c.cl.cfg.maxBufferedFetchBytes
+	for {
+		reserved := atomic.LoadInt64(&c.reservedFetchBytes)
+		room := limit - atomic.LoadInt64(&c.bufferedBytes) - reserved
+		if room < 1 {
+			room = 1
+		}
+		if room > maxBytes {
+			room = maxBytes
+		}
+		if atomic.CompareAndSwapInt64(&c.reservedFetchBytes, reserved, reserved+room) {
+			return room
+		}
+	}
+}
+
+// signalUnbuffered wakes the fetch concurrency manager after buffered data is
+// drained, allowing fetches that were waiting on buffer limits to proceed.
+func (c *consumer) signalUnbuffered() {
+	cfg := &c.cl.cfg
+	if cfg.maxBufferedFetches == 0 && cfg.maxBufferedFetchRecords == 0 && cfg.maxBufferedFetchBytes == 0 {
+		return
+	}
+	session := c.loadSession()
+	if session == noConsumerSession {
+		return
+	}
+	select {
+	case session.unbufferedCh <- struct{}{}:
+	default:
+	}
+}
+
 func (c *consumerSession) incWorker() {
 	if c == noConsumerSession { // from startNewSession
 		return
@@ -1185,6 +1938,11 @@ func (c *consumer) startNewSession(tps *topicsPartitions) *consumerSession {
 func (s *consumerSession) listOrEpoch(waiting listOrEpochLoads, immediate bool, why string) {
 	defer s.decWorker()
 
+	s.c.failNoResetLoads(&waiting)
+	if waiting.isEmpty() {
+		return
+	}
+
 	wait := true
 	if immediate {
 		s.c.cl.triggerUpdateMetadataNow(why)
@@ -1347,7 +2105,7 @@ func (s *consumerSession) handleListOrEpochResults(loaded loadedOffsets) (reload
 
 		default: // from ErrorCode in a response
 			reloads.addLoad(load.topic, load.partition, loaded.loadType, load.request)
-			if !kerr.IsRetriable(load.err) && !isRetriableBrokerErr(load.err) && !isDialErr(load.err) { // non-retriable response error; signal such in a response
+			if load.err != errListedPastEnd && !kerr.IsRetriable(load.err) && !isRetriableBrokerErr(load.err) && !isDialErr(load.err) { // non-retriable response error; signal such in a response
 				s.c.addFakeReadyForDraining(load.topic, load.partition, load.err)
 			}
 
@@ -1365,6 +2123,25 @@ func (s *consumerSession) handleListOrEpochResults(loaded loadedOffsets) (reload
 	return reloads
 }
 
+// failNoResetLoads removes any list load for NoResetOffset, injecting
+// ErrNoResetOffset for the partition rather than listing offsets. The cursor
+// for the partition is left unused.
+func (c *consumer) failNoResetLoads(loads *listOrEpochLoads) {
+	for topic, partitions := range loads.List {
+		for partition, load := range partitions {
+			if !load.isNoReset() {
+				continue
+			}
+			loads.removeLoad(topic, partition)
+			c.cl.cfg.logger.Log(LogLevelWarn, "partition needs an offset reset but the reset offset is NoResetOffset, not consuming",
+				"topic", topic,
+				"partition", partition,
+			)
+			c.addFakeReadyForDraining(topic, partition, ErrNoResetOffset)
+		}
+	}
+}
+
 // Splits the loads into per-broker loads, mapping each partition to the broker
 // that leads that partition.
 func (s *consumerSession) mapLoadsToBrokers(loads listOrEpochLoads) map[*broker]listOrEpochLoads {
@@ -1502,8 +2279,21 @@ func (cl *Client) listOffsetsForBrokerLoad(ctx context.Context, broker *broker,
 			if len(rPartition.OldStyleOffsets) > 0 { // if we have any, we used list offsets v0
 				offset = rPartition.OldStyleOffsets[0] + loadPart.relative
 			}
-			if loadPart.at >= 0 {
+			if loadPart.isExact() {
 				offset = loadPart.at + loadPart.relative // we obey exact requests, even if they end up past the end
+			} else if loadPart.afterMilli && offset < 0 {
+				// No record is at or after the timestamp: we
+				// relist to consume at the end.
+				loaded.add(loadedOffset{
+					topic:     topic,
+					partition: partition,
+					err:       errListedPastEnd,
+					request: offsetLoad{
+						replica: loadPart.replica,
+						Offset:  NewOffset().AtEnd(),
+					},
+				})
+				continue
 			}
 			if offset < 0 {
 				offset = 0
@@ -1609,13 +2399,13 @@ func (o offsetLoadMap) buildListReq(isolationLevel int8) *kmsg.ListOffsetsReques
 			// loaded by the client (due to metadata). We use -1
 			// just to ensure the partition is loaded.
 			timestamp := offset.at
-			if timestamp >= 0 {
+			if offset.isExact() {
 				timestamp = -1
 			}
 			p := kmsg.NewListOffsetsRequestTopicPartition()
 			p.Partition = partition
 			p.CurrentLeaderEpoch = offset.currentEpoch // KIP-320
-			p.Timestamp = offset.at
+			p.Timestamp = timestamp
 			p.MaxNumOffsets = 1
 
 			parts = append(parts, p)
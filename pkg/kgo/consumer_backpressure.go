@@ -0,0 +1,192 @@
+package kgo
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// backpressure tracks buffered fetch bytes per partition and pauses fetching
+// the heaviest partitions while too much is buffered; see the
+// FetchBackpressureBytes option.
+type backpressure struct {
+	threshold int64
+
+	mu    sync.Mutex
+	bytes map[string]map[int32]int64 // buffered bytes per partition
+	total int64
+
+	// paused is loaded when issuing fetches and is only stored under mu.
+	paused atomic.Value // pausedTopics
+}
+
+func newBackpressure(threshold int64) *backpressure {
+	bp := &backpressure{
+		threshold: threshold,
+		bytes:     make(map[string]map[int32]int64),
+	}
+	bp.paused.Store(make(pausedTopics))
+	return bp
+}
+
+func (bp *backpressure) loadPaused() pausedTopics { return bp.paused.Load().(pausedTopics) }
+
+type backpressurePartition struct {
+	topic     string
+	partition int32
+	bytes     int64
+}
+
+// observe updates buffered bytes for a fetch that was just buffered or
+// unbuffered, and returns what was paused or resumed as a result.
+//
+// When buffering pushes the total over the threshold, we pause the heaviest
+// partitions until what is buffered for unpaused partitions is at most half
+// the threshold; pausing only down to the threshold would have us pausing
+// and resuming on nearly every fetch. A paused partition is resumed once
+// everything buffered for it has been drained.
+func (bp *backpressure) observe(f *Fetch, buffered bool) (paused, resumed map[string][]int32, total int64) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	for i := range f.Topics {
+		t := &f.Topics[i]
+		for j := range t.Partitions {
+			p := &t.Partitions[j]
+			var nbytes int64
+			for _, r := range p.Records {
+				nbytes += r.userSize()
+			}
+			if nbytes == 0 {
+				continue
+			}
+			if !buffered {
+				nbytes = -nbytes
+			}
+			bp.total += nbytes
+
+			ps := bp.bytes[t.Topic]
+			if ps == nil {
+				ps = make(map[int32]int64)
+				bp.bytes[t.Topic] = ps
+			}
+			if now := ps[p.Partition] + nbytes; now > 0 {
+				ps[p.Partition] = now
+			} else {
+				delete(ps, p.Partition)
+				if len(ps) == 0 {
+					delete(bp.bytes, t.Topic)
+				}
+			}
+		}
+	}
+
+	current := bp.loadPaused()
+
+	if !buffered {
+		for topic, pps := range current {
+			for partition := range pps.m {
+				if bp.bytes[topic][partition] > 0 {
+					continue
+				}
+				if resumed == nil {
+					resumed = make(map[string][]int32)
+				}
+				resumed[topic] = append(resumed[topic], partition)
+			}
+		}
+		if len(resumed) > 0 {
+			next := current.clone()
+			next.delPartitions(resumed)
+			bp.paused.Store(next)
+		}
+		return nil, resumed, bp.total
+	}
+
+	if bp.total <= bp.threshold {
+		return nil, nil, bp.total
+	}
+
+	var heaviest []backpressurePartition
+	unpaused := bp.total
+	for topic, ps := range bp.bytes {
+		for partition, nbytes := range ps {
+			if current.has(topic, partition) {
+				unpaused -= nbytes
+				continue
+			}
+			heaviest = append(heaviest, backpressurePartition{topic, partition, nbytes})
+		}
+	}
+	sort.Slice(heaviest, func(i, j int) bool {
+		l, r := &heaviest[i], &heaviest[j]
+		if l.bytes != r.bytes {
+			return l.bytes > r.bytes
+		}
+		if l.topic != r.topic {
+			return l.topic < r.topic
+		}
+		return l.partition < r.partition
+	})
+	for _, p := range heaviest {
+		if unpaused <= bp.threshold/2 {
+			break
+		}
+		if paused == nil {
+			paused = make(map[string][]int32)
+		}
+		paused[p.topic] = append(paused[p.topic], p.partition)
+		unpaused -= p.bytes
+	}
+	if len(paused) > 0 {
+		next := current.clone()
+		next.addPartitions(paused)
+		bp.paused.Store(next)
+	}
+	return paused, nil, bp.total
+}
+
+// observeBackpressure is called whenever a fetch is buffered or unbuffered.
+// If partitions were resumed, we wake our sources so that they can fetch the
+// resumed partitions. This is done in a goroutine because we can be called
+// while sources or the consumer are locked.
+func (c *consumer) observeBackpressure(f *Fetch, buffered bool) {
+	if c.bp == nil {
+		return
+	}
+	paused, resumed, total := c.bp.observe(f, buffered)
+	if len(paused) == 0 && len(resumed) == 0 {
+		return
+	}
+
+	cl := c.cl
+	if len(paused) > 0 {
+		cl.cfg.logger.Log(LogLevelInfo, "pausing fetching heaviest partitions due to buffered fetch backpressure", "paused", paused, "buffered_bytes", total)
+	}
+	if len(resumed) > 0 {
+		cl.cfg.logger.Log(LogLevelInfo, "resuming fetching backpressured partitions after draining", "resumed", resumed, "buffered_bytes", total)
+		go func() {
+			cl.sinksAndSourcesMu.Lock()
+			for _, sns := range cl.sinksAndSources {
+				sns.source.maybeConsume()
+			}
+			cl.sinksAndSourcesMu.Unlock()
+		}()
+	}
+	cl.cfg.hooks.each(func(h Hook) {
+		if h, ok := h.(HookFetchBackpressure); ok {
+			h.OnFetchBackpressure(paused, resumed, total)
+		}
+	})
+}
+
+// BackpressuredPartitions returns the partitions that are currently paused
+// from fetching due to the FetchBackpressureBytes option. These partitions are
+// not included in PauseFetchPartitions, and they are resumed automatically
+// once their buffered records are drained.
+func (cl *Client) BackpressuredPartitions() map[string][]int32 {
+	if cl.consumer.bp == nil {
+		return make(map[string][]int32)
+	}
+	return cl.consumer.bp.loadPaused().pausedPartitions()
+}
@@ -0,0 +1,200 @@
+package kgo
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// checkpoint periodically persists the positions that a direct consumer has
+// polled to a local file, and loads those positions when the client starts;
+// see the ConsumeCheckpointFile option.
+type checkpoint struct {
+	cl       *Client
+	path     string
+	interval time.Duration
+
+	// loaded is what was read from the checkpoint file when the client
+	// was created. This is only accessed under the consumer mu, while
+	// finding new direct assignments.
+	loaded map[string]map[int32]EpochOffset
+
+	// As with group autocommitting, dirty is what was polled in the
+	// latest poll, and head is what was polled before that and is what
+	// we persist. head begins as what we loaded so that partitions that
+	// we have not yet polled are not dropped from the file.
+	mu      sync.Mutex
+	dirty   map[string]map[int32]EpochOffset
+	head    map[string]map[int32]EpochOffset
+	changed bool
+
+	closeOnce sync.Once
+	quit      chan struct{}
+	done      chan struct{}
+}
+
+func newCheckpoint(cl *Client) *checkpoint {
+	c := &checkpoint{
+		cl:       cl,
+		path:     cl.cfg.checkpointPath,
+		interval: cl.cfg.checkpointInterval,
+		dirty:    make(map[string]map[int32]EpochOffset),
+		head:     make(map[string]map[int32]EpochOffset),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	raw, err := ioutil.ReadFile(c.path)
+	switch {
+	case os.IsNotExist(err):
+		cl.cfg.logger.Log(LogLevelInfo, "checkpoint file does not exist, consuming from configured offsets", "path", c.path)
+	case err != nil:
+		cl.cfg.logger.Log(LogLevelWarn, "unable to read checkpoint file, consuming from configured offsets", "path", c.path, "err", err)
+	default:
+		if err := json.Unmarshal(raw, &c.loaded); err != nil {
+			cl.cfg.logger.Log(LogLevelWarn, "unable to parse checkpoint file, consuming from configured offsets", "path", c.path, "err", err)
+			c.loaded = nil
+		}
+	}
+	for topic, partitions := range c.loaded {
+		head := make(map[int32]EpochOffset, len(partitions))
+		for partition, eo := range partitions {
+			head[partition] = eo
+		}
+		c.head[topic] = head
+	}
+	if len(c.loaded) > 0 {
+		cl.cfg.logger.Log(LogLevelInfo, "loaded checkpoint file", "path", c.path, "checkpoint", c.loaded)
+	}
+
+	cl.actors.spawn("checkpoint loop", purposeLabels("checkpoint"), c.loop)
+	return c
+}
+
+// offset returns the checkpointed offset to begin consuming a partition at,
+// if there is one.
+func (c *checkpoint) offset(topic string, partition int32) (Offset, bool) {
+	if c == nil {
+		return Offset{}, false
+	}
+	eo, ok := c.loaded[topic][partition]
+	if !ok {
+		return Offset{}, false
+	}
+	return NewOffset().At(eo.Offset).WithEpoch(eo.Epoch), true
+}
+
+// undirty moves what was polled in the prior poll to be checkpointed.
+func (c *checkpoint) undirty() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for topic, partitions := range c.dirty {
+		head := c.head[topic]
+		if head == nil {
+			head = make(map[int32]EpochOffset, len(partitions))
+			c.head[topic] = head
+		}
+		for partition, eo := range partitions {
+			head[partition] = eo
+			c.changed = true
+		}
+		delete(c.dirty, topic)
+	}
+}
+
+// update tracks the latest polled offsets.
+func (c *checkpoint) update(fetches Fetches) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, fetch := range fetches {
+		for _, topic := range fetch.Topics {
+			for _, partition := range topic.Partitions {
+				if len(partition.Records) == 0 {
+					continue
+				}
+				final := partition.Records[len(partition.Records)-1]
+				dirty := c.dirty[topic.Topic]
+				if dirty == nil {
+					dirty = make(map[int32]EpochOffset)
+					c.dirty[topic.Topic] = dirty
+				}
+				dirty[partition.Partition] = EpochOffset{final.LeaderEpoch, final.Offset + 1}
+			}
+		}
+	}
+}
+
+func (c *checkpoint) loop() {
+	defer close(c.done)
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.quit:
+			c.write()
+			return
+		case <-ticker.C:
+			c.write()
+		}
+	}
+}
+
+// close stops the checkpoint loop and writes a final checkpoint.
+func (c *checkpoint) close() {
+	if c == nil {
+		return
+	}
+	c.closeOnce.Do(func() { close(c.quit) })
+	<-c.done
+}
+
+// write persists the current head if it changed since the last write. We
+// write to a temporary file and rename it so that a crash mid write does not
+// leave a corrupt checkpoint.
+func (c *checkpoint) write() {
+	c.mu.Lock()
+	if !c.changed {
+		c.mu.Unlock()
+		return
+	}
+	raw, err := json.Marshal(c.head)
+	c.changed = false
+	c.mu.Unlock()
+
+	if err == nil {
+		err = c.writeFile(raw)
+	}
+	if err != nil {
+		c.cl.cfg.logger.Log(LogLevelWarn, "unable to write checkpoint file", "path", c.path, "err", err)
+		c.mu.Lock()
+		c.changed = true // try again next time
+		c.mu.Unlock()
+	}
+}
+
+func (c *checkpoint) writeFile(raw []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(c.path), filepath.Base(c.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // no-op once renamed
+	if _, err = f.Write(raw); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.path)
+}
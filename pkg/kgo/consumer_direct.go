@@ -1,18 +1,32 @@
 package kgo
 
+import "sort"
+
 type directConsumer struct {
-	cfg    *cfg
-	tps    *topicsPartitions             // data for topics that the user assigned
-	reSeen map[string]bool               // topics we evaluated against regex, and whether we want them or not
-	using  map[string]map[int32]struct{} // topics we are currently using (this only grows)
+	cfg        *cfg
+	checkpoint *checkpoint                   // non-nil if resuming from a checkpoint file
+	tps        *topicsPartitions             // data for topics that the user assigned
+	reSeen     map[string]bool               // topics we evaluated against regex, and whether we want them or not
+	reUsed     int                           // number of topics in reSeen that we want, for MaxConsumeTopics
+	using      map[string]map[int32]struct{} // topics we are currently using (this only grows, except via RemoveConsumePartitions)
+	partitions map[string]map[int32]Offset   // partitions from ConsumePartitions, modified by Add/RemoveConsumePartitions under the consumer mu
 }
 
 func (c *consumer) initDirect() {
 	d := &directConsumer{
-		cfg:    &c.cl.cfg,
-		tps:    newTopicsPartitions(),
-		reSeen: make(map[string]bool),
-		using:  make(map[string]map[int32]struct{}),
+		cfg:        &c.cl.cfg,
+		checkpoint: c.checkpoint,
+		tps:        newTopicsPartitions(),
+		reSeen:     make(map[string]bool),
+		using:      make(map[string]map[int32]struct{}),
+		partitions: make(map[string]map[int32]Offset, len(c.cl.cfg.partitions)),
+	}
+	for topic, partitions := range c.cl.cfg.partitions {
+		dup := make(map[int32]Offset, len(partitions))
+		for partition, offset := range partitions {
+			dup[partition] = offset
+		}
+		d.partitions[topic] = dup
 	}
 	c.d = d
 
@@ -24,7 +38,7 @@ func (c *consumer) initDirect() {
 	for topic := range d.cfg.topics {
 		topics = append(topics, topic)
 	}
-	for topic := range d.cfg.partitions {
+	for topic := range d.partitions {
 		topics = append(topics, topic)
 	}
 	d.tps.storeTopics(topics) // prime topics to load if non-regex (this is of no benefit if regex)
@@ -48,14 +62,22 @@ func (d *directConsumer) findNewAssignments() map[string]map[int32]Offset {
 		if d.cfg.regex {
 			want, seen := d.reSeen[topic]
 			if !seen {
+				var matched string
 				for rawRe, re := range d.cfg.topics {
 					if want = re.MatchString(topic); want {
-						rns.add(rawRe, topic)
+						matched = rawRe
 						break
 					}
 				}
-				if !want {
+				switch {
+				case !want:
 					rns.skip(topic)
+				case d.cfg.maxConsumeTopics > 0 && d.reUsed >= d.cfg.maxConsumeTopics:
+					want = false
+					rns.cap(topic)
+				default:
+					d.reUsed++
+					rns.add(matched, topic)
 				}
 				d.reSeen[topic] = want
 			}
@@ -76,14 +98,14 @@ func (d *directConsumer) findNewAssignments() map[string]map[int32]Offset {
 			}
 			toUseTopic := make(map[int32]Offset, len(partitions.partitions))
 			for partition := range partitions.partitions {
-				toUseTopic[int32(partition)] = d.cfg.resetOffset
+				toUseTopic[int32(partition)] = d.cfg.resetOffsetFor(topic)
 			}
 			toUse[topic] = toUseTopic
 		}
 
 		// Lastly, if this topic has some specific partitions pinned,
 		// we set those.
-		for partition, offset := range d.cfg.partitions[topic] {
+		for partition, offset := range d.partitions[topic] {
 			toUseTopic, exists := toUse[topic]
 			if !exists {
 				toUseTopic = make(map[int32]Offset, 10)
@@ -91,6 +113,14 @@ func (d *directConsumer) findNewAssignments() map[string]map[int32]Offset {
 			}
 			toUseTopic[partition] = offset
 		}
+
+		// If we have a checkpoint for any of these partitions, we
+		// resume from the checkpoint over the configured offset.
+		for partition := range toUse[topic] {
+			if offset, ok := d.checkpoint.offset(topic, partition); ok {
+				toUse[topic][partition] = offset
+			}
+		}
 	}
 
 	// With everything we want to consume, remove what we are already.
@@ -126,3 +156,159 @@ func (d *directConsumer) findNewAssignments() map[string]map[int32]Offset {
 
 	return toUse
 }
+
+// findRemovedTopics returns partitions of topics we are using that are no
+// longer in our topics, which happens when deleted topics are purged; see
+// ConsumeRegexRemoveDeleted. The topics are forgotten, so that they are
+// evaluated again if they are recreated.
+func (d *directConsumer) findRemovedTopics() map[string]map[int32]Offset {
+	topics := d.tps.load()
+
+	var rns reNews
+	defer rns.log(d.cfg)
+
+	var removed map[string]map[int32]Offset
+	for topic, partitions := range d.using {
+		if _, exists := topics[topic]; exists {
+			continue
+		}
+		if removed == nil {
+			removed = make(map[string]map[int32]Offset)
+		}
+		removeTopic := make(map[int32]Offset, len(partitions))
+		for partition := range partitions {
+			removeTopic[partition] = Offset{}
+		}
+		removed[topic] = removeTopic
+		rns.remove(topic)
+
+		delete(d.using, topic)
+		if d.reSeen[topic] {
+			d.reUsed--
+		}
+		delete(d.reSeen, topic)
+	}
+	return removed
+}
+
+// removeTopics removes the given topics from what we consume, returning the
+// partitions that must be unassigned. If consuming via regex, topics that no
+// longer match the current regular expressions are removed instead. This must
+// be called after the configured topics are updated.
+func (d *directConsumer) removeTopics(topics []string) map[string]map[int32]Offset {
+	var unused []string
+	if d.cfg.regex {
+		for topic := range d.using {
+			if d.reSeen[topic] && !regexWants(d.cfg.topics, topic) {
+				d.reSeen[topic] = false
+				d.reUsed--
+				unused = append(unused, topic)
+			}
+		}
+	} else {
+		unused = topics
+		d.tps.purgeTopics(topics)
+	}
+
+	remove := make(map[string]map[int32]Offset, len(unused))
+	for _, topic := range unused {
+		delete(d.partitions, topic)
+		partitions, exists := d.using[topic]
+		if !exists {
+			continue
+		}
+		removeTopic := make(map[int32]Offset, len(partitions))
+		for partition := range partitions {
+			removeTopic[partition] = Offset{}
+		}
+		remove[topic] = removeTopic
+		delete(d.using, topic)
+	}
+	return remove
+}
+
+// AddConsumePartitions begins consuming the given partitions at the given
+// offsets, in addition to anything already being consumed. This is the
+// runtime equivalent of ConsumePartitions, and only works for clients that
+// consume partitions directly: clients consuming in a group or with regex
+// topics are not affected. Partitions that are already being consumed keep
+// their current position; to move them, use SetOffsets.
+//
+// This never involves group APIs or a group coordinator, which makes it
+// suitable for replay and backfill tools that assign partitions themselves.
+// Such tools can create a client with an empty, non-nil ConsumePartitions map
+// to begin with nothing assigned, and then add partitions as needed.
+//
+// Topics past the MaxConsumeTopics limit are not added; they are logged and
+// passed to any HookConsumeTopicsCapped hooks.
+func (cl *Client) AddConsumePartitions(partitions map[string]map[int32]Offset) {
+	c := &cl.consumer
+	if c.d == nil || cl.cfg.regex {
+		cl.cfg.logger.Log(LogLevelWarn, "ignoring AddConsumePartitions on a client that is not directly consuming partitions")
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	topics := make([]string, 0, len(partitions))
+	for topic := range partitions {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics) // deterministic capping
+	topics = c.capTopics(topics)
+	for _, topic := range topics {
+		add := partitions[topic]
+		existing := c.d.partitions[topic]
+		if existing == nil {
+			existing = make(map[int32]Offset, len(add))
+			c.d.partitions[topic] = existing
+		}
+		for partition, offset := range add {
+			existing[partition] = offset
+		}
+	}
+	c.d.tps.storeTopics(topics)
+
+	// As with partitions from ConsumePartitions, the new partitions are
+	// assigned once the metadata update loads their topics.
+	cl.triggerUpdateMetadataNow("AddConsumePartitions")
+}
+
+// RemoveConsumePartitions stops consuming the given partitions, dropping any
+// buffered records for them. This only works for clients that consume
+// partitions directly; see AddConsumePartitions.
+//
+// Partitions of topics consumed with ConsumeTopics are consumed again on the
+// next metadata update, so this is only meaningful for partitions consumed
+// with ConsumePartitions or AddConsumePartitions.
+func (cl *Client) RemoveConsumePartitions(partitions map[string][]int32) {
+	c := &cl.consumer
+	if c.d == nil || cl.cfg.regex {
+		cl.cfg.logger.Log(LogLevelWarn, "ignoring RemoveConsumePartitions on a client that is not directly consuming partitions")
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	remove := make(map[string]map[int32]Offset, len(partitions))
+	for topic, ps := range partitions {
+		removeTopic := make(map[int32]Offset, len(ps))
+		for _, partition := range ps {
+			delete(c.d.partitions[topic], partition)
+			if using := c.d.using[topic]; using != nil {
+				delete(using, partition)
+			}
+			removeTopic[partition] = Offset{}
+		}
+		if len(c.d.partitions[topic]) == 0 {
+			delete(c.d.partitions, topic)
+		}
+		if len(c.d.using[topic]) == 0 {
+			delete(c.d.using, topic)
+		}
+		remove[topic] = removeTopic
+	}
+	c.assignPartitions(remove, assignInvalidateMatching, c.d.tps, "RemoveConsumePartitions")
+}
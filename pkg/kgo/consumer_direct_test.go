@@ -0,0 +1,531 @@
+package kgo
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type cappedHook struct{ capped [][]string }
+
+func (h *cappedHook) OnConsumeTopicsCapped(skipped []string) { h.capped = append(h.capped, skipped) }
+
+func TestMaxConsumeTopicsRegex(t *testing.T) {
+	hook := new(cappedHook)
+	cfg := defaultCfg()
+	cfg.regex = true
+	cfg.topics = map[string]*regexp.Regexp{"^a": regexp.MustCompile("^a")}
+	cfg.maxConsumeTopics = 2
+	cfg.hooks = hooks{hs: []Hook{hook}}
+
+	d := &directConsumer{
+		cfg:    &cfg,
+		tps:    newTopicsPartitions(),
+		reSeen: make(map[string]bool),
+		using:  make(map[string]map[int32]struct{}),
+	}
+	addTopics := func(topics ...string) {
+		next := d.tps.ensureTopics(topics)
+		for _, topic := range topics {
+			next[topic].v.Store(&topicPartitionsData{partitions: []*topicPartition{{}}})
+		}
+		d.tps.storeData(next)
+	}
+
+	addTopics("a1", "b1")
+	if got := d.findNewAssignments(); len(got) != 1 || got["a1"] == nil {
+		t.Fatalf("got %v, expected only a1 to be newly consumed", got)
+	}
+	if len(hook.capped) != 0 {
+		t.Fatalf("unexpectedly capped %v", hook.capped)
+	}
+
+	addTopics("a2", "a3", "a4", "b2")
+	got := d.findNewAssignments()
+	if len(got) != 1 {
+		t.Fatalf("got %v, expected exactly one newly consumed topic", got)
+	}
+	if len(hook.capped) != 1 || len(hook.capped[0]) != 2 {
+		t.Fatalf("got capped %v, expected one call with two topics", hook.capped)
+	}
+
+	// Capped topics are permanently skipped, even if more topics appear.
+	addTopics("a5")
+	if got := d.findNewAssignments(); len(got) != 0 {
+		t.Errorf("got %v, expected nothing new past the cap", got)
+	}
+	if len(hook.capped) != 2 || !cmp.Equal(hook.capped[1], []string{"a5"}) {
+		t.Errorf("got capped %v, expected a5 to be capped", hook.capped)
+	}
+}
+
+func TestRegexRemoveDeleted(t *testing.T) {
+	cfg := defaultCfg()
+	cfg.regex = true
+	cfg.regexRemoveDeleted = true
+	cfg.topics = map[string]*regexp.Regexp{"^a": regexp.MustCompile("^a")}
+	cfg.maxConsumeTopics = 2
+
+	d := &directConsumer{
+		cfg:    &cfg,
+		tps:    newTopicsPartitions(),
+		reSeen: make(map[string]bool),
+		using:  make(map[string]map[int32]struct{}),
+	}
+	storeTopics := func(topics ...string) {
+		next := make(topicsPartitionsData)
+		for _, topic := range topics {
+			next[topic] = newTopicPartitions()
+			next[topic].v.Store(&topicPartitionsData{partitions: []*topicPartition{{}, {}}})
+		}
+		d.tps.storeData(next)
+	}
+
+	storeTopics("a1", "a2", "a3")
+	if got := d.findNewAssignments(); len(got) != 2 {
+		t.Fatalf("got %v, expected two newly consumed topics", got)
+	}
+	var capped string
+	for _, topic := range []string{"a1", "a2", "a3"} {
+		if _, using := d.using[topic]; !using {
+			capped = topic
+		}
+	}
+
+	// Deleting a consumed topic removes all of its partitions and frees
+	// room under the max consume topics.
+	var deleted, kept string
+	for topic := range d.using {
+		if deleted == "" {
+			deleted = topic
+		} else {
+			kept = topic
+		}
+	}
+	storeTopics(kept, capped, "a4")
+	exp := map[string]map[int32]Offset{deleted: {0: {}, 1: {}}}
+	if got := d.findRemovedTopics(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("got removed %v, expected %v", got, exp)
+	}
+	if _, using := d.using[deleted]; using {
+		t.Errorf("still using deleted topic %s", deleted)
+	}
+	if got := d.findNewAssignments(); len(got) != 1 || got["a4"] == nil {
+		t.Errorf("got %v, expected only a4 to be newly consumed", got)
+	}
+	if got := d.findRemovedTopics(); len(got) != 0 {
+		t.Errorf("got removed %v, expected nothing", got)
+	}
+
+	// A group consumer rejoins with its reduced interests.
+	cl, err := NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	g := &groupConsumer{
+		cl:       cl,
+		cfg:      &cfg,
+		tps:      d.tps,
+		reSeen:   map[string]bool{"a1": true, "a2": true},
+		reUsed:   2,
+		using:    map[string]int{"a1": 2, "a2": 2},
+		managing: true,
+		rejoinCh: make(chan string, 1),
+	}
+	storeTopics("a2")
+	g.findNewAssignments()
+	select {
+	case <-g.rejoinCh:
+	default:
+		t.Error("group did not rejoin after a consumed topic was deleted")
+	}
+	if _, using := g.using["a1"]; using || g.reUsed != 1 {
+		t.Errorf("got using %v and %d used regex topics, expected only a2", g.using, g.reUsed)
+	}
+}
+
+func TestMaxConsumeTopicsValidate(t *testing.T) {
+	if _, err := NewClient(ConsumeTopics("a", "b"), MaxConsumeTopics(1)); err == nil {
+		t.Error("expected error consuming more topics than the max")
+	}
+}
+
+func TestConsumeCheckpointFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kgo-checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint.json")
+	if err := ioutil.WriteFile(path, []byte(`{"t":{"0":{"Epoch":2,"Offset":5},"1":{"Epoch":2,"Offset":9}}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := defaultCfg()
+	cfg.topics = map[string]*regexp.Regexp{"t": nil}
+	cfg.partitions = map[string]map[int32]Offset{"t": {2: NewOffset().At(3)}}
+	cfg.checkpointPath = path
+	cfg.checkpointInterval = time.Hour
+	c := newCheckpoint(&Client{cfg: cfg})
+
+	// Checkpointed partitions resume from the checkpoint, and the rest use
+	// their configured offsets.
+	d := &directConsumer{
+		cfg:        &cfg,
+		checkpoint: c,
+		tps:        newTopicsPartitions(),
+		reSeen:     make(map[string]bool),
+		using:      make(map[string]map[int32]struct{}),
+		partitions: cfg.partitions,
+	}
+	next := d.tps.ensureTopics([]string{"t"})
+	next["t"].v.Store(&topicPartitionsData{partitions: []*topicPartition{{}, {}, {}, {}}})
+	d.tps.storeData(next)
+	exp := map[string]map[int32]Offset{"t": {
+		0: NewOffset().At(5).WithEpoch(2),
+		1: NewOffset().At(9).WithEpoch(2),
+		2: NewOffset().At(3),
+		3: cfg.resetOffset,
+	}}
+	if got := d.findNewAssignments(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("got assignments %v != exp %v", got, exp)
+	}
+
+	// Only what was polled before the latest poll is checkpointed.
+	poll := func(partition int32, offset int64) {
+		c.undirty()
+		c.update(Fetches{{Topics: []FetchTopic{{
+			Topic: "t",
+			Partitions: []FetchPartition{{
+				Partition: partition,
+				Records:   []*Record{{LeaderEpoch: 3, Offset: offset}},
+			}},
+		}}}})
+	}
+	poll(0, 10)
+	poll(3, 20)
+	c.close()
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]map[int32]EpochOffset
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatal(err)
+	}
+	expFile := map[string]map[int32]EpochOffset{"t": {
+		0: {3, 11},
+		1: {2, 9},
+	}}
+	if !reflect.DeepEqual(got, expFile) {
+		t.Errorf("got checkpoint %v != exp %v", got, expFile)
+	}
+}
+
+func TestConsumeCheckpointFileValidate(t *testing.T) {
+	if _, err := NewClient(ConsumeTopics("t"), ConsumerGroup("g"), ConsumeCheckpointFile("cp.json", time.Second)); err == nil {
+		t.Error("expected error checkpointing as a group")
+	}
+	if _, err := NewClient(ConsumeTopics("t"), ConsumeCheckpointFile("cp.json", 0)); err == nil {
+		t.Error("expected error checkpointing with no interval")
+	}
+}
+
+func TestFailNoResetLoads(t *testing.T) {
+	cfg := defaultCfg()
+	cl := &Client{cfg: cfg}
+	c := &cl.consumer
+	c.cl = cl
+	c.sourcesReadyCond = sync.NewCond(&c.sourcesReadyMu)
+
+	var loads listOrEpochLoads
+	loads.addLoad("t", 0, loadTypeList, offsetLoad{replica: -1, Offset: NoResetOffset()})
+	loads.addLoad("t", 1, loadTypeList, offsetLoad{replica: -1, Offset: NewOffset().AtStart()})
+	loads.addLoad("t", 2, loadTypeEpoch, offsetLoad{replica: -1, Offset: NewOffset().At(10).WithEpoch(1)})
+
+	c.failNoResetLoads(&loads)
+
+	if _, ok := loads.List["t"][0]; ok {
+		t.Error("NoResetOffset load was not removed")
+	}
+	if _, ok := loads.List["t"][1]; !ok {
+		t.Error("normal list load was unexpectedly removed")
+	}
+	if _, ok := loads.Epoch["t"][2]; !ok {
+		t.Error("epoch load was unexpectedly removed")
+	}
+
+	var errs []error
+	Fetches(c.fakeReadyForDraining).EachError(func(topic string, partition int32, err error) {
+		if topic != "t" || partition != 0 {
+			t.Errorf("unexpected error for %s[%d]", topic, partition)
+		}
+		errs = append(errs, err)
+	})
+	if len(errs) != 1 || errs[0] != ErrNoResetOffset {
+		t.Errorf("got errors %v, expected exactly ErrNoResetOffset", errs)
+	}
+
+	if NoResetOffset().AtStart().isNoReset() {
+		t.Error("AtStart did not turn NoResetOffset into a normal offset")
+	}
+}
+
+func TestOffsetAfterMilli(t *testing.T) {
+	after := NewOffset().AfterMilli(1234)
+	if after.isExact() {
+		t.Error("AfterMilli offset is unexpectedly exact")
+	}
+	if !NewOffset().AfterMilli(1234).At(5).isExact() {
+		t.Error("At did not turn AfterMilli into an exact offset")
+	}
+
+	load := offsetLoadMap{"t": {
+		0: {replica: -1, Offset: after},
+		1: {replica: -1, Offset: NewOffset().At(10)},
+		2: {replica: -1, Offset: NewOffset().AtStart()},
+	}}
+	req := load.buildListReq(0)
+	got := make(map[int32]int64)
+	for _, p := range req.Topics[0].Partitions {
+		got[p.Partition] = p.Timestamp
+	}
+	exp := map[int32]int64{0: 1234, 1: -1, 2: -2}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("got list timestamps %v, expected %v", got, exp)
+	}
+
+	cfg := defaultCfg()
+	ConsumeTopicResetOffsets(map[string]Offset{"a": after}).apply(&cfg)
+	if cfg.resetOffsetFor("a") != after {
+		t.Error("topic reset offset was not used for topic a")
+	}
+	if cfg.resetOffsetFor("b") != cfg.resetOffset {
+		t.Error("default reset offset was not used for topic b")
+	}
+}
+
+func TestOffsetWithinBounds(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		in       Offset
+		logStart int64
+		hwm      int64
+		exp      Offset
+	}{
+		{"end relative in range", NewOffset().AtEnd().Relative(-100), 10, 500, NewOffset().AtEnd().Relative(-100)},
+		{"end relative before start", NewOffset().AtEnd().Relative(-1000), 10, 500, NewOffset().AtStart()},
+		{"start relative past end", NewOffset().AtStart().Relative(1000), 10, 500, NewOffset().AtEnd()},
+		{"start relative in range", NewOffset().AtStart().Relative(5), 10, 500, NewOffset().AtStart().Relative(5)},
+		{"not relative", NewOffset().AtEnd(), 10, 500, NewOffset().AtEnd()},
+		{"unknown bounds", NewOffset().AtEnd().Relative(-1000), -1, -1, NewOffset().AtEnd().Relative(-1000)},
+		{"timestamp", NewOffset().AfterMilli(5), 10, 500, NewOffset().AfterMilli(5)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.in.withinBounds(test.logStart, test.hwm); got != test.exp {
+				t.Errorf("got %v, expected %v", got, test.exp)
+			}
+		})
+	}
+}
+
+func TestAddRemoveConsumePartitions(t *testing.T) {
+	initial := map[string]map[int32]Offset{}
+	cl, err := NewClient(ConsumePartitions(initial))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	d := cl.consumer.d
+	if d == nil {
+		t.Fatal("empty ConsumePartitions did not create a direct consumer")
+	}
+
+	cl.AddConsumePartitions(map[string]map[int32]Offset{
+		"a": {0: NewOffset().At(10), 1: NewOffset().AtStart()},
+		"b": {3: NewOffset().AtEnd()},
+	})
+	cl.AddConsumePartitions(map[string]map[int32]Offset{"a": {2: NewOffset().AtEnd()}})
+
+	cl.consumer.mu.Lock()
+	if got := len(d.partitions["a"]) + len(d.partitions["b"]); got != 4 {
+		t.Errorf("got %d partitions to consume, expected 4", got)
+	}
+	cl.consumer.mu.Unlock()
+	if tps := d.tps.load(); !tps.hasTopic("a") || !tps.hasTopic("b") {
+		t.Error("added topics are not loaded for metadata")
+	}
+	if len(initial) != 0 {
+		t.Error("adding partitions modified the ConsumePartitions map")
+	}
+
+	cl.consumer.mu.Lock()
+	d.using["a"] = map[int32]struct{}{0: {}, 1: {}}
+	cl.consumer.mu.Unlock()
+	cl.RemoveConsumePartitions(map[string][]int32{"a": {0, 1, 2}, "b": {4}})
+
+	cl.consumer.mu.Lock()
+	defer cl.consumer.mu.Unlock()
+	if _, ok := d.partitions["a"]; ok {
+		t.Errorf("topic a still has partitions %v after removing all", d.partitions["a"])
+	}
+	if _, ok := d.using["a"]; ok {
+		t.Error("topic a is still in use after removing all partitions")
+	}
+	if len(d.partitions["b"]) != 1 {
+		t.Error("removing an unknown partition of b changed b")
+	}
+}
+
+func TestAddConsumePartitionsGroup(t *testing.T) {
+	cl, err := NewClient(ConsumerGroup("g"), ConsumeTopics("t"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	cl.AddConsumePartitions(map[string]map[int32]Offset{"a": {0: NewOffset()}})
+	if cl.consumer.d != nil {
+		t.Error("adding partitions to a group client created a direct consumer")
+	}
+}
+
+func TestAddRemoveConsumeTopics(t *testing.T) {
+	cl, err := NewClient(ConsumeTopics("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	c := &cl.consumer
+	d := c.d
+
+	cl.AddConsumeTopics("b", "a")
+	c.mu.Lock()
+	if _, ok := cl.cfg.topics["b"]; !ok || len(cl.cfg.topics) != 2 {
+		t.Errorf("got topics %v, expected a and b", cl.cfg.topics)
+	}
+	if tps := d.tps.load(); !tps.hasTopic("b") {
+		t.Error("added topic is not loaded for metadata")
+	}
+	d.using["a"] = map[int32]struct{}{0: {}, 1: {}}
+	d.using["b"] = map[int32]struct{}{0: {}}
+	c.mu.Unlock()
+
+	cl.RemoveConsumeTopics("a")
+	c.mu.Lock()
+	if _, ok := d.using["a"]; ok {
+		t.Error("topic a is still in use after removing it")
+	}
+	if tps := d.tps.load(); tps.hasTopic("a") || !tps.hasTopic("b") {
+		t.Error("removed topic is still loaded for metadata, or b is not")
+	}
+	if _, ok := cl.cfg.topics["a"]; ok {
+		t.Error("topic a is still configured after removing it")
+	}
+	c.mu.Unlock()
+
+	// A regex group rejoins when removing an expression drops topics.
+	gcl, err := NewClient(ConsumerGroup("g"), ConsumeRegex(), ConsumeTopics("^a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gcl.Close()
+	gc := &gcl.consumer
+	g := gc.g
+
+	gc.mu.Lock()
+	next := g.tps.ensureTopics([]string{"a1"})
+	next["a1"].v.Store(&topicPartitionsData{partitions: []*topicPartition{{}}})
+	g.tps.storeData(next)
+	g.mu.Lock()
+	g.using["a1"] = 1
+	// As if metadata found a1 and began managing, and the manage
+	// goroutine already quit.
+	g.managing = true
+	close(g.manageDone)
+	g.mu.Unlock()
+	g.reSeen["a1"] = true
+	g.reSeen["b1"] = false
+	g.reUsed = 1
+	gc.mu.Unlock()
+
+	gcl.AddConsumeTopics("^b", "(")
+	gc.mu.Lock()
+	if _, seen := g.reSeen["b1"]; seen {
+		t.Error("skipped topic was not forgotten after adding an expression")
+	}
+	if len(gcl.cfg.topics) != 2 {
+		t.Errorf("got topics %v, expected ^a and ^b", gcl.cfg.topics)
+	}
+	gc.mu.Unlock()
+
+	gcl.RemoveConsumeTopics("^a")
+	select {
+	case <-g.rejoinCh:
+	default:
+		t.Error("group did not rejoin after removing an expression")
+	}
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	if _, using := g.using["a1"]; using || g.reUsed != 0 {
+		t.Errorf("got using %v and %d used regex topics, expected nothing", g.using, g.reUsed)
+	}
+}
+
+func TestAddConsumeTopicsMaxConsumeTopics(t *testing.T) {
+	hook := new(cappedHook)
+	cl, err := NewClient(ConsumeTopics("a"), MaxConsumeTopics(2), WithHooks(hook))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	cl.AddConsumeTopics("c", "b", "a")
+	cl.consumer.mu.Lock()
+	defer cl.consumer.mu.Unlock()
+	if _, ok := cl.cfg.topics["c"]; !ok || len(cl.cfg.topics) != 2 {
+		t.Errorf("got topics %v, expected a and c", cl.cfg.topics)
+	}
+	if len(hook.capped) != 1 || !reflect.DeepEqual(hook.capped[0], []string{"b"}) {
+		t.Errorf("got capped %v, expected [[b]]", hook.capped)
+	}
+}
+
+func TestAddConsumePartitionsMaxConsumeTopics(t *testing.T) {
+	hook := new(cappedHook)
+	cl, err := NewClient(
+		ConsumePartitions(map[string]map[int32]Offset{"a": {0: NewOffset()}}),
+		MaxConsumeTopics(2),
+		WithHooks(hook),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	cl.AddConsumePartitions(map[string]map[int32]Offset{
+		"a": {1: NewOffset()},
+		"b": {0: NewOffset()},
+		"c": {0: NewOffset()},
+	})
+	cl.consumer.mu.Lock()
+	defer cl.consumer.mu.Unlock()
+	d := cl.consumer.d
+	if len(d.partitions) != 2 || len(d.partitions["a"]) != 2 || len(d.partitions["b"]) != 1 {
+		t.Errorf("got partitions %v, expected a with two partitions and b", d.partitions)
+	}
+	if len(hook.capped) != 1 || !reflect.DeepEqual(hook.capped[0], []string{"c"}) {
+		t.Errorf("got capped %v, expected [[c]]", hook.capped)
+	}
+}
@@ -3,10 +3,12 @@ package kgo
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/twmb/franz-go/pkg/kerr"
@@ -30,6 +32,7 @@ type groupConsumer struct {
 	tps *topicsPartitions
 
 	reSeen map[string]bool // topics we evaluated against regex, and whether we want them or not
+	reUsed int             // number of topics in reSeen that we want, for MaxConsumeTopics
 
 	// Full lock grabbed in CommitOffsetsSync, read lock grabbed in
 	// CommitOffsets, this lock ensures that only one sync commit can
@@ -47,7 +50,9 @@ type groupConsumer struct {
 	// session, meaning we will not commit after the group has rebalanced.
 	heartbeatForceCh chan func(error)
 
-	// The following two are only updated in the manager / join&sync loop
+	// The following two are only updated in the manager / join&sync loop.
+	// The maps are replaced rather than modified, and nowAssigned is
+	// replaced under mu so that GroupSnapshot can read it.
 	lastAssigned map[string][]int32 // only updated in join&sync loop
 	nowAssigned  map[string][]int32 // only updated in join&sync loop
 
@@ -60,6 +65,17 @@ type groupConsumer struct {
 	// hard error once the heartbeat/fetch has returned.
 	fetching map[string]map[int32]struct{}
 
+	// syncStandby is the standby partitions published for us in the
+	// latest sync, and standby is what we currently gate as standby in
+	// the consumer. These are only used with PrefetchStandbyPartitions.
+	//
+	// syncStandby is only modified in the join&sync loop. standby is
+	// modified when setting up a group session, when assigning fetched
+	// offsets, and in the manage loop on a hard error; the loop waits
+	// for fetching offsets to be done before continuing.
+	syncStandby map[string][]int32
+	standby     map[string]map[int32]struct{}
+
 	// leader is whether we are the leader right now. This is set to false
 	//
 	//  - set to false at the beginning of a join group session
@@ -72,6 +88,10 @@ type groupConsumer struct {
 	// EndTransaction.
 	offsetsAddedToTxn bool
 
+	// phase is where the group is in its lifecycle, for reporting only;
+	// see GroupSnapshot.
+	phase atomic.Value // string
+
 	//////////////
 	// mu block //
 	//////////////
@@ -85,6 +105,13 @@ type groupConsumer struct {
 	// This is read when joining a group or leaving a group.
 	using map[string]int // topics *we* are currently using => # partitions known in that topic
 
+	// managing is whether the manage goroutine was started. It is started
+	// at most once: when metadata first finds topics to consume, or
+	// immediately for a group without topics. Topics can be removed and
+	// added at runtime, so an empty using does not mean we are not
+	// managing.
+	managing bool
+
 	// uncommitted is read and updated all over:
 	// - updated before PollFetches returns
 	// - updated when directly setting offsets (to rewind, for transactions)
@@ -104,6 +131,13 @@ type groupConsumer struct {
 	// expects, which would rotate a session.
 	memberID   string
 	generation int32
+	protocol   string // the balance protocol chosen for the group, set with memberID and generation
+
+	// joinReason is why we are rejoining the group, which we send in our
+	// next JoinGroup request (KIP-800). This is set when something
+	// triggers a rejoin, mostly in the join and sync loop but also from
+	// the heartbeat goroutine, and is cleared when joining.
+	joinReason string
 
 	// commitCancel and commitDone are set under mu before firing off an
 	// async commit request. If another commit happens, it cancels the
@@ -146,6 +180,17 @@ func (cl *Client) LeaveGroup() {
 	wait() // wait after we unlock
 }
 
+// commitOnClose commits everything polled before Close leaves the group, if
+// using CommitOnClose.
+func (cl *Client) commitOnClose() {
+	if cl.consumer.g == nil || !cl.cfg.commitOnClose || cl.cfg.txnID != nil {
+		return
+	}
+	if err := cl.CommitUncommittedOffsets(cl.ctx); err != nil {
+		cl.cfg.logger.Log(LogLevelError, "unable to commit polled offsets before closing", "group", cl.cfg.group, "err", err)
+	}
+}
+
 func (c *consumer) initGroup() {
 	ctx, cancel := context.WithCancel(c.cl.ctx)
 	g := &groupConsumer{
@@ -166,8 +211,27 @@ func (c *consumer) initGroup() {
 		using:            make(map[string]int),
 	}
 	c.g = g
+	g.phase.Store("awaiting metadata")
+	if !g.cooperative {
+		for _, balancer := range g.cfg.balancers {
+			if balancer.IsCooperative() {
+				g.cfg.logger.Log(LogLevelInfo, "group balancers are a mix of cooperative and eager, this member will rebalance eagerly until all of its balancers are cooperative",
+					"group", g.cfg.group,
+					"cooperative_balancer", balancer.ProtocolName(),
+				)
+				break
+			}
+		}
+	}
 	if !g.cfg.setCommitCallback {
 		g.cfg.commitCallback = g.defaultCommitCallback
+	} else if g.cl.slowCallbacks != nil || g.cl.panics != nil {
+		user := g.cfg.commitCallback
+		g.cfg.commitCallback = func(cl *Client, req *kmsg.OffsetCommitRequest, resp *kmsg.OffsetCommitResponse, err error) {
+			done := cl.slowCallbacks.watch("commit callback", nil)
+			cl.panics.run("commit callback", nil, func() { user(cl, req, resp, err) })
+			done()
+		}
 	}
 
 	if g.cfg.txnID == nil {
@@ -175,6 +239,14 @@ func (c *consumer) initGroup() {
 		// set by options.
 		if !g.cfg.setRevoked {
 			g.cfg.onRevoked = g.defaultRevoke
+		} else if g.cfg.commitOnRevoke {
+			user := g.cfg.onRevoked
+			g.cfg.onRevoked = func(ctx context.Context, cl *Client, m map[string][]int32) {
+				g.defaultRevoke(ctx, cl, m)
+				if user != nil {
+					user(ctx, cl, m)
+				}
+			}
 		}
 		// For onLost, we do not want to commit in onLost, so we
 		// explicitly set onLost to an empty function to avoid the
@@ -213,7 +285,9 @@ func (c *consumer) initGroup() {
 				for k, vs := range m {
 					dup[k] = append([]int32(nil), vs...)
 				}
-				user(ctx, cl, dup)
+				done := cl.slowCallbacks.watch(name, nil)
+				cl.panics.run(name, nil, func() { user(ctx, cl, dup) })
+				done()
 			}
 		}
 	}
@@ -231,7 +305,14 @@ func (c *consumer) initGroup() {
 
 	if !g.cfg.autocommitDisable && g.cfg.autocommitInterval > 0 {
 		g.cfg.logger.Log(LogLevelInfo, "beginning autocommit loop", "group", g.cfg.group)
-		go g.loopCommit()
+		g.cl.actors.spawn("group autocommit loop", purposeLabels("group"), g.loopCommit)
+	}
+
+	// We normally begin managing once metadata finds topics to consume.
+	// With a custom protocol and no topics, there is nothing to wait for.
+	if len(g.cfg.topics) == 0 {
+		g.managing = true
+		g.cl.actors.spawn("group manager", purposeLabels("group"), g.manage)
 	}
 }
 
@@ -251,6 +332,7 @@ func (g *groupConsumer) manage() {
 		if err == nil {
 			if err = g.setupAssignedAndHeartbeat(); err != nil {
 				if err == kerr.RebalanceInProgress {
+					g.setJoinReason("the group is rebalancing", false)
 					err = nil
 				}
 			}
@@ -259,6 +341,12 @@ func (g *groupConsumer) manage() {
 			consecutiveErrors = 0
 			continue
 		}
+		g.setJoinReason(fmt.Sprintf("rejoining after error: %v", err), true)
+		if err == context.Canceled {
+			g.phase.Store("stopping")
+		} else {
+			g.phase.Store("recovering")
+		}
 
 		hook := func() {
 			g.cfg.hooks.each(func(h Hook) {
@@ -268,6 +356,21 @@ func (g *groupConsumer) manage() {
 			})
 		}
 
+		// Before calling into the user's revoke or lost callback, we
+		// stop fetching and drop anything buffered. Otherwise, a
+		// concurrent poll could return records for partitions that
+		// the user is in the middle of giving up, or has given up.
+		g.c.mu.Lock()
+		g.c.assignPartitions(nil, assignInvalidateAll, nil, "clearing assignment at end of group management session")
+		g.c.mu.Unlock()
+		if g.cfg.requireReady {
+			g.c.markReady(nil) // nothing is assigned anymore
+		}
+		if g.standby != nil {
+			g.standby = nil // we invalidated all prefetched cursors above
+			g.c.storeStandby(nil)
+		}
+
 		if err == context.Canceled && g.cfg.onRevoked != nil {
 			// The cooperative consumer does not revoke everything
 			// while rebalancing, meaning if our context is
@@ -292,16 +395,16 @@ func (g *groupConsumer) manage() {
 			hook()
 		}
 
-		// We need to invalidate everything from an error return.
+		// We need to invalidate everything from an error return. We
+		// stopped fetching above; nothing new can be polled, so nothing
+		// can recreate uncommitted.
 		{
-			g.c.mu.Lock()
-			g.c.assignPartitions(nil, assignInvalidateAll, nil, "clearing assignment at end of group management session")
-			g.mu.Lock()     // before allowing poll to touch uncommitted, lock the group
-			g.c.mu.Unlock() // now part of poll can continue
+			g.mu.Lock()
+			g.cl.metrics.forgetLags(g.nowAssigned)
 			g.uncommitted = nil
+			g.nowAssigned = nil
 			g.mu.Unlock()
 
-			g.nowAssigned = nil
 			g.lastAssigned = nil
 			g.fetching = nil
 
@@ -309,9 +412,50 @@ func (g *groupConsumer) manage() {
 		}
 
 		if err == context.Canceled { // context was canceled, quit now
+			g.phase.Store("stopped")
 			return
 		}
 
+		// If another member joined with our instance ID, that member
+		// now owns our place in the group. Rejoining would fence the
+		// other member, which would then rejoin and fence us, and so
+		// on. As in the Java client, being fenced by instance ID is
+		// fatal by default: we stop managing the group and inject the
+		// error into polling. The user can override what we do on any
+		// fencing error.
+		if isGroupFencedErr(err) {
+			eviction := g.eviction(err)
+			g.cfg.logger.Log(LogLevelWarn, "group member was evicted from the group",
+				"group", g.cfg.group,
+				"member_id", eviction.MemberID,
+				"generation", eviction.Generation,
+				"reason", eviction.Reason,
+			)
+			g.cfg.hooks.each(func(h Hook) {
+				if h, ok := h.(HookGroupEvicted); ok {
+					h.OnGroupEvicted(eviction)
+				}
+			})
+
+			action := GroupFencedRejoin
+			if errors.Is(err, kerr.FencedInstanceID) {
+				action = GroupFencedStop
+			}
+			if g.cfg.onFenced != nil {
+				action = g.cfg.onFenced(g.cl, err)
+			}
+			if action == GroupFencedStop {
+				g.cfg.logger.Log(LogLevelError, "group member was fenced, no longer managing the group",
+					"group", g.cfg.group,
+					"instance_id", g.cfg.instanceID,
+					"err", err,
+				)
+				g.c.addFakeReadyForDraining("", 0, err)
+				g.phase.Store("stopped")
+				return
+			}
+		}
+
 		// Waiting for the backoff is a good time to update our
 		// metadata; maybe the error is from stale metadata.
 		consecutiveErrors++
@@ -335,12 +479,13 @@ func (g *groupConsumer) manage() {
 }
 
 func (g *groupConsumer) leave() (wait func()) {
-	// If g.using is nonzero before this check, then a manage goroutine has
-	// started. If not, it will never start because we set dying.
+	// If we started managing before this check, we wait for the manage
+	// goroutine below. If not, it will never start because we set dying.
 	g.mu.Lock()
 	wasDead := g.dying
 	g.dying = true
-	wasManaging := len(g.using) > 0
+	g.phase.Store("leaving")
+	wasManaging := g.managing
 	g.mu.Unlock()
 
 	done := make(chan struct{})
@@ -370,11 +515,16 @@ func (g *groupConsumer) leave() (wait func()) {
 			)
 			// If we error when leaving, there is not much
 			// we can do. We may as well just return.
+			reason := g.cfg.groupReason
+			if reason == "" {
+				reason = "the consumer is leaving the group"
+			}
 			req := kmsg.NewPtrLeaveGroupRequest()
 			req.Group = g.cfg.group
 			req.MemberID = g.memberID
 			member := kmsg.NewLeaveGroupRequestMember()
 			member.MemberID = g.memberID
+			member.Reason = &reason
 			req.Members = append(req.Members, member)
 			req.RequestWith(g.cl.ctx, g.cl)
 		}
@@ -478,7 +628,6 @@ func (g *groupConsumer) revoke(stage revokeStage, lost map[string][]int32, leavi
 		if g.cfg.onRevoked != nil {
 			g.cfg.onRevoked(g.cl.ctx, g.cl, g.nowAssigned)
 		}
-		g.nowAssigned = nil
 
 		// After nilling uncommitted here, nothing should recreate
 		// uncommitted until a future fetch after the group is
@@ -486,6 +635,8 @@ func (g *groupConsumer) revoke(stage revokeStage, lost map[string][]int32, leavi
 		// with CommitOffsets{,Sync} but we explicitly document not
 		// to do that outside the context of a live group session.
 		g.mu.Lock()
+		g.cl.metrics.forgetLags(g.nowAssigned)
+		g.nowAssigned = nil
 		g.uncommitted = nil
 		g.mu.Unlock()
 		return
@@ -548,6 +699,8 @@ func (g *groupConsumer) revoke(stage revokeStage, lost map[string][]int32, leavi
 
 	defer g.rejoin("cooperative rejoin after revoking what we lost") // cooperative consumers rejoin after they revoking what they lost
 
+	g.cl.metrics.forgetLags(lost)
+
 	// The block below deletes everything lost from our uncommitted map.
 	// All commits should be **completed** by the time this runs. An async
 	// commit can undo what we do below. The default revoke runs a sync
@@ -647,11 +800,20 @@ func (g *groupConsumer) setupAssignedAndHeartbeat() error {
 	hbErrCh := make(chan error, 1)
 	fetchErrCh := make(chan error, 1)
 
+	g.phase.Store("stable")
 	s := newAssignRevokeSession()
 	added, lost := g.diffAssigned()
 	g.cfg.logger.Log(LogLevelInfo, "new group session begun", "group", g.cfg.group, "added", tpsFmt(added), "lost", tpsFmt(lost))
 	s.prerevoke(g, lost) // for cooperative consumers
 
+	// We gate what we are adding before fetching offsets, which is before
+	// anything can be fetched, and before calling onAssigned, which may
+	// mark partitions ready.
+	if g.cfg.requireReady {
+		g.c.markReady(lost)
+		g.c.gateUnready(added)
+	}
+
 	// Since we have joined the group, we immediately begin heartbeating.
 	// This will continue until the heartbeat errors, the group is killed,
 	// or the fetch offsets below errors.
@@ -662,6 +824,15 @@ func (g *groupConsumer) setupAssignedAndHeartbeat() error {
 		hbErrCh <- g.heartbeat(fetchErrCh, s)
 	}()
 
+	// If prefetching standby partitions, we update what is standby before
+	// fetching offsets, since fetching offsets may take over a partition
+	// that we prefetched. We wait for the prefetch before returning so
+	// that it cannot race with the next session.
+	if g.cfg.prefetchStandby {
+		standbyDone := g.setupStandby(ctx)
+		defer func() { <-standbyDone }()
+	}
+
 	// We immediately begin fetching offsets. We want to wait until the
 	// fetch function returns, since it assumes within it that another
 	// assign cannot happen (it assigns partitions itself). Returning
@@ -733,21 +904,34 @@ func (g *groupConsumer) heartbeat(fetchErrCh <-chan error, s *assignRevokeSessio
 
 	ctxCh := g.ctx.Done()
 
+	// We warn if we go half of the session timeout without a successful
+	// heartbeat, which is before the coordinator would evict us. The
+	// watchdog fires from its own goroutine so that it still fires if a
+	// heartbeat request is stuck. We just joined, which counts as a
+	// successful heartbeat.
+	lastSuccess := time.Now()
+	warnAfter := g.cfg.sessionTimeout / 2
+	watchdog := time.AfterFunc(warnAfter, func() { g.heartbeatDeadline(warnAfter) })
+	defer watchdog.Stop()
+
 	for {
 		var err error
 		var force func(error)
+		var late time.Duration
 		heartbeat = false
 		select {
 		case <-cooperativeFastCheck:
 			heartbeat = true
-		case <-ticker.C:
+		case tick := <-ticker.C:
 			heartbeat = true
+			late = time.Since(tick)
 		case force = <-g.heartbeatForceCh:
 			heartbeat = true
 		case why := <-g.rejoinCh:
 			// If a metadata update changes our subscription,
 			// we just pretend we are rebalancing.
 			g.cfg.logger.Log(LogLevelInfo, "forced rejoin quitting heartbeat loop", "why", why)
+			g.setJoinReason(why, true)
 			err = kerr.RebalanceInProgress
 		case err = <-fetchErrCh:
 			fetchErrCh = nil
@@ -767,17 +951,41 @@ func (g *groupConsumer) heartbeat(fetchErrCh <-chan error, s *assignRevokeSessio
 		}
 
 		if heartbeat {
+			if late > g.cfg.heartbeatInterval {
+				g.cfg.logger.Log(LogLevelWarn, "heartbeat was delayed longer than the heartbeat interval, which can be caused by long GC pauses or a starved process", "group", g.cfg.group, "late", late)
+			}
 			g.cfg.logger.Log(LogLevelDebug, "heartbeating", "group", g.cfg.group)
 			req := kmsg.NewPtrHeartbeatRequest()
 			req.Group = g.cfg.group
 			req.Generation = g.generation
 			req.MemberID = g.memberID
 			req.InstanceID = g.cfg.instanceID
+			sent := time.Now()
 			var resp *kmsg.HeartbeatResponse
 			if resp, err = req.RequestWith(g.ctx, g.cl); err == nil {
 				err = kerr.ErrorForCode(resp.ErrorCode)
 			}
-			g.cfg.logger.Log(LogLevelDebug, "heartbeat complete", "group", g.cfg.group, "err", err)
+			rtt := time.Since(sent)
+			g.cfg.logger.Log(LogLevelDebug, "heartbeat complete", "group", g.cfg.group, "rtt", rtt, "err", err)
+
+			beat := GroupHeartbeat{
+				Group:            g.cfg.group,
+				Generation:       req.Generation,
+				RTT:              rtt,
+				Late:             late,
+				SinceLastSuccess: sent.Sub(lastSuccess),
+				Err:              err,
+			}
+			g.cfg.hooks.each(func(h Hook) {
+				if h, ok := h.(HookGroupHeartbeat); ok {
+					h.OnGroupHeartbeat(beat)
+				}
+			})
+			if err == nil {
+				lastSuccess = sent
+				watchdog.Reset(warnAfter)
+			}
+
 			if force != nil {
 				force(err)
 			}
@@ -859,12 +1067,170 @@ func (g *groupConsumer) heartbeat(fetchErrCh <-chan error, s *assignRevokeSessio
 // If neither of the cases above are true (this member is not a leader, and the
 // join group metadata has not changed), then Kafka will not actually trigger a
 // rebalance and will instead reply to the member with its current assignment.
+//
+// It is not necessary to call this function when new topics match a regex
+// subscription: the client already rejoins on its own when a metadata update
+// finds new topics to consume or, if this member is the leader, new
+// partitions in consumed topics. Metadata is updated every MetadataMaxAge.
+//
+// This function does nothing if the client is not consuming as a group
+// member. If the member is currently joining, the join already in progress
+// satisfies the rejoin.
 func (cl *Client) ForceRebalance() {
 	if g := cl.consumer.g; g != nil {
 		g.rejoin("rejoin from ForceRebalance")
 	}
 }
 
+// GroupHeartbeat describes a heartbeat issued by a group member, as passed to
+// HookGroupHeartbeat.
+type GroupHeartbeat struct {
+	// Group is the group the member heartbeated in.
+	Group string
+	// Generation is the generation the member heartbeated with.
+	Generation int32
+
+	// RTT is how long the heartbeat request took, including any time
+	// spent retrying or waiting for a connection.
+	RTT time.Duration
+	// Late is how much later than scheduled the heartbeat was sent. A
+	// large value indicates that the client's heartbeat goroutine was not
+	// running on time, e.g. due to long GC pauses or CPU starvation.
+	// This is zero for heartbeats that are not sent on the regular
+	// heartbeat interval.
+	Late time.Duration
+	// SinceLastSuccess is the time between when the prior successful
+	// heartbeat (or the group join) was sent and when this heartbeat was
+	// sent. If this approaches the session timeout, the member is at risk
+	// of being evicted.
+	SinceLastSuccess time.Duration
+
+	// Err is the error from the heartbeat, if any.
+	// kerr.RebalanceInProgress is expected whenever the group rebalances.
+	Err error
+}
+
+// heartbeatDeadline is called from the heartbeat watchdog when we have gone
+// too long without a successful heartbeat.
+func (g *groupConsumer) heartbeatDeadline(since time.Duration) {
+	g.cfg.logger.Log(LogLevelWarn, "group member has not successfully heartbeated for half of the session timeout and is at risk of being evicted",
+		"group", g.cfg.group,
+		"since_last_success", since,
+		"session_timeout", g.cfg.sessionTimeout,
+	)
+	g.cfg.hooks.each(func(h Hook) {
+		if h, ok := h.(HookGroupHeartbeatDeadline); ok {
+			h.OnGroupHeartbeatDeadline(g.cfg.group, since, g.cfg.sessionTimeout)
+		}
+	})
+}
+
+// GroupEviction describes a group member being removed from its group, as
+// passed to HookGroupEvicted.
+type GroupEviction struct {
+	// Group is the group the member was evicted from.
+	Group string
+	// MemberID is the member ID the client had when it was evicted.
+	MemberID string
+	// InstanceID is the member's instance ID, if using static membership.
+	InstanceID *string
+	// Generation is the generation the member was last in.
+	Generation int32
+
+	// Err is the error the group coordinator responded with:
+	// kerr.UnknownMemberID, kerr.IllegalGeneration, or
+	// kerr.FencedInstanceID. The coordinator does not send any reason
+	// beyond this error code.
+	Err error
+	// Reason is a description of the likely reason for Err.
+	Reason string
+}
+
+// eviction returns the eviction for a group fencing error.
+func (g *groupConsumer) eviction(err error) GroupEviction {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	e := GroupEviction{
+		Group:      g.cfg.group,
+		MemberID:   g.memberID,
+		InstanceID: g.cfg.instanceID,
+		Generation: g.generation,
+		Err:        err,
+	}
+	switch {
+	case errors.Is(err, kerr.FencedInstanceID):
+		e.Reason = "another member joined with this member's instance ID"
+	case errors.Is(err, kerr.UnknownMemberID):
+		e.Reason = "the coordinator does not know this member; the session timed out, or an admin removed the member from the group"
+	case errors.Is(err, kerr.IllegalGeneration):
+		e.Reason = "the group rebalanced without this member, likely because this member did not rejoin within the rebalance timeout"
+	}
+	return e
+}
+
+// GroupSnapshot is a point in time view of this client's group membership, as
+// returned from Client.GroupSnapshot.
+type GroupSnapshot struct {
+	// Group is the group this client is consuming in.
+	Group string
+	// MemberID is this member's ID, which is empty before the client
+	// first joins the group.
+	MemberID string
+	// InstanceID is this member's instance ID, if using static
+	// membership (see the InstanceID option).
+	InstanceID *string
+	// Generation is the generation of the group this member last joined.
+	Generation int32
+	// Leader is whether this member is the group leader, which is the
+	// member that balances the group.
+	Leader bool
+	// Protocol is the balance protocol chosen for the group, such as
+	// "cooperative-sticky".
+	Protocol string
+	// Assigned is the partitions currently assigned to this member.
+	Assigned map[string][]int32
+	// Phase is where this member is in the group lifecycle: "awaiting
+	// metadata" before the member first joins, "joining", "syncing",
+	// "stable" while heartbeating in a group session, "recovering" after
+	// a session ends with an error and before rejoining, and "leaving",
+	// "stopping", or "stopped" once the group is being left.
+	Phase string
+}
+
+func (g *groupConsumer) loadPhase() string {
+	phase, _ := g.phase.Load().(string)
+	return phase
+}
+
+// GroupSnapshot returns the current state of this client's group membership,
+// or false if the client is not consuming in a group. This is meant for
+// reporting; the group can change at any time, even immediately after this
+// returns.
+func (cl *Client) GroupSnapshot() (GroupSnapshot, bool) {
+	g := cl.consumer.g
+	if g == nil {
+		return GroupSnapshot{}, false
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	s := GroupSnapshot{
+		Group:      g.cfg.group,
+		MemberID:   g.memberID,
+		InstanceID: g.cfg.instanceID,
+		Generation: g.generation,
+		Leader:     g.leader.get(),
+		Protocol:   g.protocol,
+		Assigned:   make(map[string][]int32, len(g.nowAssigned)),
+		Phase:      g.loadPhase(),
+	}
+	for topic, partitions := range g.nowAssigned {
+		s.Assigned[topic] = append([]int32(nil), partitions...)
+	}
+	return s, true
+}
+
 // rejoin is called after a cooperative member revokes what it lost at the
 // beginning of a session, or if we are leader and detect new partitions to
 // consume.
@@ -875,6 +1241,34 @@ func (g *groupConsumer) rejoin(why string) {
 	}
 }
 
+// setJoinReason sets why we are rejoining the group, which is sent in our next
+// JoinGroup request. If overwrite is false, this keeps any reason that is
+// already set, which is more specific.
+func (g *groupConsumer) setJoinReason(reason string, overwrite bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if overwrite || g.joinReason == "" {
+		g.joinReason = reason
+	}
+}
+
+// takeJoinReason returns and clears why we are rejoining the group, or the
+// configured default reason if nothing triggered the rejoin.
+func (g *groupConsumer) takeJoinReason() *string {
+	g.mu.Lock()
+	reason := g.joinReason
+	g.joinReason = ""
+	g.mu.Unlock()
+
+	if reason == "" {
+		reason = g.cfg.groupReason
+	}
+	if reason == "" {
+		return nil
+	}
+	return &reason
+}
+
 // Joins and then syncs, issuing the two slow requests in goroutines to allow
 // for group cancelation to return early.
 func (g *groupConsumer) joinAndSync() error {
@@ -882,6 +1276,7 @@ func (g *groupConsumer) joinAndSync() error {
 	g.leader.set(false)
 
 start:
+	g.phase.Store("joining")
 	select {
 	case <-g.rejoinCh: // drain to avoid unnecessary rejoins
 	default:
@@ -895,6 +1290,7 @@ start:
 	joinReq.MemberID = g.memberID
 	joinReq.InstanceID = g.cfg.instanceID
 	joinReq.Protocols = g.joinGroupProtocols()
+	joinReq.Reason = g.takeJoinReason()
 	var (
 		joinResp *kmsg.JoinGroupResponse
 		err      error
@@ -938,6 +1334,7 @@ start:
 	)
 
 	g.cfg.logger.Log(LogLevelInfo, "syncing", "group", g.cfg.group, "protocol_type", g.cfg.protocol, "protocol", protocol)
+	g.phase.Store("syncing")
 	go func() {
 		defer close(synced)
 		syncResp, err = syncReq.RequestWith(g.ctx, g.cl)
@@ -970,12 +1367,14 @@ func (g *groupConsumer) handleJoinResp(resp *kmsg.JoinGroupResponse) (restart bo
 		case kerr.MemberIDRequired:
 			g.mu.Lock()
 			g.memberID = resp.MemberID // KIP-394
+			g.joinReason = "rejoining with the member ID from MEMBER_ID_REQUIRED"
 			g.mu.Unlock()
 			g.cfg.logger.Log(LogLevelInfo, "join returned MemberIDRequired, rejoining with response's MemberID", "group", g.cfg.group, "member_id", resp.MemberID)
 			return true, "", nil, nil
 		case kerr.UnknownMemberID:
 			g.mu.Lock()
 			g.memberID = ""
+			g.joinReason = "rejoining without a member ID after UNKNOWN_MEMBER_ID"
 			g.mu.Unlock()
 			g.cfg.logger.Log(LogLevelInfo, "join returned UnknownMemberID, rejoining without a member id", "group", g.cfg.group)
 			return true, "", nil, nil
@@ -985,15 +1384,16 @@ func (g *groupConsumer) handleJoinResp(resp *kmsg.JoinGroupResponse) (restart bo
 
 	// Concurrent committing, while erroneous to do at the moment, could
 	// race with this function. We need to lock setting these two fields.
+	if resp.Protocol != nil {
+		protocol = *resp.Protocol
+	}
+
 	g.mu.Lock()
 	g.memberID = resp.MemberID
 	g.generation = resp.Generation
+	g.protocol = protocol
 	g.mu.Unlock()
 
-	if resp.Protocol != nil {
-		protocol = *resp.Protocol
-	}
-
 	leader := resp.LeaderID == resp.MemberID
 	if leader {
 		g.leader.set(true)
@@ -1039,14 +1439,30 @@ func (g *groupConsumer) handleSyncResp(protocol string, resp *kmsg.SyncGroupResp
 		return err
 	}
 
-	g.cfg.logger.Log(LogLevelInfo, "synced", "group", g.cfg.group, "assigned", tpsFmt(assigned))
+	g.syncStandby = nil
+	if g.cfg.prefetchStandby {
+		if sb, ok := b.(GroupStandbyBalancer); ok {
+			standby, err := sb.ParseSyncStandby(resp.MemberAssignment)
+			if err != nil {
+				// Standby partitions are only an optimization; we
+				// do not fail the sync over them.
+				g.cfg.logger.Log(LogLevelWarn, "sync standby parse failed, not prefetching standby partitions", "group", g.cfg.group, "err", err)
+			} else {
+				g.syncStandby = standby
+			}
+		}
+	}
+
+	g.cfg.logger.Log(LogLevelInfo, "synced", "group", g.cfg.group, "assigned", tpsFmt(assigned), "standby", tpsFmt(g.syncStandby))
 
 	// Past this point, we will fall into the setupAssigned prerevoke code,
 	// meaning for cooperative, we will revoke what we need to.
 	if g.cooperative {
 		g.lastAssigned = g.nowAssigned
 	}
+	g.mu.Lock()
 	g.nowAssigned = assigned
+	g.mu.Unlock()
 	return nil
 }
 
@@ -1159,6 +1575,21 @@ func (g *groupConsumer) fetchOffsets(ctx context.Context, added, lost map[string
 		}()
 	}
 
+	// With an external offset store, we assign what is stored and only
+	// fetch from Kafka what is not.
+	if g.cfg.offsetStore != nil {
+		var stored map[string]map[int32]Offset
+		if stored, added, err = g.fetchStoredOffsets(ctx, added); err != nil {
+			return err
+		}
+		if len(stored) > 0 {
+			g.assignFetchedOffsets(stored)
+		}
+		if len(added) == 0 {
+			return nil
+		}
+	}
+
 	// Our client maps the v0 to v7 format to v8+ when sharding this
 	// request, if we are only requesting one group, as well as maps the
 	// response back, so we do not need to worry about v8+ here.
@@ -1197,6 +1628,7 @@ start:
 	kip320 := g.cl.supportsOffsetForLeaderEpoch()
 
 	offsets := make(map[string]map[int32]Offset)
+	unstable := make(map[string][]int32)
 	for _, rTopic := range resp.Topics {
 		topicOffsets := make(map[int32]Offset)
 		offsets[rTopic.Topic] = topicOffsets
@@ -1204,18 +1636,19 @@ start:
 			if err = kerr.ErrorForCode(rPartition.ErrorCode); err != nil {
 				// KIP-447: Unstable offset commit means there is a
 				// pending transaction that should be committing soon.
-				// We sleep for 1s and retry fetching offsets.
+				// We retry fetching offsets for only these partitions
+				// after assigning everything else, so that a pending
+				// transaction does not delay consuming every other
+				// partition.
 				if err == kerr.UnstableOffsetCommit {
-					g.cfg.logger.Log(LogLevelInfo, "fetch offsets failed with UnstableOffsetCommit, waiting 1s and retrying",
+					g.cfg.logger.Log(LogLevelInfo, "fetch offsets failed with UnstableOffsetCommit, retrying partition in 1s",
 						"group", g.cfg.group,
 						"topic", rTopic.Topic,
 						"partition", rPartition.Partition,
 					)
-					select {
-					case <-ctx.Done():
-					case <-time.After(time.Second):
-						goto start
-					}
+					unstable[rTopic.Topic] = append(unstable[rTopic.Topic], rPartition.Partition)
+					err = nil
+					continue
 				}
 				g.cfg.logger.Log(LogLevelError, "fetch offsets failed",
 					"group", g.cfg.group,
@@ -1233,12 +1666,46 @@ start:
 				offset.epoch = rPartition.LeaderEpoch
 			}
 			if rPartition.Offset == -1 {
-				offset = g.cfg.resetOffset
+				offset = g.cfg.resetOffsetFor(rTopic.Topic)
 			}
 			topicOffsets[rPartition.Partition] = offset
 		}
 	}
 
+	g.assignFetchedOffsets(offsets)
+
+	if len(unstable) > 0 {
+		// If cooperative, what we just assigned does not need to be
+		// fetched again if we are canceled before the unstable
+		// partitions are resolved.
+		if g.cooperative {
+			for topic, partitions := range offsets {
+				if ft := g.fetching[topic]; ft != nil {
+					for partition := range partitions {
+						delete(ft, partition)
+					}
+					if len(ft) == 0 {
+						delete(g.fetching, topic)
+					}
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			g.cfg.logger.Log(LogLevelInfo, "fetch offsets failed due to context cancelation while waiting to retry unstable offsets", "group", g.cfg.group)
+			return ctx.Err()
+		case <-time.After(time.Second):
+			added = unstable
+			goto start
+		}
+	}
+	return nil
+}
+
+// assignFetchedOffsets assigns offsets fetched in fetchOffsets and tracks them
+// as committed.
+func (g *groupConsumer) assignFetchedOffsets(offsets map[string]map[int32]Offset) {
 	groupTopics := g.tps.load()
 	for fetchedTopic := range offsets {
 		if !groupTopics.hasTopic(fetchedTopic) {
@@ -1253,9 +1720,16 @@ start:
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
+	// If we prefetched any of these partitions as standby, we may be able
+	// to keep the prefetched position rather than assigning anew.
+	assign := offsets
+	if g.standby != nil {
+		assign = g.takeOverStandby(offsets)
+	}
+
 	// Eager: we already invalidated everything; nothing to re-invalidate.
 	// Cooperative: assign without invalidating what we are consuming.
-	g.c.assignPartitions(offsets, assignWithoutInvalidating, g.tps, fmt.Sprintf("newly fetched offsets for group %s", g.cfg.group))
+	g.c.assignPartitions(assign, assignWithoutInvalidating, g.tps, fmt.Sprintf("newly fetched offsets for group %s", g.cfg.group))
 
 	// We need to update the uncommited map so that SetOffsets(Committed)
 	// does not rewind before the committed offsets we just fetched.
@@ -1269,7 +1743,7 @@ start:
 			g.uncommitted[topic] = topicUncommitted
 		}
 		for partition, offset := range partitions {
-			if offset.at < 0 {
+			if !offset.isExact() {
 				continue // not yet committed
 			}
 			committed := EpochOffset{
@@ -1283,7 +1757,18 @@ start:
 			}
 		}
 	}
-	return nil
+}
+
+// unwantRegexTopics marks topics we are using that no longer match the
+// current regular expressions as unwanted, so that findNewAssignments removes
+// them. This must be called after the configured topics are updated.
+func (g *groupConsumer) unwantRegexTopics() {
+	for topic := range g.using {
+		if g.reSeen[topic] && !regexWants(g.cfg.topics, topic) {
+			g.reSeen[topic] = false
+			g.reUsed--
+		}
+	}
 }
 
 // findNewAssignments updates topics the group wants to use and other metadata.
@@ -1329,14 +1814,22 @@ func (g *groupConsumer) findNewAssignments() {
 		if g.cfg.regex {
 			want, seen := g.reSeen[topic]
 			if !seen {
+				var matched string
 				for rawRe, re := range g.cfg.topics {
 					if want = re.MatchString(topic); want {
-						rns.add(rawRe, topic)
+						matched = rawRe
 						break
 					}
 				}
-				if !want {
+				switch {
+				case !want:
 					rns.skip(topic)
+				case g.cfg.maxConsumeTopics > 0 && g.reUsed >= g.cfg.maxConsumeTopics:
+					want = false
+					rns.cap(topic)
+				default:
+					g.reUsed++
+					rns.add(matched, topic)
 				}
 				g.reSeen[topic] = want
 			}
@@ -1359,7 +1852,25 @@ func (g *groupConsumer) findNewAssignments() {
 
 	}
 
-	if len(toChange) == 0 {
+	// Topics we are using that are no longer in our topics were purged
+	// from our topics after being deleted (see ConsumeRegexRemoveDeleted)
+	// or removed with RemoveConsumeTopics. With regex, topics removed with
+	// RemoveConsumeTopics are marked as unwanted instead.
+	var removed []string
+	for topic := range g.using {
+		_, exists := topics[topic]
+		if g.cfg.regex {
+			exists = exists && g.reSeen[topic]
+		} else if exists {
+			_, exists = g.cfg.topics[topic]
+		}
+		if !exists {
+			removed = append(removed, topic)
+			rns.remove(topic)
+		}
+	}
+
+	if len(toChange) == 0 && len(removed) == 0 {
 		return
 	}
 
@@ -1370,17 +1881,29 @@ func (g *groupConsumer) findNewAssignments() {
 		return
 	}
 
-	wasManaging := len(g.using) != 0
+	for _, topic := range removed {
+		delete(g.using, topic)
+		// If the topic is recreated or matches a new expression, we
+		// evaluate it again.
+		if g.reSeen[topic] {
+			g.reUsed--
+		}
+		delete(g.reSeen, topic)
+	}
+
 	for topic, change := range toChange {
 		g.using[topic] += change.delta
 	}
 
-	if !wasManaging {
-		go g.manage()
+	if !g.managing {
+		g.managing = true
+		g.cl.actors.spawn("group manager", purposeLabels("group"), g.manage)
 		return
 	}
 
-	if numNewTopics > 0 {
+	if len(removed) > 0 {
+		g.rejoin("rejoining because we are no longer consuming some topics, our interests have changed")
+	} else if numNewTopics > 0 {
 		g.rejoin("rejoining because there are more topics to consume, our interests have changed")
 	} else if g.leader.get() {
 		g.rejoin("rejoining because we are the leader and noticed some topics have new partitions")
@@ -1453,7 +1976,12 @@ func (g *groupConsumer) updateUncommitted(fetches Fetches) {
 					final.LeaderEpoch, // -1 if old message / unknown
 					final.Offset + 1,
 				}
-				prior := topicOffsets[partition.Partition]
+				prior, exists := topicOffsets[partition.Partition]
+				if !exists {
+					// Nothing was committed nor fetched as committed
+					// for this partition: committed is unknown, not 0.
+					prior.committed = EpochOffset{-1, -1}
+				}
 
 				if debug {
 					if setHead {
@@ -1639,7 +2167,12 @@ func (g *groupConsumer) updateCommitted(
 	}
 }
 
-func (g *groupConsumer) defaultCommitCallback(_ *Client, _ *kmsg.OffsetCommitRequest, resp *kmsg.OffsetCommitResponse, err error) {
+func (g *groupConsumer) defaultCommitCallback(_ *Client, req *kmsg.OffsetCommitRequest, resp *kmsg.OffsetCommitResponse, err error) {
+	var fenced *ErrCommitFenced
+	if errors.As(commitRespErr(req, resp, err), &fenced) {
+		g.cfg.logger.Log(LogLevelError, "default commit was fenced", "group", g.cfg.group, "err", fenced)
+		return
+	}
 	if err != nil {
 		if err != context.Canceled {
 			g.cfg.logger.Log(LogLevelError, "default commit failed", "group", g.cfg.group, "err", err)
@@ -1782,6 +2315,36 @@ func (cl *Client) setOffsets(setOffsets map[string]map[int32]EpochOffset, log bo
 	}
 }
 
+// rewindUncommitted, called under the consumer mu, moves the dirty and head
+// uncommitted offsets back to the rewind offsets so that records that were
+// polled but not delivered are not committed. Unlike SetOffsets, this keeps
+// what we know is committed.
+func (g *groupConsumer) rewindUncommitted(rewind map[string]map[int32]Offset) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for topic, partitions := range rewind {
+		topicUncommitted := g.uncommitted[topic]
+		if topicUncommitted == nil {
+			continue
+		}
+		for partition, offset := range partitions {
+			u, ok := topicUncommitted[partition]
+			if !ok {
+				continue
+			}
+			eo := EpochOffset{Epoch: offset.epoch, Offset: offset.at}
+			if u.dirty.Offset > eo.Offset {
+				u.dirty = eo
+			}
+			if u.head.Offset > eo.Offset {
+				u.head = eo
+			}
+			topicUncommitted[partition] = u
+		}
+	}
+}
+
 // UncommittedOffsets returns the latest uncommitted offsets. Uncommitted
 // offsets are always updated on calls to PollFetches.
 //
@@ -1816,6 +2379,115 @@ func (cl *Client) CommittedOffsets() map[string]map[int32]EpochOffset {
 	return g.getUncommittedLocked(false, false)
 }
 
+// PartitionLag is the lag of a partition assigned to this client in a group;
+// see GroupLag.
+type PartitionLag struct {
+	Topic     string // Topic is the topic of this partition.
+	Partition int32  // Partition is the partition number.
+
+	// Committed is the group's committed offset for this partition, or -1
+	// if the group has not committed for this partition.
+	Committed int64
+	// End is the end offset of this partition: the high watermark, or the
+	// last stable offset if consuming with ReadCommitted. This is -1 if
+	// the end offset could not be listed.
+	End int64
+	// Lag is how far the committed offset is behind the end offset, or -1
+	// if the end offset could not be listed. If nothing is committed, the
+	// lag is the end offset.
+	Lag int64
+
+	// Err is non-nil if the end offset could not be listed.
+	Err error
+}
+
+// GroupLag returns the lag of every partition currently assigned to this
+// client in its group, that is, how far the group's committed offset is behind
+// each partition's end offset. End offsets are listed from the partition
+// leaders on every call; the committed offsets are what this client last
+// committed or fetched when the partitions were assigned.
+//
+// This returns an error if the client is not consuming in a group, or if the
+// context is canceled. Errors listing individual partitions are returned in
+// each partition's Err field.
+func (cl *Client) GroupLag(ctx context.Context) (map[string]map[int32]PartitionLag, error) {
+	g := cl.consumer.g
+	if g == nil {
+		return nil, errNotGroup
+	}
+
+	lags := make(map[string]map[int32]PartitionLag)
+	req := kmsg.NewPtrListOffsetsRequest()
+	req.IsolationLevel = cl.cfg.isolationLevel
+
+	g.mu.Lock()
+	for topic, partitions := range g.nowAssigned {
+		committed := g.uncommitted[topic]
+		tlags := make(map[int32]PartitionLag, len(partitions))
+		rt := kmsg.NewListOffsetsRequestTopic()
+		rt.Topic = topic
+		for _, partition := range partitions {
+			l := PartitionLag{
+				Topic:     topic,
+				Partition: partition,
+				Committed: -1,
+				End:       -1,
+				Lag:       -1,
+			}
+			if c, ok := committed[partition]; ok {
+				l.Committed = c.committed.Offset
+			}
+			tlags[partition] = l
+
+			rp := kmsg.NewListOffsetsRequestTopicPartition()
+			rp.Partition = partition
+			rp.Timestamp = -1 // latest
+			rt.Partitions = append(rt.Partitions, rp)
+		}
+		lags[topic] = tlags
+		req.Topics = append(req.Topics, rt)
+	}
+	g.mu.Unlock()
+
+	if len(req.Topics) == 0 {
+		return lags, nil
+	}
+
+	for _, shard := range cl.RequestSharded(ctx, req) {
+		if shard.Err != nil {
+			for _, rt := range shard.Req.(*kmsg.ListOffsetsRequest).Topics {
+				for _, rp := range rt.Partitions {
+					l := lags[rt.Topic][rp.Partition]
+					l.Err = shard.Err
+					lags[rt.Topic][rp.Partition] = l
+				}
+			}
+			continue
+		}
+		for _, rt := range shard.Resp.(*kmsg.ListOffsetsResponse).Topics {
+			tlags := lags[rt.Topic]
+			for _, rp := range rt.Partitions {
+				l, ok := tlags[rp.Partition]
+				if !ok {
+					continue
+				}
+				if l.Err = kerr.ErrorForCode(rp.ErrorCode); l.Err == nil {
+					l.End = rp.Offset
+					l.Lag = l.End
+					if l.Committed >= 0 {
+						l.Lag = l.End - l.Committed
+					}
+				}
+				tlags[rp.Partition] = l
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return lags, nil
+}
+
 func (g *groupConsumer) getUncommitted(dirty bool) map[string]map[int32]EpochOffset {
 	g.mu.Lock()
 	defer g.mu.Unlock()
@@ -1858,9 +2530,39 @@ func (g *groupConsumer) getUncommittedLocked(head, dirty bool) map[string]map[in
 	return uncommitted
 }
 
+// CommitOffsetsForRecords returns the offsets to commit to mark the given
+// records as consumed: per partition, the offset after the latest record. If
+// records for a partition have different leader epochs, the latest epoch is
+// favored. The return can be used with CommitOffsets or CommitOffsetsSync, and
+// is what CommitRecords commits.
+func CommitOffsetsForRecords(rs ...*Record) map[string]map[int32]EpochOffset {
+	// We favor the latest epoch, then offset, if any records map to the
+	// same topic / partition.
+	offsets := make(map[string]map[int32]EpochOffset)
+	for _, r := range rs {
+		toffsets := offsets[r.Topic]
+		if toffsets == nil {
+			toffsets = make(map[int32]EpochOffset)
+			offsets[r.Topic] = toffsets
+		}
+
+		if at, exists := toffsets[r.Partition]; exists {
+			if at.Epoch > r.LeaderEpoch || at.Epoch == r.LeaderEpoch && at.Offset > r.Offset {
+				continue
+			}
+		}
+		toffsets[r.Partition] = EpochOffset{
+			r.LeaderEpoch,
+			r.Offset + 1, // need to advice to next offset to move forward
+		}
+	}
+	return offsets
+}
+
 // CommitRecords issues a synchronous offset commit for the offsets contained
 // within rs. Retriable errors are retried up to the configured retry limit,
-// and any unretriable error is returned.
+// and any unretriable error is returned. Per partition, the offset after the
+// latest record is committed; see CommitOffsetsForRecords.
 //
 // This function is useful as a simple way to commit offsets if you have
 // disabled autocommitting. As an alternative if you always want to commit
@@ -1885,46 +2587,15 @@ func (g *groupConsumer) getUncommittedLocked(head, dirty bool) map[string]map[in
 // If you do not want to wait for this function to complete before continuing
 // processing records, you can call this function in a goroutine.
 func (cl *Client) CommitRecords(ctx context.Context, rs ...*Record) error {
-	// First build the offset commit map. We favor the latest epoch, then
-	// offset, if any records map to the same topic / partition.
-	offsets := make(map[string]map[int32]EpochOffset)
-	for _, r := range rs {
-		toffsets := offsets[r.Topic]
-		if toffsets == nil {
-			toffsets = make(map[int32]EpochOffset)
-			offsets[r.Topic] = toffsets
-		}
-
-		if at, exists := toffsets[r.Partition]; exists {
-			if at.Epoch > r.LeaderEpoch || at.Epoch == r.LeaderEpoch && at.Offset > r.Offset {
-				continue
-			}
-		}
-		toffsets[r.Partition] = EpochOffset{
-			r.LeaderEpoch,
-			r.Offset + 1, // need to advice to next offset to move forward
-		}
-	}
+	offsets := CommitOffsetsForRecords(rs...)
 
 	var rerr error // return error
 
 	// Our client retries an OffsetCommitRequest as necessary if the first
 	// response partition has a retriable group error (group coordinator
 	// loading, etc), so any partition error is fatal.
-	cl.CommitOffsetsSync(ctx, offsets, func(_ *Client, _ *kmsg.OffsetCommitRequest, resp *kmsg.OffsetCommitResponse, err error) {
-		if err != nil {
-			rerr = err
-			return
-		}
-
-		for _, topic := range resp.Topics {
-			for _, partition := range topic.Partitions {
-				if err := kerr.ErrorForCode(partition.ErrorCode); err != nil {
-					rerr = err
-					return
-				}
-			}
-		}
+	cl.CommitOffsetsSync(ctx, offsets, func(_ *Client, req *kmsg.OffsetCommitRequest, resp *kmsg.OffsetCommitResponse, err error) {
+		rerr = commitRespErr(req, resp, err)
 	})
 
 	return rerr
@@ -1933,6 +2604,11 @@ func (cl *Client) CommitRecords(ctx context.Context, rs ...*Record) error {
 // MarkCommitRecords marks records to be available for autocommitting. This
 // function is only useful if you use the AutoCommitMarks config option, see
 // the documentation on that option for more details.
+//
+// Records for partitions that are no longer assigned to this client are not
+// marked. If records are processed asynchronously, processing can finish
+// after a rebalance moved the records' partition to another member; marking
+// these records would commit over the new owner's progress.
 func (cl *Client) MarkCommitRecords(rs ...*Record) {
 	g := cl.consumer.g
 	if g == nil || !cl.cfg.autocommitMarks {
@@ -1948,27 +2624,27 @@ func (cl *Client) MarkCommitRecords(rs ...*Record) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
-	if g.uncommitted == nil {
-		g.uncommitted = make(uncommitted)
-	}
+	// Partitions are added to uncommitted when they are polled and are
+	// removed when they are revoked or lost, so a record for a partition
+	// that is not in uncommitted is from a partition we no longer own.
 	var curTopic string
 	var curPartitions map[int32]uncommit
-	for _, r := range rs {
-		if curPartitions == nil || r.Topic != curTopic {
+	for i, r := range rs {
+		if i == 0 || r.Topic != curTopic {
 			curPartitions = g.uncommitted[r.Topic]
-			if curPartitions == nil {
-				curPartitions = make(map[int32]uncommit)
-				g.uncommitted[r.Topic] = curPartitions
-			}
 			curTopic = r.Topic
 		}
 
+		next, ok := curPartitions[r.Partition]
+		if !ok {
+			continue
+		}
+
 		set := EpochOffset{
 			r.LeaderEpoch,
 			r.Offset + 1,
 		}
 
-		next := curPartitions[r.Partition]
 		if next.head.less(set) {
 			next.head = set
 		}
@@ -2003,20 +2679,8 @@ func (cl *Client) MarkCommitRecords(rs ...*Record) {
 func (cl *Client) CommitUncommittedOffsets(ctx context.Context) error {
 	// This function is just the tail end of CommitRecords just above.
 	var rerr error
-	cl.CommitOffsetsSync(ctx, cl.UncommittedOffsets(), func(_ *Client, _ *kmsg.OffsetCommitRequest, resp *kmsg.OffsetCommitResponse, err error) {
-		if err != nil {
-			rerr = err
-			return
-		}
-
-		for _, topic := range resp.Topics {
-			for _, partition := range topic.Partitions {
-				if err := kerr.ErrorForCode(partition.ErrorCode); err != nil {
-					rerr = err
-					return
-				}
-			}
-		}
+	cl.CommitOffsetsSync(ctx, cl.UncommittedOffsets(), func(_ *Client, req *kmsg.OffsetCommitRequest, resp *kmsg.OffsetCommitResponse, err error) {
+		rerr = commitRespErr(req, resp, err)
 	})
 	return rerr
 }
@@ -2033,8 +2697,9 @@ func (cl *Client) CommitUncommittedOffsets(ctx context.Context) error {
 // This is an advanced function, and for simpler, more easily understandable
 // committing, see CommitRecords and CommitUncommittedOffsets.
 //
-// For more information about committing and committing asynchronously, see
-// CommitOffsets.
+// As with CommitOffsets, this function can be used whether or not
+// autocommitting is enabled. For more information about committing and
+// committing asynchronously, see CommitOffsets.
 func (cl *Client) CommitOffsetsSync(
 	ctx context.Context,
 	uncommitted map[string]map[int32]EpochOffset,
@@ -2095,9 +2760,10 @@ func (g *groupConsumer) commitOffsetsSync(
 
 // CommitOffsets commits the given offsets for a group, calling onDone with the
 // commit request and either the response or an error if the response was not
-// issued. If uncommitted is empty or the client is not consuming as a group,
-// onDone is called with (nil, nil, nil) and this function returns immediately.
-// It is OK if onDone is nil, but you will not know if your commit succeeded.
+// issued. If uncommitted is empty, onDone is called with an empty request and
+// response and no error, and this function returns immediately. If the client
+// is not consuming as a group, onDone is called with an error. It is OK if
+// onDone is nil, but you will not know if your commit succeeded.
 //
 // This is an advanced function and is difficult to use correctly. For simpler,
 // more easily understandable committing, see CommitRecords and
@@ -2108,6 +2774,11 @@ func (g *groupConsumer) commitOffsetsSync(
 // If autocommitting is enabled, this function blocks autocommitting until this
 // function is complete and the onDone has returned.
 //
+// This function does not depend on autocommitting: it can be used alongside
+// autocommitting, or with DisableAutoCommit to control exactly which offsets
+// are committed and when. Successfully committed offsets are reflected in
+// CommittedOffsets for partitions the client is currently assigned.
+//
 // It is invalid to use this function to commit offsets for a transaction.
 //
 // Note that this function ensures absolute ordering of commit requests by
@@ -2255,6 +2926,17 @@ func (g *groupConsumer) commit(
 			req.Topics = append(req.Topics, reqTopic)
 		}
 
+		if g.cfg.offsetStore != nil {
+			resp, err := g.commitToStore(commitCtx, req, uncommitted)
+			if err != nil {
+				onDone(g.cl, req, nil, err)
+				return
+			}
+			g.updateCommitted(req, resp)
+			onDone(g.cl, req, resp, nil)
+			return
+		}
+
 		resp, err := req.RequestWith(commitCtx, g.cl)
 		if err != nil {
 			onDone(g.cl, req, nil, err)
@@ -2268,6 +2950,8 @@ func (g *groupConsumer) commit(
 type reNews struct {
 	added   map[string][]string
 	skipped []string
+	capped  []string
+	removed []string
 }
 
 func (r *reNews) add(re, match string) {
@@ -2281,7 +2965,28 @@ func (r *reNews) skip(topic string) {
 	r.skipped = append(r.skipped, topic)
 }
 
+func (r *reNews) cap(topic string) {
+	r.capped = append(r.capped, topic)
+}
+
+func (r *reNews) remove(topic string) {
+	r.removed = append(r.removed, topic)
+}
+
 func (r *reNews) log(cfg *cfg) {
+	if len(r.capped) > 0 {
+		sort.Strings(r.capped)
+		cfg.logger.Log(LogLevelWarn, "consumer regular expressions matched new topics past the max consume topics, not consuming them", "max_consume_topics", cfg.maxConsumeTopics, "capped", r.capped)
+		cfg.hooks.each(func(h Hook) {
+			if h, ok := h.(HookConsumeTopicsCapped); ok {
+				h.OnConsumeTopicsCapped(r.capped)
+			}
+		})
+	}
+	if len(r.removed) > 0 {
+		sort.Strings(r.removed)
+		cfg.logger.Log(LogLevelInfo, "consumer topics were deleted or removed, no longer consuming them", "removed", r.removed)
+	}
 	if len(r.added) == 0 && len(r.skipped) == 0 {
 		return
 	}
@@ -0,0 +1,114 @@
+package kgo
+
+import (
+	"context"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// OffsetStore stores group offsets outside of Kafka; see the
+// ExternalOffsetStore option.
+type OffsetStore interface {
+	// FetchOffsets returns the stored offsets for newly assigned
+	// partitions. Partitions without a stored offset should be omitted;
+	// their offsets are fetched from Kafka as if there was no store.
+	FetchOffsets(ctx context.Context, group string, partitions map[string][]int32) (map[string]map[int32]EpochOffset, error)
+
+	// StoreOffsets stores offsets that the group consumer is committing.
+	// The context is the context that was passed to the commit function
+	// (e.g., CommitUncommittedOffsets), or the group's context if
+	// autocommitting. If this returns an error, the commit fails.
+	StoreOffsets(ctx context.Context, group string, offsets map[string]map[int32]EpochOffset) error
+}
+
+// fetchStoredOffsets fetches offsets for added partitions from the external
+// offset store, returning what was stored and what still must be fetched from
+// Kafka.
+func (g *groupConsumer) fetchStoredOffsets(ctx context.Context, added map[string][]int32) (map[string]map[int32]Offset, map[string][]int32, error) {
+	stored, err := g.cfg.offsetStore.FetchOffsets(ctx, g.cfg.group, added)
+	if err != nil {
+		g.cfg.logger.Log(LogLevelError, "fetching offsets from the external offset store failed", "group", g.cfg.group, "err", err)
+		return nil, nil, err
+	}
+
+	kip320 := g.cl.supportsOffsetForLeaderEpoch()
+
+	offsets := make(map[string]map[int32]Offset)
+	missing := make(map[string][]int32)
+	for topic, partitions := range added {
+		storedTopic := stored[topic]
+		for _, partition := range partitions {
+			eo, ok := storedTopic[partition]
+			if !ok || eo.Offset < 0 {
+				missing[topic] = append(missing[topic], partition)
+				continue
+			}
+			offset := Offset{
+				at:    eo.Offset,
+				epoch: eo.Epoch,
+			}
+			if !kip320 {
+				offset.epoch = -1
+			}
+			topicOffsets := offsets[topic]
+			if topicOffsets == nil {
+				topicOffsets = make(map[int32]Offset)
+				offsets[topic] = topicOffsets
+			}
+			topicOffsets[partition] = offset
+		}
+	}
+	return offsets, missing, nil
+}
+
+// commitToStore stores the offsets in req in the external offset store,
+// mirroring the commit to Kafka if configured, and returns a successful
+// response for every partition in req.
+func (g *groupConsumer) commitToStore(
+	ctx context.Context,
+	req *kmsg.OffsetCommitRequest,
+	uncommitted map[string]map[int32]EpochOffset,
+) (*kmsg.OffsetCommitResponse, error) {
+	if err := g.cfg.offsetStore.StoreOffsets(ctx, g.cfg.group, uncommitted); err != nil {
+		g.cfg.logger.Log(LogLevelError, "storing offsets in the external offset store failed", "group", g.cfg.group, "err", err)
+		return nil, err
+	}
+
+	// The store is the source of truth; the mirrored commit exists only
+	// so that Kafka tooling can observe the group's progress, so we only
+	// log if it fails.
+	if g.cfg.mirrorStoredOffsets {
+		mirrorResp, err := req.RequestWith(ctx, g.cl)
+		if err != nil {
+			g.cfg.logger.Log(LogLevelWarn, "unable to mirror stored offsets to Kafka", "group", g.cfg.group, "err", err)
+		} else {
+			for _, t := range mirrorResp.Topics {
+				for _, p := range t.Partitions {
+					if err := kerr.ErrorForCode(p.ErrorCode); err != nil {
+						g.cfg.logger.Log(LogLevelWarn, "unable to mirror stored offset to Kafka",
+							"group", g.cfg.group,
+							"topic", t.Topic,
+							"partition", p.Partition,
+							"err", err,
+						)
+					}
+				}
+			}
+		}
+	}
+
+	resp := kmsg.NewPtrOffsetCommitResponse()
+	resp.Version = req.Version
+	for _, t := range req.Topics {
+		respTopic := kmsg.NewOffsetCommitResponseTopic()
+		respTopic.Topic = t.Topic
+		for _, p := range t.Partitions {
+			respPartition := kmsg.NewOffsetCommitResponseTopicPartition()
+			respPartition.Partition = p.Partition
+			respTopic.Partitions = append(respTopic.Partitions, respPartition)
+		}
+		resp.Topics = append(resp.Topics, respTopic)
+	}
+	return resp, nil
+}
@@ -0,0 +1,241 @@
+package kgo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// setupStandby updates what we gate as standby for a new group session and
+// begins prefetching the standby partitions; see PrefetchStandbyPartitions.
+// The returned channel is closed once prefetching is done.
+//
+// Anything we previously prefetched that is now assigned stays gated until
+// its offsets are fetched, at which point takeOverStandby decides whether we
+// can keep the prefetched position. Anything we previously prefetched that is
+// neither standby nor assigned anymore is invalidated.
+func (g *groupConsumer) setupStandby(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+
+	assigned := make(map[string]map[int32]struct{}, len(g.nowAssigned))
+	for topic, partitions := range g.nowAssigned {
+		ps := make(map[int32]struct{}, len(partitions))
+		for _, partition := range partitions {
+			ps[partition] = struct{}{}
+		}
+		assigned[topic] = ps
+	}
+
+	next := make(map[string][]int32)
+	standby := make(map[string]map[int32]struct{})
+	for topic, partitions := range g.syncStandby {
+		for _, partition := range partitions {
+			if _, ok := assigned[topic][partition]; ok {
+				continue
+			}
+			next[topic] = append(next[topic], partition)
+			if standby[topic] == nil {
+				standby[topic] = make(map[int32]struct{})
+			}
+			standby[topic][partition] = struct{}{}
+		}
+	}
+
+	gone := make(map[string]map[int32]Offset)
+	for topic, partitions := range g.standby {
+		for partition := range partitions {
+			_, isStandby := standby[topic][partition]
+			_, isAssigned := assigned[topic][partition]
+			switch {
+			case isStandby:
+			case isAssigned:
+				if standby[topic] == nil {
+					standby[topic] = make(map[int32]struct{})
+				}
+				standby[topic][partition] = struct{}{}
+			default:
+				if gone[topic] == nil {
+					gone[topic] = make(map[int32]Offset)
+				}
+				gone[topic][partition] = Offset{}
+			}
+		}
+	}
+
+	// We must invalidate what is gone before no longer gating it,
+	// otherwise the prefetched cursors would begin returning records.
+	if len(gone) > 0 {
+		g.c.mu.Lock()
+		g.c.assignPartitions(gone, assignInvalidateMatching, g.tps, fmt.Sprintf("dropping prefetched standby partitions for group %s", g.cfg.group))
+		g.c.mu.Unlock()
+	}
+	g.standby = standby
+	g.c.storeStandby(standbyList(standby))
+
+	if len(next) == 0 {
+		close(done)
+		return done
+	}
+	go func() {
+		defer close(done)
+		g.prefetchStandby(ctx, next)
+	}()
+	return done
+}
+
+// prefetchStandby fetches the committed offsets for standby partitions and
+// assigns them. The partitions are already gated, so assigning them only
+// positions them: the partitions are validated or listed, and then fetched
+// only as standby partitions.
+func (g *groupConsumer) prefetchStandby(ctx context.Context, standby map[string][]int32) {
+	req := kmsg.NewPtrOffsetFetchRequest()
+	req.Group = g.cfg.group
+	req.RequireStable = g.cfg.requireStable
+	for topic, partitions := range standby {
+		reqTopic := kmsg.NewOffsetFetchRequestTopic()
+		reqTopic.Topic = topic
+		reqTopic.Partitions = partitions
+		req.Topics = append(req.Topics, reqTopic)
+	}
+
+	resp, err := req.RequestWith(ctx, g.cl)
+	if err != nil {
+		g.cfg.logger.Log(LogLevelInfo, "unable to fetch offsets for standby partitions, not prefetching", "group", g.cfg.group, "err", err)
+		return
+	}
+
+	kip320 := g.cl.supportsOffsetForLeaderEpoch()
+	groupTopics := g.tps.load()
+
+	offsets := make(map[string]map[int32]Offset)
+	for _, rTopic := range resp.Topics {
+		if !groupTopics.hasTopic(rTopic.Topic) {
+			continue
+		}
+		topicOffsets := make(map[int32]Offset)
+		offsets[rTopic.Topic] = topicOffsets
+		for _, rPartition := range rTopic.Partitions {
+			// Any error, including an unstable offset commit, just
+			// means we do not prefetch the partition this session.
+			if err := kerr.ErrorForCode(rPartition.ErrorCode); err != nil {
+				g.cfg.logger.Log(LogLevelInfo, "unable to fetch offset for standby partition, not prefetching",
+					"group", g.cfg.group,
+					"topic", rTopic.Topic,
+					"partition", rPartition.Partition,
+					"err", err,
+				)
+				continue
+			}
+			offset := Offset{
+				at:    rPartition.Offset,
+				epoch: -1,
+			}
+			if resp.Version >= 5 && kip320 { // KIP-320
+				offset.epoch = rPartition.LeaderEpoch
+			}
+			if rPartition.Offset == -1 {
+				offset = g.cfg.resetOffsetFor(rTopic.Topic)
+				// Without a commit and with resetting disabled,
+				// there is nothing to prefetch: the partition will
+				// fail once it is actually assigned.
+				if offset.isNoReset() {
+					continue
+				}
+			}
+			topicOffsets[rPartition.Partition] = offset
+		}
+	}
+
+	g.c.mu.Lock()
+	defer g.c.mu.Unlock()
+
+	// If our session ended while fetching, we do not assign: the next
+	// session will invalidate or refresh whatever is standby.
+	if ctx.Err() != nil {
+		return
+	}
+	g.c.assignPartitions(offsets, assignWithoutInvalidating, g.tps, fmt.Sprintf("prefetching standby partitions for group %s", g.cfg.group))
+}
+
+// takeOverStandby is called while assigning fetched offsets, with the consumer
+// and group mu held. For any partition that we prefetched as standby, we stop
+// gating it, and we keep its prefetched position if the position is what was
+// just committed. This returns the offsets that still need to be assigned.
+func (g *groupConsumer) takeOverStandby(offsets map[string]map[int32]Offset) map[string]map[int32]Offset {
+	assign := make(map[string]map[int32]Offset, len(offsets))
+	stale := make(map[string]map[int32]Offset)
+	var tookOver bool
+
+	tps := g.tps.load()
+	for topic, partitions := range offsets {
+		assignPartitions := make(map[int32]Offset, len(partitions))
+		assign[topic] = assignPartitions
+		for partition, offset := range partitions {
+			if _, ok := g.standby[topic][partition]; !ok {
+				assignPartitions[partition] = offset
+				continue
+			}
+			delete(g.standby[topic], partition)
+			if len(g.standby[topic]) == 0 {
+				delete(g.standby, topic)
+			}
+			tookOver = true
+
+			if offset.isExact() && g.c.positionedAt(tps, topic, partition, offset.at) {
+				g.cfg.logger.Log(LogLevelInfo, "keeping prefetched standby position for newly assigned partition",
+					"group", g.cfg.group,
+					"topic", topic,
+					"partition", partition,
+					"offset", offset.at,
+				)
+				continue
+			}
+
+			assignPartitions[partition] = offset
+			if stale[topic] == nil {
+				stale[topic] = make(map[int32]Offset)
+			}
+			stale[topic][partition] = Offset{}
+		}
+	}
+
+	if !tookOver {
+		return offsets
+	}
+
+	// As in setupStandby, we must invalidate prefetched positions that we
+	// are not keeping before we stop gating them.
+	if len(stale) > 0 {
+		g.c.assignPartitions(stale, assignInvalidateMatching, g.tps, "")
+	}
+	g.c.storeStandby(standbyList(g.standby))
+	return assign
+}
+
+// positionedAt returns whether the cursor for a partition is in use and ready
+// to fetch at the given offset. This must be called with the consumer mu held.
+func (c *consumer) positionedAt(tps topicsPartitionsData, topic string, partition int32, at int64) bool {
+	topicPartitions := tps.loadTopic(topic)
+	if topicPartitions == nil || partition < 0 || partition >= int32(len(topicPartitions.partitions)) {
+		return false
+	}
+	cursor := topicPartitions.partitions[partition].cursor
+	if _, ok := c.usingCursors[cursor]; !ok {
+		return false
+	}
+	return cursor.usable() && cursor.offset == at
+}
+
+func standbyList(standby map[string]map[int32]struct{}) map[string][]int32 {
+	list := make(map[string][]int32, len(standby))
+	for topic, partitions := range standby {
+		ps := make([]int32, 0, len(partitions))
+		for partition := range partitions {
+			ps = append(ps, partition)
+		}
+		list[topic] = ps
+	}
+	return list
+}
@@ -0,0 +1,761 @@
+package kgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+func TestPauseFetchPartitionsDropBuffered(t *testing.T) {
+	cl, err := NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	c := &cl.consumer
+	s := cl.newSource(1)
+	cursor0 := &cursor{topic: "t", partition: 0, source: s, cursorOffset: cursorOffset{offset: 10, lastConsumedEpoch: -1}}
+	cursor1 := &cursor{topic: "t", partition: 1, source: s, cursorOffset: cursorOffset{offset: 20, lastConsumedEpoch: -1}}
+
+	// Buffer a fetch as a source would after a fetch response: records
+	// for partition 0 and 1, with the offsets to use if taken.
+	doneFetch := make(chan struct{}, 1)
+	fetch := Fetch{Topics: []FetchTopic{{
+		Topic: "t",
+		Partitions: []FetchPartition{
+			{Partition: 0, Records: []*Record{{Topic: "t", Partition: 0, Offset: 10}, {Topic: "t", Partition: 0, Offset: 11}}},
+			{Partition: 1, Records: []*Record{{Topic: "t", Partition: 1, Offset: 20}}},
+		},
+	}}}
+	s.buffered = bufferedFetch{
+		fetch:     fetch,
+		doneFetch: doneFetch,
+		usedOffsets: usedOffsets{"t": {
+			0: {cursorOffset: cursorOffset{offset: 12, lastConsumedEpoch: -1}, from: cursor0},
+			1: {cursorOffset: cursorOffset{offset: 21, lastConsumedEpoch: -1}, from: cursor1},
+		}},
+	}
+	s.sem = make(chan struct{})
+	s.hook(&s.buffered.fetch, true, false)
+	c.addSourceReadyForDraining(s)
+
+	paused := cl.PauseFetchPartitionsDropBuffered(map[string][]int32{"t": {0}})
+	if len(paused["t"]) != 1 || paused["t"][0] != 0 {
+		t.Fatalf("got paused %v, expected t[0]", paused)
+	}
+
+	fs := cl.PollFetches(nil)
+	var polled []int32
+	fs.EachRecord(func(r *Record) { polled = append(polled, r.Partition) })
+	if len(polled) != 1 || polled[0] != 1 {
+		t.Errorf("got polled records for partitions %v, expected only partition 1", polled)
+	}
+	if cursor0.offset != 10 {
+		t.Errorf("dropped partition cursor advanced to %d, expected to stay at 10", cursor0.offset)
+	}
+	if !cursor0.usable() {
+		t.Error("dropped partition cursor is not usable")
+	}
+	if cursor1.offset != 21 {
+		t.Errorf("polled partition cursor at %d, expected 21", cursor1.offset)
+	}
+	if n := atomic.LoadInt64(&c.bufferedRecords); n != 0 {
+		t.Errorf("got %d buffered records after polling, expected 0", n)
+	}
+
+	// A buffered fetch containing only dropped partitions is discarded
+	// entirely, allowing the source to fetch again.
+	s.buffered = bufferedFetch{
+		fetch: Fetch{Topics: []FetchTopic{{
+			Topic:      "t",
+			Partitions: []FetchPartition{{Partition: 0, Records: []*Record{{Topic: "t", Partition: 0, Offset: 10}}}},
+		}}},
+		doneFetch: doneFetch,
+		usedOffsets: usedOffsets{"t": {
+			0: {cursorOffset: cursorOffset{offset: 11, lastConsumedEpoch: -1}, from: cursor0},
+		}},
+	}
+	<-doneFetch
+	s.sem = make(chan struct{})
+	s.hook(&s.buffered.fetch, true, false)
+	c.addSourceReadyForDraining(s)
+	if fs := cl.PollFetches(nil); len(fs.Records()) != 0 {
+		t.Errorf("got %d records polled, expected 0", len(fs.Records()))
+	}
+	if len(c.sourcesReadyForDraining) != 0 {
+		t.Error("source with only dropped partitions is still ready for draining")
+	}
+	select {
+	case <-s.sem:
+	default:
+		t.Error("source with only dropped partitions did not allow fetching again")
+	}
+	if n := atomic.LoadInt64(&c.bufferedRecords); n != 0 {
+		t.Errorf("got %d buffered records after dropping, expected 0", n)
+	}
+
+	cl.ResumeFetchPartitions(map[string][]int32{"t": {0}})
+	if len(c.loadDropPaused()) != 0 || len(c.loadPaused()) != 0 {
+		t.Error("resuming did not clear paused and dropped partitions")
+	}
+}
+
+func TestBufferedFetchLimits(t *testing.T) {
+	cl, err := NewClient(
+		MaxBufferedFetches(2),
+		MaxBufferedFetchRecords(3),
+		MaxBufferedFetchBytes(100),
+		FetchMaxBytes(1000),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	c := &cl.consumer
+	ctx, cancel := context.WithCancel(cl.ctx)
+	defer cancel()
+	session := &consumerSession{
+		c:             c,
+		ctx:           ctx,
+		cancel:        cancel,
+		desireFetchCh: make(chan chan chan struct{}, 8),
+		cancelFetchCh: make(chan chan chan struct{}, 4),
+		unbufferedCh:  make(chan struct{}, 1),
+	}
+	c.session.Store(session)
+
+	// wantFetch registers a fetch and returns whether it is allowed
+	// before a short timeout.
+	wantFetch := func() (chan chan struct{}, bool) {
+		canFetch := make(chan chan struct{}, 1)
+		session.desireFetch() <- canFetch
+		select {
+		case done := <-canFetch:
+			done <- struct{}{}
+			return canFetch, true
+		case <-time.After(50 * time.Millisecond):
+			return canFetch, false
+		}
+	}
+	waitAllowed := func(canFetch chan chan struct{}) bool {
+		select {
+		case done := <-canFetch:
+			done <- struct{}{}
+			return true
+		case <-time.After(time.Second):
+			return false
+		}
+	}
+
+	if _, ok := wantFetch(); !ok {
+		t.Fatal("fetch not allowed with nothing buffered")
+	}
+
+	// Each limit independently blocks fetching until the buffer drains.
+	s := cl.newSource(1)
+	for _, test := range []struct {
+		name   string
+		fetch  Fetch
+		nfetch int64
+	}{
+		{"records", Fetch{Topics: []FetchTopic{{Topic: "t", Partitions: []FetchPartition{{Records: []*Record{{}, {}, {}}}}}}}, 0},
+		{"bytes", Fetch{Topics: []FetchTopic{{Topic: "t", Partitions: []FetchPartition{{Records: []*Record{{Value: make([]byte, 100)}}}}}}}, 0},
+		{"fetches", Fetch{}, 2},
+	} {
+		atomic.AddInt64(&c.bufferedFetches, test.nfetch)
+		s.hook(&test.fetch, true, false)
+
+		canFetch, ok := wantFetch()
+		if ok {
+			t.Errorf("%s: fetch allowed while buffer is full", test.name)
+			continue
+		}
+
+		atomic.AddInt64(&c.bufferedFetches, -test.nfetch)
+		s.hook(&test.fetch, false, true)
+		if !waitAllowed(canFetch) {
+			t.Errorf("%s: fetch not allowed after buffer drained", test.name)
+		}
+	}
+
+	// While below the byte limit, fetches ask for and reserve only the
+	// remaining room.
+	atomic.StoreInt64(&c.bufferedBytes, 60)
+	if req := s.createReq(); req.maxBytes != 40 || req.reservedBytes != 40 {
+		t.Errorf("got fetch max bytes %d reserving %d, expected 40", req.maxBytes, req.reservedBytes)
+	}
+	atomic.StoreInt64(&c.bufferedBytes, 0)
+	atomic.StoreInt64(&c.reservedFetchBytes, 0)
+	if req := s.createReq(); req.maxBytes != 100 {
+		t.Errorf("got fetch max bytes %d, expected 100", req.maxBytes)
+	}
+
+	// Room reserved by inflight fetches is not available to other
+	// fetches, and reserving all room blocks fetching.
+	atomic.StoreInt64(&c.reservedFetchBytes, 70)
+	if req := s.createReq(); req.maxBytes != 30 {
+		t.Errorf("got fetch max bytes %d with 70 reserved, expected 30", req.maxBytes)
+	}
+	if c.bufferRoom() {
+		t.Error("buffer has room with all bytes reserved by inflight fetches")
+	}
+	atomic.StoreInt64(&c.reservedFetchBytes, 0)
+
+	// Fetches to many brokers created concurrently never reserve the same
+	// room: with each fetch asking for 10 bytes, ten fetches split the 100
+	// bytes of room, and once the room is gone, each further fetch
+	// reserves one byte.
+	cl.cfg.maxBytes = 10
+	const sources = 16
+	reserved := make(chan int64, sources)
+	var wg sync.WaitGroup
+	for i := 0; i < sources; i++ {
+		wg.Add(1)
+		go func(s *source) {
+			defer wg.Done()
+			reserved <- s.createReq().reservedBytes
+		}(cl.newSource(int32(i + 2)))
+	}
+	wg.Wait()
+	close(reserved)
+	var total, overflow int64
+	for r := range reserved {
+		total += r
+		if r == 1 {
+			overflow++
+		}
+	}
+	if total-overflow > 100 || total != atomic.LoadInt64(&c.reservedFetchBytes) {
+		t.Errorf("concurrent fetches reserved %d bytes (%d beyond the limit), tracked %d; expected at most 100 within the limit",
+			total, overflow, atomic.LoadInt64(&c.reservedFetchBytes))
+	}
+	atomic.StoreInt64(&c.reservedFetchBytes, 0)
+}
+
+func TestPrefetchDrainedPartitions(t *testing.T) {
+	cl, err := NewClient(PrefetchDrainedPartitions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	s := cl.newSource(1)
+	cursor0 := &cursor{topic: "t", partition: 0, source: s, cursorOffset: cursorOffset{offset: 10, lastConsumedEpoch: -1}}
+	cursor1 := &cursor{topic: "t", partition: 1, source: s, cursorOffset: cursorOffset{offset: 20, lastConsumedEpoch: -1}}
+
+	s.buffered = bufferedFetch{
+		fetch: Fetch{Topics: []FetchTopic{{
+			Topic: "t",
+			Partitions: []FetchPartition{
+				{Partition: 0, Records: []*Record{{Topic: "t", Partition: 0, Offset: 10}}},
+				{Partition: 1, Records: []*Record{{Topic: "t", Partition: 1, Offset: 20}, {Topic: "t", Partition: 1, Offset: 21}}},
+			},
+		}}},
+		doneFetch: make(chan struct{}, 1),
+		usedOffsets: usedOffsets{"t": {
+			0: {cursorOffset: cursorOffset{offset: 11, lastConsumedEpoch: -1}, from: cursor0},
+			1: {cursorOffset: cursorOffset{offset: 22, lastConsumedEpoch: -1}, from: cursor1},
+		}},
+	}
+	s.sem = make(chan struct{})
+	s.prefetch = make(chan struct{}, 1)
+
+	// Taking only partition 0's record drains partition 0, which allows
+	// prefetching it while partition 1 is still buffered.
+	if _, taken, drained := s.takeNBuffered(1); taken != 1 || drained {
+		t.Fatalf("got %d taken and drained %v, expected 1 taken and not drained", taken, drained)
+	}
+	select {
+	case <-s.prefetch:
+	default:
+		t.Error("draining a partition did not signal prefetching")
+	}
+	if !cursor0.usable() || cursor0.offset != 11 {
+		t.Errorf("drained partition cursor usable %v at %d, expected usable at 11", cursor0.usable(), cursor0.offset)
+	}
+	if cursor1.usable() {
+		t.Error("partially drained partition cursor is usable")
+	}
+	select {
+	case <-s.sem:
+		t.Error("source allowed a full fetch while records are still buffered")
+	default:
+	}
+
+	// Taking one record of partition 1 does not drain it, so there is
+	// nothing new to prefetch.
+	if _, taken, _ := s.takeNBuffered(1); taken != 1 {
+		t.Fatalf("got %d taken, expected 1", taken)
+	}
+	select {
+	case <-s.prefetch:
+		t.Error("partially taking a partition signaled prefetching")
+	default:
+	}
+}
+
+func TestPollFetchesChan(t *testing.T) {
+	cl, err := NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &cl.consumer
+
+	errFake := errors.New("fake")
+	c.addFakeReadyForDraining("t", 0, errFake)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := cl.PollFetchesChan(ctx, 0, 1)
+
+	select {
+	case fs := <-ch:
+		if errs := fs.Errors(); len(errs) != 1 || errs[0].Err != errFake {
+			t.Errorf("got errors %v, expected one fake error", errs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for fetches")
+	}
+
+	// Closing the client delivers ErrClientClosed and closes the channel.
+	cl.Close()
+	var closed bool
+	for fs := range ch {
+		closed = closed || fs.IsClientClosed()
+	}
+	if !closed {
+		t.Error("channel closed without delivering ErrClientClosed")
+	}
+
+	// Canceling the context closes the channel.
+	cl2, err := NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl2.Close()
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	ch2 := cl2.PollFetchesChan(ctx2, 0, 0)
+	cancel2()
+	select {
+	case _, ok := <-ch2:
+		if ok {
+			t.Error("got fetches after canceling, expected the channel to close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the channel to close after canceling")
+	}
+}
+
+func TestPollFetchesChanCancelRewinds(t *testing.T) {
+	cl, err := NewClient(ConsumePartitions(map[string]map[int32]Offset{"t": {0: NewOffset().At(0)}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	c := &cl.consumer
+
+	// The cursor has fetched past records 10 through 12, which are
+	// polled but never received from the channel.
+	cur := &cursor{topic: "t", partition: 0, source: cl.newSource(1), cursorOffset: cursorOffset{offset: 13, lastConsumedEpoch: 2}}
+	c.mu.Lock()
+	c.usingCursors.use(cur)
+	c.mu.Unlock()
+	c.sourcesReadyMu.Lock()
+	c.fakeReadyForDraining = append(c.fakeReadyForDraining, Fetch{Topics: []FetchTopic{{
+		Topic: "t",
+		Partitions: []FetchPartition{{Records: []*Record{
+			{Topic: "t", Offset: 10, LeaderEpoch: 2},
+			{Topic: "t", Offset: 11, LeaderEpoch: 2},
+			{Topic: "t", Offset: 12, LeaderEpoch: 2},
+		}}},
+	}}})
+	c.sourcesReadyMu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := cl.PollFetchesChan(ctx, 0, 0)
+	for polled := false; !polled; {
+		c.sourcesReadyMu.Lock()
+		polled = len(c.fakeReadyForDraining) == 0
+		c.sourcesReadyMu.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	// With nothing receiving, canceling rewinds the partition to the
+	// first polled record rather than dropping what was polled.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.mu.Lock()
+		at := cur.cursorOffset
+		c.mu.Unlock()
+		if at.offset == 10 && at.lastConsumedEpoch == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("cursor at offset %d epoch %d, expected rewound to offset 10 epoch 2", at.offset, at.lastConsumedEpoch)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := <-ch; ok {
+		t.Error("got fetches after canceling, expected the channel to close")
+	}
+
+	// Group consumers additionally move back what would be committed,
+	// keeping what is known to be committed.
+	g := &groupConsumer{uncommitted: uncommitted{"t": {
+		0: {dirty: EpochOffset{2, 13}, head: EpochOffset{2, 10}, committed: EpochOffset{1, 5}},
+		1: {dirty: EpochOffset{2, 20}, head: EpochOffset{2, 20}, committed: EpochOffset{2, 20}},
+	}}}
+	g.rewindUncommitted(map[string]map[int32]Offset{"t": {
+		0: {at: 10, epoch: 2},
+		2: {at: 1, epoch: 2}, // not uncommitted, skipped
+	}})
+	exp := uncommitted{"t": {
+		0: {dirty: EpochOffset{2, 10}, head: EpochOffset{2, 10}, committed: EpochOffset{1, 5}},
+		1: {dirty: EpochOffset{2, 20}, head: EpochOffset{2, 20}, committed: EpochOffset{2, 20}},
+	}}
+	if !reflect.DeepEqual(g.uncommitted, exp) {
+		t.Errorf("got uncommitted %v != exp %v", g.uncommitted, exp)
+	}
+}
+
+type backpressureHook struct{ paused, resumed []map[string][]int32 }
+
+func (h *backpressureHook) OnFetchBackpressure(paused, resumed map[string][]int32, _ int64) {
+	if paused != nil {
+		h.paused = append(h.paused, paused)
+	}
+	if resumed != nil {
+		h.resumed = append(h.resumed, resumed)
+	}
+}
+
+func TestFetchBackpressure(t *testing.T) {
+	h := new(backpressureHook)
+	cl, err := NewClient(FetchBackpressureBytes(100), WithHooks(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	s := cl.newSource(1)
+	part := func(p int32, nbytes int) FetchPartition {
+		return FetchPartition{Partition: p, Records: []*Record{{Topic: "t", Partition: p, Value: make([]byte, nbytes)}}}
+	}
+
+	// Below the threshold, nothing is paused.
+	light := Fetch{Topics: []FetchTopic{{Topic: "t", Partitions: []FetchPartition{part(0, 20), part(1, 30)}}}}
+	s.hook(&light, true, false)
+	if len(h.paused) != 0 || len(cl.BackpressuredPartitions()) != 0 {
+		t.Fatalf("got paused %v below the threshold, expected nothing", h.paused)
+	}
+
+	// Going over the threshold pauses the heaviest partitions until the
+	// unpaused remainder is at most half the threshold: 2 (60) is paused,
+	// which leaves 50 unpaused.
+	heavy := Fetch{Topics: []FetchTopic{{Topic: "t", Partitions: []FetchPartition{part(2, 60)}}}}
+	s.hook(&heavy, true, false)
+	if len(h.paused) != 1 || len(h.paused[0]["t"]) != 1 || h.paused[0]["t"][0] != 2 {
+		t.Fatalf("got paused %v, expected t[2]", h.paused)
+	}
+	if bp := cl.BackpressuredPartitions(); len(bp["t"]) != 1 {
+		t.Errorf("got backpressured %v, expected t[2]", bp)
+	}
+	cursor2 := &cursor{topic: "t", partition: 2, source: s, cursorOffset: cursorOffset{offset: 0, lastConsumedEpoch: -1}}
+	cursor2.allowUsable()
+	s.cursors = []*cursor{cursor2}
+	if req := s.createReq(); len(req.usedOffsets) != 0 {
+		t.Errorf("fetch request includes backpressured partition: %v", req.usedOffsets)
+	}
+
+	// Draining other partitions does not resume 2; draining 2 does.
+	s.hook(&light, false, true)
+	if len(h.resumed) != 0 {
+		t.Errorf("got resumed %v before draining t[2]", h.resumed)
+	}
+	s.hook(&heavy, false, true)
+	if len(h.resumed) != 1 || len(h.resumed[0]["t"]) != 1 || h.resumed[0]["t"][0] != 2 {
+		t.Errorf("got resumed %v, expected t[2]", h.resumed)
+	}
+	if bp := cl.BackpressuredPartitions(); len(bp) != 0 {
+		t.Errorf("got backpressured %v after draining, expected nothing", bp)
+	}
+	if req := s.createReq(); len(req.usedOffsets["t"]) != 1 {
+		t.Errorf("fetch request does not include resumed partition: %v", req.usedOffsets)
+	}
+}
+
+func TestPreferredReplicaFailover(t *testing.T) {
+	cl, err := NewClient(Rack("rack"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	leader, follower := cl.newSource(1), cl.newSource(2)
+	cl.sinksAndSourcesMu.Lock()
+	cl.sinksAndSources[1] = sinkAndSource{cl.newSink(1), leader}
+	cl.sinksAndSources[2] = sinkAndSource{cl.newSink(2), follower}
+	cl.sinksAndSourcesMu.Unlock()
+
+	newFollowerCursor := func() *cursor {
+		c := &cursor{
+			topic:              "t",
+			source:             follower,
+			topicPartitionData: topicPartitionData{leader: 1, leaderEpoch: -1},
+			cursorOffset:       cursorOffset{offset: 10, lastConsumedEpoch: -1},
+		}
+		follower.cursors = []*cursor{c}
+		leader.cursors = nil
+		c.allowUsable()
+		return c
+	}
+
+	// A partition error from the follower moves the cursor back to the
+	// leader.
+	c := newFollowerCursor()
+	req := follower.createReq()
+	resp := &kmsg.FetchResponse{Version: 11, Topics: []kmsg.FetchResponseTopic{{
+		Topic: "t",
+		Partitions: []kmsg.FetchResponseTopicPartition{{
+			Partition:            0,
+			ErrorCode:            kerr.ReplicaNotAvailable.Code,
+			PreferredReadReplica: -1,
+		}},
+	}}}
+	_, _, preferreds, _, _ := follower.handleReqResp(nil, req, resp)
+	if len(preferreds) != 1 || preferreds[0].preferredReplica != 1 {
+		t.Fatalf("got preferreds %v, expected a move to the leader", preferreds)
+	}
+	preferreds[0].move()
+	if c.source != leader || len(leader.cursors) != 1 || len(follower.cursors) != 0 {
+		t.Errorf("cursor is not on the leader after a follower error")
+	}
+	if !c.usable() {
+		t.Error("cursor is not usable after failing over")
+	}
+
+	// The same partition error from the leader itself does not move.
+	req = leader.createReq()
+	if _, _, preferreds, _, _ = leader.handleReqResp(nil, req, resp); len(preferreds) != 0 {
+		t.Errorf("got preferreds %v for a leader error, expected none", preferreds)
+	}
+
+	// Failing to fetch from the follower at all moves the cursor back to
+	// the leader and removes it from the request.
+	c = newFollowerCursor()
+	req = follower.createReq()
+	follower.failoverToLeaders(req)
+	if c.source != leader || len(follower.cursors) != 0 {
+		t.Errorf("cursor is not on the leader after a failed fetch")
+	}
+	if len(req.usedOffsets) != 0 {
+		t.Errorf("failed over cursor is still in the request: %v", req.usedOffsets)
+	}
+}
+
+func TestStandbyPartitionsFetchedNotReturned(t *testing.T) {
+	cl, err := NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	src := cl.newSource(1)
+	newCursor := func(partition int32, offset int64) *cursor {
+		c := &cursor{
+			topic:              "t",
+			partition:          partition,
+			source:             src,
+			topicPartitionData: topicPartitionData{leader: 1, leaderEpoch: -1},
+			cursorOffset:       cursorOffset{offset: offset, lastConsumedEpoch: -1},
+		}
+		c.useState = 1
+		return c
+	}
+	standby, assigned := newCursor(1, 20), newCursor(0, 10)
+	src.cursors = []*cursor{standby, assigned}
+	cl.consumer.storeStandby(map[string][]int32{"t": {1}})
+
+	req := src.createReq()
+	if req.numOffsets != 2 || req.numStandby != 1 || !req.usedOffsets["t"][1].standby || req.usedOffsets["t"][0].standby {
+		t.Fatalf("got %d offsets, %d standby, expected both partitions with only partition 1 standby", req.numOffsets, req.numStandby)
+	}
+
+	// The standby partition is requested with a small limit.
+	req.SetVersion(11)
+	kreq := kmsg.NewFetchRequest()
+	kreq.Version = 11
+	if err := kreq.ReadFrom(req.AppendTo(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if len(kreq.Topics) != 1 || len(kreq.Topics[0].Partitions) != 2 {
+		t.Fatalf("got request topics %+v, expected one topic with two partitions", kreq.Topics)
+	}
+	for _, p := range kreq.Topics[0].Partitions {
+		expOffset, expMax := int64(10), cl.cfg.maxPartBytes
+		if p.Partition == 1 {
+			expOffset, expMax = 20, standbyPartMaxBytes
+		}
+		if p.FetchOffset != expOffset || p.PartitionMaxBytes != expMax {
+			t.Errorf("partition %d: got offset %d max bytes %d, expected %d and %d", p.Partition, p.FetchOffset, p.PartitionMaxBytes, expOffset, expMax)
+		}
+	}
+
+	// The standby partition is never returned, even with an error, and
+	// its position does not advance.
+	resp := &kmsg.FetchResponse{Version: 11, Topics: []kmsg.FetchResponseTopic{{
+		Topic: "t",
+		Partitions: []kmsg.FetchResponseTopicPartition{
+			{Partition: 0, HighWatermark: 30, PreferredReadReplica: -1},
+			{Partition: 1, HighWatermark: 30, PreferredReadReplica: -1, ErrorCode: kerr.TopicAuthorizationFailed.Code},
+		},
+	}}}
+	fetch, _, _, _, _ := src.handleReqResp(nil, req, resp)
+	if len(fetch.Topics) != 1 || len(fetch.Topics[0].Partitions) != 1 || fetch.Topics[0].Partitions[0].Partition != 0 {
+		t.Errorf("got fetch %+v, expected only the assigned partition", fetch.Topics)
+	}
+	req.usedOffsets.finishUsingAllWithSet()
+	if standby.offset != 20 || !standby.usable() {
+		t.Errorf("got standby offset %d usable %v, expected 20 and usable", standby.offset, standby.usable())
+	}
+}
+
+func TestConsumePositionWatermarks(t *testing.T) {
+	cl, err := NewClient(SeedBrokers("127.0.0.1:1"), ConsumeTopics("t"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	c := &cl.consumer
+	c0 := &cursor{topic: "t", partition: 0, cursorOffset: cursorOffset{offset: 10, lastConsumedEpoch: -1}}
+	c1 := &cursor{topic: "t", partition: 1, cursorOffset: cursorOffset{offset: 20, lastConsumedEpoch: -1}}
+	c.mu.Lock()
+	c.usingCursors = usedCursors{c0: struct{}{}, c1: struct{}{}}
+	c.mu.Unlock()
+
+	// Before fetching, the watermarks are unknown.
+	if p := cl.GetConsumePositions()["t"][0]; p.HighWatermark != -1 || p.LastStableOffset != -1 || p.LogStartOffset != -1 {
+		t.Errorf("got watermarks %d/%d/%d before fetching, expected -1", p.HighWatermark, p.LastStableOffset, p.LogStartOffset)
+	}
+
+	// A fetch with no records still updates the watermarks, but a
+	// partition with an error does not.
+	used := usedOffsets{"t": {
+		0: {cursorOffset: c0.cursorOffset, from: c0},
+		1: {cursorOffset: c1.cursorOffset, from: c1},
+	}}
+	used.storeWatermarks(Fetch{Topics: []FetchTopic{{
+		Topic: "t",
+		Partitions: []FetchPartition{
+			{Partition: 0, HighWatermark: 10, LastStableOffset: 9, LogStartOffset: 2},
+			{Partition: 1, Err: kerr.UnknownServerError, HighWatermark: 30},
+		},
+	}}})
+	positions := cl.GetConsumePositions()
+	if p := positions["t"][0]; p.HighWatermark != 10 || p.LastStableOffset != 9 || p.LogStartOffset != 2 || p.Next.Offset != 10 {
+		t.Errorf("got position %+v, expected a caught up partition with watermarks 10/9/2", p)
+	}
+	if p := positions["t"][1]; p.HighWatermark != -1 {
+		t.Errorf("got high watermark %d for an errored partition, expected -1", p.HighWatermark)
+	}
+}
+
+func TestConsumePositionCommitted(t *testing.T) {
+	cl, err := NewClient(SeedBrokers("127.0.0.1:1"), ConsumerGroup("g"), ConsumeTopics("t"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	c := &cl.consumer
+	c.mu.Lock()
+	c.usingCursors = usedCursors{
+		&cursor{topic: "t", partition: 0, cursorOffset: cursorOffset{offset: 5, lastConsumedEpoch: -1}}: struct{}{},
+		&cursor{topic: "t", partition: 1, cursorOffset: cursorOffset{offset: 5, lastConsumedEpoch: -1}}: struct{}{},
+		&cursor{topic: "t", partition: 2, cursorOffset: cursorOffset{offset: 5, lastConsumedEpoch: -1}}: struct{}{},
+	}
+	c.mu.Unlock()
+
+	g := c.g
+	g.mu.Lock()
+	g.uncommitted = uncommitted{"t": {
+		0: uncommit{committed: EpochOffset{0, 0}},
+		1: uncommit{committed: EpochOffset{-1, -1}},
+		2: uncommit{committed: EpochOffset{3, 4}},
+	}}
+	g.mu.Unlock()
+
+	positions := cl.GetConsumePositions()["t"]
+	for partition, exp := range map[int32]EpochOffset{
+		0: {0, 0}, // committed at the start of the partition
+		1: {-1, -1},
+		2: {3, 4},
+	} {
+		if got := positions[partition].Committed; got != exp {
+			t.Errorf("partition %d: got committed %v, expected %v", partition, got, exp)
+		}
+	}
+}
+
+// BenchmarkPollHandoff measures handing a buffered fetch from a source to
+// polling. A source buffers its whole decoded fetch, and polling takes the
+// fetch by swapping it out of the source; nothing is sent per record, so the
+// cost per op should not grow with the number of records in the fetch beyond
+// accounting for what is buffered.
+func BenchmarkPollHandoff(b *testing.B) {
+	for _, nrecs := range []int{10, 1000} {
+		b.Run(fmt.Sprintf("records_%d", nrecs), func(b *testing.B) {
+			cl, err := NewClient()
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer cl.Close()
+
+			c := &cl.consumer
+			s := cl.newSource(1)
+			cursor := &cursor{topic: "t", source: s, cursorOffset: cursorOffset{lastConsumedEpoch: -1}}
+			records := make([]*Record, nrecs)
+			for i := range records {
+				records[i] = &Record{Topic: "t", Offset: int64(i), Value: make([]byte, 10)}
+			}
+			doneFetch := make(chan struct{}, 1)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.buffered = bufferedFetch{
+					fetch: Fetch{Topics: []FetchTopic{{
+						Topic:      "t",
+						Partitions: []FetchPartition{{Records: records}},
+					}}},
+					doneFetch: doneFetch,
+					usedOffsets: usedOffsets{"t": {
+						0: {cursorOffset: cursorOffset{offset: int64(nrecs), lastConsumedEpoch: -1}, from: cursor},
+					}},
+				}
+				s.sem = make(chan struct{})
+				s.hook(&s.buffered.fetch, true, false)
+				c.addSourceReadyForDraining(s)
+
+				var polled int
+				cl.PollFetches(nil).EachPartition(func(p FetchTopicPartition) { polled += len(p.Records) })
+				if polled != nrecs {
+					b.Fatalf("got %d polled records, expected %d", polled, nrecs)
+				}
+				<-doneFetch
+			}
+		})
+	}
+}
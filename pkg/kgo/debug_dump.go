@@ -0,0 +1,229 @@
+package kgo
+
+import (
+	"encoding/json"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// The types below are only for DebugDump. The JSON field names are not
+// stable, and these types are unexported so that the dump can change freely.
+
+type debugDump struct {
+	Time     time.Time      `json:"time"`
+	ClientID *string        `json:"client_id"`
+	Brokers  []debugBroker  `json:"brokers"`
+	Producer debugProducer  `json:"producer"`
+	Consumer *debugConsumer `json:"consumer,omitempty"`
+	Running  map[string]int `json:"running,omitempty"`
+}
+
+type debugBroker struct {
+	NodeID         int32               `json:"node_id"`
+	Seed           bool                `json:"seed,omitempty"`
+	Addr           string              `json:"addr"`
+	Rack           *string             `json:"rack,omitempty"`
+	Stopped        bool                `json:"stopped,omitempty"`
+	QueuedRequests int                 `json:"queued_requests"`
+	Connections    map[string]debugCxn `json:"connections,omitempty"`
+}
+
+type debugCxn struct {
+	Dead              bool       `json:"dead,omitempty"`
+	InflightResponses int        `json:"inflight_responses"`
+	LastWrite         *time.Time `json:"last_write,omitempty"`
+	LastRead          *time.Time `json:"last_read,omitempty"`
+	Successes         uint64     `json:"successes"`
+}
+
+type debugProducer struct {
+	BufferedRecords int64                                      `json:"buffered_records"`
+	BufferedBytes   int64                                      `json:"buffered_bytes"`
+	TransactionalID *string                                    `json:"transactional_id,omitempty"`
+	ProducerID      int64                                      `json:"producer_id"`
+	ProducerEpoch   int16                                      `json:"producer_epoch"`
+	ProducerIDErr   string                                     `json:"producer_id_err,omitempty"`
+	Partitions      map[string]map[int32]debugProducePartition `json:"partitions,omitempty"`
+}
+
+type debugProducePartition struct {
+	Leader          int32  `json:"leader"`
+	LeaderEpoch     int32  `json:"leader_epoch"`
+	BufferedRecords int64  `json:"buffered_records"`
+	Batches         int    `json:"batches"`
+	InflightBatches int    `json:"inflight_batches"`
+	InflightReqs    uint8  `json:"inflight_requests"`
+	LoadErr         string `json:"load_err,omitempty"`
+}
+
+type debugConsumer struct {
+	BufferedRecords int64                                `json:"buffered_records"`
+	BufferedBytes   int64                                `json:"buffered_bytes"`
+	BufferedFetches int64                                `json:"buffered_fetches"`
+	PausedTopics    []string                             `json:"paused_topics,omitempty"`
+	Paused          map[string][]int32                   `json:"paused_partitions,omitempty"`
+	Positions       map[string]map[int32]ConsumePosition `json:"positions,omitempty"`
+	Group           *GroupSnapshot                       `json:"group,omitempty"`
+}
+
+// DebugDump returns a JSON snapshot of the client's internal state, meant to
+// be attached to bug reports. The snapshot includes every known broker and
+// the state of its connections, what is buffered and inflight while
+// producing, and what is buffered, paused, assigned, and the consume
+// positions while consuming, including the group state if consuming as
+// part of a group.
+//
+// The state is gathered piece by piece rather than atomically, so the
+// snapshot may be slightly inconsistent while the client is active. The
+// format of the JSON is not stable and may change in any release; it is for
+// humans, not for programs.
+func (cl *Client) DebugDump() []byte {
+	d := debugDump{
+		Time:     time.Now(),
+		ClientID: cl.cfg.id,
+		Running:  cl.actors.snapshot(),
+	}
+
+	cl.brokersMu.RLock()
+	brokers := make([]*broker, 0, len(cl.seeds)+len(cl.brokers))
+	brokers = append(brokers, cl.seeds...)
+	brokers = append(brokers, cl.brokers...)
+	cl.brokersMu.RUnlock()
+	for _, b := range brokers {
+		d.Brokers = append(d.Brokers, b.debugDump())
+	}
+
+	d.Producer = cl.producer.debugDump(cl)
+
+	c := &cl.consumer
+	if c.consuming() {
+		paused := c.loadPaused()
+		dc := &debugConsumer{
+			BufferedRecords: atomic.LoadInt64(&c.bufferedRecords),
+			BufferedBytes:   atomic.LoadInt64(&c.bufferedBytes),
+			BufferedFetches: atomic.LoadInt64(&c.bufferedFetches),
+			PausedTopics:    paused.pausedTopics(),
+			Paused:          paused.pausedPartitions(),
+			Positions:       cl.GetConsumePositions(),
+		}
+		sort.Strings(dc.PausedTopics)
+		if len(dc.Paused) == 0 {
+			dc.Paused = nil
+		}
+		if g, ok := cl.GroupSnapshot(); ok {
+			dc.Group = &g
+		}
+		d.Consumer = dc
+	}
+
+	// Everything above is marshalable; an error here would be a bug.
+	out, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+func (b *broker) debugDump() debugBroker {
+	db := debugBroker{
+		NodeID:  b.meta.NodeID,
+		Seed:    b.meta.NodeID < -10,
+		Addr:    b.addr,
+		Rack:    b.meta.Rack,
+		Stopped: atomic.LoadInt32(&b.dead) == 1,
+	}
+
+	b.reqs.mu.Lock()
+	db.QueuedRequests = int(b.reqs.l)
+	b.reqs.mu.Unlock()
+
+	b.reapMu.Lock()
+	defer b.reapMu.Unlock()
+	for _, named := range []struct {
+		name string
+		cxn  *brokerCxn
+	}{
+		{"normal", b.cxnNormal},
+		{"produce", b.cxnProduce},
+		{"fetch", b.cxnFetch},
+		{"group", b.cxnGroup},
+		{"slow", b.cxnSlow},
+	} {
+		cxn := named.cxn
+		if cxn == nil {
+			continue
+		}
+		if db.Connections == nil {
+			db.Connections = make(map[string]debugCxn)
+		}
+		cxn.resps.mu.Lock()
+		inflight := int(cxn.resps.l)
+		cxn.resps.mu.Unlock()
+		db.Connections[named.name] = debugCxn{
+			Dead:              atomic.LoadInt32(&cxn.dead) == 1,
+			InflightResponses: inflight,
+			LastWrite:         debugNanos(atomic.LoadInt64(&cxn.lastWrite)),
+			LastRead:          debugNanos(atomic.LoadInt64(&cxn.lastRead)),
+			Successes:         atomic.LoadUint64(&cxn.successes),
+		}
+	}
+	return db
+}
+
+func debugNanos(nanos int64) *time.Time {
+	if nanos == 0 {
+		return nil
+	}
+	t := time.Unix(0, nanos)
+	return &t
+}
+
+func (p *producer) debugDump(cl *Client) debugProducer {
+	dp := debugProducer{
+		BufferedRecords: atomic.LoadInt64(&p.bufferedRecords),
+		BufferedBytes:   atomic.LoadInt64(&p.bufferedBytes),
+		TransactionalID: cl.cfg.txnID,
+		ProducerID:      -1,
+		ProducerEpoch:   -1,
+	}
+	if id, ok := p.id.Load().(*producerID); ok {
+		dp.ProducerID, dp.ProducerEpoch = id.id, id.epoch
+		if id.err != nil {
+			dp.ProducerIDErr = id.err.Error()
+		}
+	}
+
+	for topic, parts := range p.topics.load() {
+		for _, tp := range parts.load().partitions {
+			recBuf := tp.records
+			if recBuf == nil {
+				continue
+			}
+			dpp := debugProducePartition{
+				BufferedRecords: atomic.LoadInt64(&recBuf.buffered),
+			}
+			if tp.loadErr != nil {
+				dpp.LoadErr = tp.loadErr.Error()
+			}
+			recBuf.mu.Lock()
+			dpp.Leader = recBuf.leader
+			dpp.LeaderEpoch = recBuf.leaderEpoch
+			dpp.Batches = len(recBuf.batches)
+			dpp.InflightBatches = recBuf.batchDrainIdx
+			dpp.InflightReqs = recBuf.inflight
+			recBuf.mu.Unlock()
+
+			if dp.Partitions == nil {
+				dp.Partitions = make(map[string]map[int32]debugProducePartition)
+			}
+			ps := dp.Partitions[topic]
+			if ps == nil {
+				ps = make(map[int32]debugProducePartition)
+				dp.Partitions[topic] = ps
+			}
+			ps[recBuf.partition] = dpp
+		}
+	}
+	return dp
+}
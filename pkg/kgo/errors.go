@@ -7,8 +7,49 @@ import (
 	"io"
 	"net"
 	"os"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kmsg"
 )
 
+// isLeaderMovedErr returns whether a partition error indicates that the
+// partition's leader has moved or is moving, such as when a broker is shutting
+// down or a partition is being reassigned. For these errors, we want new
+// metadata as soon as possible rather than after the normal refresh backoff.
+func isLeaderMovedErr(err error) bool {
+	switch err {
+	case kerr.NotLeaderForPartition,
+		kerr.LeaderNotAvailable,
+		kerr.ReplicaNotAvailable,
+		kerr.KafkaStorageError,
+		kerr.FencedLeaderEpoch:
+		return true
+	}
+	return false
+}
+
+// confirmsLeaderMove returns whether an error from a partition's leader means
+// the broker is no longer the leader for the partition, which confirms a
+// leader change that metadata may not yet have confirmed with an epoch bump.
+func confirmsLeaderMove(err error) bool {
+	return err == kerr.NotLeaderForPartition || err == kerr.FencedLeaderEpoch
+}
+
+// isGroupFencedErr returns whether a group error indicates that this member
+// was fenced from the group; see OnGroupFenced.
+func isGroupFencedErr(err error) bool {
+	return errors.Is(err, kerr.IllegalGeneration) ||
+		errors.Is(err, kerr.UnknownMemberID) ||
+		errors.Is(err, kerr.FencedInstanceID)
+}
+
+// isBrokerGoneErr returns whether a request error indicates that the broker
+// we were talking to is no longer reachable or no longer exists, which
+// happens when a broker shuts down.
+func isBrokerGoneErr(err error) bool {
+	return err == errUnknownBroker || isDialErr(err)
+}
+
 func isRetriableBrokerErr(err error) bool {
 	// The error could be nil if we are evaluating multiple errors at once,
 	// and only one is non-nil. The intent of this function is to evaluate
@@ -114,6 +155,10 @@ var (
 
 	errProducerIDLoadFail = errors.New("unable to initialize a producer ID due to request failures")
 
+	// A temporary reason for retrying a metadata update, used when the
+	// update moves a partition leader without bumping the leader epoch.
+	errUnconfirmedLeaderChange = errors.New("partition leader changed without a leader epoch bump")
+
 	// A temporary error returned when Kafka replies with a different
 	// correlation ID than we were expecting for the request the client
 	// issued.
@@ -139,6 +184,10 @@ var (
 	// Returned when trying to produce a record outside of a transaction.
 	errNotInTransaction = errors.New("cannot produce record transactionally if not in a transaction")
 
+	// Used internally when listing an AfterMilli offset finds no record
+	// at or after the timestamp; the partition is relisted at the end.
+	errListedPastEnd = errors.New("no offset at or after the requested timestamp")
+
 	//////////////
 	// EXTERNAL //
 	//////////////
@@ -169,6 +218,16 @@ var (
 	//
 	// For any request, the request is failed with this error.
 	ErrClientClosed = errors.New("client closed")
+
+	// ErrRecordDeadline is passed to a RecordProcessor's failure policy
+	// when processing a record did not finish before the processor's
+	// deadline.
+	ErrRecordDeadline = errors.New("record processing deadline exceeded")
+
+	// ErrNoResetOffset is injected into a poll for a partition that needs
+	// an offset reset when ConsumeResetOffset is NoResetOffset. The
+	// partition is not consumed until it is repositioned.
+	ErrNoResetOffset = errors.New("partition needs an offset reset but resetting is disabled")
 )
 
 // ErrDataLoss is returned for Kafka >=2.1.0 when data loss is detected and the
@@ -192,6 +251,165 @@ func (e *ErrDataLoss) Error() string {
 		e.Topic, e.Partition, e.ConsumedTo, e.ResetTo)
 }
 
+// ErrCommitFenced is returned from committing when the group rejects the
+// commit because this member is no longer the member it was when the commit
+// was issued: the group rebalanced (IllegalGeneration), the member was
+// removed from the group (UnknownMemberID), or another client joined with the
+// same instance ID (FencedInstanceID).
+//
+// The offsets were not committed. The partitions that were being committed
+// may now be owned by another member, which will resume from the last
+// successfully committed offsets; records processed since then will be
+// processed again. This error wraps the underlying kerr error, so errors.Is
+// can be used to check which fencing error occurred.
+type ErrCommitFenced struct {
+	// Generation is the generation the commit was issued in.
+	Generation int32
+	// MemberID is the member ID the commit was issued with.
+	MemberID string
+	// InstanceID is the instance ID the commit was issued with, if any.
+	InstanceID *string
+	// Err is the fencing error from the group coordinator.
+	Err error
+}
+
+func (e *ErrCommitFenced) Error() string {
+	var guidance string
+	switch {
+	case errors.Is(e.Err, kerr.FencedInstanceID):
+		guidance = "another client is using this instance ID; each client in a group must use a unique instance ID"
+	case errors.Is(e.Err, kerr.UnknownMemberID):
+		guidance = "this member was removed from the group, likely from not heartbeating or polling within the session or rebalance timeout"
+	default:
+		guidance = "the group rebalanced before the commit was received; the commit must be reissued in the new generation, after partitions are reassigned"
+	}
+	instanceID := ""
+	if e.InstanceID != nil {
+		instanceID = " instance ID " + *e.InstanceID
+	}
+	return fmt.Sprintf("commit fenced for member %s%s in generation %d: %v; %s",
+		e.MemberID, instanceID, e.Generation, e.Err, guidance)
+}
+
+func (e *ErrCommitFenced) Unwrap() error { return e.Err }
+
+// commitRespErr returns the first error in an offset commit response, or err
+// if it is non-nil, wrapping fencing errors in ErrCommitFenced.
+func commitRespErr(req *kmsg.OffsetCommitRequest, resp *kmsg.OffsetCommitResponse, err error) error {
+	if err == nil {
+		for _, topic := range resp.Topics {
+			for _, partition := range topic.Partitions {
+				if err = kerr.ErrorForCode(partition.ErrorCode); err != nil {
+					break
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err != nil && req != nil && isGroupFencedErr(err) {
+		return &ErrCommitFenced{
+			Generation: req.Generation,
+			MemberID:   req.MemberID,
+			InstanceID: req.InstanceID,
+			Err:        err,
+		}
+	}
+	return err
+}
+
+// ErrProduceFailed is passed to produce promises when Kafka fails a record,
+// either in a produce response or when loading the record's topic or
+// partition (for example, TOPIC_AUTHORIZATION_FAILED, UNKNOWN_TOPIC_OR_PARTITION,
+// or INVALID_RECORD). This error wraps the underlying kerr error, so errors.Is
+// can be used to check which error occurred.
+//
+// Kafka fails records a batch at a time, and to preserve ordering, the client
+// fails every record buffered for the partition alongside a failed batch. For
+// Kafka 2.4+ (produce v8+), Kafka can indicate which records in a batch caused
+// the batch to fail; every other record in the batch is failed only because
+// it was in the same batch.
+type ErrProduceFailed struct {
+	// Topic is the topic of the record.
+	Topic string
+	// Partition is the partition of the record, or -1 if the record failed
+	// before its topic's partitions were known.
+	Partition int32
+	// Err is the error from Kafka.
+	Err error
+	// Message is the error message Kafka returned for this record, or for
+	// the record's batch if Kafka did not return a message for this record.
+	Message string
+	// Cause is true if Kafka indicated that this record caused its batch
+	// to fail. If Kafka indicated that other records in the batch were the
+	// cause, this record itself is likely fine and can be produced again.
+	Cause bool
+	// Records is the number of records that were failed alongside this
+	// record with this error, including this record.
+	Records int
+}
+
+func (e *ErrProduceFailed) Error() string {
+	var cause string
+	switch {
+	case e.Cause:
+		cause = " (this record caused the failure)"
+	case e.Records > 1:
+		cause = fmt.Sprintf(" (failed alongside %d other records)", e.Records-1)
+	}
+	msg := ""
+	if e.Message != "" {
+		msg = ": " + e.Message
+	}
+	return fmt.Sprintf("topic %s partition %d produce failed%s: %v%s", e.Topic, e.Partition, cause, e.Err, msg)
+}
+
+func (e *ErrProduceFailed) Unwrap() error { return e.Err }
+
+// produceFailedFn returns a function that returns the error to fail each
+// record in a partition with, given the index of the record's batch and of
+// the record within its batch. If err is not from Kafka, err is returned for
+// every record.
+//
+// errRecords and message are from the produce response, if any, and pertain
+// to the first batch.
+func produceFailedFn(
+	topic string,
+	partition int32,
+	err error,
+	message *string,
+	errRecords map[int32]string,
+	nrecords int,
+) func(batch, record int) error {
+	var ke *kerr.Error
+	if !errors.As(err, &ke) {
+		return func(int, int) error { return err }
+	}
+	var batchMsg string
+	if message != nil {
+		batchMsg = *message
+	}
+	return func(batch, record int) error {
+		pe := &ErrProduceFailed{
+			Topic:     topic,
+			Partition: partition,
+			Err:       err,
+			Message:   batchMsg,
+			Records:   nrecords,
+		}
+		if batch == 0 {
+			if recMsg, ok := errRecords[int32(record)]; ok {
+				pe.Cause = true
+				if recMsg != "" {
+					pe.Message = recMsg
+				}
+			}
+		}
+		return pe
+	}
+}
+
 type errUnknownController struct {
 	id int32
 }
@@ -0,0 +1,162 @@
+package kgo
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// corpusRecord and corpusExpected mirror the JSON files in
+// testdata/fetch_corpus; see capture.go in that directory for where the corpus
+// comes from and how to recapture it.
+type corpusRecord struct {
+	Offset      int64          `json:"offset"`
+	Key         *string        `json:"key"`
+	Value       *string        `json:"value"`
+	Headers     []corpusHeader `json:"headers,omitempty"`
+	TimestampMs int64          `json:"timestamp_ms"`
+	ProducerID  int64          `json:"producer_id"`
+}
+
+type corpusHeader struct {
+	Key   string  `json:"key"`
+	Value *string `json:"value"`
+}
+
+type corpusExpected struct {
+	Description string `json:"description"`
+	Aborted     []struct {
+		ProducerID  int64 `json:"producer_id"`
+		FirstOffset int64 `json:"first_offset"`
+	} `json:"aborted_transactions"`
+	Records []corpusRecord `json:"records"`
+}
+
+func corpusBytes(b []byte) *string {
+	if b == nil {
+		return nil
+	}
+	s := string(b)
+	return &s
+}
+
+// TestFetchCorpus decodes every record batch in the fetch corpus as if it
+// were a partition in a fetch response, ensuring we decode batches exactly as
+// librdkafka writes them and fetch responses exactly as a Kafka broker returns
+// them.
+func TestFetchCorpus(t *testing.T) {
+	t.Parallel()
+
+	files, err := filepath.Glob(filepath.Join("testdata", "fetch_corpus", "*.batch"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no fetch corpus files found")
+	}
+
+	d := newDecompressor()
+	for _, file := range files {
+		file := file
+		name := strings.TrimSuffix(filepath.Base(file), ".batch")
+		t.Run(name, func(t *testing.T) {
+			raw, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rawExp, err := ioutil.ReadFile(strings.TrimSuffix(file, ".batch") + ".json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var exp corpusExpected
+			if err := json.Unmarshal(rawExp, &exp); err != nil {
+				t.Fatalf("unable to decode expected records: %v", err)
+			}
+
+			rp := kmsg.NewFetchResponseTopicPartition()
+			rp.RecordBatches = raw
+			for _, a := range exp.Aborted {
+				aborted := kmsg.NewFetchResponseTopicPartitionAbortedTransaction()
+				aborted.ProducerID = a.ProducerID
+				aborted.FirstOffset = a.FirstOffset
+				rp.AbortedTransactions = append(rp.AbortedTransactions, aborted)
+			}
+
+			o := &cursorOffsetNext{
+				from: &cursor{topic: "corpus"},
+			}
+			fp := o.processRespPartition(nil, 12, &rp, d, hooks{})
+			if fp.Err != nil {
+				t.Fatalf("%s: unexpected decode err: %v", exp.Description, fp.Err)
+			}
+
+			got := make([]corpusRecord, 0, len(fp.Records))
+			for _, r := range fp.Records {
+				cr := corpusRecord{
+					Offset:      r.Offset,
+					Key:         corpusBytes(r.Key),
+					Value:       corpusBytes(r.Value),
+					TimestampMs: r.Timestamp.UnixNano() / 1e6,
+					ProducerID:  r.ProducerID,
+				}
+				for _, h := range r.Headers {
+					cr.Headers = append(cr.Headers, corpusHeader{h.Key, corpusBytes(h.Value)})
+				}
+				got = append(got, cr)
+			}
+			if diff := cmp.Diff(exp.Records, got); diff != "" {
+				t.Errorf("%s: %s", exp.Description, diff)
+			}
+		})
+	}
+}
+
+func TestReadCommittedAbortedBatches(t *testing.T) {
+	// Aborts for a producer are applied in offset order even if they are
+	// not returned in order.
+	rp := kmsg.NewFetchResponseTopicPartition()
+	for _, first := range []int64{20, 10} {
+		aborted := kmsg.NewFetchResponseTopicPartitionAbortedTransaction()
+		aborted.ProducerID = 1
+		aborted.FirstOffset = first
+		rp.AbortedTransactions = append(rp.AbortedTransactions, aborted)
+	}
+	a := buildAborter(&rp)
+	if got := a[1]; len(got) != 2 || got[0] != 10 || got[1] != 20 {
+		t.Fatalf("got aborts %v, expected [10 20]", got)
+	}
+
+	// An aborted data batch is skipped without being decoded: the records
+	// here are garbage and claim gzip compression, yet we still advance
+	// past the batch.
+	o := &cursorOffsetNext{from: &cursor{topic: "t"}, cursorOffset: cursorOffset{offset: 10, lastConsumedEpoch: -1}}
+	var fp FetchPartition
+	batch := &kmsg.RecordBatch{
+		FirstOffset:          10,
+		LastOffsetDelta:      4,
+		PartitionLeaderEpoch: 3,
+		Magic:                2,
+		Attributes:           0b0001_0001, // transactional, gzip
+		ProducerID:           1,
+		NumRecords:           5,
+		Records:              []byte("not gzip"),
+	}
+	if n, _ := o.processRecordBatch(&fp, batch, a, newDecompressor()); n != 5 {
+		t.Errorf("got %d records processed, expected 5", n)
+	}
+	if len(fp.Records) != 0 || o.offset != 15 || o.lastConsumedEpoch != 3 {
+		t.Errorf("got %d records, offset %d, epoch %d; expected 0 records, offset 15, epoch 3", len(fp.Records), o.offset, o.lastConsumedEpoch)
+	}
+
+	// The same batch, not aborted, cannot be decoded and does not advance.
+	o.offset = 10
+	if n, _ := o.processRecordBatch(&fp, batch, nil, newDecompressor()); n != 0 || o.offset != 10 {
+		t.Errorf("got %d records processed and offset %d for an undecodable committed batch, expected 0 and 10", n, o.offset)
+	}
+}
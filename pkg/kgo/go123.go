@@ -0,0 +1,61 @@
+//go:build go1.23
+// +build go1.23
+
+package kgo
+
+import (
+	"context"
+	"iter"
+)
+
+// Records returns an iterator over polled records, as an alternative to
+// calling PollFetches in a loop:
+//
+//	for r, err := range cl.Records(ctx) {
+//		if err != nil {
+//			// handle the error; errors do not stop iteration
+//			continue
+//		}
+//		// process r
+//	}
+//
+// The iterator polls as needed and yields every record in order. Fetch errors
+// are yielded with a nil record as a FetchError, which unwraps to the
+// underlying error; iteration continues after an error unless the loop
+// breaks. Iteration stops once the context is canceled or the client is
+// closed; neither the context error nor ErrClientClosed is yielded. Records
+// that were polled when the context was canceled are yielded before
+// iteration stops.
+//
+// As with PollFetches, records are considered polled once they are fetched
+// for the iterator, which can be before the loop reaches them, so
+// autocommitting can commit records that the loop breaks before processing.
+// If that is a concern, use AutoCommitMarks and mark records as they are
+// processed, or commit manually.
+//
+// This must not be used concurrently with other polling.
+func (cl *Client) Records(ctx context.Context) iter.Seq2[*Record, error] {
+	return func(yield func(*Record, error) bool) {
+		for ctx.Err() == nil {
+			fetches := cl.PollFetches(ctx)
+			var closed bool
+			for _, fe := range fetches.Errors() {
+				if fe.Err == ErrClientClosed {
+					closed = true
+					continue
+				}
+				if !yield(nil, fe) {
+					return
+				}
+			}
+			for it := fetches.RecordIter(); !it.Done(); {
+				if !yield(it.Next(), nil) {
+					return
+				}
+			}
+			if closed {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+//go:build go1.23
+// +build go1.23
+
+package kgo
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRecordsIter(t *testing.T) {
+	cl, err := NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &cl.consumer
+
+	s := cl.newSource(1)
+	cursor0 := &cursor{topic: "t", partition: 0, source: s, cursorOffset: cursorOffset{offset: 10, lastConsumedEpoch: -1}}
+	s.buffered = bufferedFetch{
+		fetch: Fetch{Topics: []FetchTopic{{
+			Topic:      "t",
+			Partitions: []FetchPartition{{Partition: 0, Records: []*Record{{Topic: "t", Offset: 10}, {Topic: "t", Offset: 11}}}},
+		}}},
+		doneFetch: make(chan struct{}, 1),
+		usedOffsets: usedOffsets{"t": {
+			0: {cursorOffset: cursorOffset{offset: 12, lastConsumedEpoch: -1}, from: cursor0},
+		}},
+	}
+	s.sem = make(chan struct{})
+	s.hook(&s.buffered.fetch, true, false)
+	c.addSourceReadyForDraining(s)
+
+	errFake := errors.New("fake")
+	c.addFakeReadyForDraining("t", 1, errFake)
+
+	// Records and errors are yielded, and closing the client ends
+	// iteration without yielding ErrClientClosed.
+	var offsets []int64
+	var errs []error
+	for r, err := range cl.Records(context.Background()) {
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			offsets = append(offsets, r.Offset)
+		}
+		if len(offsets) == 2 && len(errs) == 1 {
+			go cl.Close()
+		}
+	}
+	if len(offsets) != 2 || offsets[0] != 10 || offsets[1] != 11 {
+		t.Errorf("got offsets %v, expected [10 11]", offsets)
+	}
+	var fe FetchError
+	if len(errs) != 1 || !errors.Is(errs[0], errFake) || !errors.As(errs[0], &fe) || fe.Partition != 1 {
+		t.Errorf("got errors %v, expected one fake error for partition 1", errs)
+	}
+
+	// Canceling the context ends iteration.
+	cl2, err := NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl2.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	for r, err := range cl2.Records(ctx) {
+		t.Errorf("got record %v and error %v after canceling, expected none", r, err)
+	}
+}
+
+// errAfterCtx reports being canceled from its second Err call onward, which
+// simulates the context being canceled while polling.
+type errAfterCtx struct {
+	context.Context
+	calls int32
+}
+
+func (ctx *errAfterCtx) Err() error {
+	if atomic.AddInt32(&ctx.calls, 1) > 1 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestRecordsIterCanceledWhilePolling(t *testing.T) {
+	cl, err := NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	s := cl.newSource(1)
+	cursor0 := &cursor{topic: "t", partition: 0, source: s, cursorOffset: cursorOffset{offset: 10, lastConsumedEpoch: -1}}
+	s.buffered = bufferedFetch{
+		fetch: Fetch{Topics: []FetchTopic{{
+			Topic:      "t",
+			Partitions: []FetchPartition{{Partition: 0, Records: []*Record{{Topic: "t", Offset: 10}, {Topic: "t", Offset: 11}}}},
+		}}},
+		doneFetch: make(chan struct{}, 1),
+		usedOffsets: usedOffsets{"t": {
+			0: {cursorOffset: cursorOffset{offset: 12, lastConsumedEpoch: -1}, from: cursor0},
+		}},
+	}
+	s.sem = make(chan struct{})
+	s.hook(&s.buffered.fetch, true, false)
+	cl.consumer.addSourceReadyForDraining(s)
+
+	// Records that were polled are yielded even though the context is
+	// canceled by the time the poll returns, and then iteration stops.
+	var offsets []int64
+	for r, err := range cl.Records(&errAfterCtx{Context: context.Background()}) {
+		if err != nil {
+			t.Errorf("got unexpected error %v", err)
+			continue
+		}
+		offsets = append(offsets, r.Offset)
+	}
+	if len(offsets) != 2 || offsets[0] != 10 || offsets[1] != 11 {
+		t.Errorf("got offsets %v, expected [10 11]", offsets)
+	}
+}
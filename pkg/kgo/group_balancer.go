@@ -2,10 +2,12 @@ package kgo
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"sort"
 	"strings"
 
+	"github.com/twmb/franz-go/pkg/kbin"
 	"github.com/twmb/franz-go/pkg/kerr"
 	"github.com/twmb/franz-go/pkg/kgo/internal/sticky"
 	"github.com/twmb/franz-go/pkg/kmsg"
@@ -63,6 +65,21 @@ type GroupBalancer interface {
 	IsCooperative() bool
 }
 
+// GroupStandbyBalancer is an optional interface that a GroupBalancer can
+// implement to publish standby partitions alongside a member's assignment.
+// Standby partitions are partitions that are consumed by another member and
+// that this member is expected to take over if that other member fails. How
+// standby partitions are encoded in the member assignment is up to the
+// balancer.
+//
+// Standby partitions are only used with the PrefetchStandbyPartitions option.
+type GroupStandbyBalancer interface {
+	// ParseSyncStandby returns standby topics and partitions from an
+	// encoded SyncGroupResponse's MemberAssignment. Any standby
+	// partitions that are also assigned to this member are ignored.
+	ParseSyncStandby(assignment []byte) (map[string][]int32, error)
+}
+
 // GroupMemberBalancer balances topics amongst group members.
 type GroupMemberBalancer interface {
 	// Balance balances topics and partitions among group members, where
@@ -95,7 +112,7 @@ func (b *ConsumerBalancer) Balance(topics map[string]int32) IntoSyncAssignment {
 	return b.b.Balance(b, topics)
 }
 
-// Member returns the list of input members for this group balancer.
+// Members returns the list of input members for this group balancer.
 func (b *ConsumerBalancer) Members() []kmsg.JoinGroupResponseMember {
 	return b.members
 }
@@ -343,6 +360,17 @@ func (g *groupConsumer) balanceGroup(proto string, members []kmsg.JoinGroupRespo
 		}
 	}
 
+	var unknown []string
+	for topic := range topics {
+		if _, exists := topicPartitionCount[topic]; !exists {
+			unknown = append(unknown, topic)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		g.cl.cfg.logger.Log(LogLevelWarn, "group members are interested in topics that we could not load partitions for; these topics will be entirely unconsumed by the group until the next rebalance", "topics", unknown)
+	}
+
 	// If the returned balancer is a ConsumerBalancer (which it likely
 	// always will be), then we can print some useful debugging information
 	// about what member interests are.
@@ -372,6 +400,28 @@ func (g *groupConsumer) balanceGroup(proto string, members []kmsg.JoinGroupRespo
 	// is if the balancer is a ConsumerBalancer, then we can again print
 	// more useful debugging information.
 	into := memberBalancer.Balance(topicPartitionCount)
+	if fn := g.cl.cfg.externalPlan; fn != nil {
+		p, pok := into.(*BalancePlan)
+		cb, cbok := memberBalancer.(*ConsumerBalancer)
+		if pok && cbok {
+			if err := g.externalPlan(fn, p, cb, topicPartitionCount, b.IsCooperative()); err != nil {
+				g.cl.cfg.logger.Log(LogLevelError, "external balance plan failed, using the balancer's plan", "err", err)
+			}
+		} else {
+			g.cl.cfg.logger.Log(LogLevelWarn, "unable to use an external balance plan: the balancer is not a *ConsumerBalancer or did not return a *BalancePlan")
+		}
+	}
+	if fn := g.cl.cfg.adjustPlan; fn != nil {
+		p, pok := into.(*BalancePlan)
+		cb, cbok := memberBalancer.(*ConsumerBalancer)
+		if pok && cbok {
+			if err := p.adjust(cb, topicPartitionCount, b.IsCooperative(), fn); err != nil {
+				g.cl.cfg.logger.Log(LogLevelError, "adjusted balance plan is invalid, using the original plan", "err", err)
+			}
+		} else {
+			g.cl.cfg.logger.Log(LogLevelWarn, "unable to adjust balance plan: the balancer is not a *ConsumerBalancer or did not return a *BalancePlan")
+		}
+	}
 	if p, ok := into.(*BalancePlan); ok {
 		g.cl.cfg.logger.Log(LogLevelInfo, "balanced", "plan", p.String())
 	} else {
@@ -381,6 +431,38 @@ func (g *groupConsumer) balanceGroup(proto string, members []kmsg.JoinGroupRespo
 	return into.IntoSyncAssignment(), nil
 }
 
+// externalPlan replaces p with the plan returned from the user's external
+// planner, validating the external plan the same as an adjusted plan.
+func (g *groupConsumer) externalPlan(
+	fn func(context.Context, *ConsumerBalancer, map[string]int32) (map[string]map[string][]int32, error),
+	p *BalancePlan,
+	b *ConsumerBalancer,
+	topics map[string]int32,
+	cooperative bool,
+) error {
+	// The leader must sync the plan within the rebalance timeout, or
+	// the group coordinator kicks every member from the group.
+	ctx, cancel := context.WithTimeout(g.ctx, g.cfg.rebalanceTimeout)
+	defer cancel()
+
+	dup := make(map[string]int32, len(topics))
+	for topic, partitions := range topics {
+		dup[topic] = partitions
+	}
+	external, err := fn(ctx, b, dup)
+	if err != nil {
+		return err
+	}
+	return p.adjust(b, topics, cooperative, func(_ *ConsumerBalancer, plan map[string]map[string][]int32) {
+		for member := range plan {
+			delete(plan, member)
+		}
+		for member, memberTopics := range external {
+			plan[member] = memberTopics
+		}
+	})
+}
+
 // helper func; range and roundrobin use v0
 func memberMetadataV0(interests []string) []byte {
 	meta := kmsg.NewConsumerMemberMetadata()
@@ -614,12 +696,113 @@ func (*rangeBalancer) Balance(b *ConsumerBalancer, topics map[string]int32) Into
 // assigned partitions). This Go sticky balancer is optimal and extra sticky.
 // Thus, the Java balancer will never back out of a strategy from this
 // balancer.
-func StickyBalancer() GroupBalancer {
-	return &stickyBalancer{cooperative: false}
+func StickyBalancer(opts ...StickyBalancerOpt) GroupBalancer {
+	s := &stickyBalancer{cooperative: false}
+	for _, opt := range opts {
+		opt.apply(s)
+	}
+	return s
 }
 
 type stickyBalancer struct {
-	cooperative bool
+	cooperative  bool
+	onStep       func(StickyBalanceStep)
+	onUnassigned func(map[string][]int32)
+	onConflicts  func([]StickyBalanceConflict)
+}
+
+// StickyBalancerOpt is an option to configure a sticky or cooperative-sticky
+// balancer.
+type StickyBalancerOpt interface {
+	apply(*stickyBalancer)
+}
+
+type stickyBalancerOpt struct{ fn func(*stickyBalancer) }
+
+func (o stickyBalancerOpt) apply(s *stickyBalancer) { o.fn(s) }
+
+// StickyBalanceStep is a single partition move decided by the sticky balancer.
+type StickyBalanceStep struct {
+	// Kind is why the partition moved:
+	//
+	//   - "assign": the partition was unowned and was assigned to the least
+	//     loaded member that can consume it
+	//   - "drop": the owner no longer wants the partition's topic
+	//   - "resticky": the partition moved back to its owner from an older
+	//     generation because the newer owner is overloaded
+	//   - "balance": the partition moved from a most loaded member to a
+	//     least loaded member
+	//   - "steal": the partition moved as one hop along a steal path, which
+	//     is used when members consume different topics
+	//
+	Kind string
+
+	// Topic and Partition are the partition that moved.
+	Topic     string
+	Partition int32
+
+	// From is the member the partition moved from, or empty if the
+	// partition was not owned.
+	From string
+
+	// To is the member the partition moved to, or empty if the partition
+	// was dropped.
+	To string
+}
+
+// StickyBalanceTrace sets a function to call for every partition move the
+// sticky balancer decides on while leading a group balance, in the order the
+// decisions are made. This can be used to capture a machine readable trace of
+// why a balance plan looks the way it does.
+//
+// A partition can move multiple times in one balance. Steps describe moves
+// from what members reported owning when joining; for cooperative balancing,
+// the final plan is adjusted after all steps are traced.
+func StickyBalanceTrace(fn func(StickyBalanceStep)) StickyBalancerOpt {
+	return stickyBalancerOpt{func(s *stickyBalancer) { s.onStep = fn }}
+}
+
+// StickyBalanceUnassigned sets a function to call when balancing if any input
+// topic partitions could not be assigned because no member is interested in
+// the partition's topic. The input map is topics to sorted partitions.
+//
+// When this client leads a group balance, it only balances topics that at
+// least one member is interested in, and it warns in its logs for any
+// interested topic it could not load partitions for. This option is useful
+// when balancing a fixed set of topics with a ConsumerBalancer directly,
+// allowing the caller to warn that partitions are completely unconsumed by
+// the group.
+func StickyBalanceUnassigned(fn func(map[string][]int32)) StickyBalancerOpt {
+	return stickyBalancerOpt{func(s *stickyBalancer) { s.onUnassigned = fn }}
+}
+
+// StickyBalanceConflict is a partition that multiple members claimed to own in
+// the same generation when joining the group.
+type StickyBalanceConflict struct {
+	Topic     string
+	Partition int32
+
+	// Generation is the generation the members claimed to own the
+	// partition in. Members that have not been in a generation (the
+	// original sticky user data format) are reported as generation 0.
+	Generation int32
+
+	// Members are the claiming members. The first member is treated as the
+	// prior owner, and the rest are treated as stale.
+	Members []string
+}
+
+// StickyBalanceConflicts sets a function to call when leading a group balance
+// if multiple members claimed to own the same partition in the same
+// generation. The conflicts are sorted by topic and partition.
+//
+// Two members should never own the same partition in the same generation.
+// This usually indicates a zombie member: a member that was kicked from the
+// group but continued consuming and then rejoined with its old assignment.
+// The balancer handles this case (see KIP-341), but this function allows the
+// application to log or alert on it.
+func StickyBalanceConflicts(fn func([]StickyBalanceConflict)) StickyBalancerOpt {
+	return stickyBalancerOpt{func(s *stickyBalancer) { s.onConflicts = fn }}
 }
 
 func (s *stickyBalancer) ProtocolName() string {
@@ -683,7 +866,37 @@ func (s *stickyBalancer) Balance(b *ConsumerBalancer, topics map[string]int32) I
 		})
 	})
 
-	p := &BalancePlan{sticky.Balance(stickyMembers, topics)}
+	var opts []sticky.Opt
+	if s.onStep != nil {
+		opts = append(opts, sticky.OnStep(func(step sticky.Step) {
+			s.onStep(StickyBalanceStep{
+				Kind:      step.Kind.String(),
+				Topic:     step.Topic,
+				Partition: step.Partition,
+				From:      step.From,
+				To:        step.To,
+			})
+		}))
+	}
+	if s.onUnassigned != nil {
+		opts = append(opts, sticky.OnUnassigned(s.onUnassigned))
+	}
+	if s.onConflicts != nil {
+		opts = append(opts, sticky.OnConflicts(func(conflicts []sticky.Conflict) {
+			converted := make([]StickyBalanceConflict, 0, len(conflicts))
+			for _, c := range conflicts {
+				converted = append(converted, StickyBalanceConflict{
+					Topic:      c.Topic,
+					Partition:  c.Partition,
+					Generation: c.Generation,
+					Members:    c.Members,
+				})
+			}
+			s.onConflicts(converted)
+		}))
+	}
+
+	p := &BalancePlan{sticky.Balance(stickyMembers, topics, opts...)}
 	if s.cooperative {
 		p.AdjustCooperative(b)
 	}
@@ -718,8 +931,12 @@ func (s *stickyBalancer) Balance(b *ConsumerBalancer, topics map[string]int32) I
 // continue to be eager and give up all of their partitions every rebalance.
 // However, once a member only has cooperative-sticky, it can begin using this
 // new strategy and things will work correctly. See KIP-429 for more details.
-func CooperativeStickyBalancer() GroupBalancer {
-	return &stickyBalancer{cooperative: true}
+func CooperativeStickyBalancer(opts ...StickyBalancerOpt) GroupBalancer {
+	s := &stickyBalancer{cooperative: true}
+	for _, opt := range opts {
+		opt.apply(s)
+	}
+	return s
 }
 
 // AdjustCooperative performs the final adjustment to a plan for cooperative
@@ -849,3 +1066,384 @@ func (p *BalancePlan) AdjustCooperative(b *ConsumerBalancer) {
 		}
 	}
 }
+
+// adjust calls fn with a copy of the plan and, if the adjusted copy is a legal
+// assignment, replaces the plan with the copy.
+func (p *BalancePlan) adjust(
+	b *ConsumerBalancer,
+	topics map[string]int32,
+	cooperative bool,
+	fn func(*ConsumerBalancer, map[string]map[string][]int32),
+) error {
+	adjusted := make(map[string]map[string][]int32, len(p.plan))
+	for member, memberTopics := range p.plan {
+		adjustedTopics := make(map[string][]int32, len(memberTopics))
+		for topic, partitions := range memberTopics {
+			adjustedTopics[topic] = append([]int32(nil), partitions...)
+		}
+		adjusted[member] = adjustedTopics
+	}
+
+	fn(b, adjusted)
+
+	interests := make(map[string]map[string]bool, len(b.members))
+	b.EachMember(func(member *kmsg.JoinGroupResponseMember, meta *kmsg.ConsumerMemberMetadata) {
+		memberInterests := make(map[string]bool, len(meta.Topics))
+		for _, topic := range meta.Topics {
+			memberInterests[topic] = true
+		}
+		interests[member.MemberID] = memberInterests
+	})
+
+	assigned := make(map[string]map[int32]string)
+	for member, memberTopics := range adjusted {
+		memberInterests, exists := interests[member]
+		if !exists {
+			return fmt.Errorf("member %q is not in the group", member)
+		}
+		for topic, partitions := range memberTopics {
+			if !memberInterests[topic] {
+				return fmt.Errorf("member %q is not interested in topic %q", member, topic)
+			}
+			assignedT := assigned[topic]
+			if assignedT == nil {
+				assignedT = make(map[int32]string)
+				assigned[topic] = assignedT
+			}
+			for _, partition := range partitions {
+				if partition < 0 || partition >= topics[topic] {
+					return fmt.Errorf("member %q is assigned partition %d of topic %q, which does not exist", member, partition, topic)
+				}
+				if other, exists := assignedT[partition]; exists {
+					return fmt.Errorf("partition %d of topic %q is assigned to both %q and %q", partition, topic, other, member)
+				}
+				assignedT[partition] = member
+			}
+		}
+	}
+	for _, memberTopics := range p.plan {
+		for topic, partitions := range memberTopics {
+			for _, partition := range partitions {
+				if _, exists := assigned[topic][partition]; !exists {
+					return fmt.Errorf("partition %d of topic %q is no longer assigned", partition, topic)
+				}
+			}
+		}
+	}
+
+	for i := range b.members {
+		if _, exists := adjusted[b.members[i].MemberID]; !exists {
+			adjusted[b.members[i].MemberID] = make(map[string][]int32)
+		}
+	}
+	p.plan = adjusted
+	if cooperative {
+		p.AdjustCooperative(b)
+	}
+	return nil
+}
+
+// BalancePlanPhases is a balance plan split into an ordered set of
+// revocations and assignments, as returned from BalancePlan.Phases.
+type BalancePlanPhases struct {
+	// Revocations contains, per member, the topics and partitions that
+	// the member previously owned and no longer owns in the plan.
+	Revocations map[string]map[string][]int32 // member => topic => partitions
+
+	// Assignments contains, per member, the topics and partitions that
+	// are newly planned for the member and were not previously owned by
+	// the member.
+	Assignments map[string]map[string][]int32 // member => topic => partitions
+}
+
+// Phases returns the plan as two ordered phases: all revocations, and then
+// all assignments. Applying every revocation before any assignment ensures
+// that no two members briefly own the same partition, even with eager
+// balancing and slow members.
+//
+// Prior ownership is determined from each member's OwnedPartitions, or, if a
+// member has no owned partitions, from sticky user data if the member's
+// UserData can be decoded as kmsg.StickyMemberMetadata. Members that have no
+// prior ownership information are assumed to own nothing.
+//
+// All partitions in the returned maps are sorted.
+func (p *BalancePlan) Phases(b *ConsumerBalancer) BalancePlanPhases {
+	phases := BalancePlanPhases{
+		Revocations: make(map[string]map[string][]int32),
+		Assignments: make(map[string]map[string][]int32),
+	}
+
+	diff := func(l, r []int32) []int32 { // l - r
+		rmap := make(map[int32]struct{}, len(r))
+		for _, partition := range r {
+			rmap[partition] = struct{}{}
+		}
+		var d []int32
+		for _, partition := range l {
+			if _, exists := rmap[partition]; !exists {
+				d = append(d, partition)
+			}
+		}
+		sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+		return d
+	}
+	add := func(into map[string]map[string][]int32, member, topic string, partitions []int32) {
+		if len(partitions) == 0 {
+			return
+		}
+		topics := into[member]
+		if topics == nil {
+			topics = make(map[string][]int32)
+			into[member] = topics
+		}
+		topics[topic] = partitions
+	}
+
+	b.EachMember(func(member *kmsg.JoinGroupResponseMember, meta *kmsg.ConsumerMemberMetadata) {
+		owned := memberOwned(meta)
+		planned := p.plan[member.MemberID]
+
+		for topic, opartitions := range owned {
+			add(phases.Revocations, member.MemberID, topic, diff(opartitions, planned[topic]))
+		}
+		for topic, ppartitions := range planned {
+			add(phases.Assignments, member.MemberID, topic, diff(ppartitions, owned[topic]))
+		}
+	})
+
+	return phases
+}
+
+// memberOwned returns what a member previously owned, per its join group
+// metadata.
+func memberOwned(meta *kmsg.ConsumerMemberMetadata) map[string][]int32 {
+	owned := make(map[string][]int32)
+	if len(meta.OwnedPartitions) > 0 {
+		for _, t := range meta.OwnedPartitions {
+			owned[t.Topic] = append(owned[t.Topic], t.Partitions...)
+		}
+		return owned
+	}
+	if len(meta.UserData) == 0 {
+		return owned
+	}
+	var stickyMeta kmsg.StickyMemberMetadata
+	if err := stickyMeta.ReadFrom(meta.UserData); err != nil {
+		return owned
+	}
+	for _, t := range stickyMeta.CurrentAssignment {
+		owned[t.Topic] = append(owned[t.Topic], t.Partitions...)
+	}
+	return owned
+}
+
+// CapacityBalancer returns a group balancer that assigns partitions to members
+// proportionally to each member's capacity, rather than evenly. The input
+// capacity is this member's relative capacity (for example, the number of
+// CPUs the member has), and is sent to the leader in the member's join
+// metadata. A capacity less than one is treated as one.
+//
+// Suppose there are three members M0, M1, and M2 with capacities 1, 1, and 2,
+// and one topic t0 with eight partitions. M0 and M1 will each be assigned two
+// partitions, and M2 will be assigned four.
+//
+// This balancer is sticky: members keep their previously owned partitions, up
+// to the ceiling of their proportional share. Any remaining partitions are
+// assigned one at a time, in topic and partition order, to the interested
+// member with the lowest load relative to its capacity. If topic
+// subscriptions are unequal, members that are the only ones interested in a
+// topic may be assigned more than their share.
+//
+// This balancer uses the "capacity" protocol, which no other Kafka client
+// implements; all members of a group using this balancer must be Go clients.
+// This balancer is eager, not cooperative.
+func CapacityBalancer(capacity int32) GroupBalancer {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &capacityBalancer{capacity}
+}
+
+type capacityBalancer struct {
+	capacity int32
+}
+
+func (*capacityBalancer) ProtocolName() string { return "capacity" }
+func (*capacityBalancer) IsCooperative() bool  { return false }
+func (c *capacityBalancer) JoinGroupMetadata(interests []string, currentAssignment map[string][]int32, _ int32) []byte {
+	meta := kmsg.NewConsumerMemberMetadata()
+	meta.Version = 1
+	meta.Topics = interests // input interests are already sorted
+	for topic, partitions := range currentAssignment {
+		metaPart := kmsg.NewConsumerMemberMetadataOwnedPartition()
+		metaPart.Topic = topic
+		metaPart.Partitions = partitions
+		meta.OwnedPartitions = append(meta.OwnedPartitions, metaPart)
+	}
+	owned := meta.OwnedPartitions
+	sort.Slice(owned, func(i, j int) bool { return owned[i].Topic < owned[j].Topic })
+
+	meta.UserData = kbin.AppendInt16(nil, 0) // version
+	meta.UserData = kbin.AppendInt32(meta.UserData, c.capacity)
+	return meta.AppendTo(nil)
+}
+
+func (*capacityBalancer) ParseSyncAssignment(assignment []byte) (map[string][]int32, error) {
+	return ParseConsumerSyncAssignment(assignment)
+}
+
+func (c *capacityBalancer) MemberBalancer(members []kmsg.JoinGroupResponseMember) (GroupMemberBalancer, map[string]struct{}, error) {
+	b, err := NewConsumerBalancer(c, members)
+	return b, b.MemberTopics(), err
+}
+
+// memberCapacity returns the capacity a member encoded in its user data,
+// defaulting to one if the user data is missing or invalid.
+func memberCapacity(meta *kmsg.ConsumerMemberMetadata) int64 {
+	r := kbin.Reader{Src: meta.UserData}
+	version := r.Int16()
+	capacity := r.Int32()
+	if !r.Ok() || version < 0 || capacity < 1 {
+		return 1
+	}
+	return int64(capacity)
+}
+
+func (*capacityBalancer) Balance(b *ConsumerBalancer, topics map[string]int32) IntoSyncAssignment {
+	type topicPartition struct {
+		topic     string
+		partition int32
+	}
+
+	var (
+		nmembers   = len(b.Members())
+		capacities = make([]int64, nmembers)
+		loads      = make([]int64, nmembers)
+		interests  = make(map[string][]int, len(b.MemberTopics())) // topic => member indices
+		owners     = make(map[topicPartition]int)
+		nparts     int64
+		total      int64
+	)
+	for topic := range b.MemberTopics() {
+		nparts += int64(topics[topic])
+	}
+	for i := 0; i < nmembers; i++ {
+		_, meta := b.MemberAt(i)
+		capacities[i] = memberCapacity(meta)
+		total += capacities[i]
+		for _, topic := range meta.Topics {
+			interests[topic] = append(interests[topic], i)
+		}
+	}
+
+	// First, every member keeps what it previously owned (and still
+	// wants) up to the ceiling of its proportional share. Members are
+	// sorted, so if two members claim the same partition, the first wins.
+	for i := 0; i < nmembers; i++ {
+		_, meta := b.MemberAt(i)
+		limit := (nparts*capacities[i] + total - 1) / total
+		for _, owned := range meta.OwnedPartitions {
+			var wanted bool
+			for _, topic := range meta.Topics {
+				if topic == owned.Topic {
+					wanted = true
+					break
+				}
+			}
+			if !wanted {
+				continue
+			}
+			partitions := append([]int32(nil), owned.Partitions...)
+			sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+			for _, partition := range partitions {
+				tp := topicPartition{owned.Topic, partition}
+				if _, claimed := owners[tp]; claimed || partition >= topics[owned.Topic] || loads[i] >= limit {
+					continue
+				}
+				owners[tp] = i
+				loads[i]++
+			}
+		}
+	}
+
+	// Then, we assign everything unowned to the interested member that
+	// would have the lowest load relative to its capacity.
+	sortedTopics := make([]string, 0, len(interests))
+	for topic := range interests {
+		sortedTopics = append(sortedTopics, topic)
+	}
+	sort.Strings(sortedTopics)
+	for _, topic := range sortedTopics {
+		for partition := int32(0); partition < topics[topic]; partition++ {
+			tp := topicPartition{topic, partition}
+			if _, owned := owners[tp]; owned {
+				continue
+			}
+			best := -1
+			for _, i := range interests[topic] {
+				// (loads[i]+1)/capacities[i] < (loads[best]+1)/capacities[best]
+				if best == -1 || (loads[i]+1)*capacities[best] < (loads[best]+1)*capacities[i] {
+					best = i
+				}
+			}
+			owners[tp] = best
+			loads[best]++
+		}
+	}
+
+	plan := b.NewPlan()
+	for tp, i := range owners {
+		member, _ := b.MemberAt(i)
+		plan.AddPartition(member, tp.topic, tp.partition)
+	}
+	return plan
+}
+
+// UserDataBalancer wraps a balancer so that this member sends the output of
+// userData as the UserData in its join group metadata. This can be used to
+// ship information such as locality hints or ownership metadata to the group
+// leader through the group protocol.
+//
+// The wrapped balancer must use the "consumer" protocol and must not use
+// UserData itself: RangeBalancer and RoundRobinBalancer can be wrapped, while
+// the sticky balancers and CapacityBalancer cannot. Wrapping an incompatible
+// balancer fails client creation.
+//
+// userData is called every time this member joins the group. Kafka compares
+// join metadata to determine whether a rejoining member changed, so the
+// output should be deterministic unless the data actually changed.
+//
+// The leader can read every member's user data while balancing through
+// ConsumerBalancer.EachMember or MemberAt, such as in a custom
+// ConsumerBalancerBalance or in an AdjustBalancePlan function.
+func UserDataBalancer(balancer GroupBalancer, userData func() []byte) GroupBalancer {
+	return &userDataBalancer{balancer, userData}
+}
+
+type userDataBalancer struct {
+	GroupBalancer
+	userData func() []byte
+}
+
+func (u *userDataBalancer) JoinGroupMetadata(interests []string, currentAssignment map[string][]int32, generation int32) []byte {
+	inner := u.GroupBalancer.JoinGroupMetadata(interests, currentAssignment, generation)
+	var meta kmsg.ConsumerMemberMetadata
+	if err := meta.ReadFrom(inner); err != nil {
+		return inner // validated against when creating the client
+	}
+	meta.UserData = u.userData()
+	return meta.AppendTo(nil)
+}
+
+// validate ensures the wrapped balancer is compatible with injecting user
+// data.
+func (u *userDataBalancer) validate() error {
+	var meta kmsg.ConsumerMemberMetadata
+	if err := meta.ReadFrom(u.GroupBalancer.JoinGroupMetadata(nil, nil, -1)); err != nil {
+		return fmt.Errorf("balancer %s does not use the consumer protocol and cannot have user data: %v", u.ProtocolName(), err)
+	}
+	if len(meta.UserData) > 0 {
+		return fmt.Errorf("balancer %s uses member user data itself and cannot have user data injected", u.ProtocolName())
+	}
+	return nil
+}
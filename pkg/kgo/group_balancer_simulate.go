@@ -0,0 +1,204 @@
+package kgo
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// RebalanceSnapshot is the membership of a group and the topics in a cluster
+// at one point in time, for use in SimulateRebalances.
+type RebalanceSnapshot struct {
+	// Members maps member IDs to the topics each member is interested in.
+	Members map[string][]string
+
+	// Topics maps topics to their number of partitions.
+	Topics map[string]int32
+}
+
+// RebalanceChurn reports how much a group's assignment changed when moving
+// to a new snapshot in SimulateRebalances.
+type RebalanceChurn struct {
+	// Rounds is the number of join and sync rounds it took for the group
+	// to settle. Eager balancers always take one round; cooperative
+	// balancers take two rounds if any partition moved between members.
+	Rounds int
+
+	// Moved is the number of partitions that are owned by a different
+	// member after the rebalance than before. Partitions that were
+	// previously unowned or are now unowned are not counted.
+	Moved int
+
+	// Revoked is the number of partitions each member stopped consuming
+	// during the rebalance. With eager balancers, members revoke
+	// everything they own, even partitions that are assigned back.
+	Revoked map[string]int
+
+	// Assigned is the number of partitions each member started consuming
+	// during the rebalance.
+	Assigned map[string]int
+
+	// Plan is the final assignment after the rebalance (member => topic
+	// => partitions).
+	Plan map[string]map[string][]int32
+}
+
+// maxSimulatedRounds bounds how many join rounds a cooperative balancer can
+// take in SimulateRebalances before we consider the balancer broken.
+const maxSimulatedRounds = 10
+
+// SimulateRebalances replays a sequence of group membership snapshots through
+// the given balancer, as if every member used the balancer and the group
+// rebalanced once per snapshot, and returns the churn of each rebalance. The
+// group starts with nothing assigned.
+//
+// This can be used to compare how sticky balancers are over time, such as
+// comparing StickyBalancer against CooperativeStickyBalancer, or to check how
+// a custom balancer behaves as members come and go.
+func SimulateRebalances(balancer GroupBalancer, snapshots []RebalanceSnapshot) ([]RebalanceChurn, error) {
+	type topicPartition struct {
+		topic     string
+		partition int32
+	}
+	var (
+		generation int32
+		current    = make(map[string]map[string][]int32) // member => topic => partitions
+		churns     = make([]RebalanceChurn, 0, len(snapshots))
+	)
+
+	owners := func(plan map[string]map[string][]int32) map[topicPartition]string {
+		owners := make(map[topicPartition]string)
+		for member, topics := range plan {
+			for topic, partitions := range topics {
+				for _, partition := range partitions {
+					owners[topicPartition{topic, partition}] = member
+				}
+			}
+		}
+		return owners
+	}
+
+	for i, snapshot := range snapshots {
+		churn := RebalanceChurn{
+			Revoked:  make(map[string]int),
+			Assigned: make(map[string]int),
+		}
+		before := owners(current)
+
+		// Members that left the group lose everything they owned.
+		for member, topics := range current {
+			if _, exists := snapshot.Members[member]; !exists {
+				for _, partitions := range topics {
+					churn.Revoked[member] += len(partitions)
+				}
+				delete(current, member)
+			}
+		}
+
+		for {
+			churn.Rounds++
+			generation++
+
+			members := make([]kmsg.JoinGroupResponseMember, 0, len(snapshot.Members))
+			for member, interests := range snapshot.Members {
+				interests = append([]string(nil), interests...)
+				sort.Strings(interests)
+				currentAssignment := make(map[string][]int32, len(current[member]))
+				for topic, partitions := range current[member] {
+					partitions = append([]int32(nil), partitions...)
+					sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+					currentAssignment[topic] = partitions
+				}
+				join := kmsg.NewJoinGroupResponseMember()
+				join.MemberID = member
+				join.ProtocolMetadata = balancer.JoinGroupMetadata(interests, currentAssignment, generation-1)
+				members = append(members, join)
+			}
+			sortJoinMembers(members)
+
+			memberBalancer, topics, err := balancer.MemberBalancer(members)
+			if err != nil {
+				return churns, fmt.Errorf("snapshot %d: unable to create group member balancer: %v", i, err)
+			}
+			counts := make(map[string]int32, len(topics))
+			for topic := range topics {
+				if partitions, exists := snapshot.Topics[topic]; exists {
+					counts[topic] = partitions
+				}
+			}
+
+			var anyRevoked bool
+			for _, assignment := range memberBalancer.Balance(counts).IntoSyncAssignment() {
+				assigned, err := balancer.ParseSyncAssignment(assignment.MemberAssignment)
+				if err != nil {
+					return churns, fmt.Errorf("snapshot %d: unable to parse assignment for member %q: %v", i, assignment.MemberID, err)
+				}
+				prior := current[assignment.MemberID]
+
+				if balancer.IsCooperative() {
+					revoked, added := diffAssigned(prior, assigned), diffAssigned(assigned, prior)
+					churn.Revoked[assignment.MemberID] += revoked
+					churn.Assigned[assignment.MemberID] += added
+					anyRevoked = anyRevoked || revoked > 0
+				} else {
+					for _, partitions := range prior {
+						churn.Revoked[assignment.MemberID] += len(partitions)
+					}
+					for _, partitions := range assigned {
+						churn.Assigned[assignment.MemberID] += len(partitions)
+					}
+				}
+				current[assignment.MemberID] = assigned
+			}
+
+			// With cooperative balancing, members that revoked
+			// partitions rejoin so that the revoked partitions can
+			// be assigned to their new owners.
+			if !anyRevoked {
+				break
+			}
+			if churn.Rounds == maxSimulatedRounds {
+				return churns, fmt.Errorf("snapshot %d: group did not settle after %d join rounds", i, churn.Rounds)
+			}
+		}
+
+		for tp, owner := range owners(current) {
+			if prior, exists := before[tp]; exists && prior != owner {
+				churn.Moved++
+			}
+		}
+
+		churn.Plan = make(map[string]map[string][]int32, len(current))
+		for member, topics := range current {
+			plan := make(map[string][]int32, len(topics))
+			for topic, partitions := range topics {
+				partitions = append([]int32(nil), partitions...)
+				sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+				plan[topic] = partitions
+			}
+			churn.Plan[member] = plan
+		}
+		churns = append(churns, churn)
+	}
+
+	return churns, nil
+}
+
+// diffAssigned returns the number of partitions in l that are not in r.
+func diffAssigned(l, r map[string][]int32) int {
+	var n int
+	for topic, lpartitions := range l {
+		rpartitions := r[topic]
+	outer:
+		for _, lpartition := range lpartitions {
+			for _, rpartition := range rpartitions {
+				if lpartition == rpartition {
+					continue outer
+				}
+			}
+			n++
+		}
+	}
+	return n
+}
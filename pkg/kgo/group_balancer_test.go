@@ -1,6 +1,9 @@
 package kgo
 
 import (
+	"context"
+	"errors"
+	"sort"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -99,3 +102,672 @@ func Test_stickyAdjustCooperative(t *testing.T) {
 		t.Error(diff)
 	}
 }
+
+func TestBalancePlanPhases(t *testing.T) {
+	stickyData := func(in map[string][]int32) []byte {
+		meta := kmsg.NewStickyMemberMetadata()
+		for topic, partitions := range in {
+			assn := kmsg.NewStickyMemberMetadataCurrentAssignment()
+			assn.Topic = topic
+			assn.Partitions = partitions
+			meta.CurrentAssignment = append(meta.CurrentAssignment, assn)
+		}
+		return meta.AppendTo(nil)
+	}
+
+	b := &ConsumerBalancer{
+		members: []kmsg.JoinGroupResponseMember{
+			{MemberID: "a"},
+			{MemberID: "b"},
+			{MemberID: "c"},
+		},
+		metadatas: []kmsg.ConsumerMemberMetadata{
+			{OwnedPartitions: []kmsg.ConsumerMemberMetadataOwnedPartition{
+				{Topic: "t1", Partitions: []int32{0, 1, 2}},
+				{Topic: "tdelete", Partitions: []int32{0}},
+			}},
+			{UserData: stickyData(map[string][]int32{
+				"t2": {0, 1},
+			})},
+			{}, // new member, nothing owned
+		},
+	}
+
+	plan := &BalancePlan{map[string]map[string][]int32{
+		"a": {
+			"t1": {2, 0},
+		},
+		"b": {
+			"t2": {0},
+			"t1": {1},
+		},
+		"c": {
+			"t2": {1},
+		},
+	}}
+
+	exp := BalancePlanPhases{
+		Revocations: map[string]map[string][]int32{
+			"a": {
+				"t1":      {1},
+				"tdelete": {0},
+			},
+			"b": {
+				"t2": {1},
+			},
+		},
+		Assignments: map[string]map[string][]int32{
+			"b": {
+				"t1": {1},
+			},
+			"c": {
+				"t2": {1},
+			},
+		},
+	}
+
+	if diff := cmp.Diff(exp, plan.Phases(b)); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestStickyBalanceUnassigned(t *testing.T) {
+	meta := kmsg.NewConsumerMemberMetadata()
+	meta.Topics = []string{"t1"}
+	members := []kmsg.JoinGroupResponseMember{{MemberID: "a", ProtocolMetadata: meta.AppendTo(nil)}}
+
+	var unassigned map[string][]int32
+	balancer := StickyBalancer(StickyBalanceUnassigned(func(m map[string][]int32) { unassigned = m }))
+	mb, _, err := balancer.MemberBalancer(members)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mb.Balance(map[string]int32{"t1": 1, "t2": 2})
+
+	exp := map[string][]int32{"t2": {0, 1}}
+	if diff := cmp.Diff(exp, unassigned); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestCapacityBalancer(t *testing.T) {
+	join := func(id string, capacity int32, topics []string, owned map[string][]int32) kmsg.JoinGroupResponseMember {
+		return kmsg.JoinGroupResponseMember{
+			MemberID:         id,
+			ProtocolMetadata: CapacityBalancer(capacity).JoinGroupMetadata(topics, owned, 0),
+		}
+	}
+	balance := func(members []kmsg.JoinGroupResponseMember, topics map[string]int32) map[string]map[string][]int32 {
+		mb, _, err := CapacityBalancer(1).MemberBalancer(members)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan := mb.Balance(topics).(*BalancePlan).plan
+		for _, topics := range plan {
+			for _, partitions := range topics {
+				sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+			}
+		}
+		return plan
+	}
+
+	t0 := []string{"t0"}
+	topics := map[string]int32{"t0": 8}
+
+	plan := balance([]kmsg.JoinGroupResponseMember{
+		join("a", 1, t0, nil),
+		join("b", 1, t0, nil),
+		join("c", 2, t0, nil),
+	}, topics)
+	exp := map[string]map[string][]int32{
+		"a": {"t0": {1, 5}},
+		"b": {"t0": {2, 6}},
+		"c": {"t0": {0, 3, 4, 7}},
+	}
+	if diff := cmp.Diff(exp, plan); diff != "" {
+		t.Error(diff)
+	}
+
+	// b leaves and d joins with no capacity hint, which is treated as
+	// capacity one. a and c keep what they have, and d takes what b owned.
+	plan = balance([]kmsg.JoinGroupResponseMember{
+		join("a", 1, t0, exp["a"]),
+		join("c", 2, t0, exp["c"]),
+		{MemberID: "d", ProtocolMetadata: memberMetadataV0(t0)},
+	}, topics)
+	exp = map[string]map[string][]int32{
+		"a": {"t0": {1, 5}},
+		"c": {"t0": {0, 3, 4, 7}},
+		"d": {"t0": {2, 6}},
+	}
+	if diff := cmp.Diff(exp, plan); diff != "" {
+		t.Error(diff)
+	}
+
+	// a and d leave: c has capacity for everything, but a new member e
+	// with capacity 2 takes half of the partitions, with c keeping as much
+	// as it previously had.
+	plan = balance([]kmsg.JoinGroupResponseMember{
+		join("c", 2, t0, exp["c"]),
+		join("e", 2, t0, nil),
+	}, topics)
+	exp = map[string]map[string][]int32{
+		"c": {"t0": {0, 3, 4, 7}},
+		"e": {"t0": {1, 2, 5, 6}},
+	}
+	if diff := cmp.Diff(exp, plan); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestBalancePlanAdjust(t *testing.T) {
+	newBalancer := func() *ConsumerBalancer {
+		return &ConsumerBalancer{
+			members: []kmsg.JoinGroupResponseMember{
+				{MemberID: "a"},
+				{MemberID: "b"},
+			},
+			metadatas: []kmsg.ConsumerMemberMetadata{
+				{Topics: []string{"t1", "t2"}},
+				{Topics: []string{"t1"}},
+			},
+		}
+	}
+	topics := map[string]int32{"t1": 2, "t2": 1}
+	newPlan := func() *BalancePlan {
+		return &BalancePlan{map[string]map[string][]int32{
+			"a": {"t1": {0}, "t2": {0}},
+			"b": {"t1": {1}},
+		}}
+	}
+
+	for _, test := range []struct {
+		name   string
+		fn     func(map[string]map[string][]int32)
+		exp    map[string]map[string][]int32
+		expErr bool
+	}{
+		{
+			name: "move",
+			fn: func(plan map[string]map[string][]int32) {
+				plan["a"]["t1"] = nil
+				plan["b"]["t1"] = []int32{0, 1}
+			},
+			exp: map[string]map[string][]int32{
+				"a": {"t1": nil, "t2": {0}},
+				"b": {"t1": {0, 1}},
+			},
+		},
+		{
+			name:   "unknown member",
+			fn:     func(plan map[string]map[string][]int32) { plan["c"] = map[string][]int32{} },
+			expErr: true,
+		},
+		{
+			name:   "uninterested",
+			fn:     func(plan map[string]map[string][]int32) { plan["b"]["t2"] = plan["a"]["t2"]; delete(plan["a"], "t2") },
+			expErr: true,
+		},
+		{
+			name:   "nonexistent partition",
+			fn:     func(plan map[string]map[string][]int32) { plan["b"]["t1"] = append(plan["b"]["t1"], 2) },
+			expErr: true,
+		},
+		{
+			name:   "doubly assigned",
+			fn:     func(plan map[string]map[string][]int32) { plan["b"]["t1"] = append(plan["b"]["t1"], 0) },
+			expErr: true,
+		},
+		{
+			name:   "dropped",
+			fn:     func(plan map[string]map[string][]int32) { delete(plan["b"], "t1") },
+			expErr: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			p := newPlan()
+			err := p.adjust(newBalancer(), topics, false, func(_ *ConsumerBalancer, plan map[string]map[string][]int32) { test.fn(plan) })
+			if gotErr := err != nil; gotErr != test.expErr {
+				t.Fatalf("got err? %v (%v), exp err? %v", gotErr, err, test.expErr)
+			}
+			exp := test.exp
+			if test.expErr {
+				exp = newPlan().plan
+			}
+			if diff := cmp.Diff(exp, p.plan); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestExternalBalancePlan(t *testing.T) {
+	b := &ConsumerBalancer{
+		members: []kmsg.JoinGroupResponseMember{
+			{MemberID: "a"},
+			{MemberID: "b"},
+		},
+		metadatas: []kmsg.ConsumerMemberMetadata{
+			{Topics: []string{"t1"}},
+			{Topics: []string{"t1"}},
+		},
+	}
+	topics := map[string]int32{"t1": 3}
+	newPlan := func() *BalancePlan {
+		return &BalancePlan{map[string]map[string][]int32{
+			"a": {"t1": {0, 1}},
+			"b": {"t1": {2}},
+		}}
+	}
+	cfg := defaultCfg()
+	g := &groupConsumer{ctx: context.Background(), cfg: &cfg}
+
+	for _, test := range []struct {
+		name     string
+		external map[string]map[string][]int32
+		err      error
+		exp      map[string]map[string][]int32
+		expErr   bool
+	}{
+		{
+			name:     "replaced",
+			external: map[string]map[string][]int32{"b": {"t1": {0, 1, 2}}},
+			exp: map[string]map[string][]int32{
+				"a": {},
+				"b": {"t1": {0, 1, 2}},
+			},
+		},
+		{
+			name:   "planner error",
+			err:    errors.New("planner unavailable"),
+			exp:    newPlan().plan,
+			expErr: true,
+		},
+		{
+			name:     "invalid plan",
+			external: map[string]map[string][]int32{"b": {"t1": {0, 1}}},
+			exp:      newPlan().plan,
+			expErr:   true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			p := newPlan()
+			err := g.externalPlan(func(ctx context.Context, _ *ConsumerBalancer, got map[string]int32) (map[string]map[string][]int32, error) {
+				if _, ok := ctx.Deadline(); !ok {
+					t.Error("external planner context has no deadline")
+				}
+				if diff := cmp.Diff(topics, got); diff != "" {
+					t.Error(diff)
+				}
+				return test.external, test.err
+			}, p, b, topics, false)
+			if gotErr := err != nil; gotErr != test.expErr {
+				t.Fatalf("got err? %v (%v), exp err? %v", gotErr, err, test.expErr)
+			}
+			if diff := cmp.Diff(test.exp, p.plan); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestSimulateRebalances(t *testing.T) {
+	all := []string{"t0"}
+	topics := map[string]int32{"t0": 6}
+	snapshots := []RebalanceSnapshot{
+		{Members: map[string][]string{"a": all, "b": all}, Topics: topics},
+		{Members: map[string][]string{"a": all, "b": all, "c": all}, Topics: topics},
+		{Members: map[string][]string{"a": all, "c": all}, Topics: topics},
+	}
+
+	for _, test := range []struct {
+		name        string
+		balancer    GroupBalancer
+		expRounds   []int
+		expRevokedA []int
+	}{
+		{"sticky", StickyBalancer(), []int{1, 1, 1}, []int{0, 3, 2}},
+		{"cooperative-sticky", CooperativeStickyBalancer(), []int{1, 2, 1}, []int{0, 1, 0}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			churns, err := SimulateRebalances(test.balancer, snapshots)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Both balancers are equally sticky: the first balance
+			// moves nothing, adding c moves two partitions to c, and
+			// removing b moves b's partitions to a and c. Only
+			// how often members revoke differs.
+			for i, expMoved := range []int{0, 2, 2} {
+				churn := churns[i]
+				if churn.Moved != expMoved {
+					t.Errorf("snapshot %d: got moved %d != exp %d", i, churn.Moved, expMoved)
+				}
+				if churn.Rounds != test.expRounds[i] {
+					t.Errorf("snapshot %d: got rounds %d != exp %d", i, churn.Rounds, test.expRounds[i])
+				}
+				if churn.Revoked["a"] != test.expRevokedA[i] {
+					t.Errorf("snapshot %d: got a revoked %d != exp %d", i, churn.Revoked["a"], test.expRevokedA[i])
+				}
+				var assigned int
+				for member, topics := range churn.Plan {
+					if _, exists := snapshots[i].Members[member]; !exists {
+						t.Errorf("snapshot %d: plan contains member %s that is not in the group", i, member)
+					}
+					assigned += len(topics["t0"])
+					if n := len(topics["t0"]); n != 6/len(snapshots[i].Members) {
+						t.Errorf("snapshot %d: member %s has %d partitions, expected an even balance", i, member, n)
+					}
+				}
+				if assigned != 6 {
+					t.Errorf("snapshot %d: got %d assigned partitions != exp 6", i, assigned)
+				}
+			}
+		})
+	}
+}
+
+type balanceTestMember struct {
+	id         string
+	instanceID string
+	topics     []string
+}
+
+// balanceTest runs a full balance for members through the given balancer,
+// as the group leader does, and returns the resulting plan with sorted
+// partitions and no empty members.
+func balanceTest(t *testing.T, balancer GroupBalancer, in []balanceTestMember, topics map[string]int32) map[string]map[string][]int32 {
+	t.Helper()
+
+	members := make([]kmsg.JoinGroupResponseMember, 0, len(in))
+	for _, m := range in {
+		member := kmsg.NewJoinGroupResponseMember()
+		member.MemberID = m.id
+		if m.instanceID != "" {
+			instanceID := m.instanceID
+			member.InstanceID = &instanceID
+		}
+		member.ProtocolMetadata = balancer.JoinGroupMetadata(m.topics, nil, -1)
+		members = append(members, member)
+	}
+	sortJoinMembers(members)
+
+	mb, _, err := balancer.MemberBalancer(members)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := make(map[string]map[string][]int32)
+	for _, assignment := range mb.Balance(topics).IntoSyncAssignment() {
+		assigned, err := balancer.ParseSyncAssignment(assignment.MemberAssignment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(assigned) > 0 {
+			plan[assignment.MemberID] = assigned
+		}
+	}
+	return plan
+}
+
+// These cases mirror the Java RangeAssignorTest.
+func TestRangeBalancer(t *testing.T) {
+	t1, t2 := "t1", "t2"
+	for _, test := range []struct {
+		name    string
+		members []balanceTestMember
+		topics  map[string]int32
+		exp     map[string]map[string][]int32
+	}{
+		{
+			name:    "one consumer one topic",
+			members: []balanceTestMember{{id: "c1", topics: []string{t1}}},
+			topics:  map[string]int32{t1: 3},
+			exp:     map[string]map[string][]int32{"c1": {t1: {0, 1, 2}}},
+		},
+		{
+			name: "two consumers one topic one partition",
+			members: []balanceTestMember{
+				{id: "c1", topics: []string{t1}},
+				{id: "c2", topics: []string{t1}},
+			},
+			topics: map[string]int32{t1: 1},
+			exp:    map[string]map[string][]int32{"c1": {t1: {0}}},
+		},
+		{
+			name: "multiple consumers mixed topics",
+			members: []balanceTestMember{
+				{id: "c1", topics: []string{t1}},
+				{id: "c2", topics: []string{t1, t2}},
+				{id: "c3", topics: []string{t1}},
+			},
+			topics: map[string]int32{t1: 3, t2: 2},
+			exp: map[string]map[string][]int32{
+				"c1": {t1: {0}},
+				"c2": {t1: {1}, t2: {0, 1}},
+				"c3": {t1: {2}},
+			},
+		},
+		{
+			name: "two consumers two topics six partitions",
+			members: []balanceTestMember{
+				{id: "c1", topics: []string{t1, t2}},
+				{id: "c2", topics: []string{t1, t2}},
+			},
+			topics: map[string]int32{t1: 3, t2: 3},
+			exp: map[string]map[string][]int32{
+				"c1": {t1: {0, 1}, t2: {0, 1}},
+				"c2": {t1: {2}, t2: {2}},
+			},
+		},
+		{
+			name: "static members sort by instance id",
+			members: []balanceTestMember{
+				{id: "c1", instanceID: "i2", topics: []string{t1, t2}},
+				{id: "c2", instanceID: "i1", topics: []string{t1, t2}},
+			},
+			topics: map[string]int32{t1: 3, t2: 3},
+			exp: map[string]map[string][]int32{
+				"c2": {t1: {0, 1}, t2: {0, 1}},
+				"c1": {t1: {2}, t2: {2}},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			plan := balanceTest(t, RangeBalancer(), test.members, test.topics)
+			if diff := cmp.Diff(test.exp, plan); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+// These cases mirror the Java RoundRobinAssignorTest.
+func TestRoundRobinBalancer(t *testing.T) {
+	t1, t2 := "t1", "t2"
+	for _, test := range []struct {
+		name    string
+		members []balanceTestMember
+		topics  map[string]int32
+		exp     map[string]map[string][]int32
+	}{
+		{
+			name:    "one consumer non-existent topic",
+			members: []balanceTestMember{{id: "c1", topics: []string{t1}}},
+			topics:  map[string]int32{},
+			exp:     map[string]map[string][]int32{},
+		},
+		{
+			name: "one consumer multiple topics",
+			members: []balanceTestMember{
+				{id: "c1", topics: []string{t1, t2}},
+			},
+			topics: map[string]int32{t1: 1, t2: 2},
+			exp:    map[string]map[string][]int32{"c1": {t1: {0}, t2: {0, 1}}},
+		},
+		{
+			name: "multiple consumers mixed topics",
+			members: []balanceTestMember{
+				{id: "c1", topics: []string{t1}},
+				{id: "c2", topics: []string{t1, t2}},
+				{id: "c3", topics: []string{t1}},
+			},
+			topics: map[string]int32{t1: 3, t2: 2},
+			exp: map[string]map[string][]int32{
+				"c1": {t1: {0}},
+				"c2": {t1: {1}, t2: {0, 1}},
+				"c3": {t1: {2}},
+			},
+		},
+		{
+			name: "two consumers two topics six partitions",
+			members: []balanceTestMember{
+				{id: "c1", topics: []string{t1, t2}},
+				{id: "c2", topics: []string{t1, t2}},
+			},
+			topics: map[string]int32{t1: 3, t2: 3},
+			exp: map[string]map[string][]int32{
+				"c1": {t1: {0, 2}, t2: {1}},
+				"c2": {t1: {1}, t2: {0, 2}},
+			},
+		},
+		{
+			name: "static members sort by instance id",
+			members: []balanceTestMember{
+				{id: "c1", instanceID: "i2", topics: []string{t1, t2}},
+				{id: "c2", instanceID: "i1", topics: []string{t1, t2}},
+			},
+			topics: map[string]int32{t1: 3, t2: 3},
+			exp: map[string]map[string][]int32{
+				"c2": {t1: {0, 2}, t2: {1}},
+				"c1": {t1: {1}, t2: {0, 2}},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			plan := balanceTest(t, RoundRobinBalancer(), test.members, test.topics)
+			if diff := cmp.Diff(test.exp, plan); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+// topicBalancer is a custom balancer built only from the exported API, as a
+// user of this package would write one: it assigns every partition of a topic
+// to one member, cycling through the interested members topic by topic.
+type topicBalancer struct{}
+
+func (*topicBalancer) ProtocolName() string { return "topic" }
+func (*topicBalancer) IsCooperative() bool  { return false }
+func (*topicBalancer) JoinGroupMetadata(interests []string, _ map[string][]int32, _ int32) []byte {
+	meta := kmsg.NewConsumerMemberMetadata()
+	meta.Topics = interests
+	return meta.AppendTo(nil)
+}
+
+func (*topicBalancer) ParseSyncAssignment(assignment []byte) (map[string][]int32, error) {
+	return ParseConsumerSyncAssignment(assignment)
+}
+
+func (t *topicBalancer) MemberBalancer(members []kmsg.JoinGroupResponseMember) (GroupMemberBalancer, map[string]struct{}, error) {
+	b, err := NewConsumerBalancer(t, members)
+	return b, b.MemberTopics(), err
+}
+
+func (*topicBalancer) Balance(b *ConsumerBalancer, topics map[string]int32) IntoSyncAssignment {
+	sorted := make([]string, 0, len(topics))
+	for topic := range topics {
+		sorted = append(sorted, topic)
+	}
+	sort.Strings(sorted)
+
+	plan := b.NewPlan()
+	var next int
+	for _, topic := range sorted {
+		var interested []*kmsg.JoinGroupResponseMember
+		b.EachMember(func(member *kmsg.JoinGroupResponseMember, meta *kmsg.ConsumerMemberMetadata) {
+			for _, interest := range meta.Topics {
+				if interest == topic {
+					interested = append(interested, member)
+				}
+			}
+		})
+		if len(interested) == 0 {
+			continue
+		}
+		member := interested[next%len(interested)]
+		next++
+		for partition := int32(0); partition < topics[topic]; partition++ {
+			plan.AddPartition(member, topic, partition)
+		}
+	}
+	return plan
+}
+
+func TestCustomBalancer(t *testing.T) {
+	var balancer GroupBalancer = new(topicBalancer)
+	plan := balanceTest(t, balancer, []balanceTestMember{
+		{id: "c1", topics: []string{"t1", "t2", "t3"}},
+		{id: "c2", topics: []string{"t1", "t2", "t3"}},
+		{id: "c3", topics: []string{"t3"}},
+	}, map[string]int32{"t1": 2, "t2": 1, "t3": 3, "unwanted": 4})
+
+	exp := map[string]map[string][]int32{
+		"c1": {"t1": {0, 1}},
+		"c2": {"t2": {0}},
+		"c3": {"t3": {0, 1, 2}},
+	}
+	if diff := cmp.Diff(exp, plan); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestUserDataBalancer(t *testing.T) {
+	balancer := UserDataBalancer(RangeBalancer(), func() []byte { return []byte("rack-a") })
+
+	member := kmsg.NewJoinGroupResponseMember()
+	member.MemberID = "c1"
+	member.ProtocolMetadata = balancer.JoinGroupMetadata([]string{"t1"}, nil, -1)
+
+	mb, _, err := balancer.MemberBalancer([]kmsg.JoinGroupResponseMember{member})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var userData []string
+	mb.(*ConsumerBalancer).EachMember(func(_ *kmsg.JoinGroupResponseMember, meta *kmsg.ConsumerMemberMetadata) {
+		userData = append(userData, string(meta.UserData))
+	})
+	if diff := cmp.Diff([]string{"rack-a"}, userData); diff != "" {
+		t.Error(diff)
+	}
+
+	// The wrapped balancer still balances as it normally would.
+	plan := balanceTest(t, balancer, []balanceTestMember{
+		{id: "c1", topics: []string{"t1"}},
+		{id: "c2", topics: []string{"t1"}},
+	}, map[string]int32{"t1": 4})
+	exp := map[string]map[string][]int32{
+		"c1": {"t1": {0, 1}},
+		"c2": {"t1": {2, 3}},
+	}
+	if diff := cmp.Diff(exp, plan); diff != "" {
+		t.Error(diff)
+	}
+
+	for _, test := range []struct {
+		balancer GroupBalancer
+		valid    bool
+	}{
+		{RangeBalancer(), true},
+		{RoundRobinBalancer(), true},
+		{StickyBalancer(), false},
+		{CooperativeStickyBalancer(), false},
+		{CapacityBalancer(2), false},
+	} {
+		cfg := defaultCfg()
+		Balancers(UserDataBalancer(test.balancer, func() []byte { return nil })).apply(&cfg)
+		if err := cfg.validate(); (err == nil) != test.valid {
+			t.Errorf("%s: got err %v, expected valid %v", test.balancer.ProtocolName(), err, test.valid)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+//go:build interop
+// +build interop
+
+package kgo
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// The tests in this file run this client in groups alongside the official
+// Java consumers, ensuring that both sides can decode each other's member
+// metadata and assignments for every balancer that the Java client also
+// implements. These tests require a Kafka distribution and are only built
+// with the interop tag:
+//
+//	KGO_JAVA_CONSUMER=/opt/kafka/bin/kafka-console-consumer.sh go test -tags interop -run Interop
+//
+// KGO_SEEDS is used for both the Java consumers and this client.
+
+// interopConvergeTimeout is how long we wait for a group to stabilize with
+// the expected members and a complete assignment.
+const interopConvergeTimeout = 2 * time.Minute
+
+func TestGroupInterop(t *testing.T) {
+	javaConsumer := os.Getenv("KGO_JAVA_CONSUMER")
+	if javaConsumer == "" {
+		t.Skip("KGO_JAVA_CONSUMER is not set to the path of kafka-console-consumer.sh")
+	}
+	seeds := os.Getenv("KGO_SEEDS")
+	if seeds == "" {
+		seeds = "127.0.0.1:9092"
+	}
+
+	for _, test := range []struct {
+		assignor string
+		balancer GroupBalancer
+	}{
+		{"org.apache.kafka.clients.consumer.RangeAssignor", RangeBalancer()},
+		{"org.apache.kafka.clients.consumer.RoundRobinAssignor", RoundRobinBalancer()},
+		{"org.apache.kafka.clients.consumer.StickyAssignor", StickyBalancer()},
+		{"org.apache.kafka.clients.consumer.CooperativeStickyAssignor", CooperativeStickyBalancer()},
+	} {
+		test := test
+		t.Run(test.balancer.ProtocolName(), func(t *testing.T) {
+			testGroupInterop(t, javaConsumer, seeds, test.assignor, test.balancer)
+		})
+	}
+}
+
+func testGroupInterop(t *testing.T, javaConsumer, seeds, assignor string, balancer GroupBalancer) {
+	topic, topicCleanup := tmpTopic(t)
+	defer topicCleanup()
+	group, groupCleanup := tmpGroup(t)
+	defer groupCleanup()
+
+	// Everything in the group must be stopped before the group can be
+	// deleted, so we stop all members before the deferred cleanups run.
+	ctx, cancel := context.WithCancel(context.Background())
+	var javas []*exec.Cmd
+	defer func() {
+		cancel()
+		for _, java := range javas {
+			java.Wait()
+		}
+	}()
+
+	startJava := func() {
+		java := exec.CommandContext(ctx, javaConsumer,
+			"--bootstrap-server", seeds,
+			"--topic", topic,
+			"--group", group,
+			"--consumer-property", "partition.assignment.strategy="+assignor,
+		)
+		if testLogLevel == LogLevelDebug {
+			java.Stderr = os.Stderr
+		}
+		if err := java.Start(); err != nil {
+			t.Fatalf("unable to start java consumer: %v", err)
+		}
+		javas = append(javas, java)
+	}
+
+	startKgo := func() *Client {
+		cl, err := NewClient(
+			SeedBrokers(strings.Split(seeds, ",")...),
+			WithLogger(testLogger()),
+			ConsumerGroup(group),
+			ConsumeTopics(topic),
+			Balancers(balancer),
+		)
+		if err != nil {
+			t.Fatalf("unable to create client: %v", err)
+		}
+		go func() {
+			for {
+				fetches := cl.PollFetches(ctx)
+				if fetches.IsClientClosed() || ctx.Err() != nil {
+					return
+				}
+			}
+		}()
+		return cl
+	}
+
+	startJava()
+	kgo1 := startKgo()
+	defer kgo1.Close()
+	startJava()
+	kgo2 := startKgo()
+	defer kgo2.Close()
+
+	if err := awaitInteropConvergence(t, group, balancer.ProtocolName(), 4, 20); err != nil {
+		t.Fatal(err)
+	}
+
+	// A member leaving should cause the remaining members to converge
+	// again, which ensures that the Java and Go members can each decode
+	// the prior assignments and ownership that the others send.
+	kgo2.Close()
+	if err := awaitInteropConvergence(t, group, balancer.ProtocolName(), 3, 20); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// awaitInteropConvergence waits for the group to be stable with the given
+// protocol and number of members, and for every partition of the group's one
+// topic to be assigned to exactly one member.
+func awaitInteropConvergence(t *testing.T, group, protocol string, members, partitions int) error {
+	t.Helper()
+
+	var last error
+	deadline := time.Now().Add(interopConvergeTimeout)
+	for time.Now().Before(deadline) {
+		if last = checkInteropGroup(group, protocol, members, partitions); last == nil {
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+	return fmt.Errorf("group %s did not converge: %v", group, last)
+}
+
+func checkInteropGroup(group, protocol string, members, partitions int) error {
+	req := kmsg.NewPtrDescribeGroupsRequest()
+	req.Groups = []string{group}
+	resp, err := req.RequestWith(context.Background(), adm)
+	if err != nil {
+		return err
+	}
+	if len(resp.Groups) != 1 {
+		return fmt.Errorf("got %d groups in describe response, expected 1", len(resp.Groups))
+	}
+	described := resp.Groups[0]
+	if err := kerr.ErrorForCode(described.ErrorCode); err != nil {
+		return err
+	}
+	if described.State != "Stable" {
+		return fmt.Errorf("group state is %s", described.State)
+	}
+	if described.Protocol != protocol {
+		return fmt.Errorf("group protocol is %q, expected %q", described.Protocol, protocol)
+	}
+	if len(described.Members) != members {
+		return fmt.Errorf("group has %d members, expected %d", len(described.Members), members)
+	}
+
+	owners := make(map[int32]string)
+	for _, member := range described.Members {
+		var assignment kmsg.ConsumerMemberAssignment
+		if err := assignment.ReadFrom(member.MemberAssignment); err != nil {
+			return fmt.Errorf("unable to decode assignment for member %s (client %s): %v", member.MemberID, member.ClientID, err)
+		}
+		var meta kmsg.ConsumerMemberMetadata
+		if err := meta.ReadFrom(member.ProtocolMetadata); err != nil {
+			return fmt.Errorf("unable to decode metadata for member %s (client %s): %v", member.MemberID, member.ClientID, err)
+		}
+		for _, topic := range assignment.Topics {
+			for _, partition := range topic.Partitions {
+				if prior, exists := owners[partition]; exists {
+					return fmt.Errorf("partition %d is assigned to both %s and %s", partition, prior, member.MemberID)
+				}
+				owners[partition] = member.MemberID
+			}
+		}
+	}
+	if len(owners) != partitions {
+		var assigned []int
+		for partition := range owners {
+			assigned = append(assigned, int(partition))
+		}
+		sort.Ints(assigned)
+		return fmt.Errorf("only partitions %v of %d are assigned", assigned, partitions)
+	}
+	return nil
+}
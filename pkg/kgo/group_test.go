@@ -3,13 +3,23 @@ package kgo
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kmsg"
 )
 
 // TestGroupETL tests:
@@ -232,3 +242,834 @@ func (c *testConsumer) etl(etlsBeforeQuit int) {
 
 	}
 }
+
+func TestGroupSnapshot(t *testing.T) {
+	cl, err := NewClient(SeedBrokers("127.0.0.1:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cl.GroupSnapshot(); ok {
+		t.Error("unexpected group snapshot for a client not in a group")
+	}
+	cl.Close()
+
+	cl, err = NewClient(
+		SeedBrokers("127.0.0.1:1"),
+		ConsumerGroup("g"),
+		ConsumeTopics("t"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	s, ok := cl.GroupSnapshot()
+	if !ok {
+		t.Fatal("missing group snapshot for a client in a group")
+	}
+	if s.Group != "g" || s.MemberID != "" || s.Leader || len(s.Assigned) != 0 {
+		t.Errorf("unexpected snapshot before joining: %+v", s)
+	}
+}
+
+func TestIsGroupFencedErr(t *testing.T) {
+	for _, test := range []struct {
+		err    error
+		fenced bool
+	}{
+		{kerr.IllegalGeneration, true},
+		{kerr.UnknownMemberID, true},
+		{fmt.Errorf("heartbeat: %w", kerr.FencedInstanceID), true},
+		{kerr.RebalanceInProgress, false},
+		{kerr.CoordinatorNotAvailable, false},
+		{context.Canceled, false},
+	} {
+		if got := isGroupFencedErr(test.err); got != test.fenced {
+			t.Errorf("%v: got fenced %v, expected %v", test.err, got, test.fenced)
+		}
+	}
+}
+
+func TestGroupEviction(t *testing.T) {
+	cfg := defaultCfg()
+	cfg.group = "g"
+	g := &groupConsumer{cfg: &cfg, memberID: "m", generation: 3}
+
+	e := g.eviction(fmt.Errorf("heartbeat: %w", kerr.UnknownMemberID))
+	if e.Group != "g" || e.MemberID != "m" || e.Generation != 3 || !errors.Is(e.Err, kerr.UnknownMemberID) {
+		t.Errorf("unexpected eviction %+v", e)
+	}
+	if !strings.Contains(e.Reason, "session timed out") {
+		t.Errorf("unexpected reason %q", e.Reason)
+	}
+}
+
+func TestGroupJoinReason(t *testing.T) {
+	cfg := defaultCfg()
+	g := &groupConsumer{cfg: &cfg}
+
+	if reason := g.takeJoinReason(); reason != nil {
+		t.Errorf("got initial reason %q, expected none", *reason)
+	}
+
+	cfg.groupReason = "deploy"
+	if reason := g.takeJoinReason(); reason == nil || *reason != "deploy" {
+		t.Errorf("got initial reason %v, expected the default", reason)
+	}
+
+	// A specific reason is kept over a generic one, and is sent once.
+	g.setJoinReason("new topics", true)
+	g.setJoinReason("the group is rebalancing", false)
+	if reason := g.takeJoinReason(); reason == nil || *reason != "new topics" {
+		t.Errorf("got reason %v, expected the rejoin trigger", reason)
+	}
+	if reason := g.takeJoinReason(); reason == nil || *reason != "deploy" {
+		t.Errorf("got reason %v after joining, expected the default", reason)
+	}
+
+	g.setJoinReason("the group is rebalancing", false)
+	if reason := g.takeJoinReason(); reason == nil || *reason != "the group is rebalancing" {
+		t.Errorf("got reason %v, expected the rebalance", reason)
+	}
+}
+
+func TestCommitRespErr(t *testing.T) {
+	instance := "i"
+	req := kmsg.NewPtrOffsetCommitRequest()
+	req.Generation = 4
+	req.MemberID = "m"
+	req.InstanceID = &instance
+
+	resp := func(code int16) *kmsg.OffsetCommitResponse {
+		resp := kmsg.NewPtrOffsetCommitResponse()
+		rt := kmsg.NewOffsetCommitResponseTopic()
+		rt.Topic = "t"
+		for i, c := range []int16{0, code} {
+			rp := kmsg.NewOffsetCommitResponseTopicPartition()
+			rp.Partition = int32(i)
+			rp.ErrorCode = c
+			rt.Partitions = append(rt.Partitions, rp)
+		}
+		resp.Topics = append(resp.Topics, rt)
+		return resp
+	}
+
+	if err := commitRespErr(req, resp(0), nil); err != nil {
+		t.Errorf("got unexpected err %v", err)
+	}
+	if err := commitRespErr(req, resp(kerr.OffsetMetadataTooLarge.Code), nil); err != kerr.OffsetMetadataTooLarge {
+		t.Errorf("got err %v, expected %v", err, kerr.OffsetMetadataTooLarge)
+	}
+	if err := commitRespErr(req, nil, context.Canceled); err != context.Canceled {
+		t.Errorf("got err %v, expected %v", err, context.Canceled)
+	}
+
+	err := commitRespErr(req, resp(kerr.FencedInstanceID.Code), nil)
+	var fenced *ErrCommitFenced
+	if !errors.As(err, &fenced) {
+		t.Fatalf("got err %v, expected ErrCommitFenced", err)
+	}
+	if fenced.Generation != 4 || fenced.MemberID != "m" || fenced.InstanceID == nil || *fenced.InstanceID != "i" {
+		t.Errorf("unexpected fenced err %+v", fenced)
+	}
+	if !errors.Is(err, kerr.FencedInstanceID) {
+		t.Errorf("fenced err does not wrap %v", kerr.FencedInstanceID)
+	}
+	if !strings.Contains(err.Error(), "unique instance ID") {
+		t.Errorf("unexpected fenced err message %q", err)
+	}
+}
+
+func TestMarkCommitRecordsUnassigned(t *testing.T) {
+	cl, err := NewClient(
+		SeedBrokers("127.0.0.1:1"),
+		ConsumerGroup("g"),
+		ConsumeTopics("t"),
+		AutoCommitMarks(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	g := cl.consumer.g
+	g.mu.Lock()
+	g.uncommitted = uncommitted{"t": {0: uncommit{dirty: EpochOffset{0, 10}}}}
+	g.mu.Unlock()
+
+	cl.MarkCommitRecords(
+		&Record{Topic: "t", Partition: 0, Offset: 4, LeaderEpoch: 0},
+		&Record{Topic: "t", Partition: 1, Offset: 4, LeaderEpoch: 0},
+		&Record{Topic: "u", Partition: 0, Offset: 4, LeaderEpoch: 0},
+	)
+
+	g.mu.Lock()
+	got := g.uncommitted
+	g.mu.Unlock()
+	exp := uncommitted{"t": {0: uncommit{dirty: EpochOffset{0, 10}, head: EpochOffset{0, 5}}}}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("got marked %v, expected %v", got, exp)
+	}
+}
+
+func TestGroupLagNotAssigned(t *testing.T) {
+	cl, err := NewClient(SeedBrokers("127.0.0.1:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cl.GroupLag(context.Background()); err != errNotGroup {
+		t.Errorf("got err %v, expected %v", err, errNotGroup)
+	}
+	cl.Close()
+
+	cl, err = NewClient(
+		SeedBrokers("127.0.0.1:1"),
+		ConsumerGroup("g"),
+		ConsumeTopics("t"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	lags, err := cl.GroupLag(context.Background())
+	if err != nil || len(lags) != 0 {
+		t.Errorf("got lags %v, err %v; expected no lag before being assigned", lags, err)
+	}
+}
+
+func TestUpdateUncommittedUnknownCommit(t *testing.T) {
+	cl, err := NewClient(
+		SeedBrokers("127.0.0.1:1"),
+		ConsumerGroup("g"),
+		ConsumeTopics("t"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	g := cl.consumer.g
+	g.mu.Lock()
+	g.uncommitted = uncommitted{"t": {1: uncommit{committed: EpochOffset{0, 0}}}}
+	g.mu.Unlock()
+	g.updateUncommitted(Fetches{{Topics: []FetchTopic{{
+		Topic: "t",
+		Partitions: []FetchPartition{
+			{Partition: 0, Records: []*Record{{Offset: 3, LeaderEpoch: 0}}},
+			{Partition: 1, Records: []*Record{{Offset: 3, LeaderEpoch: 0}}},
+		},
+	}}}})
+	g.mu.Lock()
+	got := g.uncommitted["t"]
+	g.mu.Unlock()
+
+	if c := got[0].committed; c != (EpochOffset{-1, -1}) {
+		t.Errorf("polled but never committed partition has committed %v, expected unknown", c)
+	}
+	if c := got[1].committed; c != (EpochOffset{0, 0}) {
+		t.Errorf("partition committed at 0 has committed %v, expected 0", c)
+	}
+}
+
+// rawBalancer is a balancer for a non-consumer protocol: member metadata is
+// the member's name, and the leader assigns every member the list of all
+// member names.
+type rawBalancer struct{ name string }
+
+func (*rawBalancer) ProtocolName() string { return "raw" }
+func (*rawBalancer) IsCooperative() bool  { return false }
+func (b *rawBalancer) JoinGroupMetadata([]string, map[string][]int32, int32) []byte {
+	return []byte(b.name)
+}
+func (*rawBalancer) ParseSyncAssignment([]byte) (map[string][]int32, error) { return nil, nil }
+func (*rawBalancer) MemberBalancer(members []kmsg.JoinGroupResponseMember) (GroupMemberBalancer, map[string]struct{}, error) {
+	return rawMembers(members), nil, nil
+}
+
+type rawMembers []kmsg.JoinGroupResponseMember
+
+func (ms rawMembers) Balance(map[string]int32) IntoSyncAssignment { return ms }
+func (ms rawMembers) IntoSyncAssignment() []kmsg.SyncGroupRequestGroupAssignment {
+	var names []byte
+	for _, m := range ms {
+		names = append(append(names, m.ProtocolMetadata...), ',')
+	}
+	var assignments []kmsg.SyncGroupRequestGroupAssignment
+	for _, m := range ms {
+		assignments = append(assignments, kmsg.SyncGroupRequestGroupAssignment{MemberID: m.MemberID, MemberAssignment: names})
+	}
+	return assignments
+}
+
+func TestGroupCustomProtocolWithoutTopics(t *testing.T) {
+	if _, err := NewClient(
+		SeedBrokers("127.0.0.1:1"),
+		ConsumerGroup("g"),
+	); err == nil {
+		t.Error("expected error for a consumer group without topics, got nil")
+	}
+
+	cl, err := NewClient(
+		SeedBrokers("127.0.0.1:1"),
+		ConsumerGroup("g"),
+		GroupProtocol("raw"),
+		Balancers(&rawBalancer{"a"}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error for a custom protocol group without topics: %v", err)
+	}
+	if cl.consumer.g == nil {
+		t.Fatal("expected the client to be a group member")
+	}
+
+	balancer, _, _ := cl.cfg.balancers[0].MemberBalancer([]kmsg.JoinGroupResponseMember{
+		{MemberID: "1", ProtocolMetadata: []byte("a")},
+		{MemberID: "2", ProtocolMetadata: []byte("b")},
+	})
+	assignments := balancer.Balance(nil).IntoSyncAssignment()
+	if len(assignments) != 2 || string(assignments[1].MemberAssignment) != "a,b," {
+		t.Errorf("unexpected raw assignments %v", assignments)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cl.Close()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not close in time")
+	}
+}
+
+func TestGroupCustomProtocolAddTopicsManagesOnce(t *testing.T) {
+	cl, err := NewClient(
+		SeedBrokers("127.0.0.1:1"),
+		ConsumerGroup("g"),
+		GroupProtocol("raw"),
+		Balancers(&rawBalancer{"a"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	c := &cl.consumer
+	g := c.g
+	cl.AddConsumeTopics("t")
+
+	// Metadata finding the first topic for a group that is already
+	// managing (it had no topics to wait for) must not start a second
+	// manage goroutine.
+	c.mu.Lock()
+	next := g.tps.ensureTopics([]string{"t"})
+	next["t"].v.Store(&topicPartitionsData{partitions: []*topicPartition{{}}})
+	g.tps.storeData(next)
+	g.findNewAssignments()
+	c.mu.Unlock()
+
+	if n := cl.actors.snapshot()["group manager"]; n != 1 {
+		t.Errorf("got %d group managers, expected 1", n)
+	}
+}
+
+type heartbeatDeadlineHook struct {
+	group          string
+	since, timeout time.Duration
+}
+
+func (h *heartbeatDeadlineHook) OnGroupHeartbeatDeadline(group string, since, timeout time.Duration) {
+	h.group, h.since, h.timeout = group, since, timeout
+}
+
+func TestGroupHeartbeatDeadline(t *testing.T) {
+	h := new(heartbeatDeadlineHook)
+	cl, err := NewClient(
+		SeedBrokers("127.0.0.1:1"),
+		ConsumerGroup("g"),
+		ConsumeTopics("t"),
+		SessionTimeout(10*time.Second),
+		WithHooks(h),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	cl.consumer.g.heartbeatDeadline(5 * time.Second)
+	if h.group != "g" || h.since != 5*time.Second || h.timeout != 10*time.Second {
+		t.Errorf("got hook call (%s, %v, %v), exp (g, 5s, 10s)", h.group, h.since, h.timeout)
+	}
+}
+
+func TestMarkPartitionsReady(t *testing.T) {
+	if _, err := NewClient(SeedBrokers("127.0.0.1:1"), RequirePartitionsReady()); err == nil {
+		t.Error("expected error requiring partitions ready without a group")
+	}
+
+	cl, err := NewClient(
+		SeedBrokers("127.0.0.1:1"),
+		ConsumerGroup("g"),
+		ConsumeTopics("t"),
+		RequirePartitionsReady(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	c := &cl.consumer
+	c.gateUnready(map[string][]int32{"t": {0, 1, 2}})
+	cl.MarkPartitionsReady(map[string][]int32{"t": {1}, "u": {0}})
+	unready := c.loadUnready()
+	for _, check := range []struct {
+		partition int32
+		exp       bool
+	}{
+		{0, true},
+		{1, false},
+		{2, true},
+	} {
+		if got := unready.has("t", check.partition); got != check.exp {
+			t.Errorf("t[%d]: got unready %v != exp %v", check.partition, got, check.exp)
+		}
+	}
+
+	c.markReady(nil)
+	if unready := c.loadUnready(); len(unready) != 0 {
+		t.Errorf("expected nothing unready, got %v", unready.pausedPartitions())
+	}
+}
+
+func TestPrefetchStandbyPartitions(t *testing.T) {
+	if _, err := NewClient(SeedBrokers("127.0.0.1:1"), PrefetchStandbyPartitions()); err == nil {
+		t.Error("expected error prefetching standby partitions without a group")
+	}
+
+	cl, err := NewClient(
+		SeedBrokers("127.0.0.1:1"),
+		ConsumerGroup("g"),
+		ConsumeTopics("t"),
+		PrefetchStandbyPartitions(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	c := &cl.consumer
+	g := c.g
+
+	// Partition 0 was standby and is now assigned, partition 1 was
+	// standby and is gone, partition 2 stays standby, and partition 3 is
+	// newly standby. Partition 4 is published as standby but is assigned,
+	// so it is ignored.
+	g.standby = map[string]map[int32]struct{}{"t": {0: {}, 1: {}, 2: {}}}
+	g.nowAssigned = map[string][]int32{"t": {0, 4}}
+	g.syncStandby = map[string][]int32{"t": {2, 3, 4}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // we do not want to actually prefetch
+	<-g.setupStandby(ctx)
+
+	standby := c.loadStandby()
+	for _, check := range []struct {
+		partition int32
+		exp       bool
+	}{
+		{0, true},
+		{1, false},
+		{2, true},
+		{3, true},
+		{4, false},
+	} {
+		if got := standby.has("t", check.partition); got != check.exp {
+			t.Errorf("t[%d]: got standby %v != exp %v", check.partition, got, check.exp)
+		}
+	}
+
+	// Taking over partition 0, which was never positioned, assigns the
+	// fetched offset and stops gating the partition.
+	offsets := map[string]map[int32]Offset{"t": {0: NewOffset().At(10), 4: NewOffset().At(20)}}
+	c.mu.Lock()
+	g.mu.Lock()
+	assign := g.takeOverStandby(offsets)
+	g.mu.Unlock()
+	c.mu.Unlock()
+	if !reflect.DeepEqual(assign, offsets) {
+		t.Errorf("got assign %v != exp %v", assign, offsets)
+	}
+	standby = c.loadStandby()
+	if standby.has("t", 0) || !standby.has("t", 2) || !standby.has("t", 3) {
+		t.Errorf("unexpected standby after take over: %v", standby.pausedPartitions())
+	}
+}
+
+func TestCommitOffsetsForRecords(t *testing.T) {
+	got := CommitOffsetsForRecords(
+		&Record{Topic: "a", Partition: 0, LeaderEpoch: 1, Offset: 5},
+		&Record{Topic: "a", Partition: 0, LeaderEpoch: 1, Offset: 3},
+		&Record{Topic: "a", Partition: 1, LeaderEpoch: 2, Offset: 0},
+		&Record{Topic: "a", Partition: 1, LeaderEpoch: 1, Offset: 10}, // older epoch loses
+		&Record{Topic: "b", Partition: 0, LeaderEpoch: -1, Offset: 7},
+	)
+	exp := map[string]map[int32]EpochOffset{
+		"a": {
+			0: {1, 6},
+			1: {2, 1},
+		},
+		"b": {
+			0: {-1, 8},
+		},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("got %v != exp %v", got, exp)
+	}
+}
+
+type memOffsetStore struct {
+	mu     sync.Mutex
+	stored map[string]map[int32]EpochOffset
+}
+
+func (s *memOffsetStore) FetchOffsets(_ context.Context, _ string, partitions map[string][]int32) (map[string]map[int32]EpochOffset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fetched := make(map[string]map[int32]EpochOffset)
+	for topic, ps := range partitions {
+		for _, p := range ps {
+			if eo, ok := s.stored[topic][p]; ok {
+				if fetched[topic] == nil {
+					fetched[topic] = make(map[int32]EpochOffset)
+				}
+				fetched[topic][p] = eo
+			}
+		}
+	}
+	return fetched, nil
+}
+
+func (s *memOffsetStore) StoreOffsets(_ context.Context, _ string, offsets map[string]map[int32]EpochOffset) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for topic, ps := range offsets {
+		if s.stored[topic] == nil {
+			s.stored[topic] = make(map[int32]EpochOffset)
+		}
+		for p, eo := range ps {
+			s.stored[topic][p] = eo
+		}
+	}
+	return nil
+}
+
+func TestExternalOffsetStore(t *testing.T) {
+	store := &memOffsetStore{stored: map[string]map[int32]EpochOffset{"t": {0: {-1, 5}}}}
+	cl, err := NewClient(
+		SeedBrokers("127.0.0.1:1"),
+		ConsumerGroup("g"),
+		ConsumeTopics("t"),
+		ExternalOffsetStore(store),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	g := cl.consumer.g
+
+	stored, missing, err := g.fetchStoredOffsets(context.Background(), map[string][]int32{"t": {0, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := (map[string]map[int32]Offset{"t": {0: {at: 5, epoch: -1}}}); !reflect.DeepEqual(stored, exp) {
+		t.Errorf("got stored %v, expected %v", stored, exp)
+	}
+	if exp := (map[string][]int32{"t": {1}}); !reflect.DeepEqual(missing, exp) {
+		t.Errorf("got missing %v, expected %v", missing, exp)
+	}
+
+	// Commits go to the store, and the commit is tracked as successful
+	// without issuing a request to Kafka.
+	g.mu.Lock()
+	g.uncommitted = uncommitted{"t": {1: uncommit{head: EpochOffset{2, 9}}}}
+	done := make(chan error, 1)
+	g.commit(context.Background(), map[string]map[int32]EpochOffset{"t": {1: {2, 9}}}, func(_ *Client, req *kmsg.OffsetCommitRequest, resp *kmsg.OffsetCommitResponse, err error) {
+		done <- commitRespErr(req, resp, err)
+	})
+	g.mu.Unlock()
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected commit err: %v", err)
+	}
+	if got := store.stored["t"][1]; got != (EpochOffset{2, 9}) {
+		t.Errorf("got stored offset %v, expected 2/9", got)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if got := g.uncommitted["t"][1].committed; got != (EpochOffset{2, 9}) {
+		t.Errorf("got committed %v, expected 2/9", got)
+	}
+}
+
+func TestExternalOffsetStoreValidate(t *testing.T) {
+	if _, err := NewClient(ExternalOffsetStore(new(memOffsetStore))); err == nil {
+		t.Error("expected error with an external offset store and no group")
+	}
+	if _, err := NewClient(ConsumerGroup("g"), ConsumeTopics("t"), MirrorStoredOffsets()); err == nil {
+		t.Error("expected error mirroring stored offsets without a store")
+	}
+}
+
+// fakeBroker is a minimal broker that is its own group coordinator. Requests
+// other than ApiVersions, Metadata, and FindCoordinator are passed to handle,
+// which returns the response to write, or nil to close the connection.
+type fakeBroker struct {
+	ln     net.Listener
+	keys   map[int16]int16 // api key => max version
+	handle func(kmsg.Request) kmsg.Response
+}
+
+func newFakeBroker(t *testing.T, keys map[int16]int16, handle func(kmsg.Request) kmsg.Response) *fakeBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{ln: ln, keys: keys, handle: handle}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	host, portStr, _ := net.SplitHostPort(b.ln.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		buf := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		key, version := int16(binary.BigEndian.Uint16(buf)), int16(binary.BigEndian.Uint16(buf[2:]))
+		corrID := buf[4:8]
+		body := buf[8:]
+		clientIDLen := int16(binary.BigEndian.Uint16(body))
+		body = body[2:]
+		if clientIDLen > 0 {
+			body = body[clientIDLen:]
+		}
+
+		req := kmsg.RequestForKey(key)
+		req.SetVersion(version)
+		if req.IsFlexible() {
+			body = body[1:] // no request header tags
+		}
+		if err := req.ReadFrom(body); err != nil {
+			return
+		}
+
+		var resp kmsg.Response
+		switch req := req.(type) {
+		case *kmsg.ApiVersionsRequest:
+			r := req.ResponseKind().(*kmsg.ApiVersionsResponse)
+			for _, k := range []int16{kmsg.ApiVersions.Int16(), kmsg.Metadata.Int16(), kmsg.FindCoordinator.Int16()} {
+				r.ApiKeys = append(r.ApiKeys, kmsg.ApiVersionsResponseApiKey{ApiKey: k, MaxVersion: kmsg.RequestForKey(k).MaxVersion()})
+			}
+			for k, max := range b.keys {
+				r.ApiKeys = append(r.ApiKeys, kmsg.ApiVersionsResponseApiKey{ApiKey: k, MaxVersion: max})
+			}
+			resp = r
+		case *kmsg.MetadataRequest:
+			r := req.ResponseKind().(*kmsg.MetadataResponse)
+			r.Brokers = []kmsg.MetadataResponseBroker{{NodeID: 0, Host: host, Port: int32(port)}}
+			resp = r
+		case *kmsg.FindCoordinatorRequest:
+			r := req.ResponseKind().(*kmsg.FindCoordinatorResponse)
+			r.Host, r.Port = host, int32(port)
+			for _, k := range req.CoordinatorKeys {
+				r.Coordinators = append(r.Coordinators, kmsg.FindCoordinatorResponseCoordinator{Key: k, Host: host, Port: int32(port)})
+			}
+			resp = r
+		default:
+			if resp = b.handle(req); resp == nil {
+				return
+			}
+		}
+		resp.SetVersion(version)
+
+		out := append(make([]byte, 4), corrID...)
+		if resp.IsFlexible() && key != kmsg.ApiVersions.Int16() {
+			out = append(out, 0) // no response header tags
+		}
+		out = resp.AppendTo(out)
+		binary.BigEndian.PutUint32(out, uint32(len(out)-4))
+		if _, err := conn.Write(out); err != nil {
+			return
+		}
+	}
+}
+
+func TestGroupFencedStopSnapshot(t *testing.T) {
+	b := newFakeBroker(t, map[int16]int16{kmsg.JoinGroup.Int16(): 7}, func(req kmsg.Request) kmsg.Response {
+		if req, ok := req.(*kmsg.JoinGroupRequest); ok {
+			resp := req.ResponseKind().(*kmsg.JoinGroupResponse)
+			resp.ErrorCode = kerr.FencedInstanceID.Code
+			return resp
+		}
+		return nil
+	})
+	defer b.ln.Close()
+
+	cl, err := NewClient(
+		SeedBrokers(b.ln.Addr().String()),
+		ConsumerGroup("g"),
+		GroupProtocol("custom"), // join immediately, without topics
+		InstanceID("i"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// The fenced error is injected while polling is waiting, which must
+	// wake the poll.
+	fs := cl.PollFetches(ctx)
+	if errs := fs.Errors(); len(errs) != 1 || !errors.Is(errs[0].Err, kerr.FencedInstanceID) {
+		t.Fatalf("got poll errors %v, expected %v", errs, kerr.FencedInstanceID)
+	}
+
+	// Managing stops once fenced; the phase reflects that rather than
+	// remaining as recovering.
+	select {
+	case <-cl.consumer.g.manageDone:
+	case <-ctx.Done():
+		t.Fatal("group management did not stop after being fenced")
+	}
+	if s, _ := cl.GroupSnapshot(); s.Phase != "stopped" {
+		t.Errorf("got phase %q after being fenced, expected stopped", s.Phase)
+	}
+}
+
+func TestFetchOffsetsUnstable(t *testing.T) {
+	// Partition 1 has a pending transactional commit for the first two
+	// offset fetches that include it.
+	var unstableLeft int32 = 2
+	reqs := make(chan []int32, 4)
+	b := newFakeBroker(t, map[int16]int16{kmsg.OffsetFetch.Int16(): 7, kmsg.LeaveGroup.Int16(): 4}, func(req kmsg.Request) kmsg.Response {
+		r, ok := req.(*kmsg.OffsetFetchRequest)
+		if !ok {
+			if _, ok := req.(*kmsg.LeaveGroupRequest); ok {
+				return req.ResponseKind() // leaving when closing the client
+			}
+			return nil
+		}
+		resp := r.ResponseKind().(*kmsg.OffsetFetchResponse)
+		var requested []int32
+		for _, rt := range r.Topics {
+			respTopic := kmsg.NewOffsetFetchResponseTopic()
+			respTopic.Topic = rt.Topic
+			for _, p := range rt.Partitions {
+				requested = append(requested, p)
+				respPartition := kmsg.NewOffsetFetchResponseTopicPartition()
+				respPartition.Partition = p
+				respPartition.Offset = 10 + int64(p)
+				if p == 1 && atomic.AddInt32(&unstableLeft, -1) >= 0 {
+					respPartition.ErrorCode = kerr.UnstableOffsetCommit.Code
+				}
+				respTopic.Partitions = append(respTopic.Partitions, respPartition)
+			}
+			resp.Topics = append(resp.Topics, respTopic)
+		}
+		sort.Slice(requested, func(i, j int) bool { return requested[i] < requested[j] })
+		reqs <- requested
+		return resp
+	})
+	defer b.ln.Close()
+
+	cl, err := NewClient(
+		SeedBrokers(b.ln.Addr().String()),
+		ConsumerGroup("g"),
+		ConsumeTopics("t"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	g := cl.consumer.g
+
+	committed := func() map[int32]int64 {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		m := make(map[int32]int64)
+		for p, u := range g.uncommitted["t"] {
+			m[p] = u.committed.Offset
+		}
+		return m
+	}
+	expReq := func(exp []int32) {
+		t.Helper()
+		select {
+		case got := <-reqs:
+			if !reflect.DeepEqual(got, exp) {
+				t.Errorf("got offset fetch for partitions %v, expected %v", got, exp)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for offset fetch for partitions %v", exp)
+		}
+	}
+
+	// Partition 1 is unstable; partitions 0 and 2 are assigned right away,
+	// before the retry. Canceling while waiting to retry leaves only
+	// partition 1 to be fetched.
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- g.fetchOffsets(ctx, map[string][]int32{"t": {0, 1, 2}}, nil) }()
+	expReq([]int32{0, 1, 2})
+	for deadline := time.Now().Add(5 * time.Second); len(committed()) != 2; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("stable partitions not assigned while waiting to retry, committed: %v", committed())
+		}
+	}
+	if got, exp := committed(), map[int32]int64{0: 10, 2: 12}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("got committed %v, expected %v", got, exp)
+	}
+	cancel()
+	if err := <-errCh; err != context.Canceled {
+		t.Errorf("got err %v, expected %v", err, context.Canceled)
+	}
+	if exp := map[string]map[int32]struct{}{"t": {1: {}}}; !reflect.DeepEqual(g.fetching, exp) {
+		t.Errorf("got fetching %v after canceling, expected %v", g.fetching, exp)
+	}
+
+	// The next session resumes fetching partition 1 alongside what is
+	// newly added. Partition 1 is still unstable, so after assigning
+	// partition 3, only partition 1 is retried a second later.
+	start := time.Now()
+	if err := g.fetchOffsets(context.Background(), map[string][]int32{"t": {3}}, nil); err != nil {
+		t.Fatalf("unexpected fetch offsets err: %v", err)
+	}
+	expReq([]int32{1, 3})
+	expReq([]int32{1})
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried unstable offsets after %v, expected a one second wait", elapsed)
+	}
+	if g.fetching != nil {
+		t.Errorf("got fetching %v after fetching everything, expected nil", g.fetching)
+	}
+	if got, exp := committed(), map[int32]int64{0: 10, 1: 11, 2: 12, 3: 13}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("got committed %v, expected %v", got, exp)
+	}
+}
@@ -19,11 +19,22 @@ import (
 // to take time, then copy what you need and ensure the hook is async.
 type Hook interface{}
 
-type hooks []Hook
+type hooks struct {
+	hs     []Hook
+	slow   *callbackWatcher // non-nil if WarnSlowCallbacks is used
+	panics *panicRecoverer  // non-nil if RecoverCallbackPanics is used
+}
 
 func (hs hooks) each(fn func(Hook)) {
-	for _, h := range hs {
-		fn(h)
+	for _, h := range hs.hs {
+		if hs.slow == nil && hs.panics == nil {
+			fn(h)
+			continue
+		}
+		h := h
+		done := hs.slow.watch("hook", h)
+		hs.panics.run("hook", h, func() { fn(h) })
+		done()
 	}
 }
 
@@ -171,6 +182,16 @@ type HookBrokerThrottle interface {
 	OnBrokerThrottle(meta BrokerMetadata, throttleInterval time.Duration, throttledAfterResponse bool)
 }
 
+// HookThrottlePacing is called when the ThrottlePacing option is used and
+// the pace for produce or fetch requests to a broker changes.
+type HookThrottlePacing interface {
+	// OnThrottlePacing is passed the broker metadata, the key of the
+	// request that is paced (produce or fetch), and the new minimum
+	// interval between requests of that key to the broker. A pace of zero
+	// means that requests are no longer paced.
+	OnThrottlePacing(meta BrokerMetadata, key int16, pace time.Duration)
+}
+
 //////////
 // MISC //
 //////////
@@ -189,6 +210,62 @@ type HookGroupManageError interface {
 	OnGroupManageError(error)
 }
 
+// HookGroupEvicted is called when the client, operating as a group member, is
+// removed from its group; see GroupEviction for the possible reasons. This is
+// called before OnGroupFenced, if set.
+type HookGroupEvicted interface {
+	// OnGroupEvicted is passed why and from what the member was evicted.
+	OnGroupEvicted(GroupEviction)
+}
+
+// HookGroupHeartbeat is called after every heartbeat the client issues while
+// operating as a group member.
+type HookGroupHeartbeat interface {
+	// OnGroupHeartbeat is passed the round trip time, scheduling delay,
+	// and result of the heartbeat.
+	OnGroupHeartbeat(GroupHeartbeat)
+}
+
+// HookGroupHeartbeatDeadline is called when the client, operating as a group
+// member, has gone half of the group's session timeout without a successful
+// heartbeat. If the member goes the full session timeout without a successful
+// heartbeat, the group coordinator evicts it. This is called at most once
+// between successful heartbeats and is called even if a heartbeat request is
+// in flight.
+type HookGroupHeartbeatDeadline interface {
+	// OnGroupHeartbeatDeadline is passed the group, how long it has been
+	// since the last successful heartbeat or group join, and the session
+	// timeout.
+	OnGroupHeartbeatDeadline(group string, sinceLastSuccess, sessionTimeout time.Duration)
+}
+
+// HookCallbackPanic is called when the RecoverCallbackPanics option recovers
+// a panic in a user callback. This hook itself is not protected from panics.
+type HookCallbackPanic interface {
+	// OnCallbackPanic is passed the recovered panic.
+	OnCallbackPanic(*CallbackPanicError)
+}
+
+// HookConsumeTopicsCapped is called when consuming via regex and new topics
+// match after the MaxConsumeTopics limit has been reached, or when
+// AddConsumeTopics or AddConsumePartitions would exceed the limit.
+type HookConsumeTopicsCapped interface {
+	// OnConsumeTopicsCapped is passed the sorted topics that matched a
+	// regular expression or were being added but will not be consumed due
+	// to the limit.
+	OnConsumeTopicsCapped(skipped []string)
+}
+
+// HookFetchBackpressure is called when the FetchBackpressureBytes option
+// pauses or resumes fetching partitions.
+type HookFetchBackpressure interface {
+	// OnFetchBackpressure is passed the partitions that were just paused
+	// because too much is buffered, the partitions that were just resumed
+	// because their buffered records were drained, and the total bytes
+	// that are buffered. Only one of paused or resumed is non-nil.
+	OnFetchBackpressure(paused, resumed map[string][]int32, bufferedBytes int64)
+}
+
 ///////////////////////////////
 // PRODUCE & CONSUME BATCHES //
 ///////////////////////////////
@@ -235,6 +312,17 @@ type HookProduceBatchWritten interface {
 	OnProduceBatchWritten(meta BrokerMetadata, topic string, partition int32, metrics ProduceBatchMetrics)
 }
 
+// HookProduceCompressionChanged is called when the AdaptiveBatchCompression
+// option chooses a codec for a topic for the first time, or changes the
+// codec used for a topic.
+type HookProduceCompressionChanged interface {
+	// OnProduceCompressionChanged is passed the topic, the compression
+	// type that is now used for the topic (with the same values as
+	// ProduceBatchMetrics.CompressionType), and the ratio of compressed to
+	// uncompressed bytes in the sampled batch.
+	OnProduceCompressionChanged(topic string, compressionType uint8, ratio float64)
+}
+
 // FetchBatchMetrics tracks information about fetches of batches.
 type FetchBatchMetrics struct {
 	// NumRecords is the number of records that were fetched in this batch.
@@ -199,3 +199,50 @@ func testPlanUsage(t *testing.T, plan Plan, topics map[string]int32, unused []st
 		}
 	}
 }
+
+// testNoStealChain checks that no member could take a partition, possibly
+// through a chain of members handing partitions down, from a member that has
+// at least two more partitions. This only checks how even the plan is, not
+// how many partitions moved to get there.
+func testNoStealChain(t *testing.T, plan Plan, members []GroupMember, topics map[string]int32) {
+	t.Helper()
+
+	owners := make(map[topicPartition]string)
+	loads := make(map[string]int)
+	for member, topics := range plan {
+		for topic, partitions := range topics {
+			for _, partition := range partitions {
+				owners[topicPartition{topic, partition}] = member
+			}
+			loads[member] += len(partitions)
+		}
+	}
+	interests := make(map[string][]string)
+	for _, member := range members {
+		interests[member.ID] = member.Topics
+	}
+
+	for _, member := range members {
+		start := member.ID
+		seen := map[string]bool{start: true}
+		queue := []string{start}
+		for len(queue) > 0 {
+			at := queue[0]
+			queue = queue[1:]
+			if loads[at] > loads[start]+1 {
+				t.Errorf("member %s (%d partitions) can steal through a chain from %s (%d partitions)", start, loads[start], at, loads[at])
+				break
+			}
+			for _, topic := range interests[at] {
+				for partition := int32(0); partition < topics[topic]; partition++ {
+					owner, exists := owners[topicPartition{topic, partition}]
+					if !exists || seen[owner] {
+						continue
+					}
+					seen[owner] = true
+					queue = append(queue, owner)
+				}
+			}
+		}
+	}
+}
@@ -65,6 +65,134 @@ type balancer struct {
 	// stealGraph is a graphical representation of members and partitions
 	// they want to steal.
 	stealGraph graph
+
+	// onStep, if non-nil, is called for every partition move.
+	onStep func(Step)
+
+	// onUnassigned, if non-nil, is called with all partitions that no
+	// member can consume.
+	onUnassigned func(map[string][]int32)
+
+	// onConflicts, if non-nil, is called with all partitions that
+	// multiple members claimed in the same generation.
+	onConflicts func([]Conflict)
+}
+
+// StepKind is the reason a partition moved while balancing.
+type StepKind int8
+
+const (
+	// StepAssign is an unowned partition being assigned to the least
+	// loaded member that can consume it.
+	StepAssign StepKind = iota
+
+	// StepDrop is a partition being removed from a member that no longer
+	// wants the partition's topic.
+	StepDrop
+
+	// StepResticky is a partition moving back to the member that owned it
+	// in an older generation, because the newer owner is overloaded.
+	StepResticky
+
+	// StepBalance is a partition moving from a most loaded member to a
+	// least loaded member when all members consume the same topics.
+	StepBalance
+
+	// StepSteal is a partition moving along a steal path when members
+	// consume different topics.
+	StepSteal
+)
+
+func (k StepKind) String() string {
+	switch k {
+	case StepAssign:
+		return "assign"
+	case StepDrop:
+		return "drop"
+	case StepResticky:
+		return "resticky"
+	case StepBalance:
+		return "balance"
+	case StepSteal:
+		return "steal"
+	default:
+		return "unknown"
+	}
+}
+
+// Step is a single partition move decided while balancing.
+type Step struct {
+	Kind      StepKind
+	Topic     string
+	Partition int32
+	From      string // empty if the partition was not owned
+	To        string // empty if the partition was dropped
+}
+
+// Opt is an option to configure balancing.
+type Opt interface {
+	apply(*balancer)
+}
+
+type opt struct{ fn func(*balancer) }
+
+func (o opt) apply(b *balancer) { o.fn(b) }
+
+// OnStep sets a function to call for every partition move while balancing,
+// allowing a trace of why the returned plan looks the way it does.
+//
+// The function is called in the order decisions are made. A partition may
+// move multiple times in one balance.
+func OnStep(fn func(Step)) Opt {
+	return opt{func(b *balancer) { b.onStep = fn }}
+}
+
+// OnUnassigned sets a function to call with all topic partitions that could
+// not be assigned because no member is interested in the partition's topic.
+// The function is only called if there are such partitions, and the
+// partitions per topic are sorted.
+func OnUnassigned(fn func(map[string][]int32)) Opt {
+	return opt{func(b *balancer) { b.onUnassigned = fn }}
+}
+
+// Conflict is a partition that multiple members claimed to own in the same
+// generation, which can indicate a zombie member (see KIP-341).
+type Conflict struct {
+	Topic      string
+	Partition  int32
+	Generation int32    // the generation members claimed; -1 is reported as 0
+	Members    []string // the claiming members; the first is treated as the owner, the rest as stale
+}
+
+// OnConflicts sets a function to call with all partitions that multiple
+// members claimed in the same generation. The function is only called if
+// there are such partitions, and conflicts are sorted by topic and partition.
+//
+// Only conflicts in the newest generation claiming a partition are reported;
+// members claiming a partition from an older generation are expected to be
+// stale and are handled by restickying.
+func OnConflicts(fn func([]Conflict)) Opt {
+	return opt{func(b *balancer) { b.onConflicts = fn }}
+}
+
+// step calls onStep, if set, with the move of partNum from src to dst.
+func (b *balancer) step(kind StepKind, partNum int32, src, dst uint16) {
+	if b.onStep == nil {
+		return
+	}
+	info := b.topicInfos[b.partOwners[partNum]]
+	s := Step{
+		Kind:      kind,
+		Topic:     info.topic,
+		Partition: partNum - info.partNum,
+	}
+	if src != unassignedPart {
+		s.From = b.members[src].ID
+	}
+	if dst != unassignedPart {
+		s.To = b.members[dst].ID
+	}
+	b.onStep(s)
 }
 
 type topicInfo struct {
@@ -273,11 +401,14 @@ func (b *balancer) initPlanByNumPartitions() {
 
 // Balance performs sticky partitioning for the given group members and topics,
 // returning the determined plan.
-func Balance(members []GroupMember, topics map[string]int32) Plan {
+func Balance(members []GroupMember, topics map[string]int32, opts ...Opt) Plan {
 	if len(members) == 0 {
 		return make(Plan)
 	}
 	b := newBalancer(members, topics)
+	for _, opt := range opts {
+		opt.apply(b)
+	}
 	if cap(b.partOwners) == 0 {
 		return b.into()
 	}
@@ -301,6 +432,13 @@ func (b *balancer) parseMemberMetadata() {
 	var memberPlan []topicPartition
 	var gen uint32
 
+	// If we are reporting conflicts, we track every member claiming a
+	// partition in the partition's newest generation.
+	var conflicts map[int32][]uint16
+	if b.onConflicts != nil {
+		conflicts = make(map[int32][]uint16)
+	}
+
 	for _, member := range b.members {
 		resetSticky(&s)
 		memberPlan, gen = deserializeUserData(&s, member.UserData, memberPlan[:0])
@@ -315,6 +453,17 @@ func (b *balancer) parseMemberMetadata() {
 			// We keep the highest generation, and at most two generations.
 			// If something is doubly consumed, we skip it.
 			pcs := &partitionConsumersByGeneration[partNum]
+			if conflicts != nil {
+				switch {
+				case gen > pcs.genNew:
+					delete(conflicts, partNum)
+				case gen == pcs.genNew && memberNum != pcs.memberNew:
+					if len(conflicts[partNum]) == 0 {
+						conflicts[partNum] = append(conflicts[partNum], pcs.memberNew)
+					}
+					conflicts[partNum] = append(conflicts[partNum], memberNum)
+				}
+			}
 			switch {
 			case gen > pcs.genNew: // one consumer already, but new member has higher generation
 				pcs.memberOld, pcs.genOld = pcs.memberNew, pcs.genNew
@@ -334,6 +483,33 @@ func (b *balancer) parseMemberMetadata() {
 			}
 		}
 	}
+
+	if len(conflicts) > 0 {
+		b.reportConflicts(conflicts, partitionConsumersByGeneration, highBit)
+	}
+}
+
+func (b *balancer) reportConflicts(conflicts map[int32][]uint16, pcs []memberGeneration, highBit uint32) {
+	partNums := make([]int32, 0, len(conflicts))
+	for partNum := range conflicts {
+		partNums = append(partNums, partNum)
+	}
+	sort.Slice(partNums, func(i, j int) bool { return partNums[i] < partNums[j] })
+
+	report := make([]Conflict, 0, len(partNums))
+	for _, partNum := range partNums {
+		info := b.topicInfos[b.partOwners[partNum]]
+		c := Conflict{
+			Topic:      info.topic,
+			Partition:  partNum - info.partNum,
+			Generation: int32(pcs[partNum].genNew &^ highBit),
+		}
+		for _, memberNum := range conflicts[partNum] {
+			c.Members = append(c.Members, b.members[memberNum].ID)
+		}
+		report = append(report, c)
+	}
+	b.onConflicts(report)
 }
 
 type memberGeneration struct {
@@ -437,6 +613,7 @@ func (b *balancer) assignUnassignedAndInitGraph() {
 			topicNum := b.partOwners[partNum]
 			if len(topicPotentials[topicNum]) == 0 { // all prior subscriptions stopped wanting this partition
 				partNums.remove(partNum)
+				b.step(StepDrop, partNum, uint16(memberNum), unassignedPart)
 				continue
 			}
 			memberTopics := b.members[memberNum].Topics
@@ -449,6 +626,7 @@ func (b *balancer) assignUnassignedAndInitGraph() {
 			}
 			if !memberStillWantsTopic {
 				partNums.remove(partNum)
+				b.step(StepDrop, partNum, uint16(memberNum), unassignedPart)
 				continue
 			}
 			partitionConsumers[partNum] = partitionConsumer{uint16(memberNum), uint16(memberNum)}
@@ -460,18 +638,30 @@ func (b *balancer) assignUnassignedAndInitGraph() {
 		(&membersByPartitions{potentials, b.plan}).init()
 	}
 
+	var unassigned map[string][]int32
 	for partNum, owner := range partitionConsumers {
 		if owner.memberNum != unassignedPart {
 			continue
 		}
 		potentials := topicPotentials[b.partOwners[partNum]]
 		if len(potentials) == 0 {
+			if b.onUnassigned != nil {
+				if unassigned == nil {
+					unassigned = make(map[string][]int32)
+				}
+				info := b.topicInfos[b.partOwners[partNum]]
+				unassigned[info.topic] = append(unassigned[info.topic], int32(partNum)-info.partNum)
+			}
 			continue
 		}
 		assigned := potentials[0]
 		b.plan[assigned].add(int32(partNum))
 		(&membersByPartitions{potentials, b.plan}).fix0()
 		partitionConsumers[partNum].memberNum = assigned
+		b.step(StepAssign, int32(partNum), unassignedPart, assigned)
+	}
+	if len(unassigned) > 0 {
+		b.onUnassigned(unassigned)
 	}
 
 	// Lastly, with everything assigned, we build our steal graph for
@@ -497,8 +687,17 @@ func (b *balancer) tryRestickyStales(
 	topicPotentials [][]uint16,
 	partitionConsumers []partitionConsumer,
 ) {
-	for staleNum, lastOwnerNum := range b.stales {
-		potentials := topicPotentials[b.partOwners[staleNum]] // there must be a potential consumer if we are here
+	// We range over stales in partition order so that restickying is
+	// deterministic.
+	staleNums := make([]int32, 0, len(b.stales))
+	for staleNum := range b.stales {
+		staleNums = append(staleNums, staleNum)
+	}
+	sort.Slice(staleNums, func(i, j int) bool { return staleNums[i] < staleNums[j] })
+
+	for _, staleNum := range staleNums {
+		lastOwnerNum := b.stales[staleNum]
+		potentials := topicPotentials[b.partOwners[staleNum]]
 		var canTake bool
 		for _, potentialNum := range potentials {
 			if potentialNum == lastOwnerNum {
@@ -506,22 +705,30 @@ func (b *balancer) tryRestickyStales(
 			}
 		}
 		if !canTake {
-			return
+			continue
 		}
 
-		// The part cannot be unassigned here; a stale member
-		// would just have it. The part also cannot be deleted;
-		// if it is, there are no potential consumers and the
-		// logic above continues before getting here. The part
-		// must be on a different owner (cannot be lastOwner),
-		// otherwise it would not be a lastOwner in the stales
-		// map; it would just be the current owner.
+		// The part cannot be deleted; if it is, there are no potential
+		// consumers and canTake is false. The part may be unassigned if
+		// the newer owner no longer wants the topic, in which case we
+		// give it straight back to the stale member. Otherwise, the
+		// part must be on a different owner (cannot be lastOwner),
+		// otherwise it would not be a lastOwner in the stales map; it
+		// would just be the current owner.
 		currentOwner := partitionConsumers[staleNum].memberNum
 		lastOwnerPartitions := &b.plan[lastOwnerNum]
+		if currentOwner == unassignedPart {
+			lastOwnerPartitions.add(staleNum)
+			partitionConsumers[staleNum] = partitionConsumer{lastOwnerNum, lastOwnerNum}
+			b.step(StepResticky, staleNum, unassignedPart, lastOwnerNum)
+			continue
+		}
 		currentOwnerPartitions := &b.plan[currentOwner]
 		if lastOwnerPartitions.Len()+1 < currentOwnerPartitions.Len() {
 			currentOwnerPartitions.remove(staleNum)
 			lastOwnerPartitions.add(staleNum)
+			partitionConsumers[staleNum] = partitionConsumer{lastOwnerNum, lastOwnerNum}
+			b.step(StepResticky, staleNum, currentOwner, lastOwnerNum)
 		}
 	}
 }
@@ -607,7 +814,9 @@ func (b *balancer) balance() {
 			srcPartitions := &b.plan[src]
 			dstPartitions := &b.plan[dst]
 
-			dstPartitions.add(srcPartitions.takeEnd())
+			partNum := srcPartitions.takeEnd()
+			dstPartitions.add(partNum)
+			b.step(StepBalance, partNum, src, dst)
 		}
 
 		nextUp := b.findLevel(min.level + 1)
@@ -650,6 +859,7 @@ out:
 			if stealPath, found := b.stealGraph.findSteal(memberNum); found {
 				for _, segment := range stealPath {
 					b.reassignPartition(segment.src, segment.dst, segment.part)
+					b.step(StepSteal, segment.part, segment.src, segment.dst)
 				}
 				if len(max.members) == 0 {
 					continue out
@@ -3,9 +3,12 @@ package sticky
 import (
 	"fmt"
 	"math/rand"
+	"reflect"
 	"runtime"
 	"testing"
 	"time"
+
+	"github.com/twmb/franz-go/pkg/kmsg"
 )
 
 func Test_stickyBalanceStrategy_Plan(t *testing.T) {
@@ -1782,3 +1785,200 @@ func BenchmarkJava(b *testing.B) {
 		})
 	}
 }
+
+func TestOnStep(t *testing.T) {
+	t.Parallel()
+
+	replay := func(t *testing.T, members []GroupMember, topics map[string]int32, expKind StepKind) {
+		t.Helper()
+
+		owners := make(map[topicPartition]string)
+		for _, member := range members {
+			s := kmsg.NewStickyMemberMetadata()
+			prior, _ := deserializeUserData(&s, member.UserData, nil)
+			for _, tp := range prior {
+				if tp.partition < topics[tp.topic] {
+					owners[tp] = member.ID
+				}
+			}
+		}
+
+		var steps []Step
+		plan := Balance(members, topics, OnStep(func(s Step) { steps = append(steps, s) }))
+
+		var sawKind bool
+		for _, s := range steps {
+			sawKind = sawKind || s.Kind == expKind
+			tp := topicPartition{s.Topic, s.Partition}
+			if owner := owners[tp]; owner != s.From {
+				t.Fatalf("%s step for %s/%d from %q, but owner is %q", s.Kind, s.Topic, s.Partition, s.From, owner)
+			}
+			if s.To == "" {
+				delete(owners, tp)
+			} else {
+				owners[tp] = s.To
+			}
+		}
+
+		if !sawKind {
+			t.Errorf("did not see expected %s step", expKind)
+		}
+
+		exp := make(map[topicPartition]string)
+		for member, topics := range plan {
+			for topic, partitions := range topics {
+				for _, partition := range partitions {
+					exp[topicPartition{topic, partition}] = member
+				}
+			}
+		}
+		if len(exp) != len(owners) {
+			t.Fatalf("replayed %d owned partitions != exp %d", len(owners), len(exp))
+		}
+		for tp, member := range exp {
+			if owners[tp] != member {
+				t.Errorf("replayed %s/%d owned by %q != exp %q", tp.topic, tp.partition, owners[tp], member)
+			}
+		}
+	}
+
+	t.Run("drop_and_assign", func(t *testing.T) {
+		replay(t, []GroupMember{
+			{ID: "A", Topics: []string{"1"}, UserData: newUD().assign("1", 0, 1, 2).assign("2", 0, 1).encode()},
+			{ID: "B", Topics: []string{"1", "2"}},
+		}, map[string]int32{"1": 4, "2": 2}, StepDrop)
+	})
+	t.Run("balance", func(t *testing.T) {
+		members := append([]GroupMember{{ID: "new", Topics: largeWithExisting.members[0].Topics}}, largeWithExisting.members...)
+		replay(t, members, largeWithExisting.topics, StepBalance)
+	})
+	t.Run("steal", func(t *testing.T) {
+		members := append([]GroupMember{{ID: "new", Topics: []string{"topic0"}}}, largeWithExisting.members...)
+		replay(t, members, largeWithExisting.topics, StepSteal)
+	})
+}
+
+func TestOnUnassigned(t *testing.T) {
+	t.Parallel()
+
+	members := []GroupMember{
+		{ID: "A", Topics: []string{"1"}, UserData: newUD().assign("2", 1).encode()},
+		{ID: "B", Topics: []string{"1", "3"}},
+	}
+	topics := map[string]int32{"1": 2, "2": 3, "3": 1, "4": 2}
+
+	var unassigned map[string][]int32
+	plan := Balance(members, topics, OnUnassigned(func(m map[string][]int32) { unassigned = m }))
+	testPlanUsage(t, plan, topics, []string{"2", "4"})
+
+	exp := map[string][]int32{
+		"2": {0, 1, 2},
+		"4": {0, 1},
+	}
+	if !reflect.DeepEqual(unassigned, exp) {
+		t.Errorf("got unassigned %v != exp %v", unassigned, exp)
+	}
+
+	unassigned = nil
+	Balance(members, map[string]int32{"1": 2, "3": 1}, OnUnassigned(func(m map[string][]int32) { unassigned = m }))
+	if unassigned != nil {
+		t.Errorf("got unexpected unassigned %v", unassigned)
+	}
+}
+
+func TestBalanceRandomNoStealChain(t *testing.T) {
+	t.Parallel()
+
+	rng := rand.New(rand.NewSource(0))
+	for i := 0; i < 1000; i++ {
+		topics := make(map[string]int32)
+		var allTopics []string
+		for j, ntopics := 0, 1+rng.Intn(6); j < ntopics; j++ {
+			topic := fmt.Sprintf("t%d", j)
+			topics[topic] = int32(1 + rng.Intn(12))
+			allTopics = append(allTopics, topic)
+		}
+
+		var members []GroupMember
+		for j, nmembers := 0, 1+rng.Intn(8); j < nmembers; j++ {
+			var interests []string
+			for _, topic := range allTopics {
+				if rng.Intn(2) == 0 {
+					interests = append(interests, topic)
+				}
+			}
+			members = append(members, GroupMember{ID: fmt.Sprintf("m%d", j), Topics: interests})
+		}
+
+		// Balance once, then perturb the members' prior plans by moving
+		// some partitions around and changing some interests, and
+		// balance again.
+		prior := Balance(members, topics)
+		for j := range members {
+			assignments := prior[members[j].ID]
+			if rng.Intn(3) == 0 && len(members) > 1 {
+				other := prior[members[rng.Intn(len(members))].ID]
+				for topic, partitions := range other {
+					assignments[topic] = append(assignments[topic], partitions...)
+				}
+			}
+			members[j].UserData = udEncode(1, 1+rng.Intn(2), assignments)
+			if rng.Intn(4) == 0 {
+				members[j].Topics = allTopics[:rng.Intn(len(allTopics)+1)]
+			}
+		}
+
+		plan := Balance(members, topics)
+		testPlanUsage(t, plan, topics, nil)
+		testNoStealChain(t, plan, members, topics)
+		if t.Failed() {
+			t.Fatalf("failed on iteration %d", i)
+		}
+	}
+}
+
+func TestStalesNewerOwnerDropsTopic(t *testing.T) {
+	t.Parallel()
+
+	// B claims 1/0 in a newer generation than A, but B no longer wants
+	// topic 1. A should get 1/0 back. C claims 2/0 in an older generation
+	// than B; C no longer wants topic 2, which must not stop A from
+	// restickying.
+	topics := map[string]int32{"1": 2, "2": 1}
+	members := []GroupMember{
+		{ID: "A", Topics: []string{"1"}, UserData: newUD().assign("1", 0).setGeneration(1).encode()},
+		{ID: "B", Topics: []string{"2"}, UserData: newUD().assign("1", 0).assign("2", 0).setGeneration(2).encode()},
+		{ID: "C", Topics: []string{"1"}, UserData: newUD().assign("2", 0).setGeneration(1).encode()},
+	}
+
+	plan := Balance(members, topics)
+	testPlanUsage(t, plan, topics, nil)
+	if got := plan["A"]["1"]; len(got) != 1 || got[0] != 0 {
+		t.Errorf("A did not resticky 1/0, got %v", plan["A"])
+	}
+}
+
+func TestOnConflicts(t *testing.T) {
+	t.Parallel()
+
+	topics := map[string]int32{"1": 4, "2": 1}
+	members := []GroupMember{
+		{ID: "A", Topics: []string{"1", "2"}, UserData: newUD().assign("1", 0, 1).assign("2", 0).setGeneration(2).encode()},
+		{ID: "B", Topics: []string{"1", "2"}, UserData: newUD().assign("1", 0, 2).setGeneration(2).encode()},
+		{ID: "C", Topics: []string{"1", "2"}, UserData: newUD().assign("1", 0, 1, 3).setGeneration(2).encode()},
+		{ID: "D", Topics: []string{"1", "2"}, UserData: newUD().assign("2", 0).setGeneration(1).encode()}, // stale, not a conflict
+		{ID: "E", Topics: []string{"1", "2"}, UserData: newUD().assign("1", 3).setGeneration(3).encode()}, // newer, overrides C
+	}
+
+	var conflicts []Conflict
+	plan := Balance(members, topics, OnConflicts(func(c []Conflict) { conflicts = c }))
+	testPlanUsage(t, plan, topics, nil)
+
+	exp := []Conflict{
+		{Topic: "1", Partition: 0, Generation: 2, Members: []string{"A", "B", "C"}},
+		{Topic: "1", Partition: 1, Generation: 2, Members: []string{"A", "C"}},
+	}
+	if !reflect.DeepEqual(conflicts, exp) {
+		t.Errorf("got conflicts %v != exp %v", conflicts, exp)
+	}
+}
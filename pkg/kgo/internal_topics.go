@@ -0,0 +1,129 @@
+package kgo
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/twmb/franz-go/pkg/kbin"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// ErrUnknownKeyVersion is returned when decoding a record from one of Kafka's
+// internal topics if the key begins with a version that this client does not
+// know. Newer brokers may write new record types to internal topics, and
+// these records can usually be skipped.
+var ErrUnknownKeyVersion = errors.New("unknown internal topic key version")
+
+// internalKeyVersion returns the int16 version that all keys in Kafka's
+// internal topics begin with.
+func internalKeyVersion(key []byte) (int16, error) {
+	b := kbin.Reader{Src: key}
+	version := b.Int16()
+	if err := b.Complete(); err != nil {
+		return 0, fmt.Errorf("unable to read key version: %w", err)
+	}
+	return version, nil
+}
+
+// ConsumerOffsetsRecord is a decoded record from Kafka's internal
+// __consumer_offsets topic, as returned from ParseConsumerOffsetsRecord.
+//
+// Exactly one of OffsetCommitKey or GroupMetadataKey is non-nil. The
+// corresponding value is nil if the record is a tombstone, meaning the commit
+// was deleted or expired, or the group was deleted.
+type ConsumerOffsetsRecord struct {
+	// OffsetCommitKey is the key of an offset commit, which is written
+	// for every committed partition.
+	OffsetCommitKey *kmsg.OffsetCommitKey
+	// OffsetCommitValue is the committed offset for the key.
+	OffsetCommitValue *kmsg.OffsetCommitValue
+
+	// GroupMetadataKey is the key of a group's metadata, which is written
+	// whenever a group's generation or membership changes.
+	GroupMetadataKey *kmsg.GroupMetadataKey
+	// GroupMetadataValue is the group's state, members, and assignments.
+	GroupMetadataValue *kmsg.GroupMetadataValue
+}
+
+// ParseConsumerOffsetsRecord decodes the key and value of a record consumed
+// from Kafka's internal __consumer_offsets topic. This can be used to track
+// group commits in real time rather than periodically issuing OffsetFetch
+// requests.
+//
+// Keys with versions 0 and 1 are offset commits, and keys with version 2 are
+// group metadata. For any other key version, this returns an error wrapping
+// ErrUnknownKeyVersion.
+func ParseConsumerOffsetsRecord(r *Record) (ConsumerOffsetsRecord, error) {
+	var c ConsumerOffsetsRecord
+	version, err := internalKeyVersion(r.Key)
+	if err != nil {
+		return c, err
+	}
+	switch version {
+	case 0, 1:
+		c.OffsetCommitKey = new(kmsg.OffsetCommitKey)
+		if err := c.OffsetCommitKey.ReadFrom(r.Key); err != nil {
+			return c, fmt.Errorf("unable to decode offset commit key: %w", err)
+		}
+		if r.Value != nil {
+			c.OffsetCommitValue = new(kmsg.OffsetCommitValue)
+			if err := c.OffsetCommitValue.ReadFrom(r.Value); err != nil {
+				return c, fmt.Errorf("unable to decode offset commit value: %w", err)
+			}
+		}
+	case 2:
+		c.GroupMetadataKey = new(kmsg.GroupMetadataKey)
+		if err := c.GroupMetadataKey.ReadFrom(r.Key); err != nil {
+			return c, fmt.Errorf("unable to decode group metadata key: %w", err)
+		}
+		if r.Value != nil {
+			c.GroupMetadataValue = new(kmsg.GroupMetadataValue)
+			if err := c.GroupMetadataValue.ReadFrom(r.Value); err != nil {
+				return c, fmt.Errorf("unable to decode group metadata value: %w", err)
+			}
+		}
+	default:
+		return c, fmt.Errorf("__consumer_offsets key version %d: %w", version, ErrUnknownKeyVersion)
+	}
+	return c, nil
+}
+
+// TransactionStateRecord is a decoded record from Kafka's internal
+// __transaction_state topic, as returned from ParseTransactionStateRecord.
+type TransactionStateRecord struct {
+	// Key contains the transactional ID this record is for.
+	Key kmsg.TxnMetadataKey
+
+	// Value is the transactional ID's producer ID and epoch, transaction
+	// timeout, state, and the partitions in the current transaction. This
+	// is nil if the record is a tombstone, meaning the transactional ID
+	// expired.
+	Value *kmsg.TxnMetadataValue
+}
+
+// ParseTransactionStateRecord decodes the key and value of a record consumed
+// from Kafka's internal __transaction_state topic. This can be used to inspect
+// transactional IDs, their states, and their timeouts.
+//
+// Keys with version 0 are transaction metadata. For any other key version,
+// this returns an error wrapping ErrUnknownKeyVersion.
+func ParseTransactionStateRecord(r *Record) (TransactionStateRecord, error) {
+	var t TransactionStateRecord
+	version, err := internalKeyVersion(r.Key)
+	if err != nil {
+		return t, err
+	}
+	if version != 0 {
+		return t, fmt.Errorf("__transaction_state key version %d: %w", version, ErrUnknownKeyVersion)
+	}
+	if err := t.Key.ReadFrom(r.Key); err != nil {
+		return t, fmt.Errorf("unable to decode transaction metadata key: %w", err)
+	}
+	if r.Value != nil {
+		t.Value = new(kmsg.TxnMetadataValue)
+		if err := t.Value.ReadFrom(r.Value); err != nil {
+			return t, fmt.Errorf("unable to decode transaction metadata value: %w", err)
+		}
+	}
+	return t, nil
+}
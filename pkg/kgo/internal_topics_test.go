@@ -0,0 +1,136 @@
+package kgo
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+func TestParseConsumerOffsetsRecord(t *testing.T) {
+	commitKey := kmsg.NewOffsetCommitKey()
+	commitKey.Version = 1
+	commitKey.Group = "g"
+	commitKey.Topic = "t"
+	commitKey.Partition = 3
+	commitValue := kmsg.NewOffsetCommitValue()
+	commitValue.Version = 3
+	commitValue.Offset = 100
+	commitValue.LeaderEpoch = 2
+	commitValue.Metadata = "meta"
+
+	groupKey := kmsg.NewGroupMetadataKey()
+	groupKey.Version = 2
+	groupKey.Group = "g"
+	groupValue := kmsg.NewGroupMetadataValue()
+	groupValue.Version = 3
+	groupValue.ProtocolType = "consumer"
+	groupValue.Generation = 5
+	member := kmsg.NewGroupMetadataValueMember()
+	member.MemberID = "m"
+	member.Subscription = []byte{1}
+	member.Assignment = []byte{2}
+	groupValue.Members = []kmsg.GroupMetadataValueMember{member}
+
+	unknownKey := kmsg.NewGroupMetadataKey()
+	unknownKey.Version = 100
+
+	for _, test := range []struct {
+		name   string
+		r      *Record
+		exp    ConsumerOffsetsRecord
+		expErr error
+	}{
+		{
+			name: "offset commit",
+			r:    &Record{Key: commitKey.AppendTo(nil), Value: commitValue.AppendTo(nil)},
+			exp:  ConsumerOffsetsRecord{OffsetCommitKey: &commitKey, OffsetCommitValue: &commitValue},
+		},
+		{
+			name: "offset commit tombstone",
+			r:    &Record{Key: commitKey.AppendTo(nil)},
+			exp:  ConsumerOffsetsRecord{OffsetCommitKey: &commitKey},
+		},
+		{
+			name: "group metadata",
+			r:    &Record{Key: groupKey.AppendTo(nil), Value: groupValue.AppendTo(nil)},
+			exp:  ConsumerOffsetsRecord{GroupMetadataKey: &groupKey, GroupMetadataValue: &groupValue},
+		},
+		{
+			name:   "unknown version",
+			r:      &Record{Key: unknownKey.AppendTo(nil)},
+			expErr: ErrUnknownKeyVersion,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseConsumerOffsetsRecord(test.r)
+			if !errors.Is(err, test.expErr) {
+				t.Fatalf("got err %v != exp %v", err, test.expErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(test.exp, got) {
+				t.Errorf("got %#v != exp %#v", got, test.exp)
+			}
+		})
+	}
+
+	if _, err := ParseConsumerOffsetsRecord(&Record{Key: []byte{0}}); err == nil {
+		t.Error("expected error decoding truncated key")
+	}
+	if _, err := ParseConsumerOffsetsRecord(&Record{Key: commitKey.AppendTo(nil), Value: []byte{0, 3}}); err == nil {
+		t.Error("expected error decoding truncated value")
+	}
+}
+
+func TestParseTransactionStateRecord(t *testing.T) {
+	key := kmsg.NewTxnMetadataKey()
+	key.TransactionalID = "txn"
+	value := kmsg.NewTxnMetadataValue()
+	value.ProducerID = 7
+	value.ProducerEpoch = 1
+	value.TimeoutMillis = 60000
+	value.State = kmsg.TransactionStateOngoing
+	value.Topics = []kmsg.TxnMetadataValueTopic{{Topic: "t", Partitions: []int32{0, 2}}}
+
+	unknownKey := kmsg.NewTxnMetadataKey()
+	unknownKey.Version = 1
+
+	for _, test := range []struct {
+		name   string
+		r      *Record
+		exp    TransactionStateRecord
+		expErr error
+	}{
+		{
+			name: "metadata",
+			r:    &Record{Key: key.AppendTo(nil), Value: value.AppendTo(nil)},
+			exp:  TransactionStateRecord{Key: key, Value: &value},
+		},
+		{
+			name: "tombstone",
+			r:    &Record{Key: key.AppendTo(nil)},
+			exp:  TransactionStateRecord{Key: key},
+		},
+		{
+			name:   "unknown version",
+			r:      &Record{Key: unknownKey.AppendTo(nil)},
+			expErr: ErrUnknownKeyVersion,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseTransactionStateRecord(test.r)
+			if !errors.Is(err, test.expErr) {
+				t.Fatalf("got err %v != exp %v", err, test.expErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(test.exp, got) {
+				t.Errorf("got %#v != exp %#v", got, test.exp)
+			}
+		})
+	}
+}
@@ -22,6 +22,11 @@ import (
 // causes a sleep before proceeding into a metadata request.
 const minRefreshTrigger = 5 * time.Second / 2
 
+// maxUnconfirmedLeaderTries is how many consecutive metadata updates can move a
+// partition leader without bumping the leader epoch before we accept the move
+// even if our current leader has not confirmed it.
+const maxUnconfirmedLeaderTries = 3
+
 type metawait struct {
 	mu         sync.Mutex
 	c          *sync.Cond
@@ -200,6 +205,8 @@ func (cl *Client) updateMetadata() (needsRetry bool, err error, why multiUpdateW
 	defer cl.metawait.signal()
 	defer cl.consumer.doOnMetadataUpdate()
 
+	cl.evictIdleProduceTopics()
+
 	var (
 		tpsProducerLoad = cl.producer.topics.load()
 		tpsConsumer     *topicsPartitions
@@ -249,7 +256,7 @@ func (cl *Client) updateMetadata() (needsRetry bool, err error, why multiUpdateW
 			allTopics = append(allTopics, topic)
 		}
 		tpsConsumerLoad = tpsConsumer.ensureTopics(allTopics)
-		defer tpsConsumer.storeData(tpsConsumerLoad)
+		defer func() { tpsConsumer.storeData(tpsConsumerLoad) }()
 	}
 
 	// Migrating a cursor requires stopping any consumer session. If we
@@ -278,7 +285,7 @@ func (cl *Client) updateMetadata() (needsRetry bool, err error, why multiUpdateW
 		}
 	}()
 
-	var missingProduceTopics []string
+	var missingProduceTopics, deletedConsumeTopics []string
 	for _, m := range []struct {
 		priors    map[string]*topicPartitions
 		isProduce bool
@@ -291,6 +298,10 @@ func (cl *Client) updateMetadata() (needsRetry bool, err error, why multiUpdateW
 			if !exists {
 				if m.isProduce {
 					missingProduceTopics = append(missingProduceTopics, topic)
+				} else if all && cl.cfg.regexRemoveDeleted {
+					// When loading all topics, a topic
+					// that is not returned was deleted.
+					deletedConsumeTopics = append(deletedConsumeTopics, topic)
 				}
 				continue
 			}
@@ -312,6 +323,16 @@ func (cl *Client) updateMetadata() (needsRetry bool, err error, why multiUpdateW
 			missingProduceTopics...,
 		)
 	}
+	if len(deletedConsumeTopics) > 0 {
+		purged := make(topicsPartitionsData, len(tpsConsumerLoad))
+		for topic, parts := range tpsConsumerLoad {
+			purged[topic] = parts
+		}
+		for _, topic := range deletedConsumeTopics {
+			delete(purged, topic)
+		}
+		tpsConsumerLoad = purged
+	}
 
 	return needsRetry, nil, why
 }
@@ -468,6 +489,9 @@ func (cl *Client) mergeTopicPartitions(
 	// store the update.
 	if isProduce {
 		hadPartitions := len(lv.partitions) != 0
+		if !hadPartitions && r.loadErr == nil && cl.lockEvictedSeqs(topic, r.partitions) {
+			defer cl.producer.evictedSeqsMu.Unlock()
+		}
 		defer func() { cl.storePartitionsUpdate(topic, l, &lv, hadPartitions) }()
 	} else {
 		defer l.v.Store(&lv)
@@ -571,6 +595,49 @@ func (cl *Client) mergeTopicPartitions(
 			continue
 		}
 
+		// Every leader change bumps the leader epoch. If the leader
+		// changed but the epoch did not, brokers disagree on who the
+		// leader is, which can happen briefly while leadership is
+		// moving. Rather than ping-ponging produce and fetch requests
+		// between brokers (and potentially causing sequence errors),
+		// we keep our current leader and retry the update soon.
+		//
+		// We accept the move once our current leader has told us it
+		// is no longer the leader, or after a few consecutive updates
+		// still report the move: a broker that missed the epoch bump
+		// will not be corrected by waiting forever.
+		//
+		// Brokers that do not support leader epochs always return
+		// -1, in which case we have no choice but to trust the update.
+		if newTP.leaderEpoch == oldTP.leaderEpoch && newTP.leaderEpoch >= 0 && newTP.leader != oldTP.leader {
+			confirmed := oldTP.leaderMoveConfirmed()
+			if !confirmed && oldTP.unconfirmedTries < maxUnconfirmedLeaderTries {
+				cl.cfg.logger.Log(LogLevelInfo, "metadata leader changed without a leader epoch bump, keeping the current leader until the move is confirmed",
+					"topic", topic,
+					"partition", part,
+					"current_leader", oldTP.leader,
+					"update_leader", newTP.leader,
+					"leader_epoch", oldTP.leaderEpoch,
+					"tries", oldTP.unconfirmedTries+1,
+				)
+
+				*newTP = *oldTP
+				newTP.unconfirmedTries++
+				needsRetry = true
+				why.add(topic, int32(part), errUnconfirmedLeaderChange)
+				continue
+			}
+
+			cl.cfg.logger.Log(LogLevelInfo, "accepting metadata leader change without a leader epoch bump",
+				"topic", topic,
+				"partition", part,
+				"current_leader", oldTP.leader,
+				"update_leader", newTP.leader,
+				"leader_epoch", oldTP.leaderEpoch,
+				"confirmed_by_leader", confirmed,
+			)
+		}
+
 		// If the tp data is the same, we simply copy over the records
 		// and cursor pointers.
 		//
@@ -0,0 +1,110 @@
+package kgo
+
+import "testing"
+
+func TestMergeTopicPartitionsLeaderFlap(t *testing.T) {
+	cl := &Client{cfg: defaultCfg()}
+
+	current := &topicPartition{
+		topicPartitionData: topicPartitionData{leader: 1, leaderEpoch: 5},
+		cursor:             &cursor{cursorsIdx: 0},
+	}
+	l := newTopicPartitions()
+	l.v.Store(&topicPartitionsData{
+		partitions:         []*topicPartition{current},
+		writablePartitions: []*topicPartition{current},
+	})
+
+	// A broker claims leadership at the same epoch: we keep our current
+	// leader and retry the update.
+	update := &topicPartition{
+		topicPartitionData: topicPartitionData{leader: 2, leaderEpoch: 5},
+		cursor:             &cursor{cursorsIdx: -1},
+	}
+	r := &topicPartitionsData{
+		partitions:         []*topicPartition{update},
+		writablePartitions: []*topicPartition{update},
+	}
+
+	var why multiUpdateWhy
+	needsRetry := cl.mergeTopicPartitions("t", l, r, false, new(listOrEpochLoads), func() {
+		t.Error("unexpectedly stopped the consumer session")
+	}, &why)
+
+	if !needsRetry {
+		t.Error("expected the update to be retried")
+	}
+	if len(why) == 0 {
+		t.Error("expected a reason for retrying the update")
+	}
+	got := l.load().partitions[0]
+	if got.leader != 1 || got.leaderEpoch != 5 || got.cursor != current.cursor {
+		t.Errorf("got leader %d epoch %d, expected to keep leader 1 epoch 5 and the current cursor", got.leader, got.leaderEpoch)
+	}
+}
+
+func TestMergeTopicPartitionsLeaderFlapAccepted(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		confirmed bool
+		keeps     int
+	}{
+		{"bounded", false, maxUnconfirmedLeaderTries},
+		{"confirmed", true, 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cl := &Client{cfg: defaultCfg()}
+
+			oldSource := &source{nodeID: 1}
+			newSource := &source{nodeID: 2}
+			newSource.fetchState.state = stateWorking // do not spawn a fetch loop
+
+			c := &cursor{source: oldSource, topicPartitionData: topicPartitionData{leader: 1, leaderEpoch: 5}}
+			c.lastConsumedEpoch = -1
+			c.leaderMoved.set(test.confirmed)
+			oldSource.cursors = []*cursor{c}
+
+			current := &topicPartition{topicPartitionData: c.topicPartitionData, cursor: c}
+			l := newTopicPartitions()
+			l.v.Store(&topicPartitionsData{
+				partitions:         []*topicPartition{current},
+				writablePartitions: []*topicPartition{current},
+			})
+
+			merge := func() bool {
+				update := &topicPartition{
+					topicPartitionData: topicPartitionData{leader: 2, leaderEpoch: 5},
+					cursor:             &cursor{source: newSource, cursorsIdx: -1},
+				}
+				r := &topicPartitionsData{
+					partitions:         []*topicPartition{update},
+					writablePartitions: []*topicPartition{update},
+				}
+				var why multiUpdateWhy
+				needsRetry := cl.mergeTopicPartitions("t", l, r, false, new(listOrEpochLoads), func() {}, &why)
+				l.v.Store(r)
+				return needsRetry
+			}
+
+			for i := 0; i < test.keeps; i++ {
+				if !merge() {
+					t.Fatalf("update %d: expected the update to be retried", i)
+				}
+				if got := l.load().partitions[0]; got.leader != 1 || got.unconfirmedTries != i+1 {
+					t.Fatalf("update %d: got leader %d tries %d, expected leader 1 tries %d", i, got.leader, got.unconfirmedTries, i+1)
+				}
+			}
+
+			if merge() {
+				t.Error("expected the move to be accepted without a retry")
+			}
+			got := l.load().partitions[0]
+			if got.leader != 2 || got.cursor != c || c.source != newSource || c.leader != 2 {
+				t.Errorf("got leader %d, cursor leader %d, expected the cursor to move to leader 2", got.leader, c.leader)
+			}
+			if c.leaderMoved.get() {
+				t.Error("expected the migration to clear the leader moved confirmation")
+			}
+		})
+	}
+}
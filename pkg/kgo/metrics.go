@@ -0,0 +1,238 @@
+package kgo
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TopicMetrics are counters for a single topic, as returned from
+// Client.TopicMetrics. Counters only increase over the lifetime of a client.
+type TopicMetrics struct {
+	// ProducedRecords is the number of records successfully produced.
+	ProducedRecords int64
+	// ProducedBytes is the user size (key, value, and headers) of records
+	// successfully produced.
+	ProducedBytes int64
+	// ProduceErrors is the number of records that failed to be produced.
+	ProduceErrors int64
+
+	// ConsumedRecords is the number of records returned from polling.
+	ConsumedRecords int64
+	// ConsumedBytes is the user size (key, value, and headers) of records
+	// returned from polling.
+	ConsumedBytes int64
+	// MaxConsumeLag is the largest lag across partitions of this topic, as
+	// of the most recent poll of each partition: the high watermark minus
+	// the offset after the last polled record. Partitions that are revoked
+	// or lost are no longer included. This is -1 if no records have been
+	// polled for any partition of the topic that is still consumed.
+	MaxConsumeLag int64
+}
+
+// topicMetrics tracks the counters for a topic. Counters are updated
+// atomically; lags are guarded by lagMu.
+type topicMetrics struct {
+	producedRecords int64
+	producedBytes   int64
+	produceErrors   int64
+	consumedRecords int64
+	consumedBytes   int64
+
+	lagMu sync.Mutex
+	lags  map[int32]int64
+}
+
+type clientMetrics struct {
+	mu     sync.RWMutex
+	topics map[string]*topicMetrics
+
+	txnMu sync.Mutex
+	txn   TransactionMetrics
+}
+
+func (m *clientMetrics) topic(topic string) *topicMetrics {
+	m.mu.RLock()
+	t := m.topics[topic]
+	m.mu.RUnlock()
+	if t != nil {
+		return t
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t = m.topics[topic]; t == nil {
+		if m.topics == nil {
+			m.topics = make(map[string]*topicMetrics)
+		}
+		t = new(topicMetrics)
+		m.topics[topic] = t
+	}
+	return t
+}
+
+// observeProduced is called when a record is finished being produced.
+func (m *clientMetrics) observeProduced(topic string, size int64, err error) {
+	t := m.topic(topic)
+	if err != nil {
+		atomic.AddInt64(&t.produceErrors, 1)
+		return
+	}
+	atomic.AddInt64(&t.producedRecords, 1)
+	atomic.AddInt64(&t.producedBytes, size)
+}
+
+// observePolled is called with fetches that are about to be returned from
+// polling.
+func (m *clientMetrics) observePolled(fetches Fetches) {
+	for i := range fetches {
+		for j := range fetches[i].Topics {
+			ft := &fetches[i].Topics[j]
+			var t *topicMetrics
+			for k := range ft.Partitions {
+				p := &ft.Partitions[k]
+				if len(p.Records) == 0 {
+					continue
+				}
+				if t == nil {
+					t = m.topic(ft.Topic)
+				}
+				var nbytes int64
+				for _, r := range p.Records {
+					nbytes += r.userSize()
+				}
+				atomic.AddInt64(&t.consumedRecords, int64(len(p.Records)))
+				atomic.AddInt64(&t.consumedBytes, nbytes)
+
+				if p.HighWatermark < 0 {
+					continue
+				}
+				lag := p.HighWatermark - (p.Records[len(p.Records)-1].Offset + 1)
+				if lag < 0 {
+					lag = 0
+				}
+				t.lagMu.Lock()
+				if t.lags == nil {
+					t.lags = make(map[int32]int64)
+				}
+				t.lags[p.Partition] = lag
+				t.lagMu.Unlock()
+			}
+		}
+	}
+}
+
+// forgetLags deletes the lags for partitions that were revoked or lost, so
+// that MaxConsumeLag does not keep reporting partitions that are no longer
+// consumed.
+func (m *clientMetrics) forgetLags(partitions map[string][]int32) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for topic, ps := range partitions {
+		t := m.topics[topic]
+		if t == nil {
+			continue
+		}
+		t.lagMu.Lock()
+		for _, p := range ps {
+			delete(t.lags, p)
+		}
+		t.lagMu.Unlock()
+	}
+}
+
+func (m *clientMetrics) snapshot() map[string]TopicMetrics {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s := make(map[string]TopicMetrics, len(m.topics))
+	for topic, t := range m.topics {
+		tm := TopicMetrics{
+			ProducedRecords: atomic.LoadInt64(&t.producedRecords),
+			ProducedBytes:   atomic.LoadInt64(&t.producedBytes),
+			ProduceErrors:   atomic.LoadInt64(&t.produceErrors),
+			ConsumedRecords: atomic.LoadInt64(&t.consumedRecords),
+			ConsumedBytes:   atomic.LoadInt64(&t.consumedBytes),
+			MaxConsumeLag:   -1,
+		}
+		t.lagMu.Lock()
+		for _, lag := range t.lags {
+			if lag > tm.MaxConsumeLag {
+				tm.MaxConsumeLag = lag
+			}
+		}
+		t.lagMu.Unlock()
+		s[topic] = tm
+	}
+	return s
+}
+
+// TopicMetrics returns a snapshot of per-topic produce and consume counters
+// for every topic this client has produced to or consumed from.
+//
+// This is an alternative to hooks for applications that periodically poll
+// for metrics rather than receive callbacks. The counters are always tracked
+// and are cheap to maintain; the snapshot is a point in time view and each
+// call returns a new map.
+func (cl *Client) TopicMetrics() map[string]TopicMetrics {
+	return cl.metrics.snapshot()
+}
+
+// TransactionMetrics are counters for transactions, as returned from
+// Client.TransactionMetrics. Only transactions that are successfully ended
+// with EndTransaction and that produced or committed offsets are counted.
+type TransactionMetrics struct {
+	// Committed is the number of transactions committed.
+	Committed int64
+	// Aborted is the number of transactions aborted.
+	Aborted int64
+
+	// Records is the number of records produced across transactions.
+	Records int64
+	// Partitions is the number of partitions added across transactions.
+	Partitions int64
+	// AddPartitionsRequests is the number of AddPartitionsToTxn requests
+	// issued, including requests for transactions that are not yet
+	// ended. Partitions that sinks add concurrently are batched into one
+	// request, so this is normally much less than Partitions.
+	AddPartitionsRequests int64
+	// Duration is the total time spent in transactions, from
+	// BeginTransaction to EndTransaction returning.
+	Duration time.Duration
+
+	// LastRecords, LastPartitions, and LastDuration are the records,
+	// partitions, and duration of the most recently ended transaction.
+	LastRecords    int64
+	LastPartitions int64
+	LastDuration   time.Duration
+}
+
+func (m *clientMetrics) observeAddPartitionsToTxn() {
+	m.txnMu.Lock()
+	defer m.txnMu.Unlock()
+	m.txn.AddPartitionsRequests++
+}
+
+func (m *clientMetrics) observeTxnEnd(commit bool, records, partitions int64, dur time.Duration) {
+	m.txnMu.Lock()
+	defer m.txnMu.Unlock()
+	if commit {
+		m.txn.Committed++
+	} else {
+		m.txn.Aborted++
+	}
+	m.txn.Records += records
+	m.txn.Partitions += partitions
+	m.txn.Duration += dur
+	m.txn.LastRecords = records
+	m.txn.LastPartitions = partitions
+	m.txn.LastDuration = dur
+}
+
+// TransactionMetrics returns a snapshot of transaction counters, which can be
+// used to monitor the size and duration of transactions.
+func (cl *Client) TransactionMetrics() TransactionMetrics {
+	cl.metrics.txnMu.Lock()
+	defer cl.metrics.txnMu.Unlock()
+	return cl.metrics.txn
+}
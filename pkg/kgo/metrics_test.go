@@ -0,0 +1,131 @@
+package kgo
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+func TestTopicMetrics(t *testing.T) {
+	var m clientMetrics
+
+	m.observeProduced("a", 10, nil)
+	m.observeProduced("a", 5, nil)
+	m.observeProduced("a", 7, errors.New("failed"))
+
+	m.observePolled(Fetches{{Topics: []FetchTopic{
+		{
+			Topic: "b",
+			Partitions: []FetchPartition{
+				{Partition: 0, HighWatermark: 100, Records: []*Record{{Value: []byte("12"), Offset: 48}, {Value: []byte("3"), Offset: 49}}},
+				{Partition: 1, HighWatermark: 10, Records: []*Record{{Key: []byte("k"), Offset: 9}}},
+				{Partition: 2, Err: errors.New("no records")},
+			},
+		},
+		{
+			Topic:      "c",
+			Partitions: []FetchPartition{{Partition: 0, HighWatermark: 3}},
+		},
+	}}})
+
+	// A later poll of partition 0 replaces its lag.
+	m.observePolled(Fetches{{Topics: []FetchTopic{{
+		Topic:      "b",
+		Partitions: []FetchPartition{{Partition: 0, HighWatermark: 100, Records: []*Record{{Offset: 89}}}},
+	}}}})
+
+	exp := map[string]TopicMetrics{
+		"a": {
+			ProducedRecords: 2,
+			ProducedBytes:   15,
+			ProduceErrors:   1,
+			MaxConsumeLag:   -1,
+		},
+		"b": {
+			ConsumedRecords: 4,
+			ConsumedBytes:   4,
+			MaxConsumeLag:   10,
+		},
+	}
+	if got := m.snapshot(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("got %+v, expected %+v", got, exp)
+	}
+
+	// Revoking partition 0 drops its lag; revoking the rest of the topic
+	// leaves no lag at all.
+	m.forgetLags(map[string][]int32{"b": {0}, "unknown": {0}})
+	if got := m.snapshot()["b"].MaxConsumeLag; got != 0 {
+		t.Errorf("got max lag %d after revoking partition 0, expected 0", got)
+	}
+	m.forgetLags(map[string][]int32{"b": {0, 1, 2}})
+	if got := m.snapshot()["b"].MaxConsumeLag; got != -1 {
+		t.Errorf("got max lag %d after revoking everything, expected -1", got)
+	}
+}
+
+func TestTransactionMetrics(t *testing.T) {
+	var m clientMetrics
+	m.observeAddPartitionsToTxn()
+	m.observeAddPartitionsToTxn()
+	m.observeTxnEnd(true, 10, 3, time.Second)
+	m.observeTxnEnd(false, 4, 1, 2*time.Second)
+
+	exp := TransactionMetrics{
+		Committed:             1,
+		Aborted:               1,
+		Records:               14,
+		Partitions:            4,
+		AddPartitionsRequests: 2,
+		Duration:              3 * time.Second,
+		LastRecords:           4,
+		LastPartitions:        1,
+		LastDuration:          2 * time.Second,
+	}
+	if got := m.txn; got != exp {
+		t.Errorf("got %+v, expected %+v", got, exp)
+	}
+}
+
+func TestSplitTxnAddResp(t *testing.T) {
+	req := kmsg.NewPtrAddPartitionsToTxnRequest()
+	for _, tp := range []struct {
+		topic      string
+		partitions []int32
+	}{
+		{"a", []int32{0, 2}},
+		{"c", []int32{0}},
+	} {
+		reqTopic := kmsg.NewAddPartitionsToTxnRequestTopic()
+		reqTopic.Topic = tp.topic
+		reqTopic.Partitions = tp.partitions
+		req.Topics = append(req.Topics, reqTopic)
+	}
+
+	// The merged response also has partitions from other sinks.
+	merged := kmsg.NewPtrAddPartitionsToTxnResponse()
+	codes := map[string]map[int32]int16{
+		"a": {0: 0, 1: 0, 2: kerr.ConcurrentTransactions.Code},
+		"b": {0: 0},
+		"c": {0: 0},
+	}
+
+	resp := splitTxnAddResp(merged, req, codes)
+	got := make(map[string]map[int32]int16)
+	for _, t := range resp.Topics {
+		got[t.Topic] = make(map[int32]int16)
+		for _, p := range t.Partitions {
+			got[t.Topic][p.Partition] = p.ErrorCode
+		}
+	}
+	exp := map[string]map[int32]int16{
+		"a": {0: 0, 2: kerr.ConcurrentTransactions.Code},
+		"c": {0: 0},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("got split response %v, expected %v", got, exp)
+	}
+}
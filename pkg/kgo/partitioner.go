@@ -71,6 +71,12 @@ type TopicBackupIter interface {
 	Rem() int
 }
 
+// randSeeder is implemented by topic partitioners in this package that use
+// randomness, so that the client can seed them (see RandSeed).
+type randSeeder interface {
+	seedRand(int64)
+}
+
 type leastBackupInput struct {
 	mapping []*topicPartition
 }
@@ -208,6 +214,8 @@ type leastBackupTopicPartitioner struct {
 	rng    *rand.Rand
 }
 
+func (p *leastBackupTopicPartitioner) seedRand(seed int64) { p.rng = rand.New(rand.NewSource(seed)) }
+
 func (p *leastBackupTopicPartitioner) OnNewBatch()                    { p.onPart = -1 }
 func (*leastBackupTopicPartitioner) RequiresConsistency(*Record) bool { return false }
 func (*leastBackupTopicPartitioner) Partition(*Record, int) int       { panic("unreachable") }
@@ -261,6 +269,8 @@ type stickyTopicPartitioner struct {
 	rng      *rand.Rand
 }
 
+func (p *stickyTopicPartitioner) seedRand(seed int64) { p.rng = rand.New(rand.NewSource(seed)) }
+
 func (p *stickyTopicPartitioner) OnNewBatch()                    { p.lastPart, p.onPart = p.onPart, -1 }
 func (*stickyTopicPartitioner) RequiresConsistency(*Record) bool { return false }
 func (p *stickyTopicPartitioner) Partition(_ *Record, n int) int {
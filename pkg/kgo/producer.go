@@ -30,7 +30,15 @@ type producer struct {
 	unknownTopicsMu sync.Mutex
 	unknownTopics   map[string]*unknownTopicProduces
 
+	// evictedSeqs saves the sequence number of every partition of topics
+	// evicted by ProduceTopicMaxIdle, so that producing to the topic
+	// again continues the partitions' sequences. This is cleared whenever
+	// all sequences are reset. The lock is taken before unknownTopicsMu.
+	evictedSeqsMu sync.Mutex
+	evictedSeqs   map[string][]int32
+
 	bufferedRecords int64
+	bufferedBytes   int64
 
 	id           atomic.Value
 	producingTxn uint32 // 1 if in txn
@@ -50,8 +58,16 @@ type producer struct {
 	notifyMu   sync.Mutex
 	notifyCond *sync.Cond
 
-	txnMu sync.Mutex
-	inTxn bool
+	txnMu    sync.Mutex
+	inTxn    bool
+	txnStart time.Time // when the current transaction began, under txnMu
+
+	txnAdder      txnAdder
+	txnRecords    int64 // atomic, records produced in the current transaction
+	txnPartitions int64 // atomic, partitions added to the current transaction
+
+	promiseLimit limiter // see PromiseConcurrency
+	drainLimit   limiter // see ProduceDrainConcurrency
 }
 
 // BufferedProduceRecords returns the number of records currently buffered for
@@ -64,6 +80,18 @@ func (cl *Client) BufferedProduceRecords() int64 {
 	return atomic.LoadInt64(&cl.producer.bufferedRecords)
 }
 
+// BufferedProduceBytes returns the number of bytes currently buffered for
+// producing within the client. This is the sum of all keys, values, and
+// headers in buffered records, which is the bulk of the memory the client
+// holds for producing, but not including per-record overhead nor the memory
+// of batches that are being written to brokers.
+//
+// This can be used to include the client in an application's own memory
+// accounting, or to implement admission control before producing.
+func (cl *Client) BufferedProduceBytes() int64 {
+	return atomic.LoadInt64(&cl.producer.bufferedBytes)
+}
+
 type unknownTopicProduces struct {
 	buffered []promisedRec
 	wait     chan error
@@ -80,6 +108,8 @@ func (p *producer) init(cl *Client) {
 		err:   errReloadProducerID,
 	})
 	p.notifyCond = sync.NewCond(&p.notifyMu)
+	p.promiseLimit = newLimiter(cl.cfg.promiseConcurrency)
+	p.drainLimit = newLimiter(cl.cfg.drainConcurrency)
 
 	inithooks := func() {
 		if p.hooks == nil {
@@ -250,7 +280,15 @@ func (f *FirstErrPromise) Err() error {
 //
 // If the record is too large to fit in a batch on its own in a produce
 // request, the promise will be called with kerr.MessageTooLarge and there will
-// be no attempt to produce the record.
+// be no attempt to produce the record. If Kafka rejects a batch as too large
+// (because the broker or topic max message bytes is lower than
+// ProducerBatchMaxBytes), the batch is split in half and retried, and only
+// records that are too large on their own are failed.
+//
+// If Kafka fails the record, such as for authorization, an unknown topic, or
+// an invalid record, the promise is called with *ErrProduceFailed, which
+// wraps the Kafka error and indicates whether this specific record caused
+// the failure or was failed alongside other records in its batch.
 //
 // The context is used if the client currently has the max amount of buffered
 // records. If so, the client waits for some records to complete or for the
@@ -302,6 +340,9 @@ func (cl *Client) Produce(
 		go promise(r, errNotInTransaction) // see comment just below for why we 'go' this
 		return
 	}
+	if cl.cfg.txnID != nil {
+		atomic.AddInt64(&p.txnRecords, 1)
+	}
 
 	// Our record is now "buffered", and past this point will fall into
 	// finishRecordPromise, where we track it is finished.
@@ -311,6 +352,7 @@ func (cl *Client) Produce(
 		}
 	}
 
+	atomic.AddInt64(&p.bufferedBytes, r.userSize())
 	if atomic.AddInt64(&p.bufferedRecords, 1) > cl.cfg.maxBufferedRecords {
 		// If the client ctx cancels or the produce ctx cancels, we
 		// need to un-count our buffering of this record. We also need
@@ -352,15 +394,31 @@ func (cl *Client) finishRecordPromise(pr promisedRec, err error) {
 
 	if p.hooks != nil {
 		for _, h := range p.hooks.unbuffered {
-			h.OnProduceRecordUnbuffered(pr.Record, err)
+			done := cl.slowCallbacks.watch("hook", h)
+			if cl.panics != nil {
+				cl.panics.run("hook", h, func() { h.OnProduceRecordUnbuffered(pr.Record, err) })
+			} else {
+				h.OnProduceRecordUnbuffered(pr.Record, err)
+			}
+			done()
 		}
 	}
 
 	// We call the promise before finishing the record; this allows users
 	// of Flush to know that all buffered records are completely done
-	// before Flush returns.
-	pr.promise(pr.Record, err)
+	// before Flush returns. The user can modify the record in the
+	// promise, so we compute its size first.
+	size := pr.Record.userSize()
+	cl.metrics.observeProduced(pr.Record.Topic, size, err)
+	done := cl.slowCallbacks.watch("produce promise", nil)
+	if cl.panics != nil {
+		cl.panics.run("produce promise", nil, func() { pr.promise(pr.Record, err) })
+	} else {
+		pr.promise(pr.Record, err)
+	}
+	done()
 
+	atomic.AddInt64(&p.bufferedBytes, -size)
 	buffered := atomic.AddInt64(&p.bufferedRecords, -1)
 	if buffered >= cl.cfg.maxBufferedRecords {
 		go func() { p.waitBuffer <- struct{}{} }()
@@ -386,63 +444,149 @@ func (cl *Client) partitionRecord(pr promisedRec) {
 // partitions can call this directly.
 func (cl *Client) doPartitionRecord(parts *topicPartitions, partsData *topicPartitionsData, pr promisedRec) {
 	if partsData.loadErr != nil && !kerr.IsRetriable(partsData.loadErr) {
-		cl.finishRecordPromise(pr, partsData.loadErr)
+		cl.finishRecordPromise(pr, produceFailedFn(pr.Topic, -1, partsData.loadErr, nil, nil, 1)(-1, 0))
 		return
 	}
 
+	// We only read lock the topic while buffering, which just guards
+	// against the topic being evicted. Partitioning is serialized, but
+	// buffering is not: many goroutines can buffer to different
+	// partitions of the same topic at once.
+	parts.bufferMu.RLock()
+	if parts.evicted {
+		// The topic was evicted after we loaded it. We wait for the
+		// eviction to finish, after which partitioning loads the topic
+		// as new.
+		parts.bufferMu.RUnlock()
+		cl.producer.topicsMu.Lock()
+		cl.producer.topicsMu.Unlock()
+		cl.partitionRecord(pr)
+		return
+	}
+	defer parts.bufferMu.RUnlock()
+	if cl.cfg.produceTopicMaxIdle > 0 {
+		atomic.StoreInt64(&parts.lastProduce, time.Now().UnixNano())
+	}
+
+	mapping, partition, onNewBatch, newBatches, err := cl.partitionFor(parts, partsData, pr.Record)
+	if err != nil {
+		cl.finishRecordPromise(pr, err)
+		return
+	}
+	if partition.records.bufferRecord(pr, onNewBatch != nil) { // KIP-480
+		return
+	}
+
+	// Buffering would have created a new batch, and the partitioner wants
+	// to know before a new batch is created. If a concurrent produce
+	// already created a new batch since we partitioned, the partitioner
+	// has already been notified, and we use its latest choice rather than
+	// notifying it again.
+	parts.partsMu.Lock()
+	if parts.newBatches == newBatches {
+		parts.newBatches++
+		err = cl.panics.run("partitioner", onNewBatch, onNewBatch.OnNewBatch)
+	}
+	if err == nil {
+		partition, err = cl.pickPartitionLocked(parts, pr.Record, mapping)
+	}
+	parts.partsMu.Unlock()
+	if err != nil {
+		cl.finishRecordPromise(pr, err)
+		return
+	}
+	partition.records.bufferRecord(pr, false) // KIP-480
+}
+
+// partitionFor chooses the partition for a record under the topic's
+// partitioning lock. This returns the partitions that were chosen from, the
+// partition, the partitioner if it wants to be notified of new batches, and
+// how many new batches the partitioner has been notified of.
+func (cl *Client) partitionFor(parts *topicPartitions, partsData *topicPartitionsData, r *Record) (
+	mapping []*topicPartition,
+	partition *topicPartition,
+	onNewBatch TopicPartitionerOnNewBatch,
+	newBatches uint64,
+	err error,
+) {
 	parts.partsMu.Lock()
 	defer parts.partsMu.Unlock()
+
 	if parts.partitioner == nil {
-		parts.partitioner = cl.cfg.partitioner.ForTopic(pr.Topic)
+		if err := cl.panics.run("partitioner", cl.cfg.partitioner, func() {
+			parts.partitioner = cl.cfg.partitioner.ForTopic(r.Topic)
+		}); err != nil {
+			parts.partitioner = nil
+			return nil, nil, nil, 0, err
+		}
+		if seeder, ok := parts.partitioner.(randSeeder); ok {
+			seeder.seedRand(cl.randInt63())
+		}
 	}
 
-	mapping := partsData.writablePartitions
-	if parts.partitioner.RequiresConsistency(pr.Record) {
+	var consistent bool
+	if cl.panics != nil {
+		if err := cl.panics.run("partitioner", parts.partitioner, func() {
+			consistent = parts.partitioner.RequiresConsistency(r)
+		}); err != nil {
+			return nil, nil, nil, 0, err
+		}
+	} else {
+		consistent = parts.partitioner.RequiresConsistency(r)
+	}
+	mapping = partsData.writablePartitions
+	if consistent {
 		mapping = partsData.partitions
 	}
 	if len(mapping) == 0 {
-		cl.finishRecordPromise(pr, errors.New("unable to partition record due to no usable partitions"))
-		return
+		return nil, nil, nil, 0, errors.New("unable to partition record due to no usable partitions")
 	}
 
-	var pick int
+	if partition, err = cl.pickPartitionLocked(parts, r, mapping); err != nil {
+		return nil, nil, nil, 0, err
+	}
+	onNewBatch, _ = parts.partitioner.(TopicPartitionerOnNewBatch)
+	return mapping, partition, onNewBatch, parts.newBatches, nil
+}
+
+// pickPartitionLocked, called under the topic's partitioning lock, returns the
+// partition in mapping that the topic's partitioner chooses for a record.
+func (cl *Client) pickPartitionLocked(parts *topicPartitions, r *Record, mapping []*topicPartition) (*topicPartition, error) {
 	tlp, _ := parts.partitioner.(TopicBackupPartitioner)
-	if tlp != nil {
-		if parts.lb == nil {
-			parts.lb = new(leastBackupInput)
-		}
-		parts.lb.mapping = mapping
-		pick = tlp.PartitionByBackup(pr.Record, len(mapping), parts.lb)
-	} else {
-		pick = parts.partitioner.Partition(pr.Record, len(mapping))
+	if tlp != nil && parts.lb == nil {
+		parts.lb = new(leastBackupInput)
+	}
+	pick, err := cl.pickPartition(parts, tlp, r, mapping)
+	if err != nil {
+		return nil, err
 	}
 	if pick < 0 || pick >= len(mapping) {
-		cl.finishRecordPromise(pr, fmt.Errorf("invalid record partitioning choice of %d from %d available", pick, len(mapping)))
-		return
+		return nil, fmt.Errorf("invalid record partitioning choice of %d from %d available", pick, len(mapping))
 	}
+	return mapping[pick], nil
+}
 
-	partition := mapping[pick]
-
-	onNewBatch, _ := parts.partitioner.(TopicPartitionerOnNewBatch)
-	abortOnNewBatch := onNewBatch != nil
-	processed := partition.records.bufferRecord(pr, abortOnNewBatch) // KIP-480
-	if !processed {
-		onNewBatch.OnNewBatch()
-
+// pickPartition returns the index in mapping that the topic's partitioner
+// chooses for a record, or an error if the partitioner panicked and we are
+// recovering panics.
+func (cl *Client) pickPartition(parts *topicPartitions, tlp TopicBackupPartitioner, r *Record, mapping []*topicPartition) (pick int, err error) {
+	if tlp != nil {
+		parts.lb.mapping = mapping
+	}
+	if cl.panics == nil {
 		if tlp != nil {
-			parts.lb.mapping = mapping
-			pick = tlp.PartitionByBackup(pr.Record, len(mapping), parts.lb)
-		} else {
-			pick = parts.partitioner.Partition(pr.Record, len(mapping))
+			return tlp.PartitionByBackup(r, len(mapping), parts.lb), nil
 		}
-
-		if pick < 0 || pick >= len(mapping) {
-			cl.finishRecordPromise(pr, fmt.Errorf("invalid record partitioning choice of %d from %d available", pick, len(mapping)))
-			return
-		}
-		partition = mapping[pick]
-		partition.records.bufferRecord(pr, false) // KIP-480
+		return parts.partitioner.Partition(r, len(mapping)), nil
 	}
+	err = cl.panics.run("partitioner", parts.partitioner, func() {
+		if tlp != nil {
+			pick = tlp.PartitionByBackup(r, len(mapping), parts.lb)
+		} else {
+			pick = parts.partitioner.Partition(r, len(mapping))
+		}
+	})
+	return pick, err
 }
 
 type producerID struct {
@@ -526,6 +670,10 @@ func (cl *Client) producerID() (int64, int16, error) {
 // 2.5.0+, it is safe to call this if the producer ID can be reset (KIP-360),
 // in EndTransaction.
 func (cl *Client) resetAllProducerSequences() {
+	cl.producer.evictedSeqsMu.Lock()
+	defer cl.producer.evictedSeqsMu.Unlock()
+	cl.producer.evictedSeqs = nil // evicted topics reload at sequence 0
+
 	for _, tp := range cl.producer.topics.load() {
 		for _, p := range tp.load().partitions {
 			p.records.mu.Lock()
@@ -682,7 +830,7 @@ func (cl *Client) addUnknownTopicRecord(pr promisedRec) {
 	}
 	unknown.buffered = append(unknown.buffered, pr)
 	if len(unknown.buffered) == 1 {
-		go cl.waitUnknownTopic(pr.Topic, unknown)
+		cl.actors.spawn("unknown topic wait", purposeLabels("produce"), func() { cl.waitUnknownTopic(pr.Topic, unknown) })
 	}
 }
 
@@ -759,9 +907,10 @@ func (cl *Client) waitUnknownTopic(
 // consequence because clients should not really be producing to loads of
 // unknown topics.
 func (cl *Client) failUnknownTopicRecords(topic string, unknown *unknownTopicProduces, err error) {
+	errFn := produceFailedFn(topic, -1, err, nil, nil, len(unknown.buffered))
 	go func() {
-		for _, pr := range unknown.buffered {
-			cl.finishRecordPromise(pr, err)
+		for i, pr := range unknown.buffered {
+			cl.finishRecordPromise(pr, errFn(-1, i))
 		}
 	}()
 }
@@ -849,6 +998,120 @@ func (cl *Client) bumpRepeatedLoadErr(err error) {
 	}
 }
 
+// evictIdleProduceTopics evicts producer topics that have not been produced to
+// within ProduceTopicMaxIdle and that have nothing buffered or inflight.
+//
+// This is called at the start of a metadata update. Metadata updates are
+// serialized, so no update concurrently migrates an evicted partition's record
+// buffer to a new sink.
+func (cl *Client) evictIdleProduceTopics() {
+	maxIdle := cl.cfg.produceTopicMaxIdle
+	if maxIdle == 0 {
+		return
+	}
+	cutoff := time.Now().Add(-maxIdle).UnixNano()
+
+	p := &cl.producer
+	p.topicsMu.Lock()
+	defer p.topicsMu.Unlock()
+
+	// We save sequence numbers under the same lock that resetting all
+	// sequences uses, so that a reset cannot miss what we save.
+	p.evictedSeqsMu.Lock()
+	defer p.evictedSeqsMu.Unlock()
+
+	// Topics with records waiting for metadata are in use; we hold the
+	// unknown topics lock so that no topic begins waiting while we evict.
+	p.unknownTopicsMu.Lock()
+	defer p.unknownTopicsMu.Unlock()
+
+	var evicted []string
+	for topic, parts := range p.topics.load() {
+		if _, waiting := p.unknownTopics[topic]; waiting {
+			continue
+		}
+		seqs, ok := parts.maybeEvict(cutoff)
+		if !ok {
+			continue
+		}
+		evicted = append(evicted, topic)
+		if !cl.cfg.disableIdempotency {
+			if p.evictedSeqs == nil {
+				p.evictedSeqs = make(map[string][]int32)
+			}
+			p.evictedSeqs[topic] = seqs
+		}
+	}
+	if len(evicted) == 0 {
+		return
+	}
+	p.topics.purgeTopics(evicted)
+	cl.cfg.logger.Log(LogLevelInfo, "evicted idle produce topics", "topics", evicted, "max_idle", maxIdle)
+}
+
+// maybeEvict evicts the topic if it has not been produced to since cutoff and
+// no partition has anything buffered or inflight, removing every partition's
+// record buffer from its sink. Once evicted, producing to this topic must load
+// the topic again.
+//
+// This returns the next sequence number of every partition, which the reload
+// must continue from: the broker still has our producer ID's sequences.
+func (t *topicPartitions) maybeEvict(cutoff int64) ([]int32, bool) {
+	t.bufferMu.Lock()
+	defer t.bufferMu.Unlock()
+	if atomic.LoadInt64(&t.lastProduce) > cutoff {
+		return nil, false
+	}
+
+	partitions := t.load().partitions
+	seqs := make([]int32, len(partitions))
+	for i, p := range partitions {
+		recBuf := p.records
+		recBuf.mu.Lock()
+		idle := len(recBuf.batches) == 0 && recBuf.inflight == 0
+		if !recBuf.needSeqReset {
+			seqs[i] = recBuf.seq
+		}
+		recBuf.mu.Unlock()
+		if !idle {
+			return nil, false
+		}
+	}
+
+	t.evicted = true
+	for _, p := range partitions {
+		p.records.sink.removeRecBuf(p.records)
+	}
+	return seqs, true
+}
+
+// lockEvictedSeqs restores the sequence numbers saved when the topic was
+// evicted into the topic's new partitions. If anything was saved, this returns
+// with evictedSeqsMu locked, and the caller must unlock it once the partitions
+// are stored: otherwise, resetting all sequences could miss the restored
+// sequences.
+func (cl *Client) lockEvictedSeqs(topic string, partitions []*topicPartition) (locked bool) {
+	p := &cl.producer
+	p.evictedSeqsMu.Lock()
+	seqs, exists := p.evictedSeqs[topic]
+	if !exists {
+		p.evictedSeqsMu.Unlock()
+		return false
+	}
+	delete(p.evictedSeqs, topic)
+	for _, tp := range partitions {
+		recBuf := tp.records
+		if recBuf.recBufsIdx != -1 || int(recBuf.partition) >= len(seqs) {
+			continue
+		}
+		recBuf.mu.Lock()
+		recBuf.seq = seqs[recBuf.partition]
+		recBuf.batch0Seq = recBuf.seq
+		recBuf.mu.Unlock()
+	}
+	return true
+}
+
 // Clears all buffered records in the client with the given error.
 //
 // - closing client
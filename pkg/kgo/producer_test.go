@@ -0,0 +1,478 @@
+package kgo
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+func TestBufferedProduceBytes(t *testing.T) {
+	cl, err := NewClient(SeedBrokers("127.0.0.1:1"), MaxBufferedRecords(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Record{
+		Topic:   "foo",
+		Key:     []byte("key"),
+		Value:   []byte("value"),
+		Headers: []RecordHeader{{Key: "h", Value: []byte("v")}},
+	}
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		cl.Produce(context.Background(), r, func(_ *Record, err error) { done <- err })
+	}
+	if got, exp := cl.BufferedProduceBytes(), int64(2*(3+5+1+1)); got != exp {
+		t.Errorf("got %d buffered bytes != exp %d", got, exp)
+	}
+
+	cl.Close() // fails all buffered records
+	for i := 0; i < 2; i++ {
+		if err := <-done; err == nil {
+			t.Error("expected record to fail when closing the client")
+		}
+	}
+	if got := cl.BufferedProduceBytes(); got != 0 {
+		t.Errorf("got %d buffered bytes after closing != exp 0", got)
+	}
+}
+
+func TestProduceFailedPerRecord(t *testing.T) {
+	cl, err := NewClient(SeedBrokers("127.0.0.1:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	errs := make(map[int64]error)
+	pnr := func(offset int64) promisedNumberedRecord {
+		return promisedNumberedRecord{promisedRec: promisedRec{
+			ctx:     context.Background(),
+			promise: func(r *Record, err error) { errs[r.Offset] = err },
+			Record:  &Record{Topic: "foo", Offset: offset},
+		}}
+	}
+	recBuf := &recBuf{cl: cl, topic: "foo", partition: 3, buffered: 4}
+	recBuf.batches = []*recBatch{
+		{owner: recBuf, records: []promisedNumberedRecord{pnr(0), pnr(1), pnr(2)}},
+		{owner: recBuf, records: []promisedNumberedRecord{pnr(3)}},
+	}
+
+	msg := "batch is invalid"
+	recBuf.failAllRecordsFromKafka(kerr.InvalidRecord, &msg, map[int32]string{1: "record is invalid"})
+
+	for offset := int64(0); offset < 4; offset++ {
+		var pe *ErrProduceFailed
+		if !errors.As(errs[offset], &pe) || !errors.Is(errs[offset], kerr.InvalidRecord) {
+			t.Errorf("record %d: got err %v, expected ErrProduceFailed wrapping INVALID_RECORD", offset, errs[offset])
+			continue
+		}
+		expCause, expMsg := offset == 1, msg
+		if expCause {
+			expMsg = "record is invalid"
+		}
+		if pe.Topic != "foo" || pe.Partition != 3 || pe.Records != 4 || pe.Cause != expCause || pe.Message != expMsg {
+			t.Errorf("record %d: got %+v, expected cause %v, message %q, and 4 records", offset, pe, expCause, expMsg)
+		}
+	}
+	if len(recBuf.batches) != 0 {
+		t.Errorf("got %d batches remaining, expected 0", len(recBuf.batches))
+	}
+
+	// Errors that are not from Kafka are not wrapped.
+	if err := produceFailedFn("foo", 0, ErrRecordTimeout, nil, nil, 1)(0, 0); err != ErrRecordTimeout {
+		t.Errorf("got err %v, expected ErrRecordTimeout", err)
+	}
+}
+
+func TestMessageTooLargeSplit(t *testing.T) {
+	cl, err := NewClient(SeedBrokers("127.0.0.1:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	errs := make(map[string]error)
+	s := cl.newSink(1)
+	recBuf := &recBuf{cl: cl, topic: "foo", sink: s, maxRecordBatchBytes: 1 << 20}
+	newBatch := func(values ...string) *recBatch {
+		batch := recBuf.newRecordBatch()
+		for _, v := range values {
+			v := v
+			batch.tryBuffer(promisedRec{
+				ctx:     context.Background(),
+				promise: func(_ *Record, err error) { errs[v] = err },
+				Record:  &Record{Topic: "foo", Value: []byte(v)},
+			}, 9, recBuf.maxRecordBatchBytes, false)
+		}
+		batch.tries = 1
+		recBuf.buffered += int64(len(values))
+		return batch
+	}
+	values := func(batch *recBatch) (vs []string) {
+		for _, r := range batch.records {
+			vs = append(vs, string(r.Value))
+		}
+		return vs
+	}
+
+	// Two batches are inflight when the first is too large.
+	recBuf.batches = []*recBatch{newBatch("a", "b", "c"), newBatch("d")}
+	recBuf.batchDrainIdx, recBuf.seq = 2, 4
+	tooLarge := func() {
+		retry, didProduce := s.handleReqRespBatch(nil, "foo", 0, seqRecBatch{0, recBuf.batches[0]}, 1, 0, -1, kerr.MessageTooLarge.Code, nil, nil)
+		if retry || didProduce {
+			t.Fatalf("got retry %v, didProduce %v, expected neither", retry, didProduce)
+		}
+	}
+
+	// The batch is split in half and drained again from the start,
+	// keeping its sequence numbers.
+	tooLarge()
+	if len(recBuf.batches) != 3 || len(values(recBuf.batches[0])) != 1 || len(values(recBuf.batches[1])) != 2 {
+		t.Fatalf("got %d batches, expected the first batch split into 1 and 2 records", len(recBuf.batches))
+	}
+	if recBuf.batchDrainIdx != 0 || recBuf.seq != 0 {
+		t.Errorf("got drain index %d and seq %d, expected 0 and 0", recBuf.batchDrainIdx, recBuf.seq)
+	}
+	if len(errs) != 0 {
+		t.Errorf("got failed records %v after splitting, expected none", errs)
+	}
+
+	// A single record that is too large fails alone, and the batches
+	// after it that were inflight are resequenced.
+	recBuf.batchDrainIdx = 2
+	tooLarge()
+	var pe *ErrProduceFailed
+	if !errors.As(errs["a"], &pe) || !errors.Is(pe, kerr.MessageTooLarge) || !pe.Cause || pe.Records != 1 {
+		t.Errorf("got err %v for the too large record, expected ErrProduceFailed for only it", errs["a"])
+	}
+	if len(errs) != 1 || recBuf.buffered != 3 {
+		t.Errorf("got %d failed records and %d buffered, expected 1 and 3", len(errs), recBuf.buffered)
+	}
+	if got := values(recBuf.batches[0]); len(recBuf.batches) != 2 || len(got) != 2 || got[0] != "b" {
+		t.Fatalf("got first batch %v of %d, expected [b c] of 2", got, len(recBuf.batches))
+	}
+	if !recBuf.batches[0].resequenced || recBuf.batches[1].resequenced {
+		t.Error("expected only the inflight batch after the failed one to be resequenced")
+	}
+
+	// The resequenced batch's out of order response redrains it.
+	recBuf.batchDrainIdx = 1
+	retry, _ := s.handleReqRespBatch(nil, "foo", 0, seqRecBatch{1, recBuf.batches[0]}, 1, 0, -1, kerr.OutOfOrderSequenceNumber.Code, nil, nil)
+	if retry || recBuf.batchDrainIdx != 0 || recBuf.batches[0].resequenced || len(errs) != 1 {
+		t.Errorf("got retry %v, drain index %d, and %d failed records, expected a redrain without failing", retry, recBuf.batchDrainIdx, len(errs))
+	}
+}
+
+func TestSplitBatchRebatches(t *testing.T) {
+	cl, err := NewClient(SeedBrokers("127.0.0.1:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	errs := make(map[string]error)
+	recBuf := &recBuf{cl: cl, topic: "foo", sink: cl.newSink(1), maxRecordBatchBytes: 1 << 20}
+	batch := recBuf.newRecordBatch()
+	for _, v := range []string{"a", "b", "c", strings.Repeat("d", 100)} {
+		v := v
+		batch.tryBuffer(promisedRec{
+			ctx:     context.Background(),
+			promise: func(_ *Record, err error) { errs[v] = err },
+			Record:  &Record{Topic: "foo", Value: []byte(v)},
+		}, 9, recBuf.maxRecordBatchBytes, false)
+	}
+	batch.tries = 1
+	recBuf.batches = []*recBatch{batch}
+	recBuf.buffered = 4
+
+	// Shrink our max batch bytes so that only one small record fits in a
+	// batch. Splitting in half gives [a b] and [c d...]; b and c move to
+	// their own batches, and the large record cannot fit anywhere and
+	// fails rather than disappearing.
+	produceVersion := atomic.LoadInt32(&recBuf.sink.produceVersion)
+	one := recBuf.newRecordBatch()
+	one.tryBuffer(promisedRec{Record: &Record{Topic: "foo", Value: []byte("a")}}, produceVersion, recBuf.maxRecordBatchBytes, false)
+	recBuf.maxRecordBatchBytes, _ = one.wireLengthForProduceVersion(produceVersion)
+
+	recBuf.splitBatch0()
+
+	var got [][]string
+	for _, b := range recBuf.batches {
+		var vs []string
+		for _, r := range b.records {
+			vs = append(vs, string(r.Value))
+		}
+		if b.tries != 1 {
+			t.Errorf("got batch %v with %d tries, expected 1", vs, b.tries)
+		}
+		got = append(got, vs)
+	}
+	if exp := [][]string{{"a"}, {"b"}, {"c"}}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("got batches %v, expected %v", got, exp)
+	}
+	if len(errs) != 1 || errs[strings.Repeat("d", 100)] != kerr.MessageTooLarge || recBuf.buffered != 3 {
+		t.Errorf("got failed records %v and %d buffered, expected only the large record failed and 3 buffered", errs, recBuf.buffered)
+	}
+}
+
+func TestProduceTopicMaxIdle(t *testing.T) {
+	if _, err := NewClient(ProduceTopicMaxIdle(time.Minute), TransactionalID("txn")); err == nil {
+		t.Error("expected an error evicting idle topics with a transactional ID")
+	}
+
+	cl, err := NewClient(SeedBrokers("127.0.0.1:1"), ProduceTopicMaxIdle(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	s := cl.newSink(1)
+	recBuf := &recBuf{cl: cl, topic: "foo", sink: s, recBufsIdx: -1}
+	s.addRecBuf(recBuf)
+	parts := new(topicPartitions)
+	tp := &topicPartition{records: recBuf}
+	parts.v.Store(&topicPartitionsData{partitions: []*topicPartition{tp}, writablePartitions: []*topicPartition{tp}})
+	cl.producer.topics.storeData(topicsPartitionsData{"foo": parts})
+
+	exists := func() bool { return cl.producer.topics.load().hasTopic("foo") }
+
+	// Recently produced topics and topics with buffered records are kept.
+	parts.lastProduce = time.Now().UnixNano()
+	cl.evictIdleProduceTopics()
+	if !exists() {
+		t.Fatal("recently produced topic was evicted")
+	}
+	parts.lastProduce = time.Now().Add(-time.Hour).UnixNano()
+	recBuf.batches = []*recBatch{recBuf.newRecordBatch()}
+	cl.evictIdleProduceTopics()
+	if !exists() {
+		t.Fatal("topic with buffered records was evicted")
+	}
+
+	recBuf.batches = nil
+	cl.evictIdleProduceTopics()
+	if exists() {
+		t.Fatal("idle topic was not evicted")
+	}
+	if !parts.evicted || len(s.recBufs) != 0 {
+		t.Errorf("got evicted %v with %d sink record buffers, expected evicted with 0", parts.evicted, len(s.recBufs))
+	}
+}
+
+// BenchmarkProduceParallel measures buffering records from many goroutines
+// into one topic, which is bound by lock contention on the produce path. The
+// client flushes manually and is never flushed, so nothing is written.
+func BenchmarkProduceParallel(b *testing.B) {
+	for _, bench := range []struct {
+		name        string
+		partitioner Partitioner
+	}{
+		{"sticky", StickyPartitioner()},
+		{"round_robin", RoundRobinPartitioner()},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			cl, err := NewClient(
+				SeedBrokers("127.0.0.1:1"),
+				ManualFlushing(),
+				MaxBufferedRecords(1<<40),
+				RecordPartitioner(bench.partitioner),
+			)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer cl.Close()
+
+			storeProduceTopic(cl, "foo", 16)
+
+			value := make([]byte, 100)
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					cl.Produce(context.Background(), &Record{Topic: "foo", Value: value}, nil)
+				}
+			})
+		})
+	}
+}
+
+// storeProduceTopic loads a topic for producing with the given number of
+// partitions, each on its own sink, without any metadata request.
+func storeProduceTopic(cl *Client, topic string, partitions int32) *topicPartitionsData {
+	parts := newTopicPartitions()
+	data := new(topicPartitionsData)
+	for i := int32(0); i < partitions; i++ {
+		tp := &topicPartition{records: &recBuf{
+			cl:                  cl,
+			topic:               topic,
+			partition:           i,
+			sink:                cl.newSink(i),
+			maxRecordBatchBytes: 1 << 10,
+			recBufsIdx:          -1,
+		}}
+		data.partitions = append(data.partitions, tp)
+		data.writablePartitions = append(data.writablePartitions, tp)
+	}
+	parts.v.Store(data)
+	cl.producer.topics.v.Store(topicsPartitionsData{topic: parts})
+	return data
+}
+
+func TestProduceConcurrentBuffering(t *testing.T) {
+	cl, err := NewClient(
+		SeedBrokers("127.0.0.1:1"),
+		ManualFlushing(),
+		MaxBufferedRecords(1<<20),
+		RecordPartitioner(StickyPartitioner()), // notified on new batches
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	data := storeProduceTopic(cl, "foo", 4)
+
+	// Many goroutines partition and buffer at once, with the partitioner
+	// moving partitions on every new batch; every record must be
+	// buffered exactly once.
+	const goroutines, each = 8, 500
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < each; j++ {
+				cl.Produce(context.Background(), &Record{Topic: "foo", Value: make([]byte, 50)}, nil)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var buffered, inBatches int64
+	for _, tp := range data.partitions {
+		buffered += atomic.LoadInt64(&tp.records.buffered)
+		for _, b := range tp.records.batches {
+			inBatches += int64(len(b.records))
+		}
+	}
+	if buffered != goroutines*each || inBatches != goroutines*each {
+		t.Errorf("got %d buffered records in %d batched records, expected %d", buffered, inBatches, goroutines*each)
+	}
+	if n := cl.BufferedProduceRecords(); n != goroutines*each {
+		t.Errorf("got %d buffered produce records, expected %d", n, goroutines*each)
+	}
+}
+
+func TestProduceTopicMaxIdleKeepsSequences(t *testing.T) {
+	cl, err := NewClient(SeedBrokers("127.0.0.1:1"), ProduceTopicMaxIdle(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	s := cl.newSink(1)
+	load := func() *recBuf {
+		recBuf := &recBuf{cl: cl, topic: "foo", sink: s, recBufsIdx: -1}
+		tp := &topicPartition{records: recBuf}
+		r := &topicPartitionsData{partitions: []*topicPartition{tp}, writablePartitions: []*topicPartition{tp}}
+		parts := newTopicPartitions()
+		cl.producer.topics.storeData(topicsPartitionsData{"foo": parts})
+		var why multiUpdateWhy
+		cl.mergeTopicPartitions("foo", parts, r, true, new(listOrEpochLoads), func() {}, &why)
+		return recBuf
+	}
+	evict := func() {
+		parts := cl.producer.topics.load()["foo"]
+		parts.lastProduce = time.Now().Add(-time.Hour).UnixNano()
+		cl.evictIdleProduceTopics()
+		if cl.producer.topics.load().hasTopic("foo") {
+			t.Fatal("idle topic was not evicted")
+		}
+	}
+
+	// We produced 7 records with our producer ID before evicting: producing
+	// after reloading must continue at sequence 7, not 0.
+	recBuf := load()
+	recBuf.seq, recBuf.batch0Seq = 7, 7
+	evict()
+	if recBuf = load(); recBuf.seq != 7 || recBuf.batch0Seq != 7 {
+		t.Errorf("got reloaded seq %d batch0 seq %d, expected 7", recBuf.seq, recBuf.batch0Seq)
+	}
+
+	// If our sequences are reset while evicted, the reload starts at 0.
+	evict()
+	cl.resetAllProducerSequences()
+	if recBuf = load(); recBuf.seq != 0 || recBuf.batch0Seq != 0 {
+		t.Errorf("got reloaded seq %d batch0 seq %d after a reset, expected 0", recBuf.seq, recBuf.batch0Seq)
+	}
+}
+
+func TestProduceLeaderMovedUpdatesMetadataNow(t *testing.T) {
+	cl := &Client{
+		cfg:                 defaultCfg(),
+		updateMetadataCh:    make(chan string, 1),
+		updateMetadataNowCh: make(chan string, 1),
+	}
+	s := cl.newSink(1)
+
+	produce := func(err error) (now, later bool) {
+		recBuf := &recBuf{cl: cl, topic: "foo", sink: s}
+		batch := &recBatch{owner: recBuf, records: []promisedNumberedRecord{{promisedRec: promisedRec{
+			ctx:    context.Background(),
+			Record: &Record{Topic: "foo"},
+		}}}}
+		recBuf.batches = []*recBatch{batch}
+		recBuf.batchDrainIdx = 1
+
+		req := &produceRequest{acks: -1}
+		req.batches.addBatch("foo", 0, 0, batch)
+		resp := &kmsg.ProduceResponse{Topics: []kmsg.ProduceResponseTopic{{
+			Topic:      "foo",
+			Partitions: []kmsg.ProduceResponseTopicPartition{{Partition: 0, ErrorCode: err.(*kerr.Error).Code}},
+		}}}
+		s.handleReqResp(nil, req, resp, nil)
+
+		if recBuf.batchDrainIdx != 0 || !recBuf.failing {
+			t.Errorf("%v: batch was not reset for a retry after a metadata update", err)
+		}
+		select {
+		case <-cl.updateMetadataNowCh:
+			now = true
+		default:
+		}
+		select {
+		case <-cl.updateMetadataCh:
+			later = true
+		default:
+		}
+		return now, later
+	}
+
+	// A leader that moved or a broker shutting down bypasses the
+	// metadata refresh backoff.
+	for _, err := range []error{
+		kerr.NotLeaderForPartition,
+		kerr.LeaderNotAvailable,
+		kerr.ReplicaNotAvailable,
+		kerr.KafkaStorageError,
+		kerr.FencedLeaderEpoch,
+	} {
+		if now, later := produce(err); !now || later {
+			t.Errorf("%v: got immediate update %v, backed off update %v, expected only an immediate update", err, now, later)
+		}
+	}
+
+	// Other retriable errors keep the normal backoff.
+	if now, later := produce(kerr.UnknownTopicOrPartition); now || !later {
+		t.Errorf("UNKNOWN_TOPIC_OR_PARTITION: got immediate update %v, backed off update %v, expected only a backed off update", now, later)
+	}
+}
@@ -1,9 +1,14 @@
 package kgo
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"reflect"
 	"time"
 	"unsafe"
+
+	"github.com/twmb/franz-go/pkg/kmsg"
 )
 
 // RecordHeader contains extra information that can be sent with Records.
@@ -64,6 +69,62 @@ func (a RecordAttrs) IsControl() bool {
 	return a.attrs&0b0010_0000 != 0
 }
 
+// ControlRecord is a decoded control record, as returned from
+// ParseControlRecord.
+type ControlRecord struct {
+	// Key is the decoded control record key, which contains the type of
+	// the control record.
+	Key kmsg.ControlRecordKey
+
+	// EndTxnMarker is the decoded value for ABORT and COMMIT control
+	// records, and is nil for other types.
+	EndTxnMarker *kmsg.EndTxnMarker
+
+	// LeaderChange is the decoded value for LEADER_CHANGE control records,
+	// and is nil for other types.
+	LeaderChange *kmsg.LeaderChangeMessage
+}
+
+// ErrNotControlRecord is returned from ParseControlRecord if the input record
+// is not a control record.
+var ErrNotControlRecord = errors.New("record is not a control record")
+
+// ParseControlRecord decodes the key and value of a control record. Control
+// records are only returned from polling if the client is configured with
+// KeepControlRecords.
+//
+// Control record values are decoded for the known types: ABORT and COMMIT
+// values are decoded into EndTxnMarker, and LEADER_CHANGE values are decoded
+// into LeaderChange. The values of other types are not decoded, and can be
+// decoded manually from the record's Value.
+func ParseControlRecord(r *Record) (ControlRecord, error) {
+	var c ControlRecord
+	if !r.Attrs.IsControl() {
+		return c, ErrNotControlRecord
+	}
+	// The key is an int16 version and an int16 type. We decode it
+	// ourselves because older kmsg versions decode the type as an int8,
+	// which reads the high byte of the type and sees every type as ABORT.
+	if len(r.Key) < 4 {
+		return c, fmt.Errorf("unable to decode control record key: key is %d bytes, expected at least 4", len(r.Key))
+	}
+	c.Key.Version = int16(binary.BigEndian.Uint16(r.Key))
+	c.Key.Type = kmsg.ControlRecordKeyType(int16(binary.BigEndian.Uint16(r.Key[2:])))
+	switch c.Key.Type {
+	case kmsg.ControlRecordKeyTypeAbort, kmsg.ControlRecordKeyTypeCommit:
+		c.EndTxnMarker = new(kmsg.EndTxnMarker)
+		if err := c.EndTxnMarker.ReadFrom(r.Value); err != nil {
+			return c, fmt.Errorf("unable to decode %s control record value: %w", c.Key.Type, err)
+		}
+	case kmsg.ControlRecordKeyTypeLeaderChange:
+		c.LeaderChange = new(kmsg.LeaderChangeMessage)
+		if err := c.LeaderChange.ReadFrom(r.Value); err != nil {
+			return c, fmt.Errorf("unable to decode %s control record value: %w", c.Key.Type, err)
+		}
+	}
+	return c, nil
+}
+
 // Record is a record to write to Kafka.
 type Record struct {
 	// Key is an optional field that can be used for partition assignment.
@@ -126,6 +187,21 @@ type Record struct {
 	// record was written, or -1 if on message sets.
 	LeaderEpoch int32
 
+	// Lag is, for consumed records, how many records were after this
+	// record in its partition at the time it was fetched: the partition's
+	// high watermark in the fetch response minus this record's offset
+	// minus one. When consuming with ReadCommitted, the last stable offset
+	// is used rather than the high watermark, since records past it cannot
+	// be consumed yet. This is only set with the AnnotateRecordLag option,
+	// and is -1 if the broker did not return the offset. A lag that does
+	// not fit in an int32 is capped at math.MaxInt32.
+	//
+	// This is an int32 so that it fits in padding after LeaderEpoch and
+	// does not grow the Record struct.
+	//
+	// For producing, this is unused.
+	Lag int32
+
 	// Offset is the offset that a record is written as.
 	//
 	// For producing, this is left unset. This will be set by the client as
@@ -135,6 +211,16 @@ type Record struct {
 	Offset int64
 }
 
+// userSize returns the size of the user provided portions of a record: the
+// key, value, and headers. This is used for tracking buffered bytes.
+func (r *Record) userSize() int64 {
+	s := len(r.Key) + len(r.Value)
+	for _, h := range r.Headers {
+		s += len(h.Key) + len(h.Value)
+	}
+	return int64(s)
+}
+
 // AppendFormat appends a record to b given the layout or returns an error if
 // the layout is invalid. This is a one-off shortcut for using
 // NewRecordFormatter. See that function's documentation for the layout
@@ -222,6 +308,12 @@ type FetchPartition struct {
 	LastStableOffset int64
 	// LogStartOffset is the low watermark of this partition, otherwise
 	// known as the earliest offset in the partition.
+	//
+	// These three offsets are as of the fetch response this partition is
+	// from. Fetch responses that have no records for any partition are not
+	// returned from polling, so a caught up partition may not be returned
+	// at all; GetConsumePositions returns the latest watermarks for every
+	// assigned partition, including those that are caught up.
 	LogStartOffset int64
 	// Records contains feched records for this partition.
 	Records []*Record
@@ -293,6 +385,14 @@ type FetchError struct {
 	Err       error
 }
 
+// Error returns the error string, prefixed with the topic and partition.
+func (e FetchError) Error() string {
+	return fmt.Sprintf("topic %s partition %d: %v", e.Topic, e.Partition, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e FetchError) Unwrap() error { return e.Err }
+
 // Errors returns all errors in a fetch with the topic and partition that
 // errored.
 //
@@ -0,0 +1,229 @@
+package kgo
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// controlKey returns a control record key as brokers write it: an int16
+// version and an int16 type.
+func controlKey(typ kmsg.ControlRecordKeyType) []byte {
+	return []byte{0, 0, 0, byte(typ)}
+}
+
+func TestParseControlRecord(t *testing.T) {
+	const controlAttrs = 0b0011_0000 // transactional and control
+
+	commitKey := kmsg.NewControlRecordKey()
+	commitKey.Type = kmsg.ControlRecordKeyTypeCommit
+	marker := kmsg.NewEndTxnMarker()
+	marker.CoordinatorEpoch = 3
+
+	abortKey := kmsg.NewControlRecordKey()
+	abortKey.Type = kmsg.ControlRecordKeyTypeAbort
+
+	leaderKey := kmsg.NewControlRecordKey()
+	leaderKey.Type = kmsg.ControlRecordKeyTypeLeaderChange
+	leader := kmsg.NewLeaderChangeMessage()
+	leader.LeaderID = 2
+	leader.Voters = []kmsg.LeaderChangeMessageVoter{{VoterID: 1}, {VoterID: 2}}
+	leader.GrantingVoters = []kmsg.LeaderChangeMessageVoter{{VoterID: 2}}
+
+	for _, test := range []struct {
+		name   string
+		r      *Record
+		exp    ControlRecord
+		expErr error
+		anyErr bool
+	}{
+		{
+			name: "commit",
+			r:    &Record{Attrs: RecordAttrs{controlAttrs}, Key: controlKey(kmsg.ControlRecordKeyTypeCommit), Value: marker.AppendTo(nil)},
+			exp:  ControlRecord{Key: commitKey, EndTxnMarker: &marker},
+		},
+		{
+			name: "abort",
+			r:    &Record{Attrs: RecordAttrs{controlAttrs}, Key: controlKey(kmsg.ControlRecordKeyTypeAbort), Value: marker.AppendTo(nil)},
+			exp:  ControlRecord{Key: abortKey, EndTxnMarker: &marker},
+		},
+		{
+			name: "leader change",
+			r:    &Record{Attrs: RecordAttrs{controlAttrs}, Key: controlKey(kmsg.ControlRecordKeyTypeLeaderChange), Value: leader.AppendTo(nil)},
+			exp:  ControlRecord{Key: leaderKey, LeaderChange: &leader},
+		},
+		{
+			name:   "not control",
+			r:      &Record{Key: controlKey(kmsg.ControlRecordKeyTypeCommit), Value: marker.AppendTo(nil)},
+			expErr: ErrNotControlRecord,
+		},
+		{
+			name:   "short key",
+			r:      &Record{Attrs: RecordAttrs{controlAttrs}, Key: []byte{0, 0, 1}, Value: marker.AppendTo(nil)},
+			anyErr: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseControlRecord(test.r)
+			if test.anyErr {
+				if err == nil {
+					t.Fatal("got no error, expected one")
+				}
+				return
+			}
+			if !errors.Is(err, test.expErr) {
+				t.Fatalf("got err %v != exp %v", err, test.expErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(test.exp, got) {
+				t.Errorf("got %#v != exp %#v", got, test.exp)
+			}
+		})
+	}
+
+	if _, err := ParseControlRecord(&Record{Attrs: RecordAttrs{controlAttrs}, Key: []byte{0}}); err == nil {
+		t.Error("expected error decoding truncated control key")
+	}
+}
+
+func TestParseControlRecordBrokerBatch(t *testing.T) {
+	// A COMMIT marker batch laid out byte for byte the way the broker
+	// writes it (MemoryRecords.withEndTransactionMarker in Kafka): a
+	// transactional control batch holding one record whose key is an
+	// int16 version and an int16 type, and whose value is an int16 version
+	// and an int32 coordinator epoch. The bytes are written out by hand,
+	// not with kmsg, so that they cannot share a kmsg encoding bug: when
+	// kmsg read the key type as an int8, it read the type's high byte and
+	// decoded every marker as ABORT.
+	raw := []byte{
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x07, // base offset: 7
+		0x00, 0x00, 0x00, 0x42, // batch length: 66
+		0x00, 0x00, 0x00, 0x02, // partition leader epoch: 2
+		0x02,                   // magic: 2
+		0x8a, 0x77, 0x3c, 0x25, // crc
+		0x00, 0x30, // attributes: transactional, control
+		0x00, 0x00, 0x00, 0x00, // last offset delta: 0
+		0x00, 0x00, 0x01, 0x8b, 0xcf, 0xe5, 0x68, 0x00, // base timestamp
+		0x00, 0x00, 0x01, 0x8b, 0xcf, 0xe5, 0x68, 0x00, // max timestamp
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03, 0xe8, // producer ID: 1000
+		0x00, 0x03, // producer epoch: 3
+		0xff, 0xff, 0xff, 0xff, // base sequence: -1
+		0x00, 0x00, 0x00, 0x01, // records: 1
+		0x20,                   // record length: 16
+		0x00,                   // record attributes
+		0x00,                   // timestamp delta
+		0x00,                   // offset delta
+		0x08,                   // key length: 4
+		0x00, 0x00, 0x00, 0x01, // key: version 0, type 1 (COMMIT)
+		0x0c,                               // value length: 6
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x05, // value: version 0, coordinator epoch 5
+		0x00, // headers: 0
+	}
+
+	rp := kmsg.NewFetchResponseTopicPartition()
+	rp.HighWatermark = 8
+	rp.LastStableOffset = 8
+	rp.RecordBatches = raw
+	o := &cursorOffsetNext{
+		from:         &cursor{topic: "t", keepControl: true},
+		cursorOffset: cursorOffset{offset: 7, lastConsumedEpoch: -1},
+	}
+	fp := o.processRespPartition(nil, 12, &rp, newDecompressor(), hooks{})
+	if fp.Err != nil || len(fp.Records) != 1 {
+		t.Fatalf("got err %v and %d records, expected one control record", fp.Err, len(fp.Records))
+	}
+
+	r := fp.Records[0]
+	c, err := ParseControlRecord(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Key.Version != 0 || c.Key.Type != kmsg.ControlRecordKeyTypeCommit {
+		t.Errorf("got key version %d type %v, expected version 0 COMMIT", c.Key.Version, c.Key.Type)
+	}
+	if c.EndTxnMarker == nil || c.EndTxnMarker.Version != 0 || c.EndTxnMarker.CoordinatorEpoch != 5 {
+		t.Errorf("got end txn marker %#v, expected version 0 coordinator epoch 5", c.EndTxnMarker)
+	}
+	if r.Offset != 7 || r.ProducerID != 1000 || r.ProducerEpoch != 3 || o.offset != 8 {
+		t.Errorf("got record offset %d producer %d epoch %d and next offset %d, expected 7, 1000, 3, and 8", r.Offset, r.ProducerID, r.ProducerEpoch, o.offset)
+	}
+}
+
+func TestKeepControlRecordsReadCommitted(t *testing.T) {
+	// An abort marker for producer 1 in a transactional control batch.
+	marker := kmsg.NewEndTxnMarker()
+	marker.CoordinatorEpoch = 7
+	kr := kmsg.Record{Key: controlKey(kmsg.ControlRecordKeyTypeAbort), Value: marker.AppendTo(nil)}
+	kr.Length = int32(len(kr.AppendTo(nil)) - 1)
+	batch := &kmsg.RecordBatch{
+		FirstOffset:   12,
+		Magic:         2,
+		Attributes:    0b0011_0000, // transactional and control
+		ProducerID:    1,
+		NumRecords:    1,
+		Records:       kr.AppendTo(nil),
+		FirstSequence: -1,
+	}
+
+	for _, keep := range []bool{false, true} {
+		a := aborter{1: {10}}
+		o := &cursorOffsetNext{from: &cursor{topic: "t", keepControl: keep}, cursorOffset: cursorOffset{offset: 12, lastConsumedEpoch: -1}}
+		var fp FetchPartition
+		o.processRecordBatch(&fp, batch, a, newDecompressor())
+
+		// Whether or not the marker is kept, it ends the aborted
+		// transaction and we advance past it.
+		if len(a) != 0 || o.offset != 13 {
+			t.Errorf("keep %v: got aborter %v and offset %d, expected no aborts and offset 13", keep, a, o.offset)
+		}
+		if !keep {
+			if len(fp.Records) != 0 {
+				t.Errorf("got %d records without keeping control records, expected 0", len(fp.Records))
+			}
+			continue
+		}
+		if len(fp.Records) != 1 {
+			t.Fatalf("got %d records keeping control records, expected 1", len(fp.Records))
+		}
+		r := fp.Records[0]
+		c, err := ParseControlRecord(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Key.Type != kmsg.ControlRecordKeyTypeAbort || c.EndTxnMarker == nil || c.EndTxnMarker.CoordinatorEpoch != 7 || r.ProducerID != 1 || r.Offset != 12 {
+			t.Errorf("got control record %#v for record %v, expected an abort marker for producer 1 at offset 12", c, r)
+		}
+	}
+}
+
+func TestAnnotateRecordLag(t *testing.T) {
+	fp := FetchPartition{
+		HighWatermark:    10,
+		LastStableOffset: 8,
+		Records:          []*Record{{Offset: 5}, {Offset: 9}, {Offset: 12}},
+	}
+	fp.annotateLag(false)
+	for i, exp := range []int32{4, 0, 0} {
+		if got := fp.Records[i].Lag; got != exp {
+			t.Errorf("record at offset %d: got lag %d, expected %d", fp.Records[i].Offset, got, exp)
+		}
+	}
+
+	// Reading committed, the lag is to the last stable offset.
+	fp.annotateLag(true)
+	for i, exp := range []int32{2, 0, 0} {
+		if got := fp.Records[i].Lag; got != exp {
+			t.Errorf("read committed record at offset %d: got lag %d, expected %d", fp.Records[i].Offset, got, exp)
+		}
+	}
+
+	fp = FetchPartition{HighWatermark: -1, Records: []*Record{{Offset: 5}}}
+	fp.annotateLag(false)
+	if got := fp.Records[0].Lag; got != -1 {
+		t.Errorf("got lag %d without a high watermark, expected -1", got)
+	}
+}
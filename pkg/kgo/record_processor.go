@@ -0,0 +1,143 @@
+package kgo
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// FailurePolicy decides what happens to a record that a RecordProcessor
+// failed to process. The policy is called with the record and the final
+// processing error, which is ErrRecordDeadline if processing did not finish in
+// time. If the policy returns nil, the record is considered handled and
+// processing continues with the next record. If the policy returns an error,
+// processing stops and the error is returned from Process.
+type FailurePolicy func(ctx context.Context, cl *Client, r *Record, err error) error
+
+// SkipFailures returns a FailurePolicy that drops failed records, continuing
+// with the next record.
+func SkipFailures() FailurePolicy {
+	return func(context.Context, *Client, *Record, error) error { return nil }
+}
+
+// StopOnFailure returns a FailurePolicy that stops processing at the first
+// failed record, returning the record's processing error from Process.
+func StopOnFailure() FailurePolicy {
+	return func(_ context.Context, _ *Client, _ *Record, err error) error { return err }
+}
+
+// ProduceFailuresTo returns a FailurePolicy that synchronously produces failed
+// records to the given topic, which can be a retry topic or a dead letter
+// topic. The produced record has the failed record's key, value, and headers,
+// plus headers describing where the record came from and why it failed:
+//
+//     kgo-failed-topic
+//     kgo-failed-partition
+//     kgo-failed-offset
+//     kgo-failed-error
+//
+// If producing fails, processing stops and the produce error is returned.
+func ProduceFailuresTo(topic string) FailurePolicy {
+	return func(ctx context.Context, cl *Client, r *Record, err error) error {
+		return cl.ProduceSync(ctx, failedRecord(topic, r, err)).FirstErr()
+	}
+}
+
+func failedRecord(topic string, r *Record, err error) *Record {
+	headers := make([]RecordHeader, 0, len(r.Headers)+4)
+	headers = append(headers, r.Headers...)
+	headers = append(headers,
+		RecordHeader{"kgo-failed-topic", []byte(r.Topic)},
+		RecordHeader{"kgo-failed-partition", []byte(strconv.Itoa(int(r.Partition)))},
+		RecordHeader{"kgo-failed-offset", []byte(strconv.FormatInt(r.Offset, 10))},
+		RecordHeader{"kgo-failed-error", []byte(err.Error())},
+	)
+	return &Record{
+		Topic:   topic,
+		Key:     r.Key,
+		Value:   r.Value,
+		Headers: headers,
+	}
+}
+
+// RecordProcessor processes records one at a time with a per-record deadline,
+// routing records that fail or take too long to a FailurePolicy so that one
+// poison record cannot stall a partition forever.
+type RecordProcessor struct {
+	// Deadline is how long processing a single record, including
+	// retries, may take. The context passed to the processing function
+	// is canceled at the deadline. If zero, there is no deadline.
+	Deadline time.Duration
+
+	// Retries is how many times to retry processing a record that
+	// returned an error before the record is failed. Records that exceed
+	// the deadline are not retried.
+	Retries int
+
+	// OnFailure is called for records that failed. If nil, failures stop
+	// processing, as with StopOnFailure.
+	OnFailure FailurePolicy
+}
+
+// Process calls fn for each record in order, returning how many records were
+// handled, i.e. either processed successfully or accepted by the failure
+// policy. If the failure policy returns an error, or if ctx is canceled,
+// processing stops and the error is returned. The records up to the returned
+// count are safe to commit, for example with CommitRecords(ctx, rs[:n]...).
+//
+// fn should return promptly once its context is canceled. If fn does not
+// return by the deadline, Process stops waiting for it and fails the record,
+// but the call to fn continues running in the background until it returns.
+func (p RecordProcessor) Process(ctx context.Context, cl *Client, rs []*Record, fn func(context.Context, *Record) error) (int, error) {
+	onFailure := p.OnFailure
+	if onFailure == nil {
+		onFailure = StopOnFailure()
+	}
+	for i, r := range rs {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+		if err := p.processOne(ctx, r, fn); err != nil {
+			if ctx.Err() != nil {
+				return i, ctx.Err()
+			}
+			if err := onFailure(ctx, cl, r, err); err != nil {
+				return i, err
+			}
+		}
+	}
+	return len(rs), nil
+}
+
+// processOne processes a record with retries, returning ErrRecordDeadline if
+// the deadline passed, or the last error.
+func (p RecordProcessor) processOne(ctx context.Context, r *Record, fn func(context.Context, *Record) error) error {
+	rctx := ctx
+	if p.Deadline > 0 {
+		var cancel func()
+		rctx, cancel = context.WithTimeout(ctx, p.Deadline)
+		defer cancel()
+	}
+
+	var err error
+	for try := 0; try <= p.Retries; try++ {
+		done := make(chan error, 1)
+		go func() { done <- fn(rctx, r) }()
+		select {
+		case err = <-done:
+			if err == nil {
+				return nil
+			}
+		case <-rctx.Done():
+		}
+		if rctx.Err() != nil {
+			// If our parent context was canceled, the record did
+			// not fail; Process returns the context error.
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return ErrRecordDeadline
+		}
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package kgo
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRecordProcessor(t *testing.T) {
+	rs := []*Record{
+		{Topic: "t", Offset: 0},
+		{Topic: "t", Offset: 1}, // poison: blocks, ignoring its context
+		{Topic: "t", Offset: 2}, // fails once, then succeeds
+		{Topic: "t", Offset: 3}, // always fails
+		{Topic: "t", Offset: 4},
+	}
+	block := make(chan struct{})
+	defer close(block)
+
+	var mu sync.Mutex
+	tries := make(map[int64]int)
+	fn := func(_ context.Context, r *Record) error {
+		mu.Lock()
+		tries[r.Offset]++
+		try := tries[r.Offset]
+		mu.Unlock()
+		switch r.Offset {
+		case 1:
+			<-block
+		case 2:
+			if try == 1 {
+				return errors.New("transient")
+			}
+		case 3:
+			return errors.New("permanent")
+		}
+		return nil
+	}
+
+	failed := make(map[int64]error)
+	p := RecordProcessor{
+		Deadline: 20 * time.Millisecond,
+		Retries:  1,
+		OnFailure: func(_ context.Context, _ *Client, r *Record, err error) error {
+			failed[r.Offset] = err
+			return nil
+		},
+	}
+	n, err := p.Process(context.Background(), nil, rs, fn)
+	if n != len(rs) || err != nil {
+		t.Fatalf("got (%d, %v) != exp (%d, nil)", n, err, len(rs))
+	}
+	if len(failed) != 2 || failed[1] != ErrRecordDeadline || failed[3] == nil || failed[3].Error() != "permanent" {
+		t.Errorf("unexpected failures %v", failed)
+	}
+	mu.Lock()
+	if exp := map[int64]int{0: 1, 1: 1, 2: 2, 3: 2, 4: 1}; !reflect.DeepEqual(tries, exp) {
+		t.Errorf("got tries %v != exp %v", tries, exp)
+	}
+	mu.Unlock()
+
+	// Without a failure policy, we stop at the first failure.
+	p.OnFailure = nil
+	n, err = p.Process(context.Background(), nil, rs[2:], fn)
+	if n != 1 || err == nil || err.Error() != "permanent" {
+		t.Errorf("got (%d, %v) != exp (1, permanent)", n, err)
+	}
+}
+
+func TestFailedRecord(t *testing.T) {
+	r := &Record{
+		Topic:     "src",
+		Partition: 3,
+		Offset:    42,
+		Key:       []byte("k"),
+		Value:     []byte("v"),
+		Headers:   []RecordHeader{{"h", []byte("hv")}},
+	}
+	got := failedRecord("dlq", r, errors.New("boom"))
+	exp := &Record{
+		Topic: "dlq",
+		Key:   []byte("k"),
+		Value: []byte("v"),
+		Headers: []RecordHeader{
+			{"h", []byte("hv")},
+			{"kgo-failed-topic", []byte("src")},
+			{"kgo-failed-partition", []byte("3")},
+			{"kgo-failed-offset", []byte("42")},
+			{"kgo-failed-error", []byte("boom")},
+		},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("got %v != exp %v", got, exp)
+	}
+}
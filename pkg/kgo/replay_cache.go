@@ -0,0 +1,143 @@
+package kgo
+
+import (
+	"sort"
+	"sync"
+)
+
+// replayCache keeps recently polled records so that they can be replayed
+// without refetching; see the ConsumeReplayCacheBytes option.
+type replayCache struct {
+	maxBytes int64
+
+	mu     sync.Mutex
+	bytes  int64
+	parts  map[string]map[int32]*replayPartition
+	chunks []replayChunk // in the order added, oldest first
+}
+
+// replayPartition is the cached records for a partition, in increasing offset
+// order.
+type replayPartition struct {
+	records []*Record
+}
+
+// replayChunk tracks records added to a partition at once, so that we can
+// evict the oldest records across all partitions.
+//
+// If a partition is reset, its old chunks continue to account for bytes until
+// they are evicted. This overcounts our size a bit, but it keeps eviction
+// simple and the cache stays bounded.
+type replayChunk struct {
+	p     *replayPartition
+	n     int
+	bytes int64
+}
+
+func newReplayCache(maxBytes int64) *replayCache {
+	return &replayCache{
+		maxBytes: maxBytes,
+		parts:    make(map[string]map[int32]*replayPartition),
+	}
+}
+
+// add caches all records in fetches, evicting the oldest records if we are
+// over our byte limit.
+func (c *replayCache) add(fetches Fetches) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, f := range fetches {
+		for _, t := range f.Topics {
+			for _, fp := range t.Partitions {
+				if len(fp.Records) > 0 {
+					c.addPartition(t.Topic, fp.Partition, fp.Records)
+				}
+			}
+		}
+	}
+
+	for c.bytes > c.maxBytes && len(c.chunks) > 0 {
+		chunk := c.chunks[0]
+		c.chunks[0] = replayChunk{}
+		c.chunks = c.chunks[1:]
+		c.bytes -= chunk.bytes
+
+		p := chunk.p
+		if chunk.n > len(p.records) { // reset since this chunk was added
+			chunk.n = len(p.records)
+		}
+		for i := 0; i < chunk.n; i++ {
+			p.records[i] = nil
+		}
+		p.records = p.records[chunk.n:]
+	}
+}
+
+func (c *replayCache) addPartition(topic string, partition int32, records []*Record) {
+	ps := c.parts[topic]
+	if ps == nil {
+		ps = make(map[int32]*replayPartition)
+		c.parts[topic] = ps
+	}
+	p := ps[partition]
+
+	// If the partition was rewound, e.g. with SetOffsets, the new records
+	// overlap what we have cached. We drop what we have so that our
+	// records stay in increasing offset order.
+	if p != nil && len(p.records) > 0 && records[0].Offset <= p.records[len(p.records)-1].Offset {
+		p.records = nil
+		p = nil
+	}
+	if p == nil {
+		p = new(replayPartition)
+		ps[partition] = p
+	}
+
+	var bytes int64
+	for _, r := range records {
+		bytes += r.userSize()
+	}
+	p.records = append(p.records, records...)
+	c.chunks = append(c.chunks, replayChunk{p, len(records), bytes})
+	c.bytes += bytes
+}
+
+// replay returns the cached records for the partition starting at offset.
+func (c *replayCache) replay(topic string, partition int32, offset int64) ([]*Record, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p := c.parts[topic][partition]
+	if p == nil || len(p.records) == 0 || offset < p.records[0].Offset {
+		return nil, false
+	}
+	last := p.records[len(p.records)-1].Offset
+	if offset > last+1 {
+		return nil, false
+	}
+	start := sort.Search(len(p.records), func(i int) bool { return p.records[i].Offset >= offset })
+	return append([]*Record(nil), p.records[start:]...), true
+}
+
+// ReplayRecords returns the records for a partition from the replay cache,
+// starting at the first cached record at or after the given offset through
+// the last record that was polled for the partition. This can be used to
+// reprocess records, for example to retry a failed handler, without
+// refetching from the broker. This returns false if the replay cache is not
+// enabled, if the offset has been evicted from the cache, or if the offset is
+// past what has been polled. If the offset is exactly one past the last polled
+// record, this returns no records and true.
+//
+// The returned records are the same records that were returned from polling
+// and must not be modified if they are to be replayed again. Replaying records
+// does not change what is consumed next, nor does it change what is committed.
+//
+// See ConsumeReplayCacheBytes for more details.
+func (cl *Client) ReplayRecords(topic string, partition int32, offset int64) ([]*Record, bool) {
+	c := cl.consumer.replay
+	if c == nil {
+		return nil, false
+	}
+	return c.replay(topic, partition, offset)
+}
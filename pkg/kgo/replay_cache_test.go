@@ -0,0 +1,70 @@
+package kgo
+
+import (
+	"testing"
+)
+
+func TestReplayCache(t *testing.T) {
+	t.Parallel()
+
+	fetch := func(topic string, partition int32, offsets ...int64) Fetches {
+		fp := FetchPartition{Partition: partition}
+		for _, o := range offsets {
+			fp.Records = append(fp.Records, &Record{Topic: topic, Partition: partition, Offset: o, Value: []byte("0123456789")})
+		}
+		return Fetches{{Topics: []FetchTopic{{Topic: topic, Partitions: []FetchPartition{fp}}}}}
+	}
+	offsets := func(rs []*Record) []int64 {
+		out := []int64{}
+		for _, r := range rs {
+			out = append(out, r.Offset)
+		}
+		return out
+	}
+	check := func(c *replayCache, topic string, partition int32, offset int64, expOK bool, exp ...int64) {
+		t.Helper()
+		rs, ok := c.replay(topic, partition, offset)
+		if ok != expOK {
+			t.Fatalf("replay %s[%d] at %d: got ok %v != exp %v", topic, partition, offset, ok, expOK)
+		}
+		if !ok {
+			return
+		}
+		got := offsets(rs)
+		if exp == nil {
+			exp = []int64{}
+		}
+		if len(got) != len(exp) {
+			t.Fatalf("replay %s[%d] at %d: got %v != exp %v", topic, partition, offset, got, exp)
+		}
+		for i := range got {
+			if got[i] != exp[i] {
+				t.Fatalf("replay %s[%d] at %d: got %v != exp %v", topic, partition, offset, got, exp)
+			}
+		}
+	}
+
+	c := newReplayCache(50) // five records
+
+	c.add(fetch("t", 0, 0, 1, 2))
+	c.add(fetch("t", 1, 5, 7)) // gap, as if compacted
+	check(c, "t", 0, 0, true, 0, 1, 2)
+	check(c, "t", 0, 2, true, 2)
+	check(c, "t", 0, 3, true)
+	check(c, "t", 0, 4, false)
+	check(c, "t", 1, 6, true, 7)
+	check(c, "t", 2, 0, false)
+
+	// Adding one more record evicts the oldest chunk, all of partition 0.
+	c.add(fetch("t", 1, 8))
+	check(c, "t", 0, 0, false)
+	check(c, "t", 1, 5, true, 5, 7, 8)
+
+	// Rewinding partition 1 drops what was cached for it.
+	c.add(fetch("t", 1, 6))
+	check(c, "t", 1, 5, false)
+	check(c, "t", 1, 6, true, 6)
+	if c.bytes > c.maxBytes {
+		t.Errorf("cache bytes %d > max %d", c.bytes, c.maxBytes)
+	}
+}
@@ -171,7 +171,7 @@ func (s *sink) maybeDrain() {
 		return
 	}
 	if s.drainState.maybeBegin() {
-		go s.drain()
+		s.cl.actors.spawn("sink drain", brokerLabels("produce", s.nodeID), s.drain)
 	}
 }
 
@@ -229,6 +229,11 @@ func (s *sink) drain() {
 	for again {
 		s.maybeBackoff()
 
+		if !s.cl.paceRequest(s.nodeID, kmsg.Produce.Int16(), s.cl.ctx.Done()) {
+			s.drainState.hardFinish()
+			return
+		}
+
 		sem := s.inflightSem.Load().(chan struct{})
 		select {
 		case sem <- struct{}{}:
@@ -237,7 +242,8 @@ func (s *sink) drain() {
 			return
 		}
 
-		again = s.drainState.maybeFinish(s.produce(sem))
+		produced := s.produce(sem)
+		again = s.drainState.maybeFinish(produced)
 	}
 }
 
@@ -293,7 +299,14 @@ func (s *sink) produce(sem <-chan struct{}) bool {
 	// the request, otherwise we will create a request with the old
 	// sequence numbers using our new producer ID, which will then again
 	// fail with OOOSN.
+	//
+	// We only bound creating the request: loading the producer ID above
+	// and adding partitions to a transaction below are round trips.
+	if !s.cl.producer.drainLimit.acquire(s.cl.ctx.Done()) {
+		return false // client closing
+	}
 	req, txnReq, moreToDrain := s.createReq(id, epoch)
+	s.cl.producer.drainLimit.release()
 	if len(req.batches) == 0 { // everything was failing or lingering
 		return moreToDrain
 	}
@@ -370,7 +383,7 @@ func (s *sink) doSequenced(
 	}
 
 	if first, _ := s.seqResps.push(wait); first {
-		go s.handleSeqResps(wait)
+		s.cl.actors.spawn("sink sequenced responses", brokerLabels("produce", s.nodeID), func() { s.handleSeqResps(wait) })
 	}
 }
 
@@ -421,7 +434,7 @@ func (s *sink) issueTxnReq(
 	req *produceRequest,
 	txnReq *kmsg.AddPartitionsToTxnRequest,
 ) error {
-	resp, err := txnReq.RequestWith(s.cl.ctx, s.cl)
+	resp, err := s.cl.addPartitionsToTxn(txnReq)
 	if err != nil {
 		return err
 	}
@@ -463,6 +476,153 @@ func (s *sink) issueTxnReq(
 	return nil
 }
 
+// txnAdder coalesces AddPartitionsToTxn requests across sinks. Every sink
+// adds the new partitions it is about to produce to before producing, so a
+// transaction that fans out to many brokers would otherwise issue a request
+// per broker. While one request is in flight, requests from other sinks
+// queue, and the next request adds every queued partition at once.
+type txnAdder struct {
+	mu      sync.Mutex
+	issuing bool
+	waiting []*txnAdd
+}
+
+type txnAdd struct {
+	req  *kmsg.AddPartitionsToTxnRequest
+	resp *kmsg.AddPartitionsToTxnResponse
+	err  error
+	done chan struct{}
+}
+
+// addPartitionsToTxn issues req, potentially merged with concurrent requests
+// from other sinks, and returns a response containing only the partitions in
+// req.
+//
+// The first sink to add partitions issues requests until nothing is queued;
+// other sinks wait for their partitions to be added.
+func (cl *Client) addPartitionsToTxn(req *kmsg.AddPartitionsToTxnRequest) (*kmsg.AddPartitionsToTxnResponse, error) {
+	a := &cl.producer.txnAdder
+	add := &txnAdd{req: req, done: make(chan struct{})}
+
+	a.mu.Lock()
+	a.waiting = append(a.waiting, add)
+	if a.issuing {
+		a.mu.Unlock()
+		<-add.done
+		return add.resp, add.err
+	}
+	a.issuing = true
+	for len(a.waiting) > 0 {
+		adds := a.waiting
+		a.waiting = nil
+		a.mu.Unlock()
+		cl.issueTxnAdds(adds)
+		a.mu.Lock()
+	}
+	a.issuing = false
+	a.mu.Unlock()
+
+	return add.resp, add.err
+}
+
+// issueTxnAdds merges the queued adds into as few requests as possible and
+// splits the responses back out. Adds are only merged if they are for the
+// same producer ID and epoch, which is always the case unless the producer ID
+// changed while adds were queued.
+func (cl *Client) issueTxnAdds(adds []*txnAdd) {
+	for len(adds) > 0 {
+		first := adds[0].req
+		var merging, rest []*txnAdd
+		for _, add := range adds {
+			if add.req.ProducerID == first.ProducerID && add.req.ProducerEpoch == first.ProducerEpoch {
+				merging = append(merging, add)
+			} else {
+				rest = append(rest, add)
+			}
+		}
+		adds = rest
+
+		req := first
+		if len(merging) > 1 {
+			req = kmsg.NewPtrAddPartitionsToTxnRequest()
+			req.TransactionalID = first.TransactionalID
+			req.ProducerID = first.ProducerID
+			req.ProducerEpoch = first.ProducerEpoch
+			topicIdx := make(map[string]int)
+			for _, add := range merging {
+				for _, t := range add.req.Topics {
+					idx, exists := topicIdx[t.Topic]
+					if !exists {
+						idx = len(req.Topics)
+						topicIdx[t.Topic] = idx
+						reqTopic := kmsg.NewAddPartitionsToTxnRequestTopic()
+						reqTopic.Topic = t.Topic
+						req.Topics = append(req.Topics, reqTopic)
+					}
+					req.Topics[idx].Partitions = append(req.Topics[idx].Partitions, t.Partitions...)
+				}
+			}
+		}
+
+		cl.metrics.observeAddPartitionsToTxn()
+		resp, err := req.RequestWith(cl.ctx, cl)
+
+		var codes map[string]map[int32]int16
+		if err == nil {
+			codes = make(map[string]map[int32]int16, len(resp.Topics))
+			for _, t := range resp.Topics {
+				tcodes := make(map[int32]int16, len(t.Partitions))
+				for _, p := range t.Partitions {
+					tcodes[p.Partition] = p.ErrorCode
+					if p.ErrorCode == 0 {
+						atomic.AddInt64(&cl.producer.txnPartitions, 1)
+					}
+				}
+				codes[t.Topic] = tcodes
+			}
+		}
+
+		for _, add := range merging {
+			add.err = err
+			if err == nil {
+				add.resp = splitTxnAddResp(resp, add.req, codes)
+			}
+			close(add.done)
+		}
+	}
+}
+
+// splitTxnAddResp returns a response with only the partitions in req.
+func splitTxnAddResp(
+	merged *kmsg.AddPartitionsToTxnResponse,
+	req *kmsg.AddPartitionsToTxnRequest,
+	codes map[string]map[int32]int16,
+) *kmsg.AddPartitionsToTxnResponse {
+	resp := kmsg.NewPtrAddPartitionsToTxnResponse()
+	resp.Version = merged.Version
+	resp.ThrottleMillis = merged.ThrottleMillis
+	for _, t := range req.Topics {
+		tcodes, ok := codes[t.Topic]
+		if !ok {
+			continue
+		}
+		respTopic := kmsg.NewAddPartitionsToTxnResponseTopic()
+		respTopic.Topic = t.Topic
+		for _, p := range t.Partitions {
+			code, ok := tcodes[p]
+			if !ok {
+				continue
+			}
+			respPartition := kmsg.NewAddPartitionsToTxnResponseTopicPartition()
+			respPartition.Partition = p
+			respPartition.ErrorCode = code
+			respTopic.Partitions = append(respTopic.Partitions, respPartition)
+		}
+		resp.Topics = append(resp.Topics, respTopic)
+	}
+	return resp
+}
+
 // firstRespCheck is effectively a sink.Once. On the first response, if the
 // used request version is at least 4, we upgrade our inflight sem.
 //
@@ -501,7 +661,7 @@ func (s *sink) handleReqClientErr(req *produceRequest, err error) {
 		if updateMeta {
 			s.cl.cfg.logger.Log(LogLevelInfo, "produce request failed triggering metadata update", "broker", logID(s.nodeID), "err", err)
 		}
-		s.handleRetryBatches(req.batches, req.backoffSeq, updateMeta, false, "failed produce request triggering metadata update")
+		s.handleRetryBatches(req.batches, req.backoffSeq, updateMeta, isBrokerGoneErr(err), false, "failed produce request triggering metadata update")
 
 	case err == ErrClientClosed:
 		s.cl.failBufferedRecords(ErrClientClosed)
@@ -524,7 +684,7 @@ func (s *sink) handleReqRespNoack(b *bytes.Buffer, debug bool, req *produceReque
 				if debug {
 					fmt.Fprintf(b, "%d{0=>%d}, ", partition, len(batch.records))
 				}
-				s.cl.finishBatch(batch.recBatch, req.producerID, req.producerEpoch, partition, 0, nil)
+				s.cl.finishBatch(batch.recBatch, req.producerID, req.producerEpoch, partition, 0, nil, nil, nil)
 			} else if debug {
 				fmt.Fprintf(b, "%d{skipped}, ", partition)
 			}
@@ -564,7 +724,10 @@ func (s *sink) handleReqResp(br *broker, req *produceRequest, resp kmsg.Response
 		return
 	}
 
-	var reqRetry seqRecBatches // handled at the end
+	var (
+		reqRetry    seqRecBatches // handled at the end
+		leaderMoved bool          // if any retry is because a leader moved
+	)
 
 	pr := resp.(*kmsg.ProduceResponse)
 	for _, rTopic := range pr.Topics {
@@ -600,9 +763,16 @@ func (s *sink) handleReqResp(br *broker, req *produceRequest, resp kmsg.Response
 				req.producerEpoch,
 				rPartition.BaseOffset,
 				rPartition.ErrorCode,
+				rPartition.ErrorMessage,
+				rPartition.ErrorRecords,
 			)
 			if retry {
 				reqRetry.addSeqBatch(topic, partition, batch)
+				err := kerr.ErrorForCode(rPartition.ErrorCode)
+				leaderMoved = leaderMoved || isLeaderMovedErr(err)
+				if confirmsLeaderMove(err) {
+					batch.owner.leaderMoved.set(true)
+				}
 			}
 			if !didProduce {
 				delete(tmetrics, partition)
@@ -623,10 +793,10 @@ func (s *sink) handleReqResp(br *broker, req *produceRequest, resp kmsg.Response
 
 	if len(req.batches) > 0 {
 		s.cl.cfg.logger.Log(LogLevelError, "Kafka did not reply to all topics / partitions in the produce request! reenqueuing missing partitions", "broker", logID(s.nodeID))
-		s.handleRetryBatches(req.batches, 0, true, false, "kafka did not reply to all topics in produce request")
+		s.handleRetryBatches(req.batches, 0, true, false, false, "kafka did not reply to all topics in produce request")
 	}
 	if len(reqRetry) > 0 {
-		s.handleRetryBatches(reqRetry, 0, true, true, "produce request had retry batches")
+		s.handleRetryBatches(reqRetry, 0, true, leaderMoved, true, "produce request had retry batches")
 	}
 }
 
@@ -639,6 +809,8 @@ func (s *sink) handleReqRespBatch(
 	producerEpoch int16,
 	baseOffset int64,
 	errorCode int16,
+	errorMessage *string,
+	errorRecords []kmsg.ProduceResponseTopicPartitionErrorRecord,
 ) (retry, didProduce bool) {
 	batch.owner.mu.Lock()
 	defer batch.owner.mu.Unlock()
@@ -677,7 +849,54 @@ func (s *sink) handleReqRespBatch(
 	batch.canFailFromLoadErrs = true
 
 	err := kerr.ErrorForCode(errorCode)
+
+	// If a batch before this one was removed while this batch was
+	// inflight, this batch was sent with sequence numbers that accounted
+	// for the removed batch, and Kafka replies out of order. We
+	// redrain with the corrected sequence numbers.
+	if batch.resequenced {
+		batch.resequenced = false
+		if err == kerr.OutOfOrderSequenceNumber {
+			if debug {
+				fmt.Fprintf(b, "resequencing@%d,%d}, ", baseOffset, nrec)
+			}
+			batch.owner.resetBatchDrainIdx()
+			s.maybeDrain()
+			return false, false
+		}
+	}
+
 	switch {
+	case err == kerr.MessageTooLarge:
+		// Kafka's max.message.bytes (or the topic's message.max.bytes)
+		// can be lower than our ProducerBatchMaxBytes. As with the Java
+		// client, we split the batch in half and retry the halves, and
+		// only fail records that are too large on their own.
+		if nrec > 1 {
+			s.cl.cfg.logger.Log(LogLevelInfo, "batch was too large for Kafka, splitting in half and retrying",
+				"broker", logID(s.nodeID),
+				"topic", topic,
+				"partition", partition,
+				"records", nrec,
+			)
+			batch.owner.splitBatch0()
+			if debug {
+				fmt.Fprintf(b, "splitting@%d,%d}, ", baseOffset, nrec)
+			}
+		} else {
+			s.cl.cfg.logger.Log(LogLevelInfo, "record was too large for Kafka, failing it",
+				"broker", logID(s.nodeID),
+				"topic", topic,
+				"partition", partition,
+			)
+			batch.owner.failBatch0(err, errorMessage)
+			if debug {
+				fmt.Fprintf(b, "toolarge@%d,%d}, ", baseOffset, nrec)
+			}
+		}
+		s.maybeDrain()
+		return false, false
+
 	case kerr.IsRetriable(err) &&
 		err != kerr.CorruptMessage &&
 		batch.tries < s.cl.cfg.recordRetries:
@@ -737,7 +956,7 @@ func (s *sink) handleReqRespBatch(
 			)
 			s.cl.failProducerID(producerID, producerEpoch, err)
 
-			s.cl.finishBatch(batch.recBatch, producerID, producerEpoch, partition, baseOffset, err)
+			s.cl.finishBatch(batch.recBatch, producerID, producerEpoch, partition, baseOffset, err, errorMessage, errorRecords)
 			if debug {
 				fmt.Fprintf(b, "fatal@%d,%d(%s)}, ", baseOffset, nrec, err)
 			}
@@ -795,7 +1014,7 @@ func (s *sink) handleReqRespBatch(
 				"max_retries_reached", batch.tries >= s.cl.cfg.recordRetries,
 			)
 		}
-		s.cl.finishBatch(batch.recBatch, producerID, producerEpoch, partition, baseOffset, err)
+		s.cl.finishBatch(batch.recBatch, producerID, producerEpoch, partition, baseOffset, err, errorMessage, errorRecords)
 		didProduce = err == nil
 		if debug {
 			if err != nil {
@@ -809,18 +1028,40 @@ func (s *sink) handleReqRespBatch(
 }
 
 // finishBatch removes a batch from its owning record buffer and finishes all
-// records in the batch.
+// records in the batch. If the batch failed, the records are failed with
+// ErrProduceFailed, using the error message and error records from the
+// produce response.
 //
 // This is safe even if the owning recBuf migrated sinks, since we are
 // finishing based off the status of an inflight req from the original sink.
-func (cl *Client) finishBatch(batch *recBatch, producerID int64, producerEpoch int16, partition int32, baseOffset int64, err error) {
+func (cl *Client) finishBatch(
+	batch *recBatch,
+	producerID int64,
+	producerEpoch int16,
+	partition int32,
+	baseOffset int64,
+	err error,
+	errorMessage *string,
+	errorRecords []kmsg.ProduceResponseTopicPartitionErrorRecord,
+) {
 	recBuf := batch.owner
 
 	if err != nil {
 		// We know that Kafka replied this batch is a failure. We can
 		// fail this batch and all batches in this partition.
 		// This will keep sequence numbers correct.
-		recBuf.failAllRecords(err)
+		var errRecs map[int32]string
+		for _, r := range errorRecords {
+			if errRecs == nil {
+				errRecs = make(map[int32]string, len(errorRecords))
+			}
+			var msg string
+			if r.ErrorMessage != nil {
+				msg = *r.ErrorMessage
+			}
+			errRecs[r.RelativeOffset] = msg
+		}
+		recBuf.failAllRecordsFromKafka(err, errorMessage, errRecs)
 		return
 	}
 
@@ -838,6 +1079,8 @@ func (cl *Client) finishBatch(batch *recBatch, producerID int64, producerEpoch i
 	batch.records = nil
 	batch.mu.Unlock()
 
+	cl.producer.promiseLimit.acquire(nil)
+	defer cl.producer.promiseLimit.release()
 	for i, pnr := range records {
 		pnr.Offset = baseOffset + int64(i)
 		pnr.Partition = partition
@@ -866,6 +1109,7 @@ func (s *sink) handleRetryBatches(
 	retry seqRecBatches,
 	backoffSeq uint32,
 	updateMeta bool, // if we should maybe update the metadata
+	updateMetaNow bool, // if updating, whether to bypass the normal refresh backoff
 	canFail bool, // if records can fail if they are at limits
 	why string,
 ) {
@@ -897,7 +1141,11 @@ func (s *sink) handleRetryBatches(
 	// If we do want to metadata update, we only do so if any batch was the
 	// first batch in its buf / not concurrently failed.
 	if needsMetaUpdate {
-		s.cl.triggerUpdateMetadata(true, why)
+		if updateMetaNow {
+			s.cl.triggerUpdateMetadataNow(why)
+		} else {
+			s.cl.triggerUpdateMetadata(true, why)
+		}
 	} else if !updateMeta {
 		s.maybeTriggerBackoff(backoffSeq)
 		s.maybeDrain()
@@ -915,11 +1163,15 @@ func (s *sink) addRecBuf(add *recBuf) {
 	add.clearFailing()
 }
 
-// removeRecBuf removes a record buffer from a sink.
+// removeRecBuf removes a record buffer from a sink, if it is in the sink.
 func (s *sink) removeRecBuf(rm *recBuf) {
 	s.recBufsMu.Lock()
 	defer s.recBufsMu.Unlock()
 
+	if rm.recBufsIdx < 0 || rm.recBufsIdx >= len(s.recBufs) || s.recBufs[rm.recBufsIdx] != rm {
+		return
+	}
+
 	if rm.recBufsIdx != len(s.recBufs)-1 {
 		s.recBufs[rm.recBufsIdx], s.recBufs[len(s.recBufs)-1] =
 			s.recBufs[len(s.recBufs)-1], nil
@@ -962,6 +1214,10 @@ type recBuf struct {
 	// of records buffered in total on this recBuf.
 	buffered int64
 
+	// leaderMoved is set when our leader replies to a produce request that
+	// it is no longer the leader, and cleared when metadata migrates us.
+	leaderMoved atomicBool
+
 	mu sync.Mutex // guards r/w access to all fields below
 
 	// sink is who is currently draining us. This can be modified
@@ -1157,7 +1413,7 @@ func (recBuf *recBuf) bumpRepeatedLoadErr(err error) {
 	batch0.tries++
 	failErr := batch0.maybeFailErr(&recBuf.cl.cfg)
 	if (!recBuf.cl.idempotent() || batch0.canFailFromLoadErrs) && (failErr != nil || !isRetriableBrokerErr(err) && !isDialErr(err) && !kerr.IsRetriable(err)) {
-		recBuf.failAllRecords(err)
+		recBuf.failAllRecordsFromKafka(err, nil, nil)
 	}
 }
 
@@ -1170,8 +1426,23 @@ func (recBuf *recBuf) bumpRepeatedLoadErr(err error) {
 //   - if not idempotent && hit retry / timeout limit
 //   - if batch fails fatally when producing
 func (recBuf *recBuf) failAllRecords(err error) {
+	recBuf.failAllRecordsFn(func(int, int) error { return err })
+}
+
+// failAllRecordsFromKafka fails all buffered records with an error from Kafka,
+// wrapping the error in ErrProduceFailed. The message and errRecords are from
+// a produce response for the first batch, if any.
+func (recBuf *recBuf) failAllRecordsFromKafka(err error, message *string, errRecords map[int32]string) {
+	nrecords := int(atomic.LoadInt64(&recBuf.buffered))
+	recBuf.failAllRecordsFn(produceFailedFn(recBuf.topic, recBuf.partition, err, message, errRecords, nrecords))
+}
+
+// failAllRecordsFn is failAllRecords, failing each record with the error
+// returned from fn for the index of the record's batch and the index of the
+// record within the batch.
+func (recBuf *recBuf) failAllRecordsFn(fn func(batch, record int) error) {
 	recBuf.lockedStopLinger()
-	for _, batch := range recBuf.batches {
+	for b, batch := range recBuf.batches {
 		// We need to guard our clearing of records against a
 		// concurrent produceRequest's write, which can have this batch
 		// buffered wile we are failing.
@@ -1185,7 +1456,7 @@ func (recBuf *recBuf) failAllRecords(err error) {
 		batch.mu.Unlock()
 
 		for i, pnr := range records {
-			recBuf.cl.finishRecordPromise(pnr.promisedRec, err)
+			recBuf.cl.finishRecordPromise(pnr.promisedRec, fn(b, i))
 			records[i] = noPNR
 		}
 		recBuf.cl.pnrPool.put(records)
@@ -1210,6 +1481,86 @@ func (recBuf *recBuf) clearFailing() {
 	}
 }
 
+// splitBatch0 splits the first batch in half after Kafka replied that the
+// batch is too large, replacing it with the two halves and resetting the drain
+// index so that the halves are produced next. The halves keep the sequence
+// numbers of the original batch, so batches that were sent after the first
+// remain valid.
+func (recBuf *recBuf) splitBatch0() {
+	batch := recBuf.batches[0]
+	batch.mu.Lock()
+	records := batch.records
+	batch.records = nil
+	batch.mu.Unlock()
+
+	produceVersion := atomic.LoadInt32(&recBuf.sink.produceVersion)
+	half := len(records) / 2
+	split := make([]*recBatch, 0, len(recBuf.batches)+1)
+	for _, recs := range [][]promisedNumberedRecord{records[:half], records[half:]} {
+		newBatch := recBuf.newRecordBatch()
+		for _, pnr := range recs {
+			if appended, _ := newBatch.tryBuffer(pnr.promisedRec, produceVersion, recBuf.maxRecordBatchBytes, false); appended {
+				continue
+			}
+			// Renumbering a record in a new batch can grow it past
+			// our max batch bytes. We start another batch for it,
+			// and if it does not fit on its own, we fail it as we
+			// would when buffering it.
+			if len(newBatch.records) > 0 {
+				newBatch.tries = batch.tries
+				split = append(split, newBatch)
+				newBatch = recBuf.newRecordBatch()
+				if appended, _ := newBatch.tryBuffer(pnr.promisedRec, produceVersion, recBuf.maxRecordBatchBytes, false); appended {
+					continue
+				}
+			}
+			recBuf.cl.finishRecordPromise(pnr.promisedRec, kerr.MessageTooLarge)
+			atomic.AddInt64(&recBuf.buffered, -1)
+		}
+		if len(newBatch.records) > 0 {
+			newBatch.tries = batch.tries
+			split = append(split, newBatch)
+		}
+	}
+	recBuf.cl.pnrPool.put(records)
+
+	recBuf.batches = append(split, recBuf.batches[1:]...)
+	recBuf.resetBatchDrainIdx()
+}
+
+// failBatch0 fails and removes the first batch, which Kafka replied with a
+// non-retriable error for, without failing any batch after it. This is only
+// safe for errors where Kafka did not accept the batch's sequence numbers, so
+// that the next batch can use them.
+//
+// Any batch after the first that is inflight was sent with sequence numbers
+// that accounted for the removed batch; these are marked so that the out of
+// order sequence number error Kafka replies with causes a redrain rather than
+// failing the partition.
+func (recBuf *recBuf) failBatch0(err error, message *string) {
+	batch := recBuf.batches[0]
+	batch.mu.Lock()
+	records := batch.records
+	batch.records = nil
+	batch.mu.Unlock()
+
+	errFn := produceFailedFn(recBuf.topic, recBuf.partition, err, message, map[int32]string{0: ""}, 1)
+	for i, pnr := range records {
+		recBuf.cl.finishRecordPromise(pnr.promisedRec, errFn(0, i))
+		records[i] = noPNR
+	}
+	recBuf.cl.pnrPool.put(records)
+	atomic.AddInt64(&recBuf.buffered, -int64(len(records)))
+
+	if recBuf.cl.idempotent() && recBuf.batchDrainIdx > 1 {
+		for _, inflight := range recBuf.batches[1:recBuf.batchDrainIdx] {
+			inflight.resequenced = true
+		}
+	}
+	recBuf.batches = recBuf.batches[1:]
+	recBuf.resetBatchDrainIdx()
+}
+
 func (recBuf *recBuf) resetBatchDrainIdx() {
 	recBuf.seq = recBuf.batch0Seq
 	recBuf.batchDrainIdx = 0
@@ -1245,6 +1596,10 @@ type recBatch struct {
 	// process a response.
 	canFailFromLoadErrs bool
 
+	// resequenced is set if this batch was inflight when a batch before
+	// it was removed without being produced; see failBatch0.
+	resequenced bool
+
 	wireLength   int32 // tracks total size this batch would currently encode as, including length prefix
 	v1wireLength int32 // same as wireLength, but for message set v1
 
@@ -1923,7 +2278,11 @@ func (r seqRecBatch) appendTo(
 		w := sliceWriters.Get().(*sliceWriter)
 		defer sliceWriters.Put(w)
 
-		compressed, codec := compressor.compress(w, toCompress, version)
+		var topic string
+		if compressor.adaptive != nil {
+			topic = r.owner.topic // only adaptive compression needs the topic
+		}
+		compressed, codec := compressor.compressTopic(w, toCompress, version, topic)
 		if compressed != nil && // nil would be from an error
 			len(compressed) < len(toCompress) {
 
@@ -0,0 +1,87 @@
+package kgo
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// callbackWatcher warns about user callbacks that block an internal client
+// goroutine for too long; see the WarnSlowCallbacks option.
+type callbackWatcher struct {
+	threshold time.Duration
+	logger    Logger
+}
+
+func noWatch() {}
+
+// watch begins watching a user callback that is about to be called on the
+// current goroutine, returning a function to call once the callback returns.
+// If the callback is still running once the threshold elapses, we log a
+// warning with the callback's stack. If it eventually returns, we log how long
+// it took in total.
+//
+// impl, if non-nil, is the user type implementing the callback (i.e., a hook)
+// and is only formatted when warning.
+func (w *callbackWatcher) watch(callback string, impl interface{}) func() {
+	if w == nil {
+		return noWatch
+	}
+	id := goroutineID()
+	start := time.Now()
+	kvs := func(extra ...interface{}) []interface{} {
+		kvs := []interface{}{"callback", callback}
+		if impl != nil {
+			kvs = append(kvs, "type", fmt.Sprintf("%T", impl))
+		}
+		return append(kvs, extra...)
+	}
+	t := time.AfterFunc(w.threshold, func() {
+		w.logger.Log(LogLevelWarn, "user callback is blocking an internal client goroutine longer than the slow callback threshold",
+			kvs("elapsed", time.Since(start), "threshold", w.threshold, "stack", goroutineStack(id))...)
+	})
+	return func() {
+		if !t.Stop() {
+			w.logger.Log(LogLevelWarn, "slow user callback returned", kvs("elapsed", time.Since(start))...)
+		}
+	}
+}
+
+// goroutineID returns the ID of the current goroutine, which is parsed from
+// the first line of the goroutine's stack, "goroutine 123 [running]:".
+func goroutineID() uint64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
+
+// goroutineStack returns the stack of the goroutine with the given ID, or
+// an empty string if the goroutine no longer exists. Only the stack of the
+// current goroutine can be captured directly, so we capture all stacks and
+// find the one we want. This stops the world briefly, but is only done when
+// a callback is already slow.
+func goroutineStack(id uint64) string {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= 64<<20 {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	prefix := []byte("goroutine " + strconv.FormatUint(id, 10) + " ")
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		if bytes.HasPrefix(stack, prefix) {
+			return string(stack)
+		}
+	}
+	return ""
+}
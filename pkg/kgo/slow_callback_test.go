@@ -0,0 +1,63 @@
+package kgo
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func blockingTestCallback(d time.Duration) { time.Sleep(d) }
+
+func TestWarnSlowCallbacks(t *testing.T) {
+	var buf syncBuffer
+	w := &callbackWatcher{threshold: 10 * time.Millisecond, logger: BasicLogger(&buf, LogLevelWarn, nil)}
+
+	// A fast callback logs nothing.
+	done := w.watch("produce promise", nil)
+	done()
+	time.Sleep(20 * time.Millisecond)
+	if s := buf.String(); s != "" {
+		t.Fatalf("got log %q for a fast callback, expected nothing", s)
+	}
+
+	// A slow callback logs its stack while blocked, and then how long it
+	// took once it returns.
+	done = w.watch("hook", new(syncBuffer))
+	blockingTestCallback(100 * time.Millisecond)
+	done()
+	logged := buf.String()
+	for _, want := range []string{
+		"longer than the slow callback threshold",
+		"callback: hook",
+		"type: *kgo.syncBuffer",
+		"blockingTestCallback",
+		"slow user callback returned",
+	} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("log is missing %q; got:\n%s", want, logged)
+		}
+	}
+
+	// Watching is a no-op without the option.
+	var nilw *callbackWatcher
+	nilw.watch("hook", nil)()
+}
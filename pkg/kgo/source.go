@@ -5,6 +5,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"hash/crc32"
+	"math"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -36,6 +38,11 @@ type source struct {
 	sem        chan struct{} // closed when fetchable, recreated when a buffered fetch exists
 	buffered   bufferedFetch // contains a fetch the source has buffered for polling
 
+	// prefetch, if PrefetchDrainedPartitions is used, is signaled when
+	// polling drains some partitions of the buffered fetch, allowing the
+	// source to fetch those partitions while the rest remain buffered.
+	prefetch chan struct{}
+
 	session fetchSession // supports fetch sessions as per KIP-227
 
 	cursorsMu    sync.Mutex
@@ -126,11 +133,35 @@ type cursor struct {
 
 	topicPartitionData // updated in metadata when session is stopped
 
+	// leaderMoved is set when our leader replies to a fetch that it is no
+	// longer the leader, and cleared when metadata migrates the cursor.
+	leaderMoved atomicBool
+
 	// cursorOffset is our epoch/offset that we are consuming. When a fetch
 	// request is issued, we "freeze" a view of the offset and of the
 	// leader epoch (see cursorOffsetNext for why the leader epoch). When a
 	// buffered fetch is taken, we update the cursor.
 	cursorOffset
+
+	// watermarks is a *cursorWatermarks, the watermarks from the latest
+	// fetch response for this partition, for GetConsumePositions.
+	watermarks atomic.Value
+}
+
+// cursorWatermarks are the watermarks from a fetch response partition.
+type cursorWatermarks struct {
+	highWatermark    int64
+	lastStableOffset int64
+	logStartOffset   int64
+}
+
+// loadWatermarks returns the cursor's latest watermarks, which are all -1 if
+// the partition has not yet been fetched.
+func (c *cursor) loadWatermarks() cursorWatermarks {
+	if w, ok := c.watermarks.Load().(*cursorWatermarks); ok {
+		return *w
+	}
+	return cursorWatermarks{-1, -1, -1}
 }
 
 // cursorOffset tracks offsets/epochs for a cursor.
@@ -215,6 +246,12 @@ type cursorOffsetNext struct {
 	// Basically, any field read in AppendTo needs to be copied into
 	// cursorOffsetNext.
 	currentLeaderEpoch int32
+
+	// standby is whether this cursor is only fetched as a standby
+	// partition; see PrefetchStandbyPartitions. Standby cursors are
+	// requested at their current offset with a small partition limit,
+	// and their records are never processed or returned.
+	standby bool
 }
 
 type cursorOffsetPreferred struct {
@@ -248,6 +285,32 @@ func (p *cursorOffsetPreferred) move() {
 	c.source.addCursor(c)
 }
 
+// failoverToLeaders moves every cursor in a failed fetch request that was
+// fetching from this source as a preferred read replica back to its leader.
+// The moved cursors are removed from the request's used offsets, since the
+// move makes the cursors usable again.
+func (s *source) failoverToLeaders(req *fetchRequest) {
+	for topic, ps := range req.usedOffsets {
+		for partition, o := range ps {
+			if o.from.leader == s.nodeID {
+				continue
+			}
+			s.cl.cfg.logger.Log(LogLevelDebug, "fetch from preferred read replica failed, failing over to the leader",
+				"broker", logID(s.nodeID),
+				"topic", topic,
+				"partition", partition,
+				"leader", o.from.leader,
+			)
+			p := cursorOffsetPreferred{*o, o.from.leader}
+			p.move()
+			delete(ps, partition)
+		}
+		if len(ps) == 0 {
+			delete(req.usedOffsets, topic)
+		}
+	}
+}
+
 type cursorPreferreds []cursorOffsetPreferred
 
 func (cs cursorPreferreds) eachPreferred(fn func(cursorOffsetPreferred)) {
@@ -266,6 +329,23 @@ func (os usedOffsets) eachOffset(fn func(*cursorOffsetNext)) {
 	}
 }
 
+// storeWatermarks keeps the watermarks of every partition in the fetch that
+// does not have an error, even partitions with no records: a fetch with no
+// records is never buffered, and the watermarks of a caught up partition are
+// how users can tell that it is caught up.
+func (os usedOffsets) storeWatermarks(f Fetch) {
+	for _, t := range f.Topics {
+		for i := range t.Partitions {
+			p := &t.Partitions[i]
+			o, ok := os[t.Topic][p.Partition]
+			if !ok || p.Err != nil {
+				continue
+			}
+			o.from.watermarks.Store(&cursorWatermarks{p.HighWatermark, p.LastStableOffset, p.LogStartOffset})
+		}
+	}
+}
+
 func (os usedOffsets) finishUsingAllWithSet() {
 	os.eachOffset(func(o *cursorOffsetNext) { o.from.setOffset(o.cursorOffset); o.from.allowUsable() })
 }
@@ -316,17 +396,26 @@ func (s *source) hook(f *Fetch, buffered, polled bool) {
 	})
 
 	var nrecs int
+	var nbytes int64
 	for i := range f.Topics {
 		t := &f.Topics[i]
 		for j := range t.Partitions {
-			nrecs += len(t.Partitions[j].Records)
+			p := &t.Partitions[j]
+			nrecs += len(p.Records)
+			for _, r := range p.Records {
+				nbytes += r.userSize()
+			}
 		}
 	}
 	if buffered {
 		atomic.AddInt64(&s.cl.consumer.bufferedRecords, int64(nrecs))
+		atomic.AddInt64(&s.cl.consumer.bufferedBytes, nbytes)
 	} else {
 		atomic.AddInt64(&s.cl.consumer.bufferedRecords, -int64(nrecs))
+		atomic.AddInt64(&s.cl.consumer.bufferedBytes, -nbytes)
+		s.cl.consumer.signalUnbuffered()
 	}
+	s.cl.consumer.observeBackpressure(f, buffered)
 }
 
 // takeBuffered drains a buffered fetch and updates offsets.
@@ -388,6 +477,10 @@ func (s *source) takeNBuffered(n int) (Fetch, int, bool) {
 				if len(tCursors) == 0 {
 					delete(b.usedOffsets, t.Topic)
 				}
+				select {
+				case s.prefetch <- struct{}{}:
+				default:
+				}
 				break
 			}
 
@@ -412,12 +505,66 @@ func (s *source) takeNBuffered(n int) (Fetch, int, bool) {
 	return r, taken, drained
 }
 
+// dropBuffered removes partitions in drop from the buffered fetch. The cursors
+// of dropped partitions are allowed to be used again without advancing their
+// offsets, so that the dropped records are fetched again once the partitions
+// are resumed. If nothing remains in the buffered fetch, the fetch is
+// discarded and this returns true.
+func (s *source) dropBuffered(drop pausedTopics) (discarded bool) {
+	var dropped Fetch
+	b := &s.buffered
+	bf := &b.fetch
+
+	keepTopics := bf.Topics[:0]
+	for _, t := range bf.Topics {
+		var droppedPartitions []FetchPartition
+		keepPartitions := t.Partitions[:0]
+		for _, p := range t.Partitions {
+			if !drop.has(t.Topic, p.Partition) {
+				keepPartitions = append(keepPartitions, p)
+				continue
+			}
+			droppedPartitions = append(droppedPartitions, p)
+			if tCursors := b.usedOffsets[t.Topic]; tCursors != nil {
+				if pCursor := tCursors[p.Partition]; pCursor != nil {
+					pCursor.from.allowUsable()
+					delete(tCursors, p.Partition)
+					if len(tCursors) == 0 {
+						delete(b.usedOffsets, t.Topic)
+					}
+				}
+			}
+		}
+		if len(droppedPartitions) > 0 {
+			dropped.Topics = append(dropped.Topics, FetchTopic{
+				Topic:      t.Topic,
+				Partitions: droppedPartitions,
+			})
+		}
+		if len(keepPartitions) > 0 {
+			t.Partitions = keepPartitions
+			keepTopics = append(keepTopics, t)
+		}
+	}
+	bf.Topics = keepTopics
+
+	if len(dropped.Topics) > 0 {
+		s.hook(&dropped, false, false) // unbuffered, not polled
+	}
+	if bf.hasErrorsOrRecords() {
+		return false
+	}
+	s.takeBufferedFn(false, usedOffsets.finishUsingAllWithSet)
+	return true
+}
+
 func (s *source) takeBufferedFn(polled bool, offsetFn func(usedOffsets)) Fetch {
 	r := s.buffered
 	s.buffered = bufferedFetch{}
 	offsetFn(r.usedOffsets)
 	r.doneFetch <- struct{}{}
 	close(s.sem)
+	atomic.AddInt64(&s.cl.consumer.bufferedFetches, -1)
 
 	s.hook(&r.fetch, false, polled) // unbuffered, potentially polled
 
@@ -439,7 +586,22 @@ func (s *source) createReq() *fetchRequest {
 		session: s.session,
 	}
 
+	// If buffered bytes are limited, we only ask for what room remains so
+	// that this fetch does not overshoot the limit by much. We reserve the
+	// room as we take it so that concurrent fetches to other brokers
+	// cannot also take it; fetch releases the reservation.
+	if s.cl.cfg.maxBufferedFetchBytes > 0 {
+		req.reservedBytes = s.cl.consumer.reserveFetchBytes(int64(req.maxBytes))
+		req.maxBytes = int32(req.reservedBytes)
+	}
+
 	paused := s.cl.consumer.loadPaused()
+	unready := s.cl.consumer.loadUnready()
+	standby := s.cl.consumer.loadStandby()
+	var backpressured pausedTopics
+	if bp := s.cl.consumer.bp; bp != nil {
+		backpressured = bp.loadPaused()
+	}
 
 	s.cursorsMu.Lock()
 	defer s.cursorsMu.Unlock()
@@ -448,7 +610,11 @@ func (s *source) createReq() *fetchRequest {
 	for i := 0; i < len(s.cursors); i++ {
 		c := s.cursors[cursorIdx]
 		cursorIdx = (cursorIdx + 1) % len(s.cursors)
-		if !c.usable() || paused.has(c.topic, c.partition) {
+		if !c.usable() || paused.has(c.topic, c.partition) || unready.has(c.topic, c.partition) || backpressured.has(c.topic, c.partition) {
+			continue
+		}
+		if standby.has(c.topic, c.partition) {
+			req.addStandbyCursor(c)
 			continue
 		}
 		req.addCursor(c)
@@ -465,7 +631,7 @@ func (s *source) createReq() *fetchRequest {
 
 func (s *source) maybeConsume() {
 	if s.fetchState.maybeBegin() {
-		go s.loopFetch()
+		s.cl.actors.spawn("source fetch loop", brokerLabels("fetch", s.nodeID), s.loopFetch)
 	}
 }
 
@@ -503,6 +669,12 @@ func (s *source) loopFetch() {
 			s.fetchState.hardFinish()
 			return
 		case <-s.sem:
+		case <-s.prefetch:
+		}
+
+		if !s.cl.paceRequest(s.nodeID, kmsg.Fetch.Int16(), session.ctx.Done()) {
+			s.fetchState.hardFinish()
+			return
 		}
 
 		select {
@@ -543,6 +715,16 @@ func (s *source) loopFetch() {
 func (s *source) fetch(consumerSession *consumerSession, doneFetch chan<- struct{}) (fetched bool) {
 	req := s.createReq()
 
+	// The bytes this fetch can buffer were reserved when creating the
+	// request. The reservation is released after the fetch is buffered
+	// (or is not), at which point buffered bytes account for the fetch.
+	if req.reservedBytes > 0 {
+		defer func() {
+			atomic.AddInt64(&s.cl.consumer.reservedFetchBytes, -req.reservedBytes)
+			s.cl.consumer.signalUnbuffered()
+		}()
+	}
+
 	// For all returns, if we do not buffer our fetch, then we want to
 	// ensure our used offsets are usable again.
 	var (
@@ -568,6 +750,7 @@ func (s *source) fetch(consumerSession *consumerSession, doneFetch chan<- struct
 	if req.numOffsets == 0 { // cursors could have been set unusable
 		return
 	}
+	sent := time.Now()
 
 	// If our fetch is killed, we want to cancel waiting for the response.
 	var (
@@ -605,7 +788,16 @@ func (s *source) fetch(consumerSession *consumerSession, doneFetch chan<- struct
 		alreadySentToDoneFetch = true
 		s.session.reset()
 
-		s.cl.triggerUpdateMetadata(false, "opportunistic load during source backoff") // as good a time as any
+		if isBrokerGoneErr(err) {
+			// If we cannot reach a follower at all, we fail over
+			// to the leader rather than retrying against the
+			// follower. Other errors (our session being stopped,
+			// a connection being cut) retry the same broker.
+			s.failoverToLeaders(req)
+			s.cl.triggerUpdateMetadataNow("fetch broker is unreachable, it may be shutting down")
+		} else {
+			s.cl.triggerUpdateMetadata(false, "opportunistic load during source backoff") // as good a time as any
+		}
 		s.consecutiveFailures++
 		after := time.NewTimer(s.cl.cfg.retryBackoff(s.consecutiveFailures))
 		defer after.Stop()
@@ -646,6 +838,8 @@ func (s *source) fetch(consumerSession *consumerSession, doneFetch chan<- struct
 
 	// The logic below here should be relatively quick.
 
+	req.usedOffsets.storeWatermarks(fetch)
+
 	deleteReqUsedOffset := func(topic string, partition int32) {
 		t := req.usedOffsets[topic]
 		delete(t, partition)
@@ -721,6 +915,18 @@ func (s *source) fetch(consumerSession *consumerSession, doneFetch chan<- struct
 	}
 
 	if fetch.hasErrorsOrRecords() {
+		// If we are prefetching drained partitions, the prior
+		// buffered fetch may not yet be fully polled. We only ever
+		// buffer one fetch, so we wait. If the session is stopped
+		// while waiting, we do not keep our offset advancements so
+		// that these records are fetched again.
+		select {
+		case <-s.sem:
+		case <-ctx.Done():
+			setOffsets = false
+			return
+		}
+
 		buffered = true
 		s.buffered = bufferedFetch{
 			fetch:       fetch,
@@ -728,8 +934,28 @@ func (s *source) fetch(consumerSession *consumerSession, doneFetch chan<- struct
 			usedOffsets: req.usedOffsets,
 		}
 		s.sem = make(chan struct{})
+		if s.cl.cfg.prefetchDrained {
+			s.prefetch = make(chan struct{}, 1)
+		}
+		atomic.AddInt64(&s.cl.consumer.bufferedFetches, 1)
 		s.hook(&fetch, true, false) // buffered, not polled
 		s.cl.consumer.addSourceReadyForDraining(s)
+		return
+	}
+
+	// Standby partitions are requested at an offset that does not
+	// advance, so the broker replies immediately whenever they have data
+	// even if nothing else does. If we have nothing to return, we wait out
+	// the rest of our max wait as if the broker held the fetch.
+	if req.numStandby > 0 {
+		doneFetch <- struct{}{}
+		alreadySentToDoneFetch = true
+		after := time.NewTimer(time.Until(sent.Add(time.Duration(s.cl.cfg.maxWait) * time.Millisecond)))
+		defer after.Stop()
+		select {
+		case <-after.C:
+		case <-ctx.Done():
+		}
 	}
 	return
 }
@@ -803,12 +1029,36 @@ func (s *source) handleReqResp(br *broker, req *fetchRequest, resp *kmsg.FetchRe
 				continue
 			}
 
-			fp := partOffset.processRespPartition(br, resp.Version, rp, s.cl.decompressor, s.cl.cfg.hooks)
+			// We do not process records for standby partitions:
+			// their cursors stay where they are, and only their
+			// errors matter to keep their positions valid.
+			var fp FetchPartition
+			if partOffset.standby {
+				fp = FetchPartition{
+					Partition:        rp.Partition,
+					Err:              kerr.ErrorForCode(rp.ErrorCode),
+					HighWatermark:    rp.HighWatermark,
+					LastStableOffset: rp.LastStableOffset,
+					LogStartOffset:   rp.LogStartOffset,
+				}
+			} else {
+				fp = partOffset.processRespPartition(br, resp.Version, rp, s.cl.decompressor, s.cl.cfg.hooks)
+			}
+			if s.cl.cfg.recordLag {
+				fp.annotateLag(s.cl.cfg.isolationLevel == 1)
+			}
 			if fp.Err != nil {
 				updateMeta = true
 				updateWhy.add(topic, partition, fp.Err)
 			}
 
+			// Our leader saying it is no longer the leader confirms a
+			// leader move that metadata may not have bumped the epoch
+			// for yet.
+			if s.nodeID == partOffset.from.leader && confirmsLeaderMove(fp.Err) {
+				partOffset.from.leaderMoved.set(true)
+			}
+
 			// We only keep the partition if it has no error, or an
 			// error we do not internally retry.
 			var keep bool
@@ -828,6 +1078,26 @@ func (s *source) handleReqResp(br *broker, req *fetchRequest, resp *kmsg.FetchRe
 				kerr.UnknownLeaderEpoch, // our meta is newer than broker we fetched from
 				kerr.OffsetNotAvailable: // fetched from out of sync replica or a behind in-sync one (KIP-392: case 1 and case 2)
 
+				// If we were fetching from a preferred read replica,
+				// the follower may be out of sync, offline, or no
+				// longer a replica at all. Rather than continuing to
+				// retry against the follower, we fail over back to
+				// the leader, which will redirect us to a (possibly
+				// different) preferred replica if one is usable.
+				if s.nodeID != partOffset.from.leader {
+					s.cl.cfg.logger.Log(LogLevelDebug, "fetch from preferred read replica failed, failing over to the leader",
+						"broker", logID(s.nodeID),
+						"topic", topic,
+						"partition", partition,
+						"leader", partOffset.from.leader,
+						"err", fp.Err,
+					)
+					preferreds = append(preferreds, cursorOffsetPreferred{
+						*partOffset,
+						partOffset.from.leader,
+					})
+				}
+
 			case kerr.OffsetOutOfRange:
 				// If we are out of range, we reset to what we can.
 				// With Kafka >= 2.1.0, we should only get offset out
@@ -854,12 +1124,12 @@ func (s *source) handleReqResp(br *broker, req *fetchRequest, resp *kmsg.FetchRe
 				if s.nodeID == partOffset.from.leader { // non KIP-392 case
 					reloadOffsets.addLoad(topic, partition, loadTypeList, offsetLoad{
 						replica: -1,
-						Offset:  s.cl.cfg.resetOffset,
+						Offset:  s.cl.cfg.resetOffsetFor(topic).withinBounds(fp.LogStartOffset, fp.HighWatermark),
 					})
 				} else if partOffset.offset < fp.LogStartOffset { // KIP-392 case 3
 					reloadOffsets.addLoad(topic, partition, loadTypeList, offsetLoad{
 						replica: s.nodeID,
-						Offset:  s.cl.cfg.resetOffset,
+						Offset:  s.cl.cfg.resetOffsetFor(topic).withinBounds(fp.LogStartOffset, fp.HighWatermark),
 					})
 				} else { // partOffset.offset > fp.HighWatermark, KIP-392 case 4
 					if kip320 {
@@ -876,7 +1146,7 @@ func (s *source) handleReqResp(br *broker, req *fetchRequest, resp *kmsg.FetchRe
 						// fallback to listing.
 						reloadOffsets.addLoad(topic, partition, loadTypeList, offsetLoad{
 							replica: -1,
-							Offset:  s.cl.cfg.resetOffset,
+							Offset:  s.cl.cfg.resetOffsetFor(topic),
 						})
 					}
 				}
@@ -902,6 +1172,17 @@ func (s *source) handleReqResp(br *broker, req *fetchRequest, resp *kmsg.FetchRe
 				}
 			}
 
+			if keep && partOffset.standby {
+				if fp.Err != nil {
+					s.cl.cfg.logger.Log(LogLevelDebug, "standby partition fetch errored, not returning the error",
+						"broker", logID(s.nodeID),
+						"topic", topic,
+						"partition", partition,
+						"err", fp.Err,
+					)
+				}
+				keep = false
+			}
 			if keep {
 				fetchTopic.Partitions = append(fetchTopic.Partitions, fp)
 			}
@@ -915,6 +1196,35 @@ func (s *source) handleReqResp(br *broker, req *fetchRequest, resp *kmsg.FetchRe
 	return f, reloadOffsets, preferreds, updateMeta, updateWhy.reason("fetch had inner topic errors")
 }
 
+// annotateLag sets the Lag of every record in the partition; see the
+// AnnotateRecordLag option.
+//
+// When reading committed, records past the last stable offset cannot be
+// consumed yet, so the lag is to the last stable offset rather than the high
+// watermark.
+func (p *FetchPartition) annotateLag(readCommitted bool) {
+	end := p.HighWatermark
+	if readCommitted {
+		end = p.LastStableOffset
+	}
+	for _, r := range p.Records {
+		if end < 0 {
+			r.Lag = -1
+			continue
+		}
+		// A follower's watermarks can trail what it has replicated
+		// and returned to us.
+		switch lag := end - r.Offset - 1; {
+		case lag < 0:
+			r.Lag = 0
+		case lag > math.MaxInt32:
+			r.Lag = math.MaxInt32
+		default:
+			r.Lag = int32(lag)
+		}
+	}
+}
+
 // processRespPartition processes all records in all potentially compressed
 // batches (or message sets).
 func (o *cursorOffsetNext) processRespPartition(br *broker, version int16, rp *kmsg.FetchResponseTopicPartition, decompressor *decompressor, hooks hooks) FetchPartition {
@@ -1061,6 +1371,14 @@ func buildAborter(rp *kmsg.FetchResponseTopicPartition) aborter {
 	for _, abort := range rp.AbortedTransactions {
 		a[abort.ProducerID] = append(a[abort.ProducerID], abort.FirstOffset)
 	}
+	// We track aborts per producer by popping the first abort as we see
+	// its abort marker, which requires each producer's aborts to be in
+	// offset order. Brokers return aborts in offset order, but we do not
+	// rely on that: an out of order abort would have us return aborted
+	// records.
+	for _, firstOffsets := range a {
+		sort.Slice(firstOffsets, func(i, j int) bool { return firstOffsets[i] < firstOffsets[j] })
+	}
 	return a
 }
 
@@ -1129,6 +1447,19 @@ func (o *cursorOffsetNext) processRecordBatch(
 		return 0, 0
 	}
 
+	// If reading committed and this entire batch is aborted, we will keep
+	// none of its records. Unless this is a control batch, which we need
+	// to read for the abort marker, we skip decompressing and decoding
+	// and just advance past the batch.
+	abortBatch := aborter.shouldAbortBatch(batch)
+	if abortBatch && batch.Attributes&0b0010_0000 == 0 {
+		if nextAskOffset := lastOffset + 1; o.offset < nextAskOffset {
+			o.offset = nextAskOffset
+			o.lastConsumedEpoch = batch.PartitionLeaderEpoch
+		}
+		return int(batch.NumRecords), 0
+	}
+
 	rawRecords := batch.Records
 	if compression := byte(batch.Attributes & 0x0007); compression != 0 {
 		var err error
@@ -1159,7 +1490,6 @@ func (o *cursorOffsetNext) processRecordBatch(
 		}
 	}()
 
-	abortBatch := aborter.shouldAbortBatch(batch)
 	for i := range krecords {
 		record := recordToRecord(
 			o.from.topic,
@@ -1494,8 +1824,11 @@ type fetchRequest struct {
 	isolationLevel int8
 
 	numOffsets  int
+	numStandby  int // how many of numOffsets are standby cursors
 	usedOffsets usedOffsets
 
+	reservedBytes int64 // reserved from MaxBufferedFetchBytes, if limited
+
 	topic2id map[string][16]byte
 	id2topic map[[16]byte]string
 
@@ -1522,6 +1855,18 @@ func (f *fetchRequest) addCursor(c *cursor) {
 	f.numOffsets++
 }
 
+// standbyPartMaxBytes is the partition max bytes we request for standby
+// partitions, which keeps them from crowding out partitions we are consuming.
+// We do not use what we fetch, we only want the broker to read the log at the
+// partition's position.
+const standbyPartMaxBytes = 64 << 10
+
+func (f *fetchRequest) addStandbyCursor(c *cursor) {
+	f.addCursor(c)
+	f.usedOffsets[c.topic][c.partition].standby = true
+	f.numStandby++
+}
+
 func (*fetchRequest) Key() int16           { return 1 }
 func (*fetchRequest) MaxVersion() int16    { return 12 }
 func (f *fetchRequest) SetVersion(v int16) { f.version = v }
@@ -1567,6 +1912,7 @@ func (f *fetchRequest) AppendTo(dst []byte) []byte {
 				partition,
 				cursorOffsetNext.offset,
 				cursorOffsetNext.currentLeaderEpoch,
+				cursorOffsetNext.standby,
 			) {
 
 				if reqTopic == nil {
@@ -1584,6 +1930,9 @@ func (f *fetchRequest) AppendTo(dst []byte) []byte {
 				reqPartition.LastFetchedEpoch = -1
 				reqPartition.LogStartOffset = -1
 				reqPartition.PartitionMaxBytes = f.maxPartBytes
+				if cursorOffsetNext.standby && standbyPartMaxBytes < f.maxPartBytes {
+					reqPartition.PartitionMaxBytes = standbyPartMaxBytes
+				}
 				reqTopic.Partitions = append(reqTopic.Partitions, reqPartition)
 			}
 		}
@@ -1697,18 +2046,19 @@ func (s *fetchSession) lookupTopic(topic string) fetchSessionTopic {
 }
 
 type fetchSessionOffsetEpoch struct {
-	offset int64
-	epoch  int32
+	offset  int64
+	epoch   int32
+	standby bool // if a partition stops being standby, we resend it for its full partition max bytes
 }
 
 type fetchSessionTopic map[int32]fetchSessionOffsetEpoch
 
-func (s fetchSessionTopic) hasPartitionAt(partition int32, offset int64, epoch int32) bool {
+func (s fetchSessionTopic) hasPartitionAt(partition int32, offset int64, epoch int32, standby bool) bool {
 	if s == nil { // if we are nil, the session was killed
 		return false
 	}
 	at, exists := s[partition]
-	now := fetchSessionOffsetEpoch{offset, epoch}
+	now := fetchSessionOffsetEpoch{offset, epoch, standby}
 	s[partition] = now
 	return exists && at == now
 }
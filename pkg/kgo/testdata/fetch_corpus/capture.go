@@ -0,0 +1,406 @@
+//go:build ignore
+// +build ignore
+
+// This program captures the fetch corpus that TestFetchCorpus decodes. Each
+// case is a .batch file containing the raw RecordBatches of one fetch response
+// partition and a .json file containing what should be decoded.
+//
+// The corpus has two sources:
+//
+//   - batches written by librdkafka, produced through confluent-kafka-go to
+//     librdkafka's mock cluster, which stores produced batches as is and
+//     returns them unmodified in fetch responses;
+//
+//   - fetch responses captured from a real Kafka broker, taken from
+//     segmentio/kafka-go's fixtures directory. These mix message set v1 and
+//     record batch v2, compressed and uncompressed.
+//
+// The expected records are what was produced for librdkafka cases, and what
+// kafka-go's own decoder returns for the broker captures, so that no part of
+// the corpus is derived from this client's encoding or decoding.
+//
+// This program needs cgo and modules this repo does not depend on, so it is
+// run from a scratch module, with REPO set to the root of this repo:
+//
+//	mkdir /tmp/capture && cd /tmp/capture && go mod init capture
+//	go mod edit -replace github.com/twmb/franz-go=$REPO -replace github.com/twmb/franz-go/pkg/kmsg=$REPO/pkg/kmsg
+//	go get github.com/twmb/franz-go github.com/confluentinc/confluent-kafka-go/v2@v2.3.0 github.com/segmentio/kafka-go@v0.4.47
+//	go run $REPO/pkg/kgo/testdata/fetch_corpus/capture.go \
+//		$REPO/pkg/kgo/testdata/fetch_corpus \
+//		$(go env GOMODCACHE)/github.com/segmentio/kafka-go@v0.4.47/fixtures
+//
+// Transactions in librdkafka's mock cluster do not write control batches, and
+// neither source writes xerial framed snappy (only the Java client does);
+// those are covered by unit tests instead.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	_ "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+	"github.com/twmb/franz-go/pkg/kversion"
+)
+
+type header struct {
+	Key   string  `json:"key"`
+	Value *string `json:"value"`
+}
+
+type record struct {
+	Offset      int64    `json:"offset"`
+	Key         *string  `json:"key"`
+	Value       *string  `json:"value"`
+	Headers     []header `json:"headers,omitempty"`
+	TimestampMs int64    `json:"timestamp_ms"`
+	ProducerID  int64    `json:"producer_id"`
+}
+
+type aborted struct {
+	ProducerID  int64 `json:"producer_id"`
+	FirstOffset int64 `json:"first_offset"`
+}
+
+type expected struct {
+	Description string    `json:"description"`
+	Source      string    `json:"source"`
+	Aborted     []aborted `json:"aborted_transactions,omitempty"`
+	Records     []record  `json:"records"`
+}
+
+const firstTimestamp = 1600000000000
+
+func die(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func str(b []byte) *string {
+	if b == nil {
+		return nil
+	}
+	s := string(b)
+	return &s
+}
+
+func write(dir, name string, raw []byte, exp expected) {
+	die(ioutil.WriteFile(filepath.Join(dir, name+".batch"), raw, 0o644))
+	out, err := json.MarshalIndent(exp, "", "  ")
+	die(err)
+	die(ioutil.WriteFile(filepath.Join(dir, name+".json"), append(out, '\n'), 0o644))
+}
+
+// msgs returns n messages starting at index start; odd messages have headers.
+func msgs(start, n int) []*kafka.Message {
+	var ms []*kafka.Message
+	for i := start; i < start+n; i++ {
+		m := &kafka.Message{
+			Key:       []byte(fmt.Sprintf("key-%d", i)),
+			Value:     []byte(fmt.Sprintf("value-%d", i)),
+			Timestamp: time.UnixMilli(firstTimestamp + int64(i)),
+		}
+		if i%2 == 1 {
+			m.Headers = []kafka.Header{
+				{Key: "trace", Value: []byte(fmt.Sprintf("t%d", i))},
+				{Key: "source", Value: []byte("librdkafka")},
+			}
+		}
+		ms = append(ms, m)
+	}
+	return ms
+}
+
+// produce produces ms to partition 0 of topic in one batch. Note that
+// librdkafka writes a batch uncompressed if compressing it does not shrink it.
+func produce(bootstrap, topic string, conf kafka.ConfigMap, txnID string, commit bool, ms []*kafka.Message) {
+	conf["bootstrap.servers"] = bootstrap
+	conf["linger.ms"] = 100
+	if txnID != "" {
+		conf["transactional.id"] = txnID
+	}
+	p, err := kafka.NewProducer(&conf)
+	die(err)
+	defer p.Close()
+
+	ctx := context.Background()
+	if txnID != "" {
+		die(p.InitTransactions(ctx))
+		die(p.BeginTransaction())
+	}
+	done := make(chan kafka.Event, len(ms))
+	for _, m := range ms {
+		m.TopicPartition = kafka.TopicPartition{Topic: &topic, Partition: 0}
+		die(p.Produce(m, done))
+	}
+	for range ms {
+		m := (<-done).(*kafka.Message)
+		die(m.TopicPartition.Error)
+	}
+	if txnID != "" {
+		if commit {
+			die(p.CommitTransaction(ctx))
+		} else {
+			die(p.AbortTransaction(ctx))
+		}
+	}
+}
+
+// fetch returns every record batch in partition 0 of topic, concatenated as
+// a fetch response returns them.
+func fetch(cl *kgo.Client, topic string) []byte {
+	var raw []byte
+	var offset int64
+	for {
+		req := kmsg.NewPtrFetchRequest()
+		req.MaxWaitMillis = 100
+		req.MaxBytes = 1 << 20
+		rt := kmsg.NewFetchRequestTopic()
+		rt.Topic = topic
+		rp := kmsg.NewFetchRequestTopicPartition()
+		rp.FetchOffset = offset
+		rp.PartitionMaxBytes = 1 << 20
+		rp.CurrentLeaderEpoch = -1
+		rt.Partitions = append(rt.Partitions, rp)
+		req.Topics = append(req.Topics, rt)
+		resp, err := req.RequestWith(context.Background(), cl)
+		die(err)
+		p := resp.Topics[0].Partitions[0]
+		die(kerrFor(p.ErrorCode))
+		if len(p.RecordBatches) == 0 {
+			if offset >= p.HighWatermark {
+				return raw
+			}
+			die(fmt.Errorf("no batches at offset %d below high watermark %d", offset, p.HighWatermark))
+		}
+		raw = append(raw, p.RecordBatches...)
+		for b := p.RecordBatches; len(b) > 0; {
+			var batch kmsg.RecordBatch
+			die(batch.ReadFrom(b))
+			offset = batch.FirstOffset + int64(batch.LastOffsetDelta) + 1
+			b = b[12+batch.Length:]
+		}
+	}
+}
+
+func kerrFor(code int16) error {
+	if code != 0 {
+		return fmt.Errorf("fetch error code %d", code)
+	}
+	return nil
+}
+
+// expect returns the records ms as they are expected to be consumed starting
+// at offset, with the given producer ID.
+func expect(offset int64, producerID int64, ms []*kafka.Message) []record {
+	var rs []record
+	for _, m := range ms {
+		r := record{
+			Offset:      offset,
+			Key:         str(m.Key),
+			Value:       str(m.Value),
+			TimestampMs: m.Timestamp.UnixMilli(),
+			ProducerID:  producerID,
+		}
+		for _, h := range m.Headers {
+			r.Headers = append(r.Headers, header{h.Key, str(h.Value)})
+		}
+		rs = append(rs, r)
+		offset++
+	}
+	return rs
+}
+
+// producerIDs returns the producer ID of every batch in raw.
+func producerIDs(raw []byte) []int64 {
+	var ids []int64
+	for len(raw) > 0 {
+		var batch kmsg.RecordBatch
+		die(batch.ReadFrom(raw))
+		ids = append(ids, batch.ProducerID)
+		raw = raw[12+batch.Length:]
+	}
+	return ids
+}
+
+func librdkafka(dir string) {
+	mc, err := kafka.NewMockCluster(1)
+	die(err)
+	defer mc.Close()
+	bs := mc.BootstrapServers()
+
+	cl, err := kgo.NewClient(kgo.SeedBrokers(bs), kgo.MaxVersions(kversion.V2_3_0()))
+	die(err)
+	defer cl.Close()
+
+	const source = "librdkafka v2.3.0 via confluent-kafka-go, mock cluster"
+
+	for _, codec := range []string{"none", "gzip", "snappy", "lz4", "zstd"} {
+		die(mc.CreateTopic(codec, 1, 1))
+		ms := msgs(0, 5)
+		produce(bs, codec, kafka.ConfigMap{"compression.codec": codec}, "", false, ms)
+		write(dir, codec, fetch(cl, codec), expected{
+			Description: codec + " batch with headers",
+			Source:      source,
+			Records:     expect(0, -1, ms),
+		})
+	}
+
+	{
+		const topic = "nulls_and_headers"
+		die(mc.CreateTopic(topic, 1, 1))
+		ts := time.UnixMilli(firstTimestamp)
+		ms := []*kafka.Message{
+			{Value: []byte("no key"), Timestamp: ts},
+			{Key: []byte("no value"), Timestamp: ts.Add(time.Millisecond)},
+			{Key: []byte{}, Value: []byte{}, Timestamp: ts.Add(2 * time.Millisecond)},
+			{Key: []byte("headers"), Value: []byte("v"), Timestamp: ts.Add(3 * time.Millisecond), Headers: []kafka.Header{
+				{Key: "nil"},
+				{Key: "empty", Value: []byte{}},
+				{Key: "dup", Value: []byte("1")},
+				{Key: "dup", Value: []byte("2")},
+			}},
+		}
+		produce(bs, topic, kafka.ConfigMap{}, "", false, ms)
+		write(dir, topic, fetch(cl, topic), expected{
+			Description: "null and empty keys, values, and header values",
+			Source:      source,
+			Records:     expect(0, -1, ms),
+		})
+	}
+
+	{
+		const topic = "mixed_codecs"
+		die(mc.CreateTopic(topic, 1, 1))
+		var exp []record
+		var offset int64
+		for i, codec := range []string{"zstd", "none", "gzip", "lz4", "snappy"} {
+			ms := msgs(5*i, 5)
+			produce(bs, topic, kafka.ConfigMap{"compression.codec": codec}, "", false, ms)
+			exp = append(exp, expect(offset, -1, ms)...)
+			offset += int64(len(ms))
+		}
+		write(dir, topic, fetch(cl, topic), expected{
+			Description: "one batch per codec in one partition",
+			Source:      source,
+			Records:     exp,
+		})
+	}
+
+	{
+		const topic = "txn_commit"
+		die(mc.CreateTopic(topic, 1, 1))
+		ms := msgs(0, 4)
+		produce(bs, topic, kafka.ConfigMap{"compression.codec": "snappy"}, "committer", true, ms)
+		raw := fetch(cl, topic)
+		write(dir, topic, raw, expected{
+			Description: "committed transactional snappy batch",
+			Source:      source,
+			Records:     expect(0, producerIDs(raw)[0], ms),
+		})
+	}
+
+	{
+		const topic = "txn_abort"
+		die(mc.CreateTopic(topic, 1, 1))
+		committed := msgs(0, 5)
+		abortedMs := msgs(5, 5)
+		produce(bs, topic, kafka.ConfigMap{"compression.codec": "gzip"}, "committer", true, committed)
+		produce(bs, topic, kafka.ConfigMap{"compression.codec": "zstd"}, "aborter", false, abortedMs)
+		raw := fetch(cl, topic)
+		ids := producerIDs(raw)
+		write(dir, topic, raw, expected{
+			Description: "a committed gzip transaction followed by an aborted zstd transaction",
+			Source:      source,
+			Aborted:     []aborted{{ProducerID: ids[1], FirstOffset: 5}},
+			Records:     expect(0, ids[0], committed),
+		})
+	}
+}
+
+// broker converts kafka-go's captured fetch responses into corpus cases.
+func broker(dir, fixtures string) {
+	const source = "Kafka broker fetch response, from segmentio/kafka-go v0.4.47 fixtures"
+	for _, c := range []struct {
+		name, file, desc string
+	}{
+		{"broker_v1c_v1_v1c", "v1c-v1-v1c.hex", "message set v1: compressed, uncompressed, compressed"},
+		{"broker_v2c_v2_v2c", "v2c-v2-v2c.hex", "record batch v2: compressed, uncompressed, compressed"},
+		{"broker_mixed", "v1-v1c-v2-v2c-v2b-v2b-v2b-v2bc-v1b-v1bc.hex", "message set v1 and record batch v2, compressed and uncompressed, mixed"},
+	} {
+		rawHex, err := ioutil.ReadFile(filepath.Join(fixtures, c.file))
+		die(err)
+		resp, err := hex.DecodeString(string(bytes.TrimSpace(rawHex)))
+		die(err)
+
+		// Strip the size and correlation ID; the fixtures are fetch v10.
+		fr := kmsg.NewFetchResponse()
+		fr.Version = 10
+		die(fr.ReadFrom(resp[8:]))
+		raw := fr.Topics[0].Partitions[0].RecordBatches
+
+		var exp []record
+		rs := new(protocol.RecordSet)
+		if _, err := rs.ReadFrom(bytes.NewReader(sized(raw))); err != nil {
+			die(err)
+		}
+		for {
+			r, err := rs.Records.ReadRecord()
+			if err == io.EOF {
+				break
+			}
+			die(err)
+			rec := record{
+				Offset:      r.Offset,
+				TimestampMs: r.Time.UnixMilli(),
+				ProducerID:  -1,
+			}
+			if r.Key != nil {
+				k, err := protocol.ReadAll(r.Key)
+				die(err)
+				rec.Key = str(k)
+			}
+			if r.Value != nil {
+				v, err := protocol.ReadAll(r.Value)
+				die(err)
+				rec.Value = str(v)
+			}
+			for _, h := range r.Headers {
+				rec.Headers = append(rec.Headers, header{h.Key, str(h.Value)})
+			}
+			exp = append(exp, rec)
+		}
+		write(dir, c.name, raw, expected{
+			Description: c.desc,
+			Source:      source,
+			Records:     exp,
+		})
+	}
+}
+
+// sized prefixes raw with its length, as kafka-go's RecordSet expects.
+func sized(raw []byte) []byte {
+	n := len(raw)
+	return append([]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}, raw...)
+}
+
+func main() {
+	if len(os.Args) != 3 {
+		die(fmt.Errorf("usage: %s <corpus dir> <kafka-go fixtures dir>", os.Args[0]))
+	}
+	librdkafka(os.Args[1])
+	broker(os.Args[1], os.Args[2])
+}
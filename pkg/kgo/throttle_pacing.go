@@ -0,0 +1,118 @@
+package kgo
+
+import (
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// pacer adaptively spaces out produce or fetch requests to one broker after
+// the broker throttles us; see the ThrottlePacing option.
+type pacer struct {
+	mu   sync.Mutex
+	pace time.Duration
+	next time.Time // earliest time the next request can be written
+}
+
+// paceDecay is how much the pace decreases on every response that is not
+// throttled.
+const paceDecay = 10 // percent
+
+// wait waits until the next request can be issued, reserving the next slot
+// for this request. If done is closed while waiting, this releases the slot
+// and returns false.
+func (p *pacer) wait(done <-chan struct{}) bool {
+	sleep, start, end := p.reserve()
+	if sleep <= 0 {
+		return true
+	}
+	after := time.NewTimer(sleep)
+	defer after.Stop()
+	select {
+	case <-after.C:
+		return true
+	case <-done:
+		p.release(start, end)
+		return false
+	}
+}
+
+// reserve returns how long to wait before issuing the next request, and
+// reserves the slot from start to end for this request.
+func (p *pacer) reserve() (sleep time.Duration, start, end time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	start = p.next
+	p.next = p.next.Add(p.pace)
+	return start.Sub(now), start, p.next
+}
+
+// release gives back a reserved slot if nothing was reserved after it.
+func (p *pacer) release(start, end time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.next.Equal(end) {
+		p.next = start
+	}
+}
+
+// observe adjusts the pace after a response: a throttled response increases
+// the pace by half of the throttle, up to max, while a response that is not
+// throttled decays the pace. This returns the new pace and whether it changed.
+func (p *pacer) observe(throttle, max time.Duration) (time.Duration, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	prior := p.pace
+	if throttle > 0 {
+		p.pace += throttle / 2
+		if p.pace > max {
+			p.pace = max
+		}
+	} else if p.pace > 0 {
+		p.pace -= p.pace * paceDecay / 100
+		if p.pace < time.Millisecond {
+			p.pace = 0
+		}
+	}
+	return p.pace, p.pace != prior
+}
+
+// pacer returns the broker's pacer for the given request key, or nil if the
+// request is not paced.
+func (b *broker) pacer(key int16) *pacer {
+	if b.cl.cfg.throttlePacingMax <= 0 {
+		return nil
+	}
+	switch key {
+	case kmsg.Produce.Int16():
+		return &b.producePacer
+	case kmsg.Fetch.Int16():
+		return &b.fetchPacer
+	}
+	return nil
+}
+
+// paceRequest waits until the broker's pacer for the request key allows the
+// next request, returning false if done is closed first. The sink and source
+// loops pace themselves with this before issuing requests, so that pacing
+// never blocks the broker's serial write loop.
+func (cl *Client) paceRequest(nodeID int32, key int16, done <-chan struct{}) bool {
+	if cl.cfg.throttlePacingMax <= 0 {
+		return true
+	}
+	br, err := cl.brokerOrErr(nil, nodeID, errUnknownBroker)
+	if err != nil {
+		return true // issuing the request fails with this same error
+	}
+	if p := br.pacer(key); p != nil {
+		return p.wait(done)
+	}
+	return true
+}
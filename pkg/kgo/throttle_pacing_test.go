@@ -0,0 +1,59 @@
+package kgo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPacer(t *testing.T) {
+	var p pacer
+	if !p.wait(nil) {
+		t.Error("unexpectedly failed waiting before any throttle")
+	}
+
+	for _, test := range []struct {
+		throttle   time.Duration
+		expPace    time.Duration
+		expChanged bool
+	}{
+		{0, 0, false}, // nothing to decay
+		{100 * time.Millisecond, 50 * time.Millisecond, true}, // +throttle/2
+		{100 * time.Millisecond, 100 * time.Millisecond, true},
+		{time.Second, 200 * time.Millisecond, true}, // capped
+		{time.Second, 200 * time.Millisecond, false},
+		{0, 180 * time.Millisecond, true}, // -10%
+		{0, 162 * time.Millisecond, true},
+	} {
+		pace, changed := p.observe(test.throttle, 200*time.Millisecond)
+		if pace != test.expPace || changed != test.expChanged {
+			t.Errorf("throttle %v: got pace %v (changed? %v), expected %v (changed? %v)", test.throttle, pace, changed, test.expPace, test.expChanged)
+		}
+	}
+
+	// With a pace, back to back requests are spaced out by the pace.
+	if sleep, _, _ := p.reserve(); sleep > time.Millisecond {
+		t.Errorf("got first sleep %v, expected none", sleep)
+	}
+	if sleep, _, _ := p.reserve(); sleep < 150*time.Millisecond || sleep > 162*time.Millisecond {
+		t.Errorf("got second sleep %v, expected about 162ms", sleep)
+	}
+
+	// A canceled wait gives its slot back to the next request.
+	done := make(chan struct{})
+	close(done)
+	next := p.next
+	if p.wait(done) {
+		t.Error("unexpectedly finished a canceled wait")
+	}
+	if !p.next.Equal(next) {
+		t.Errorf("got next slot %v after a canceled wait, expected the released %v", p.next, next)
+	}
+
+	// Enough unthrottled responses decay the pace to zero.
+	for i := 0; i < 100; i++ {
+		p.observe(0, 200*time.Millisecond)
+	}
+	if p.pace != 0 {
+		t.Errorf("got pace %v after decaying, expected 0", p.pace)
+	}
+}
@@ -137,9 +137,18 @@ func newTopicPartitions() *topicPartitions {
 type topicPartitions struct {
 	v atomic.Value // *topicPartitionsData
 
+	// bufferMu is read locked while buffering a record to the topic and
+	// write locked while evicting the topic.
+	bufferMu    sync.RWMutex
+	lastProduce int64 // atomic unix nanos, only tracked if ProduceTopicMaxIdle is set
+	evicted     bool  // set once evicted under bufferMu; producing must load the topic again
+
+	// partsMu serializes partitioning records, which is done under
+	// bufferMu's read lock.
 	partsMu     sync.Mutex
 	partitioner TopicPartitioner
 	lb          *leastBackupInput // for partitioning if the partitioner is a LoadTopicPartitioner
+	newBatches  uint64            // how many times the partitioner was notified of a new batch
 }
 
 func (t *topicPartitions) load() *topicPartitionsData { return t.v.Load().(*topicPartitionsData) }
@@ -206,6 +215,15 @@ func (t *topicsPartitions) ensureTopics(topics []string) topicsPartitionsData {
 	return current
 }
 
+// purgeTopics removes the topics from the stored data.
+func (t *topicsPartitions) purgeTopics(topics []string) {
+	current := t.clone()
+	for _, topic := range topics {
+		delete(current, topic)
+	}
+	t.storeData(current)
+}
+
 // Updates the topic partitions data atomic value.
 //
 // If this is the first time seeing partitions, we do processing of unknown
@@ -366,6 +384,21 @@ type topicPartition struct {
 	// Only one of records or cursor is non-nil.
 	records *recBuf
 	cursor  *cursor
+
+	// unconfirmedTries is how many consecutive metadata updates moved
+	// the leader without bumping the leader epoch. This is carried across
+	// updates while we keep the current leader, and bounds how long we
+	// wait for the move to be confirmed.
+	unconfirmedTries int
+}
+
+// leaderMoveConfirmed returns whether our current leader has replied that it is
+// no longer the leader for this partition.
+func (tp *topicPartition) leaderMoveConfirmed() bool {
+	if tp.records != nil {
+		return tp.records.leaderMoved.get()
+	}
+	return tp.cursor != nil && tp.cursor.leaderMoved.get()
 }
 
 // Contains stuff that changes on metadata update that we copy into a cursor or
@@ -402,6 +435,7 @@ func (old *topicPartition) migrateProductionTo(new *topicPartition) {
 	old.records.mu.Lock() // guard setting sink and topic partition data
 	old.records.sink = new.records.sink
 	old.records.topicPartitionData = new.topicPartitionData
+	old.records.leaderMoved.set(false)
 	old.records.mu.Unlock()
 
 	// After the unlock above, record buffering can trigger drains
@@ -455,6 +489,7 @@ func (old *topicPartition) migrateCursorTo(
 	}
 
 	old.cursor.topicPartitionData = new.topicPartitionData
+	old.cursor.leaderMoved.set(false)
 
 	old.cursor.source.addCursor(old.cursor)
 	new.cursor = old.cursor
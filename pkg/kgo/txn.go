@@ -200,6 +200,19 @@ func (s *GroupTransactSession) failed() bool {
 	return s.revoked || s.lost
 }
 
+// WillAbort returns whether the group rebalanced in a way that will cause the
+// current transaction to abort when ended, even if ended with TryCommit.
+//
+// Once this returns true, any further records produced in the transaction
+// will be aborted. If processing is slow, you can check this periodically to
+// stop processing early and end the transaction. The state is reset when the
+// transaction ends.
+func (s *GroupTransactSession) WillAbort() bool {
+	s.failMu.Lock()
+	defer s.failMu.Unlock()
+	return s.failed()
+}
+
 // End ends a transaction, committing if commit is true, if the group did not
 // rebalance since the transaction began, and if committing offsets is
 // successful. If commit is false, the group has rebalanced, or any partition
@@ -396,6 +409,9 @@ func (cl *Client) BeginTransaction() error {
 	}
 
 	cl.producer.inTxn = true
+	cl.producer.txnStart = time.Now()
+	atomic.StoreInt64(&cl.producer.txnRecords, 0)
+	atomic.StoreInt64(&cl.producer.txnPartitions, 0)
 	atomic.StoreUint32(&cl.producer.producingTxn, 1) // allow produces for txns now
 	cl.cfg.logger.Log(LogLevelInfo, "beginning transaction", "transactional_id", *cl.cfg.txnID)
 
@@ -549,6 +565,15 @@ func (cl *Client) EndTransaction(ctx context.Context, commit TransactionEndTry)
 		cl.failProducerID(id, epoch, err)
 	}
 
+	if err == nil {
+		cl.metrics.observeTxnEnd(
+			bool(commit),
+			atomic.LoadInt64(&cl.producer.txnRecords),
+			atomic.LoadInt64(&cl.producer.txnPartitions),
+			time.Since(cl.producer.txnStart),
+		)
+	}
+
 	return err
 }
 
@@ -569,8 +594,12 @@ func (cl *Client) maybeRecoverProducerID() (necessary, did bool, err error) {
 
 	kip360 := cl.producer.idVersion >= 3 && (ke == kerr.UnknownProducerID || ke == kerr.InvalidProducerIDMapping)
 	kip588 := cl.producer.idVersion >= 4 && (ke == kerr.InvalidProducerEpoch || false /* TODO err == kerr.TransactionTimedOut */)
+	// KIP-890 brokers return TRANSACTION_ABORTABLE when the transaction
+	// must be aborted but the producer ID is still usable; bumping the
+	// epoch (as with KIP-360) aborts the transaction for us.
+	kip890 := cl.producer.idVersion >= 3 && ke == kerr.TransactionAbortable
 
-	recoverable := kip360 || kip588
+	recoverable := kip360 || kip588 || kip890
 	if !recoverable {
 		return true, false, err // fatal, unrecoverable
 	}
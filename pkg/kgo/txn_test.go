@@ -251,3 +251,24 @@ func (c *testConsumer) transact(txnsBeforeQuit int) {
 		c.mu.Unlock()
 	}
 }
+
+func TestGroupTransactSessionWillAbort(t *testing.T) {
+	s, err := NewGroupTransactSession(
+		SeedBrokers("127.0.0.1:1"),
+		TransactionalID("txn"),
+		ConsumerGroup("g"),
+		ConsumeTopics("t"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if s.WillAbort() {
+		t.Error("unexpected abort before any rebalance")
+	}
+	s.cl.cfg.onRevoked(context.Background(), s.cl, map[string][]int32{"t": {0}})
+	if !s.WillAbort() {
+		t.Error("expected abort after partitions were revoked")
+	}
+}
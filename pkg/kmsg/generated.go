@@ -2251,8 +2251,8 @@ func (v *ControlRecordKey) AppendTo(dst []byte) []byte {
 	{
 		v := v.Type
 		{
-			v := int8(v)
-			dst = kbin.AppendInt8(dst, v)
+			v := int16(v)
+			dst = kbin.AppendInt16(dst, v)
 		}
 	}
 	return dst
@@ -2268,7 +2268,7 @@ func (v *ControlRecordKey) ReadFrom(src []byte) error {
 	{
 		var t ControlRecordKeyType
 		{
-			v := b.Int8()
+			v := b.Int16()
 			t = ControlRecordKeyType(v)
 		}
 		v := t
@@ -2585,6 +2585,15 @@ func NewProduceRequestTopic() ProduceRequestTopic {
 // Kafka 0.10.0 (v2) changed Records from MessageSet v0 to MessageSet v1.
 // Kafka 0.11.0 (v3) again changed Records to RecordBatch.
 //
+// Kafka 3.7.0 (v10) added the tagged CurrentLeader and NodeEndpoints response
+// fields for KIP-951. v11 uses the same format but signals the client can
+// handle the KIP-951 fields in NOT_LEADER_OR_FOLLOWER responses.
+//
+// v12 signals that the client supports KIP-890 transaction protocol v2: if
+// the cluster has finalized transaction.version 2 or higher, partitions
+// produced to transactionally are implicitly added to the transaction and
+// AddPartitionsToTxn is not needed.
+//
 // Note that the special client ID "__admin_client" will allow you to produce
 // records to internal topics. This is generally recommended if you want to
 // break your Kafka cluster.
@@ -2622,7 +2631,7 @@ type ProduceRequest struct {
 }
 
 func (*ProduceRequest) Key() int16                 { return 0 }
-func (*ProduceRequest) MaxVersion() int16          { return 9 }
+func (*ProduceRequest) MaxVersion() int16          { return 12 }
 func (v *ProduceRequest) SetVersion(version int16) { v.Version = version }
 func (v *ProduceRequest) GetVersion() int16        { return v.Version }
 func (v *ProduceRequest) IsFlexible() bool         { return v.Version >= 9 }
@@ -2868,6 +2877,37 @@ func NewProduceResponseTopicPartitionErrorRecord() ProduceResponseTopicPartition
 	return v
 }
 
+type ProduceResponseTopicPartitionCurrentLeader struct {
+	// The ID of the current leader, or -1 if unknown.
+	//
+	// This field has a default of -1.
+	LeaderID int32
+
+	// The latest known leader epoch.
+	//
+	// This field has a default of -1.
+	LeaderEpoch int32
+
+	// UnknownTags are tags Kafka sent that we do not know the purpose of.
+	UnknownTags Tags // v9+
+
+}
+
+// Default sets any default fields. Calling this allows for future compatibility
+// if new fields are added to ProduceResponseTopicPartitionCurrentLeader.
+func (v *ProduceResponseTopicPartitionCurrentLeader) Default() {
+	v.LeaderID = -1
+	v.LeaderEpoch = -1
+}
+
+// NewProduceResponseTopicPartitionCurrentLeader returns a default ProduceResponseTopicPartitionCurrentLeader
+// This is a shortcut for creating a struct and calling Default yourself.
+func NewProduceResponseTopicPartitionCurrentLeader() ProduceResponseTopicPartitionCurrentLeader {
+	var v ProduceResponseTopicPartitionCurrentLeader
+	v.Default()
+	return v
+}
+
 type ProduceResponseTopicPartition struct {
 	// Partition is the partition this response pertains to.
 	Partition int32
@@ -2977,6 +3017,11 @@ type ProduceResponseTopicPartition struct {
 	// to error.
 	ErrorMessage *string // v8+
 
+	// CurrentLeader is the current leader of the partition if the
+	// partition errored with NOT_LEADER_OR_FOLLOWER, allowing the client
+	// to retry without a metadata refresh. This was added for KIP-951.
+	CurrentLeader ProduceResponseTopicPartitionCurrentLeader // v10+, tag 0
+
 	// UnknownTags are tags Kafka sent that we do not know the purpose of.
 	UnknownTags Tags // v9+
 
@@ -2987,6 +3032,12 @@ type ProduceResponseTopicPartition struct {
 func (v *ProduceResponseTopicPartition) Default() {
 	v.LogAppendTime = -1
 	v.LogStartOffset = -1
+	{
+		v := &v.CurrentLeader
+		_ = v
+		v.LeaderID = -1
+		v.LeaderEpoch = -1
+	}
 }
 
 // NewProduceResponseTopicPartition returns a default ProduceResponseTopicPartition
@@ -3023,6 +3074,37 @@ func NewProduceResponseTopic() ProduceResponseTopic {
 	return v
 }
 
+type ProduceResponseNodeEndpoint struct {
+	// NodeID is the ID of the node.
+	NodeID int32
+
+	// Host is the hostname of the node.
+	Host string
+
+	// Port is the port of the node.
+	Port int32
+
+	// Rack is the rack of the node, if any.
+	Rack *string
+
+	// UnknownTags are tags Kafka sent that we do not know the purpose of.
+	UnknownTags Tags // v9+
+
+}
+
+// Default sets any default fields. Calling this allows for future compatibility
+// if new fields are added to ProduceResponseNodeEndpoint.
+func (v *ProduceResponseNodeEndpoint) Default() {
+}
+
+// NewProduceResponseNodeEndpoint returns a default ProduceResponseNodeEndpoint
+// This is a shortcut for creating a struct and calling Default yourself.
+func NewProduceResponseNodeEndpoint() ProduceResponseNodeEndpoint {
+	var v ProduceResponseNodeEndpoint
+	v.Default()
+	return v
+}
+
 // ProduceResponse is returned from a ProduceRequest.
 type ProduceResponse struct {
 	// Version is the version of this message used with a Kafka broker.
@@ -3040,13 +3122,17 @@ type ProduceResponse struct {
 	// This request switched at version 6.
 	ThrottleMillis int32 // v1+
 
+	// NodeEndpoints are the endpoints of leaders in CurrentLeader fields,
+	// added for KIP-951.
+	NodeEndpoints []ProduceResponseNodeEndpoint // v10+, tag 0
+
 	// UnknownTags are tags Kafka sent that we do not know the purpose of.
 	UnknownTags Tags // v9+
 
 }
 
 func (*ProduceResponse) Key() int16                 { return 0 }
-func (*ProduceResponse) MaxVersion() int16          { return 9 }
+func (*ProduceResponse) MaxVersion() int16          { return 12 }
 func (v *ProduceResponse) SetVersion(version int16) { v.Version = version }
 func (v *ProduceResponse) GetVersion() int16        { return v.Version }
 func (v *ProduceResponse) IsFlexible() bool         { return v.Version >= 9 }
@@ -3140,7 +3226,46 @@ func (v *ProduceResponse) AppendTo(dst []byte) []byte {
 						}
 					}
 					if isFlexible {
-						dst = kbin.AppendUvarint(dst, 0+uint32(v.UnknownTags.Len()))
+						var toEncode []uint32
+						if version >= 10 {
+							if !reflect.DeepEqual(v.CurrentLeader, (func() ProduceResponseTopicPartitionCurrentLeader {
+								var v ProduceResponseTopicPartitionCurrentLeader
+								v.Default()
+								return v
+							})()) {
+								toEncode = append(toEncode, 0)
+							}
+						}
+						dst = kbin.AppendUvarint(dst, uint32(len(toEncode)+v.UnknownTags.Len()))
+						for _, tag := range toEncode {
+							switch tag {
+							case 0:
+								{
+									v := v.CurrentLeader
+									dst = kbin.AppendUvarint(dst, 0)
+									sized := false
+									lenAt := len(dst)
+								fCurrentLeader:
+									{
+										v := v.LeaderID
+										dst = kbin.AppendInt32(dst, v)
+									}
+									{
+										v := v.LeaderEpoch
+										dst = kbin.AppendInt32(dst, v)
+									}
+									if isFlexible {
+										dst = kbin.AppendUvarint(dst, 0+uint32(v.UnknownTags.Len()))
+										dst = v.UnknownTags.AppendEach(dst)
+									}
+									if !sized {
+										dst = kbin.AppendUvarint(dst[:lenAt], uint32(len(dst[lenAt:])))
+										sized = true
+										goto fCurrentLeader
+									}
+								}
+							}
+						}
 						dst = v.UnknownTags.AppendEach(dst)
 					}
 				}
@@ -3156,7 +3281,66 @@ func (v *ProduceResponse) AppendTo(dst []byte) []byte {
 		dst = kbin.AppendInt32(dst, v)
 	}
 	if isFlexible {
-		dst = kbin.AppendUvarint(dst, 0+uint32(v.UnknownTags.Len()))
+		var toEncode []uint32
+		if version >= 10 {
+			if v.NodeEndpoints != nil {
+				toEncode = append(toEncode, 0)
+			}
+		}
+		dst = kbin.AppendUvarint(dst, uint32(len(toEncode)+v.UnknownTags.Len()))
+		for _, tag := range toEncode {
+			switch tag {
+			case 0:
+				{
+					v := v.NodeEndpoints
+					dst = kbin.AppendUvarint(dst, 0)
+					sized := false
+					lenAt := len(dst)
+				fNodeEndpoints:
+					if isFlexible {
+						dst = kbin.AppendCompactArrayLen(dst, len(v))
+					} else {
+						dst = kbin.AppendArrayLen(dst, len(v))
+					}
+					for i := range v {
+						v := &v[i]
+						{
+							v := v.NodeID
+							dst = kbin.AppendInt32(dst, v)
+						}
+						{
+							v := v.Host
+							if isFlexible {
+								dst = kbin.AppendCompactString(dst, v)
+							} else {
+								dst = kbin.AppendString(dst, v)
+							}
+						}
+						{
+							v := v.Port
+							dst = kbin.AppendInt32(dst, v)
+						}
+						{
+							v := v.Rack
+							if isFlexible {
+								dst = kbin.AppendCompactNullableString(dst, v)
+							} else {
+								dst = kbin.AppendNullableString(dst, v)
+							}
+						}
+						if isFlexible {
+							dst = kbin.AppendUvarint(dst, 0+uint32(v.UnknownTags.Len()))
+							dst = v.UnknownTags.AppendEach(dst)
+						}
+					}
+					if !sized {
+						dst = kbin.AppendUvarint(dst[:lenAt], uint32(len(dst[lenAt:])))
+						sized = true
+						goto fNodeEndpoints
+					}
+				}
+			}
+		}
 		dst = v.UnknownTags.AppendEach(dst)
 	}
 	return dst
@@ -3286,7 +3470,35 @@ func (v *ProduceResponse) ReadFrom(src []byte) error {
 						s.ErrorMessage = v
 					}
 					if isFlexible {
-						s.UnknownTags = internalReadTags(&b)
+						for i := b.Uvarint(); i > 0; i-- {
+							switch key := b.Uvarint(); key {
+							default:
+								s.UnknownTags.Set(key, b.Span(int(b.Uvarint())))
+							case 0:
+								if version < 10 {
+									s.UnknownTags.Set(key, b.Span(int(b.Uvarint())))
+									continue
+								}
+								b := kbin.Reader{Src: b.Span(int(b.Uvarint()))}
+								v := &s.CurrentLeader
+								v.Default()
+								s := v
+								{
+									v := b.Int32()
+									s.LeaderID = v
+								}
+								{
+									v := b.Int32()
+									s.LeaderEpoch = v
+								}
+								if isFlexible {
+									s.UnknownTags = internalReadTags(&b)
+								}
+								if err := b.Complete(); err != nil {
+									return err
+								}
+							}
+						}
 					}
 				}
 				v = a
@@ -3304,7 +3516,71 @@ func (v *ProduceResponse) ReadFrom(src []byte) error {
 		s.ThrottleMillis = v
 	}
 	if isFlexible {
-		s.UnknownTags = internalReadTags(&b)
+		for i := b.Uvarint(); i > 0; i-- {
+			switch key := b.Uvarint(); key {
+			default:
+				s.UnknownTags.Set(key, b.Span(int(b.Uvarint())))
+			case 0:
+				if version < 10 {
+					s.UnknownTags.Set(key, b.Span(int(b.Uvarint())))
+					continue
+				}
+				b := kbin.Reader{Src: b.Span(int(b.Uvarint()))}
+				v := s.NodeEndpoints
+				a := v
+				var l int32
+				if isFlexible {
+					l = b.CompactArrayLen()
+				} else {
+					l = b.ArrayLen()
+				}
+				if !b.Ok() {
+					return b.Complete()
+				}
+				if l > 0 {
+					a = make([]ProduceResponseNodeEndpoint, l)
+				}
+				for i := int32(0); i < l; i++ {
+					v := &a[i]
+					v.Default()
+					s := v
+					{
+						v := b.Int32()
+						s.NodeID = v
+					}
+					{
+						var v string
+						if isFlexible {
+							v = b.CompactString()
+						} else {
+							v = b.String()
+						}
+						s.Host = v
+					}
+					{
+						v := b.Int32()
+						s.Port = v
+					}
+					{
+						var v *string
+						if isFlexible {
+							v = b.CompactNullableString()
+						} else {
+							v = b.NullableString()
+						}
+						s.Rack = v
+					}
+					if isFlexible {
+						s.UnknownTags = internalReadTags(&b)
+					}
+				}
+				v = a
+				s.NodeEndpoints = v
+				if err := b.Complete(); err != nil {
+					return err
+				}
+			}
+		}
 	}
 	return b.Complete()
 }
@@ -11573,6 +11849,9 @@ func NewJoinGroupRequestProtocol() JoinGroupRequestProtocol {
 //
 // Version 5 introduced InstanceID, allowing for more "static" membership.
 // See KIP-345 for more details.
+//
+// Version 8 introduced Reason, allowing a member to describe why it is
+// joining. See KIP-800 for more details.
 type JoinGroupRequest struct {
 	// Version is the version of this message used with a Kafka broker.
 	Version int16
@@ -11622,13 +11901,17 @@ type JoinGroupRequest struct {
 	// name.
 	Protocols []JoinGroupRequestProtocol
 
+	// Reason is an optional reason the member is joining (or rejoining) the
+	// group, which the broker logs to help diagnose rebalances (KIP-800).
+	Reason *string // v8+
+
 	// UnknownTags are tags Kafka sent that we do not know the purpose of.
 	UnknownTags Tags // v6+
 
 }
 
 func (*JoinGroupRequest) Key() int16                   { return 11 }
-func (*JoinGroupRequest) MaxVersion() int16            { return 7 }
+func (*JoinGroupRequest) MaxVersion() int16            { return 8 }
 func (v *JoinGroupRequest) SetVersion(version int16)   { v.Version = version }
 func (v *JoinGroupRequest) GetVersion() int16          { return v.Version }
 func (v *JoinGroupRequest) IsFlexible() bool           { return v.Version >= 6 }
@@ -11720,6 +12003,14 @@ func (v *JoinGroupRequest) AppendTo(dst []byte) []byte {
 			}
 		}
 	}
+	if version >= 8 {
+		v := v.Reason
+		if isFlexible {
+			dst = kbin.AppendCompactNullableString(dst, v)
+		} else {
+			dst = kbin.AppendNullableString(dst, v)
+		}
+	}
 	if isFlexible {
 		dst = kbin.AppendUvarint(dst, 0+uint32(v.UnknownTags.Len()))
 		dst = v.UnknownTags.AppendEach(dst)
@@ -11823,6 +12114,15 @@ func (v *JoinGroupRequest) ReadFrom(src []byte) error {
 		v = a
 		s.Protocols = v
 	}
+	if version >= 8 {
+		var v *string
+		if isFlexible {
+			v = b.CompactNullableString()
+		} else {
+			v = b.NullableString()
+		}
+		s.Reason = v
+	}
 	if isFlexible {
 		s.UnknownTags = internalReadTags(&b)
 	}
@@ -11964,7 +12264,7 @@ type JoinGroupResponse struct {
 }
 
 func (*JoinGroupResponse) Key() int16                 { return 11 }
-func (*JoinGroupResponse) MaxVersion() int16          { return 7 }
+func (*JoinGroupResponse) MaxVersion() int16          { return 8 }
 func (v *JoinGroupResponse) SetVersion(version int16) { v.Version = version }
 func (v *JoinGroupResponse) GetVersion() int16        { return v.Version }
 func (v *JoinGroupResponse) IsFlexible() bool         { return v.Version >= 6 }
@@ -12489,6 +12789,10 @@ type LeaveGroupRequestMember struct {
 
 	InstanceID *string
 
+	// Reason is an optional reason why this member is leaving the group,
+	// which the broker logs to help diagnose rebalances (KIP-800).
+	Reason *string // v5+
+
 	// UnknownTags are tags Kafka sent that we do not know the purpose of.
 	UnknownTags Tags // v4+
 
@@ -12512,6 +12816,9 @@ func NewLeaveGroupRequestMember() LeaveGroupRequestMember {
 //
 // Version 3 changed removed MemberID and added a batch instance+member ID
 // way of leaving a group.
+//
+// Version 5 introduced a per member Reason for leaving. See KIP-800 for more
+// details.
 type LeaveGroupRequest struct {
 	// Version is the version of this message used with a Kafka broker.
 	Version int16
@@ -12531,7 +12838,7 @@ type LeaveGroupRequest struct {
 }
 
 func (*LeaveGroupRequest) Key() int16                   { return 13 }
-func (*LeaveGroupRequest) MaxVersion() int16            { return 4 }
+func (*LeaveGroupRequest) MaxVersion() int16            { return 5 }
 func (v *LeaveGroupRequest) SetVersion(version int16)   { v.Version = version }
 func (v *LeaveGroupRequest) GetVersion() int16          { return v.Version }
 func (v *LeaveGroupRequest) IsFlexible() bool           { return v.Version >= 4 }
@@ -12593,6 +12900,14 @@ func (v *LeaveGroupRequest) AppendTo(dst []byte) []byte {
 					dst = kbin.AppendNullableString(dst, v)
 				}
 			}
+			if version >= 5 {
+				v := v.Reason
+				if isFlexible {
+					dst = kbin.AppendCompactNullableString(dst, v)
+				} else {
+					dst = kbin.AppendNullableString(dst, v)
+				}
+			}
 			if isFlexible {
 				dst = kbin.AppendUvarint(dst, 0+uint32(v.UnknownTags.Len()))
 				dst = v.UnknownTags.AppendEach(dst)
@@ -12669,6 +12984,15 @@ func (v *LeaveGroupRequest) ReadFrom(src []byte) error {
 				}
 				s.InstanceID = v
 			}
+			if version >= 5 {
+				var v *string
+				if isFlexible {
+					v = b.CompactNullableString()
+				} else {
+					v = b.NullableString()
+				}
+				s.Reason = v
+			}
 			if isFlexible {
 				s.UnknownTags = internalReadTags(&b)
 			}
@@ -12770,7 +13094,7 @@ type LeaveGroupResponse struct {
 }
 
 func (*LeaveGroupResponse) Key() int16                 { return 13 }
-func (*LeaveGroupResponse) MaxVersion() int16          { return 4 }
+func (*LeaveGroupResponse) MaxVersion() int16          { return 5 }
 func (v *LeaveGroupResponse) SetVersion(version int16) { v.Version = version }
 func (v *LeaveGroupResponse) GetVersion() int16        { return v.Version }
 func (v *LeaveGroupResponse) IsFlexible() bool         { return v.Version >= 4 }
@@ -18683,6 +19007,11 @@ func NewAddOffsetsToTxnResponse() AddOffsetsToTxnResponse {
 
 // EndTxnRequest ends a transaction. This should be called after
 // TxnOffsetCommitRequest.
+//
+// Version 4 adds the TRANSACTION_ABORTABLE error. Version 5 is used for
+// KIP-890 transaction protocol v2, in which the coordinator bumps the
+// producer epoch when ending every transaction and returns the new producer
+// ID and epoch in the response.
 type EndTxnRequest struct {
 	// Version is the version of this message used with a Kafka broker.
 	Version int16
@@ -18707,7 +19036,7 @@ type EndTxnRequest struct {
 }
 
 func (*EndTxnRequest) Key() int16                 { return 26 }
-func (*EndTxnRequest) MaxVersion() int16          { return 3 }
+func (*EndTxnRequest) MaxVersion() int16          { return 5 }
 func (v *EndTxnRequest) SetVersion(version int16) { v.Version = version }
 func (v *EndTxnRequest) GetVersion() int16        { return v.Version }
 func (v *EndTxnRequest) IsFlexible() bool         { return v.Version >= 3 }
@@ -18844,15 +19173,30 @@ type EndTxnResponse struct {
 	//
 	// INVALID_TXN_STATE is returned if this request is attempted at the wrong
 	// time (given the order of how transaction requests should go).
+	//
+	// TRANSACTION_ABORTABLE is returned in v4+ if the transaction must be
+	// aborted, such as after a commit attempt timed out.
 	ErrorCode int16
 
+	// ProducerID is the producer ID to use for the next transaction. This is
+	// the same as the request's producer ID unless the epoch was exhausted.
+	//
+	// This field has a default of -1.
+	ProducerID int64 // v5+
+
+	// ProducerEpoch is the bumped producer epoch to use for the next
+	// transaction.
+	//
+	// This field has a default of -1.
+	ProducerEpoch int16 // v5+
+
 	// UnknownTags are tags Kafka sent that we do not know the purpose of.
 	UnknownTags Tags // v3+
 
 }
 
 func (*EndTxnResponse) Key() int16                 { return 26 }
-func (*EndTxnResponse) MaxVersion() int16          { return 3 }
+func (*EndTxnResponse) MaxVersion() int16          { return 5 }
 func (v *EndTxnResponse) SetVersion(version int16) { v.Version = version }
 func (v *EndTxnResponse) GetVersion() int16        { return v.Version }
 func (v *EndTxnResponse) IsFlexible() bool         { return v.Version >= 3 }
@@ -18872,6 +19216,14 @@ func (v *EndTxnResponse) AppendTo(dst []byte) []byte {
 		v := v.ErrorCode
 		dst = kbin.AppendInt16(dst, v)
 	}
+	if version >= 5 {
+		v := v.ProducerID
+		dst = kbin.AppendInt64(dst, v)
+	}
+	if version >= 5 {
+		v := v.ProducerEpoch
+		dst = kbin.AppendInt16(dst, v)
+	}
 	if isFlexible {
 		dst = kbin.AppendUvarint(dst, 0+uint32(v.UnknownTags.Len()))
 		dst = v.UnknownTags.AppendEach(dst)
@@ -18895,6 +19247,14 @@ func (v *EndTxnResponse) ReadFrom(src []byte) error {
 		v := b.Int16()
 		s.ErrorCode = v
 	}
+	if version >= 5 {
+		v := b.Int64()
+		s.ProducerID = v
+	}
+	if version >= 5 {
+		v := b.Int16()
+		s.ProducerEpoch = v
+	}
 	if isFlexible {
 		s.UnknownTags = internalReadTags(&b)
 	}
@@ -18912,6 +19272,8 @@ func NewPtrEndTxnResponse() *EndTxnResponse {
 // Default sets any default fields. Calling this allows for future compatibility
 // if new fields are added to EndTxnResponse.
 func (v *EndTxnResponse) Default() {
+	v.ProducerID = -1
+	v.ProducerEpoch = -1
 }
 
 // NewEndTxnResponse returns a default EndTxnResponse
@@ -40304,7 +40666,7 @@ const (
 //
 // * 3 (LEADER_CHANGE)
 //
-type ControlRecordKeyType int8
+type ControlRecordKeyType int16
 
 func (v ControlRecordKeyType) String() string {
 	switch v {
@@ -0,0 +1,324 @@
+package ktable
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// ErrStateRevoked is returned from State.Set if the state's partition has
+// been revoked from or lost by the group member.
+var ErrStateRevoked = errors.New("state partition is no longer assigned")
+
+// Changelog keeps per-partition application state for a group consumer,
+// backed by a compacted changelog topic.
+//
+// Partition N of the input topic that the group consumes has its state in
+// partition N of the changelog topic, so the changelog topic must have the
+// same number of partitions as the input topic. When the group assigns an
+// input partition, the changelog restores that partition's state by consuming
+// its changelog partition from the start, and the state is persisted by
+// producing every update to the changelog partition. When the partition is
+// revoked or lost, the state is dropped, and whichever member is assigned the
+// partition next restores it. State thus moves with partitions.
+//
+// To coordinate with rebalances, the changelog's OnAssigned, OnRevoked, and
+// OnLost methods must be called from the group client's callbacks:
+//
+//	cl, err := kgo.NewClient(
+//	        kgo.ConsumerGroup("group"),
+//	        kgo.ConsumeTopics("input"),
+//	        kgo.OnPartitionsAssigned(changelog.OnAssigned),
+//	        kgo.OnPartitionsRevoked(changelog.OnRevoked),
+//	        kgo.OnPartitionsLost(changelog.OnLost),
+//	)
+//
+// If the client has its own callbacks, they can call the changelog's methods
+// themselves, and can call Restore rather than OnAssigned to handle restore
+// errors directly. OnAssigned blocks until the assigned state is restored, so
+// once a partition is being fetched, its State is ready.
+type Changelog struct {
+	input     string
+	changelog string
+
+	// cl produces state updates to the changelog and consumes changelog
+	// partitions while restoring them. Restoring adds the partitions to
+	// consume and removes them once restored.
+	cl        *kgo.Client
+	restoreMu sync.Mutex // serializes restores, which poll cl
+
+	mu        sync.Mutex
+	states    map[int32]*State
+	onRestore func(error)
+}
+
+// NewChangelog returns a changelog that keeps state for partitions of the
+// input topic in the changelog topic. The options should configure how to
+// connect to the cluster; they are used for the one client that produces to
+// the changelog and restores partitions from it. The changelog adds options
+// to produce to explicit partitions and to consume partitions directly,
+// reading committed.
+func NewChangelog(input, changelog string, opts ...kgo.Opt) (*Changelog, error) {
+	cl, err := kgo.NewClient(append(opts[:len(opts):len(opts)],
+		kgo.RecordPartitioner(kgo.ManualPartitioner()),
+		kgo.ConsumePartitions(make(map[string]map[int32]kgo.Offset)), // restoring adds partitions
+		kgo.FetchIsolationLevel(kgo.ReadCommitted()),
+	)...)
+	if err != nil {
+		return nil, err
+	}
+	return &Changelog{
+		input:     input,
+		changelog: changelog,
+		cl:        cl,
+		states:    make(map[int32]*State),
+	}, nil
+}
+
+// OnRestoreError sets a function that is called with the error whenever
+// OnAssigned fails to restore partitions. By default, errors are dropped, and
+// the partitions have no State until they are assigned again.
+func (c *Changelog) OnRestoreError(fn func(error)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onRestore = fn
+}
+
+// State returns the state for a partition of the input topic, and whether the
+// partition is currently assigned and restored.
+func (c *Changelog) State(partition int32) (*State, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.states[partition]
+	return s, ok
+}
+
+// OnAssigned restores the state of any newly assigned input partition,
+// blocking until every partition is restored or restoring fails. This matches
+// the signature of kgo.OnPartitionsAssigned. Errors are passed to the
+// function set with OnRestoreError.
+func (c *Changelog) OnAssigned(ctx context.Context, _ *kgo.Client, assigned map[string][]int32) {
+	partitions := assigned[c.input]
+	if len(partitions) == 0 {
+		return
+	}
+	if err := c.Restore(ctx, partitions...); err != nil {
+		c.mu.Lock()
+		onRestore := c.onRestore
+		c.mu.Unlock()
+		if onRestore != nil {
+			onRestore(err)
+		}
+	}
+}
+
+// Restore restores the state of the given input partitions by consuming their
+// changelog partitions from the start, blocking until every partition is
+// restored, restoring fails, or the context is canceled. All partitions are
+// restored with the changelog's one client.
+//
+// If restoring fails, none of the partitions have a State until they are
+// restored again, and this returns the error.
+func (c *Changelog) Restore(ctx context.Context, partitions ...int32) error {
+	if len(partitions) == 0 {
+		return nil
+	}
+	if err := c.restore(ctx, partitions); err != nil {
+		return fmt.Errorf("unable to restore %s partitions %v: %w", c.changelog, partitions, err)
+	}
+	return nil
+}
+
+// OnRevoked waits for any in flight state updates to be persisted and then
+// drops the state of revoked input partitions. This matches the signature of
+// kgo.OnPartitionsRevoked.
+func (c *Changelog) OnRevoked(ctx context.Context, _ *kgo.Client, revoked map[string][]int32) {
+	c.cl.Flush(ctx)
+	c.drop(revoked[c.input])
+}
+
+// OnLost drops the state of lost input partitions. This matches the signature
+// of kgo.OnPartitionsLost.
+func (c *Changelog) OnLost(_ context.Context, _ *kgo.Client, lost map[string][]int32) {
+	c.drop(lost[c.input])
+}
+
+// Close flushes any in flight state updates and closes the changelog's
+// client. This should be called after the group client is closed.
+func (c *Changelog) Close() {
+	c.cl.Flush(context.Background())
+	c.cl.Close()
+}
+
+func (c *Changelog) drop(partitions []int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, partition := range partitions {
+		if s, ok := c.states[partition]; ok {
+			s.revoke()
+			delete(c.states, partition)
+		}
+	}
+}
+
+// restore consumes changelog partitions into new states. Each partition is
+// applied to its own table, which tracks when the partition is caught up; the
+// tables are not running and have no client of their own.
+func (c *Changelog) restore(ctx context.Context, partitions []int32) error {
+	c.restoreMu.Lock()
+	defer c.restoreMu.Unlock()
+
+	ends, err := listEnds(ctx, c.cl, c.changelog, partitions)
+	if err != nil {
+		return err
+	}
+
+	tables := make(map[int32]*Table, len(partitions))
+	offsets := make(map[int32]kgo.Offset, len(partitions))
+	for _, partition := range partitions {
+		t := newTable(c.changelog)
+		t.setEnds(map[int32][2]int64{partition: ends[partition]})
+		tables[partition] = t
+		offsets[partition] = kgo.NewOffset().AtStart()
+	}
+	c.cl.AddConsumePartitions(map[string]map[int32]kgo.Offset{c.changelog: offsets})
+	defer c.cl.RemoveConsumePartitions(map[string][]int32{c.changelog: partitions})
+
+	for !c.restored(tables) {
+		fs := poll(ctx, c.cl, false)
+		if fs.IsClientClosed() {
+			return kgo.ErrClientClosed
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var fetchErr error
+		fs.EachError(func(topic string, partition int32, err error) {
+			if _, ok := tables[partition]; ok && topic == c.changelog && fetchErr == nil {
+				fetchErr = err
+			}
+		})
+		if fetchErr != nil {
+			return fetchErr
+		}
+		fs.EachPartition(func(p kgo.FetchTopicPartition) {
+			if t, ok := tables[p.Partition]; ok && p.Topic == c.changelog {
+				t.apply(p)
+			}
+		})
+		positions := c.cl.GetConsumePositions()[c.changelog]
+		for _, t := range tables {
+			t.updateCaughtUp(positions)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for partition, t := range tables {
+		c.states[partition] = newState(c, partition, t.m)
+	}
+	return nil
+}
+
+// restored returns whether every restoring table is caught up.
+func (*Changelog) restored(tables map[int32]*Table) bool {
+	for _, t := range tables {
+		if !isCaughtUp(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// State is the restored state of one input partition. Updates are applied in
+// memory and persisted to the partition's changelog partition.
+type State struct {
+	c         *Changelog
+	partition int32
+
+	mu      sync.RWMutex
+	m       map[string][]byte
+	revoked bool
+}
+
+func newState(c *Changelog, partition int32, m map[string][]byte) *State {
+	return &State{
+		c:         c,
+		partition: partition,
+		m:         m,
+	}
+}
+
+// Partition returns the input partition this state is for.
+func (s *State) Partition() int32 {
+	return s.partition
+}
+
+// Get returns the value for key and whether the key exists. The returned
+// slice must not be modified.
+func (s *State) Get(key string) ([]byte, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.m[key]
+	return v, ok
+}
+
+// Snapshot returns a copy of the state. The map can be modified freely, but
+// the values are shared with the state and must not be modified.
+func (s *State) Snapshot() map[string][]byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m := make(map[string][]byte, len(s.m))
+	for k, v := range s.m {
+		m[k] = v
+	}
+	return m
+}
+
+// Set sets key to value, deleting the key if value is nil, and persists the
+// update to the changelog. This blocks until the update is persisted or fails
+// to be persisted, in which case the state is not updated.
+//
+// Set returns ErrStateRevoked if the partition is no longer assigned. An
+// update that is persisted while the partition is being revoked is kept in
+// the changelog and is restored by the next owner of the partition.
+func (s *State) Set(ctx context.Context, key string, value []byte) error {
+	s.mu.RLock()
+	revoked := s.revoked
+	s.mu.RUnlock()
+	if revoked {
+		return ErrStateRevoked
+	}
+
+	r := &kgo.Record{
+		Topic:     s.c.changelog,
+		Partition: s.partition,
+		Key:       []byte(key),
+		Value:     value,
+	}
+	if err := s.c.cl.ProduceSync(ctx, r).FirstErr(); err != nil {
+		return err
+	}
+
+	s.apply(key, value)
+	return nil
+}
+
+func (s *State) apply(key string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if value == nil {
+		delete(s.m, key)
+	} else {
+		s.m[key] = value
+	}
+}
+
+func (s *State) revoke() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoked = true
+}
@@ -0,0 +1,88 @@
+package ktable
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestChangelogDrop(t *testing.T) {
+	c := &Changelog{
+		input:     "in",
+		changelog: "in-changelog",
+		states:    make(map[int32]*State),
+	}
+	for _, partition := range []int32{0, 1, 2} {
+		c.states[partition] = newState(c, partition, map[string][]byte{"k": []byte("v")})
+	}
+	s1, _ := c.State(1)
+	s2, _ := c.State(2)
+
+	// Assignments for other topics are ignored.
+	c.OnAssigned(context.Background(), nil, map[string][]int32{"other": {5}})
+	c.OnLost(context.Background(), nil, map[string][]int32{"in": {1}, "other": {2}})
+	c.drop([]int32{2})
+
+	if _, ok := c.State(0); !ok {
+		t.Error("partition 0 state was unexpectedly dropped")
+	}
+	for _, partition := range []int32{1, 2} {
+		if _, ok := c.State(partition); ok {
+			t.Errorf("partition %d state was not dropped", partition)
+		}
+	}
+	for _, s := range []*State{s1, s2} {
+		if err := s.Set(context.Background(), "k", nil); err != ErrStateRevoked {
+			t.Errorf("got %v setting on dropped partition %d state, expected ErrStateRevoked", err, s.Partition())
+		}
+	}
+
+	// Dropped state can still be read, and was not modified.
+	if got, exp := s1.Snapshot(), map[string][]byte{"k": []byte("v")}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("got %q, expected %q", got, exp)
+	}
+}
+
+func TestStateApply(t *testing.T) {
+	s := newState(nil, 3, make(map[string][]byte))
+	s.apply("a", []byte("1"))
+	s.apply("b", []byte("2"))
+	s.apply("a", nil)
+	if _, ok := s.Get("a"); ok {
+		t.Error("key a was not deleted")
+	}
+	if v, ok := s.Get("b"); !ok || string(v) != "2" {
+		t.Errorf("got b = %q, %v, expected 2", v, ok)
+	}
+}
+
+func TestChangelogRestoreError(t *testing.T) {
+	c, err := NewChangelog("in", "in-changelog", kgo.SeedBrokers("127.0.0.1:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var restoreErr error
+	c.OnRestoreError(func(err error) { restoreErr = err })
+
+	// The cluster is unreachable, so restoring fails once the context is
+	// canceled, and the error is surfaced rather than dropped.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.OnAssigned(ctx, nil, map[string][]int32{"in": {0, 1}})
+	if !errors.Is(restoreErr, context.Canceled) {
+		t.Errorf("got restore err %v, expected context.Canceled", restoreErr)
+	}
+	for _, partition := range []int32{0, 1} {
+		if _, ok := c.State(partition); ok {
+			t.Errorf("partition %d has state after failing to restore", partition)
+		}
+	}
+	if err := c.Restore(ctx); err != nil {
+		t.Errorf("got err %v restoring no partitions, expected nil", err)
+	}
+}
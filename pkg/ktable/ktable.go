@@ -0,0 +1,337 @@
+// Package ktable materializes a compacted topic into an in-memory table.
+//
+// A common Kafka pattern is to store configuration or reference data in a
+// compacted topic, where the latest record for a key is that key's value and a
+// record with a nil value (a tombstone) deletes the key. Every service that
+// needs the data consumes the topic from the beginning into a map and keeps
+// consuming to keep the map up to date. This package implements that pattern.
+//
+// A Table owns its own *kgo.Client, consumes every partition of its topic
+// from the start, and signals once it has caught up to the end offsets that
+// the topic had when the table was created. Reading a table before it has
+// caught up returns a partial view, so most users should wait on CaughtUp or
+// WaitCaughtUp before serving reads.
+//
+// Building on tables, a Changelog keeps per-partition state for a group
+// consumer in a changelog topic, restoring a partition's state when the
+// partition is assigned and persisting every update, so that state moves
+// between group members with its partition.
+package ktable
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// ErrClosed is returned from WaitCaughtUp if the table is closed before it
+// caught up.
+var ErrClosed = errors.New("table closed")
+
+// Table is a key to latest value view of a compacted topic.
+type Table struct {
+	cl    *kgo.Client
+	topic string
+
+	ctx    context.Context
+	cancel func()
+	done   chan struct{}
+
+	mu sync.RWMutex
+	m  map[string][]byte
+
+	// remaining tracks, per partition, the last stable offset that we must
+	// consume up to before we are caught up. It is only used in the run
+	// goroutine, and is nil until the end offsets are loaded.
+	remaining map[int32]int64
+	caughtUp  chan struct{}
+}
+
+// New returns a table that consumes topic, creating a client with the given
+// options to do so.
+//
+// The options should configure how to connect to the cluster, such as
+// kgo.SeedBrokers and kgo.SASL. The table itself adds options to consume the
+// topic from the start without a group and to read committed, so that the
+// table never contains records from aborted transactions. Options that
+// consume other topics or join a group must not be used.
+func New(topic string, opts ...kgo.Opt) (*Table, error) {
+	opts = append(opts[:len(opts):len(opts)],
+		kgo.ConsumeTopics(topic),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+		kgo.FetchIsolationLevel(kgo.ReadCommitted()),
+	)
+	cl, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	t := newTable(topic)
+	t.cl = cl
+	go t.run()
+	return t, nil
+}
+
+func newTable(topic string) *Table {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Table{
+		topic: topic,
+
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+
+		m:        make(map[string][]byte),
+		caughtUp: make(chan struct{}),
+	}
+}
+
+// Client returns the client the table uses. The client can be used for
+// requests, such as producing to the table's topic, but must not be closed.
+func (t *Table) Client() *kgo.Client {
+	return t.cl
+}
+
+// CaughtUp returns a channel that is closed once the table has consumed every
+// partition up to the last stable offset it had when the table was created.
+func (t *Table) CaughtUp() <-chan struct{} {
+	return t.caughtUp
+}
+
+// WaitCaughtUp waits until the table has caught up, returning the context's
+// error if the context is canceled first, or ErrClosed if the table is
+// closed first.
+func (t *Table) WaitCaughtUp(ctx context.Context) error {
+	select {
+	case <-t.caughtUp:
+		return nil
+	default:
+	}
+	select {
+	case <-t.caughtUp:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.done:
+		return ErrClosed
+	}
+}
+
+// Get returns the latest value for key and whether the key exists. The
+// returned slice must not be modified.
+func (t *Table) Get(key string) ([]byte, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	v, ok := t.m[key]
+	return v, ok
+}
+
+// Len returns the number of keys in the table.
+func (t *Table) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.m)
+}
+
+// Snapshot returns a copy of the table. The map can be modified freely, but
+// the values are shared with the table and must not be modified.
+func (t *Table) Snapshot() map[string][]byte {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	m := make(map[string][]byte, len(t.m))
+	for k, v := range t.m {
+		m[k] = v
+	}
+	return m
+}
+
+// Close stops consuming and closes the table's client. The table can still be
+// read after it is closed, but it is no longer updated.
+func (t *Table) Close() {
+	t.cancel()
+	<-t.done
+	t.cl.Close()
+}
+
+func (t *Table) run() {
+	defer close(t.done)
+
+	for {
+		ends, err := listEnds(t.ctx, t.cl, t.topic, nil)
+		if err == nil {
+			t.setEnds(ends)
+			break
+		}
+		select {
+		case <-t.ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+
+	for {
+		fs := poll(t.ctx, t.cl, len(t.remaining) == 0)
+		if fs.IsClientClosed() || t.ctx.Err() != nil {
+			return
+		}
+		fs.EachPartition(t.apply)
+		if len(t.remaining) > 0 {
+			t.updateCaughtUp(t.cl.GetConsumePositions()[t.topic])
+		}
+	}
+}
+
+// caughtUpCheckInterval bounds how long we poll before checking positions
+// again while catching up.
+const caughtUpCheckInterval = 250 * time.Millisecond
+
+// poll polls cl, bounding the poll while not caught up: a partition that ends
+// in a transaction marker or an aborted batch advances its position with a
+// fetch that has no records, which does not return from polling.
+func poll(ctx context.Context, cl *kgo.Client, caughtUp bool) kgo.Fetches {
+	if !caughtUp {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, caughtUpCheckInterval)
+		defer cancel()
+	}
+	return cl.PollFetches(ctx)
+}
+
+// setEnds saves the offsets each partition must be consumed to, given each
+// partition's start and end offsets. Partitions that are empty are already
+// caught up.
+func (t *Table) setEnds(ends map[int32][2]int64) {
+	t.remaining = make(map[int32]int64, len(ends))
+	for partition, startEnd := range ends {
+		if startEnd[1] > startEnd[0] {
+			t.remaining[partition] = startEnd[1]
+		}
+	}
+	t.maybeCaughtUp()
+}
+
+// updateCaughtUp removes partitions from remaining once their consume
+// position reaches the offset they must be consumed to, or the partition's
+// current last stable offset.
+//
+// We use the consume position rather than the offset of the last record we
+// applied: reading committed, the end of a partition can be a transaction
+// marker or an aborted batch, which the client skips without returning any
+// record. The client only advances a position once its fetch is polled, so a
+// position never runs ahead of what the table has applied.
+func (t *Table) updateCaughtUp(positions map[int32]kgo.ConsumePosition) {
+	for partition, end := range t.remaining {
+		position, ok := positions[partition]
+		if !ok {
+			continue
+		}
+		next := position.Next.Offset
+		if next >= end || position.LastStableOffset >= 0 && next >= position.LastStableOffset {
+			delete(t.remaining, partition)
+		}
+	}
+	t.maybeCaughtUp()
+}
+
+func isCaughtUp(t *Table) bool {
+	select {
+	case <-t.caughtUp:
+		return true
+	default:
+		return false
+	}
+}
+
+func (t *Table) maybeCaughtUp() {
+	if t.remaining == nil || len(t.remaining) > 0 {
+		return
+	}
+	select {
+	case <-t.caughtUp:
+	default:
+		close(t.caughtUp)
+	}
+}
+
+// apply applies a fetched partition's records to the table.
+func (t *Table) apply(p kgo.FetchTopicPartition) {
+	if len(p.Records) == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, r := range p.Records {
+		if r.Value == nil {
+			delete(t.m, string(r.Key))
+		} else {
+			t.m[string(r.Key)] = r.Value
+		}
+	}
+}
+
+// listEnds returns the start and last stable offsets for the given partitions
+// of topic, or for every partition if partitions is nil.
+func listEnds(ctx context.Context, cl *kgo.Client, topic string, partitions []int32) (map[int32][2]int64, error) {
+	if partitions == nil {
+		metaReq := kmsg.NewPtrMetadataRequest()
+		metaReqTopic := kmsg.NewMetadataRequestTopic()
+		metaReqTopic.Topic = kmsg.StringPtr(topic)
+		metaReq.Topics = append(metaReq.Topics, metaReqTopic)
+		metaResp, err := metaReq.RequestWith(ctx, cl)
+		if err != nil {
+			return nil, err
+		}
+		if len(metaResp.Topics) != 1 {
+			return nil, fmt.Errorf("metadata returned %d topics when we requested only %s", len(metaResp.Topics), topic)
+		}
+		metaTopic := metaResp.Topics[0]
+		if err := kerr.ErrorForCode(metaTopic.ErrorCode); err != nil {
+			return nil, err
+		}
+		for _, metaPartition := range metaTopic.Partitions {
+			partitions = append(partitions, metaPartition.Partition)
+		}
+	}
+
+	ends := make(map[int32][2]int64, len(partitions))
+	for i, timestamp := range []int64{-2, -1} { // start, then end
+		req := kmsg.NewPtrListOffsetsRequest()
+		req.ReplicaID = -1
+		req.IsolationLevel = 1 // read committed: list the last stable offset
+		reqTopic := kmsg.NewListOffsetsRequestTopic()
+		reqTopic.Topic = topic
+		for _, partition := range partitions {
+			reqPartition := kmsg.NewListOffsetsRequestTopicPartition()
+			reqPartition.Partition = partition
+			reqPartition.Timestamp = timestamp
+			reqTopic.Partitions = append(reqTopic.Partitions, reqPartition)
+		}
+		req.Topics = append(req.Topics, reqTopic)
+
+		resp, err := req.RequestWith(ctx, cl)
+		if err != nil {
+			return nil, err
+		}
+		for _, rTopic := range resp.Topics {
+			for _, rPartition := range rTopic.Partitions {
+				if err := kerr.ErrorForCode(rPartition.ErrorCode); err != nil {
+					return nil, err
+				}
+				startEnd := ends[rPartition.Partition]
+				startEnd[i] = rPartition.Offset
+				ends[rPartition.Partition] = startEnd
+			}
+		}
+	}
+	if len(ends) != len(partitions) {
+		return nil, fmt.Errorf("listed offsets for %d partitions of %s, expected %d", len(ends), topic, len(partitions))
+	}
+	return ends, nil
+}
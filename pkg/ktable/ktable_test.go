@@ -0,0 +1,131 @@
+package ktable
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func fetched(partition int32, rs ...*kgo.Record) kgo.FetchTopicPartition {
+	return kgo.FetchTopicPartition{
+		Topic: "t",
+		FetchPartition: kgo.FetchPartition{
+			Partition: partition,
+			Records:   rs,
+		},
+	}
+}
+
+func positions(nexts map[int32]int64) map[int32]kgo.ConsumePosition {
+	ps := make(map[int32]kgo.ConsumePosition)
+	for partition, next := range nexts {
+		ps[partition] = kgo.ConsumePosition{
+			Next:             kgo.EpochOffset{Epoch: -1, Offset: next},
+			LastStableOffset: -1,
+		}
+	}
+	return ps
+}
+
+func TestTableApply(t *testing.T) {
+	tbl := newTable("t")
+	tbl.setEnds(map[int32][2]int64{
+		0: {0, 3},
+		1: {5, 8},
+		2: {4, 4}, // empty, already caught up
+	})
+	if isCaughtUp(tbl) {
+		t.Fatal("caught up before consuming anything")
+	}
+
+	tbl.apply(fetched(0,
+		&kgo.Record{Key: []byte("a"), Value: []byte("1"), Offset: 0},
+		&kgo.Record{Key: []byte("b"), Value: []byte("2"), Offset: 1},
+		&kgo.Record{Key: []byte("a"), Value: nil, Offset: 2}, // tombstone
+	))
+	tbl.apply(fetched(1,
+		&kgo.Record{Key: []byte("c"), Value: []byte("3"), Offset: 5},
+	))
+	tbl.updateCaughtUp(positions(map[int32]int64{0: 3, 1: 6}))
+	if isCaughtUp(tbl) {
+		t.Fatal("caught up with partition 1 partially consumed")
+	}
+
+	// Compaction can leave gaps; the position reaching the end is what
+	// matters.
+	tbl.apply(fetched(1,
+		&kgo.Record{Key: []byte("c"), Value: []byte("4"), Offset: 7},
+	))
+	tbl.updateCaughtUp(positions(map[int32]int64{0: 3, 1: 8}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := tbl.WaitCaughtUp(ctx); err != nil {
+		t.Fatalf("unexpected err waiting to catch up: %v", err)
+	}
+
+	exp := map[string][]byte{
+		"b": []byte("2"),
+		"c": []byte("4"),
+	}
+	if got := tbl.Snapshot(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("got snapshot %q, expected %q", got, exp)
+	}
+	if v, ok := tbl.Get("a"); ok {
+		t.Errorf("got deleted key a = %q", v)
+	}
+	if tbl.Len() != 2 {
+		t.Errorf("got len %d, expected 2", tbl.Len())
+	}
+
+	// Applying after catching up keeps the table updated.
+	tbl.apply(fetched(2,
+		&kgo.Record{Key: []byte("b"), Value: nil, Offset: 4},
+	))
+	if _, ok := tbl.Get("b"); ok {
+		t.Error("key b was not deleted after catching up")
+	}
+}
+
+func TestTableEmptyTopic(t *testing.T) {
+	tbl := newTable("t")
+	tbl.setEnds(map[int32][2]int64{0: {0, 0}, 1: {9, 9}})
+	if !isCaughtUp(tbl) {
+		t.Error("empty topic is not immediately caught up")
+	}
+}
+
+func TestTableWaitClosed(t *testing.T) {
+	tbl := newTable("t")
+	close(tbl.done)
+	if err := tbl.WaitCaughtUp(context.Background()); err != ErrClosed {
+		t.Errorf("got %v, expected ErrClosed", err)
+	}
+}
+
+func TestTableCaughtUpWithoutRecords(t *testing.T) {
+	tbl := newTable("t")
+	tbl.setEnds(map[int32][2]int64{0: {0, 10}, 1: {0, 10}})
+
+	// Partition 0 ends in an aborted transaction: the client skips past it
+	// without returning records, so only the position shows we are done.
+	ps := positions(map[int32]int64{0: 10, 1: 4})
+	tbl.updateCaughtUp(ps)
+	if isCaughtUp(tbl) {
+		t.Fatal("caught up with partition 1 partially consumed")
+	}
+
+	// If partition 1 was truncated below its listed end (an unclean leader
+	// election), reaching its current last stable offset is caught up.
+	ps = positions(map[int32]int64{1: 9})
+	p1 := ps[1]
+	p1.LastStableOffset = 9
+	ps[1] = p1
+	tbl.updateCaughtUp(ps)
+	if !isCaughtUp(tbl) {
+		t.Error("not caught up after every position reached its end")
+	}
+}
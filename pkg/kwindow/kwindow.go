@@ -0,0 +1,207 @@
+// Package kwindow groups consumed records into event-time windows.
+//
+// This package is meant for simple aggregations over consumed records, such as
+// counting records per minute, without adopting a full stream processing
+// framework. Records are assigned to windows by their timestamp (event time),
+// not by when they are consumed. A watermark tracks how far event time has
+// progressed: it is the largest record timestamp seen so far minus an allowed
+// lateness. Once the watermark passes the end of a window, the window is
+// closed and returned to the caller. Records that arrive for windows that are
+// already closed are late, and are handled with a late record policy.
+//
+// Windows are aligned to the Unix epoch: a one minute tumbling window always
+// begins on a minute boundary.
+//
+// A Windower is not safe for concurrent use. Because the watermark is global
+// across every record added, a Windower is best used per partition or with
+// topics whose partitions progress at similar rates; otherwise, a partition
+// that lags behind others has its records considered late.
+package kwindow
+
+import (
+	"sort"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Window is a closed window of records.
+type Window struct {
+	// Start is the inclusive start of the window.
+	Start time.Time
+	// End is the exclusive end of the window.
+	End time.Time
+	// Records are the records in the window, in the order they were added.
+	Records []*kgo.Record
+}
+
+// Opt is an option to configure a Windower.
+type Opt interface {
+	apply(*Windower)
+}
+
+type opt struct{ fn func(*Windower) }
+
+func (o opt) apply(w *Windower) { o.fn(w) }
+
+// AllowedLateness sets how far behind the largest record timestamp seen a
+// record can be before it is considered late, overriding the default of 0.
+// This delays closing windows by the lateness, allowing out of order records
+// to still be included in their windows.
+func AllowedLateness(lateness time.Duration) Opt {
+	return opt{func(w *Windower) { w.lateness = lateness }}
+}
+
+// OnLateRecord sets the late record policy: fn is called with any record that
+// arrives after every window it belongs to has closed. By default, late
+// records are dropped. To keep late records, fn can, for example, save them
+// for a separate correction pass or produce them to another topic.
+func OnLateRecord(fn func(*kgo.Record)) Opt {
+	return opt{func(w *Windower) { w.onLate = fn }}
+}
+
+// Windower assigns records to event-time windows and closes windows as the
+// watermark advances.
+type Windower struct {
+	size     time.Duration
+	hop      time.Duration
+	lateness time.Duration
+	onLate   func(*kgo.Record)
+
+	open     map[int64]*Window // keyed by window start in unix nanoseconds
+	maxSeen  int64             // largest record timestamp seen, in unix nanoseconds
+	seen     bool
+	closedTo int64 // every window ending at or before this has been closed
+}
+
+// NewTumbling returns a Windower with fixed size, non-overlapping windows:
+// every record belongs to exactly one window. This panics if size is not
+// positive.
+func NewTumbling(size time.Duration, opts ...Opt) *Windower {
+	return NewHopping(size, size, opts...)
+}
+
+// NewHopping returns a Windower with fixed size windows that begin every hop.
+// If hop is smaller than size, windows overlap and a record belongs to
+// multiple windows; if hop is larger than size, there are gaps between
+// windows and records in the gaps are dropped. This panics if size or hop is
+// not positive.
+func NewHopping(size, hop time.Duration, opts ...Opt) *Windower {
+	if size <= 0 || hop <= 0 {
+		panic("kwindow: window size and hop must be positive")
+	}
+	w := &Windower{
+		size: size,
+		hop:  hop,
+		open: make(map[int64]*Window),
+	}
+	for _, opt := range opts {
+		opt.apply(w)
+	}
+	return w
+}
+
+// Watermark returns the current watermark: the largest record timestamp seen
+// minus the allowed lateness. This returns the zero time if no record has
+// been added.
+func (w *Windower) Watermark() time.Time {
+	if !w.seen {
+		return time.Time{}
+	}
+	return time.Unix(0, w.watermark())
+}
+
+func (w *Windower) watermark() int64 { return w.maxSeen - int64(w.lateness) }
+
+// Add adds a record to every window it belongs to, advances the watermark,
+// and returns any windows that the watermark closed, ordered by start.
+func (w *Windower) Add(r *kgo.Record) []Window {
+	ts := r.Timestamp.UnixNano()
+
+	var added, late bool
+	for start := w.firstStart(ts); start <= ts; start += int64(w.hop) {
+		end := start + int64(w.size)
+		if ts >= end {
+			continue // in a gap between hopping windows
+		}
+		if w.seen && end <= w.closedTo {
+			late = true
+			continue
+		}
+		win := w.open[start]
+		if win == nil {
+			win = &Window{
+				Start: time.Unix(0, start),
+				End:   time.Unix(0, end),
+			}
+			w.open[start] = win
+		}
+		win.Records = append(win.Records, r)
+		added = true
+	}
+	if late && !added && w.onLate != nil {
+		w.onLate(r)
+	}
+
+	if !w.seen || ts > w.maxSeen {
+		w.maxSeen = ts
+		if !w.seen {
+			w.closedTo = w.watermark()
+			w.seen = true
+		}
+	}
+	return w.close(w.watermark())
+}
+
+// AddFetches adds every record in fs, returning every window that closed.
+func (w *Windower) AddFetches(fs kgo.Fetches) []Window {
+	var closed []Window
+	fs.EachRecord(func(r *kgo.Record) {
+		closed = append(closed, w.Add(r)...)
+	})
+	return closed
+}
+
+// Flush closes and returns every open window, ordered by start, regardless of
+// the watermark. This is useful when shutting down. Records added after a
+// flush for windows that were flushed are late.
+func (w *Windower) Flush() []Window {
+	var last int64
+	for start := range w.open {
+		if end := start + int64(w.size); end > last {
+			last = end
+		}
+	}
+	return w.close(last)
+}
+
+// firstStart returns the start of the earliest window that could contain ts.
+func (w *Windower) firstStart(ts int64) int64 {
+	hop := int64(w.hop)
+	start := ts - int64(w.size) + 1
+	// Round up to the next hop boundary, handling negative timestamps.
+	rem := start % hop
+	if rem < 0 {
+		rem += hop
+	}
+	if rem != 0 {
+		start += hop - rem
+	}
+	return start
+}
+
+// close closes every open window ending at or before to.
+func (w *Windower) close(to int64) []Window {
+	if to > w.closedTo {
+		w.closedTo = to
+	}
+	var closed []Window
+	for start, win := range w.open {
+		if start+int64(w.size) <= w.closedTo {
+			closed = append(closed, *win)
+			delete(w.open, start)
+		}
+	}
+	sort.Slice(closed, func(i, j int) bool { return closed[i].Start.Before(closed[j].Start) })
+	return closed
+}
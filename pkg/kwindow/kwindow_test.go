@@ -0,0 +1,118 @@
+package kwindow
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func rec(sec int64) *kgo.Record {
+	return &kgo.Record{Timestamp: time.Unix(sec, 0), Value: []byte{byte(sec)}}
+}
+
+// summarize returns each window's start second and its records' seconds.
+func summarize(ws []Window) [][]int64 {
+	var s [][]int64
+	for _, w := range ws {
+		secs := []int64{w.Start.Unix()}
+		for _, r := range w.Records {
+			secs = append(secs, r.Timestamp.Unix())
+		}
+		s = append(s, secs)
+	}
+	return s
+}
+
+func TestTumbling(t *testing.T) {
+	var late []int64
+	w := NewTumbling(10*time.Second, OnLateRecord(func(r *kgo.Record) {
+		late = append(late, r.Timestamp.Unix())
+	}))
+
+	var closed []Window
+	for _, sec := range []int64{1, 5, 12, 9, 21, 3, 30} {
+		closed = append(closed, w.Add(rec(sec))...)
+	}
+
+	// 12 advances the watermark past 10, closing [0, 10), so 9 is late.
+	// Similarly, 21 closes [10, 20) and 3 is late, and 30 closes [20, 30).
+	exp := [][]int64{
+		{0, 1, 5},
+		{10, 12},
+		{20, 21},
+	}
+	if got := summarize(closed); !reflect.DeepEqual(got, exp) {
+		t.Errorf("got closed windows %v, expected %v", got, exp)
+	}
+	if exp := []int64{9, 3}; !reflect.DeepEqual(late, exp) {
+		t.Errorf("got late records %v, expected %v", late, exp)
+	}
+	if got := w.Watermark().Unix(); got != 30 {
+		t.Errorf("got watermark %d, expected 30", got)
+	}
+
+	if got, exp := summarize(w.Flush()), [][]int64{{30, 30}}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("got flushed windows %v, expected %v", got, exp)
+	}
+	if got := w.Flush(); len(got) != 0 {
+		t.Errorf("got %d windows flushing again, expected 0", len(got))
+	}
+}
+
+func TestAllowedLateness(t *testing.T) {
+	var late int
+	w := NewTumbling(10*time.Second, AllowedLateness(5*time.Second), OnLateRecord(func(*kgo.Record) { late++ }))
+
+	var closed []Window
+	for _, sec := range []int64{1, 12, 9, 14, 15, 8} {
+		closed = append(closed, w.Add(rec(sec))...)
+	}
+
+	// 9 is within the allowed lateness after 12. Window [0, 10) closes
+	// once 15 is seen (watermark 10), so 8 is then late.
+	exp := [][]int64{{0, 1, 9}}
+	if got := summarize(closed); !reflect.DeepEqual(got, exp) {
+		t.Errorf("got closed windows %v, expected %v", got, exp)
+	}
+	if late != 1 {
+		t.Errorf("got %d late records, expected 1", late)
+	}
+}
+
+func TestHopping(t *testing.T) {
+	w := NewHopping(10*time.Second, 5*time.Second)
+
+	var closed []Window
+	for _, sec := range []int64{7, 12, 25} {
+		closed = append(closed, w.Add(rec(sec))...)
+	}
+	closed = append(closed, w.Flush()...)
+
+	exp := [][]int64{
+		{0, 7},
+		{5, 7, 12},
+		{10, 12},
+		{20, 25},
+		{25, 25},
+	}
+	if got := summarize(closed); !reflect.DeepEqual(got, exp) {
+		t.Errorf("got windows %v, expected %v", got, exp)
+	}
+}
+
+func TestHoppingGaps(t *testing.T) {
+	w := NewHopping(5*time.Second, 10*time.Second)
+
+	var closed []Window
+	for _, sec := range []int64{3, 7, 11} { // 7 is in the gap [5, 10)
+		closed = append(closed, w.Add(rec(sec))...)
+	}
+	closed = append(closed, w.Flush()...)
+
+	exp := [][]int64{{0, 3}, {10, 11}}
+	if got := summarize(closed); !reflect.DeepEqual(got, exp) {
+		t.Errorf("got windows %v, expected %v", got, exp)
+	}
+}